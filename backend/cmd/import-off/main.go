@@ -0,0 +1,87 @@
+// Command import-off loads an Open Food Facts dump into the local mirror
+// used for nutrition lookups:
+//
+//	go run ./cmd/import-off -country en:india openfoodfacts-products.jsonl.gz
+//
+// The JSONL and CSV exports are supported, gzipped or not, as are filtered
+// subsets of either.
+package main
+
+import (
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/joho/godotenv"
+	"github.com/pmitra96/pateproject/database"
+	"github.com/pmitra96/pateproject/logger"
+	"github.com/pmitra96/pateproject/services"
+)
+
+func main() {
+	format := flag.String("format", "", "jsonl or csv; by default from the file name")
+	country := flag.String("country", "", "only import products sold in this country, e.g. en:india")
+	limit := flag.Int("limit", 0, "stop after importing this many products")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: import-off [flags] <dump.jsonl[.gz] | dump.csv[.gz]>")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	path := flag.Arg(0)
+
+	logger.Init()
+	if err := godotenv.Load(); err != nil {
+		logger.Warn("No .env file found, using system env vars")
+	}
+
+	name := strings.TrimSuffix(strings.ToLower(path), ".gz")
+	if *format == "" {
+		switch {
+		case strings.HasSuffix(name, ".jsonl"), strings.HasSuffix(name, ".json"):
+			*format = services.OFFFormatJSONL
+		case strings.HasSuffix(name, ".csv"), strings.HasSuffix(name, ".tsv"):
+			*format = services.OFFFormatCSV
+		default:
+			fmt.Fprintf(os.Stderr, "cannot tell the format of %s; pass -format jsonl or -format csv\n", path)
+			os.Exit(2)
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open dump: %v\n", err)
+		os.Exit(1)
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(strings.ToLower(path), ".gz") {
+		gr, err := gzip.NewReader(f)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read gzip: %v\n", err)
+			os.Exit(1)
+		}
+		defer gr.Close()
+		r = gr
+	}
+
+	database.InitDB()
+
+	stats, err := services.ImportOpenFoodFacts(database.DB, r, services.OFFImportOptions{
+		Format:  *format,
+		Country: *country,
+		Limit:   *limit,
+	})
+	fmt.Printf("Read %d products: imported %d, skipped %d\n", stats.Read, stats.Imported, stats.Skipped)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Import failed: %v\n", err)
+		os.Exit(1)
+	}
+}
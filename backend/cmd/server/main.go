@@ -9,6 +9,7 @@ import (
 	"github.com/pmitra96/pateproject/jobs"
 	"github.com/pmitra96/pateproject/logger"
 	"github.com/pmitra96/pateproject/routes"
+	"github.com/pmitra96/pateproject/services"
 )
 
 func main() {
@@ -28,9 +29,15 @@ func main() {
 
 	database.InitDB()
 
+	// Ingredient taxonomy; CATEGORIES_FILE may point at the extractor's all_categories.md
+	services.SeedDefaultCategories(database.DB, config.GetEnv("CATEGORIES_FILE", ""))
+
 	// Start background nutrition worker
 	jobs.GetWorker()
 
+	// Start the ingestion worker; resumes jobs interrupted by a restart
+	jobs.GetIngestionWorker()
+
 	// Setup Router
 	r := routes.SetupRouter()
 
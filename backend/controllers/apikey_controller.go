@@ -0,0 +1,171 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/pmitra96/pateproject/database"
+	"github.com/pmitra96/pateproject/logger"
+	"github.com/pmitra96/pateproject/middleware"
+	"github.com/pmitra96/pateproject/models"
+)
+
+type CreateAPIKeyRequest struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expires_in_days,omitempty"`
+}
+
+// APIKeyResponse is returned on creation and rotation; Key is the only
+// time the plaintext is ever shown.
+type APIKeyResponse struct {
+	models.APIKey
+	Key string `json:"key"`
+}
+
+// GetAPIKeys lists the current user's API keys (without secrets).
+func GetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserID(r)
+	if err != nil {
+		writeJSONError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var keys []models.APIKey
+	database.DB.Where("user_id = ?", userID).Order("created_at desc").Find(&keys)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keys)
+}
+
+// CreateAPIKey issues a new key bound to the current user.
+func CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserID(r)
+	if err != nil {
+		writeJSONError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req CreateAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if len(req.Scopes) == 0 || !middleware.ValidScopes(req.Scopes) {
+		writeJSONError(w, http.StatusBadRequest, "scopes must be a non-empty subset of: "+strings.Join(middleware.KnownScopes, ", "))
+		return
+	}
+
+	plaintext, prefix, hash, err := middleware.GenerateAPIKey()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to generate key")
+		return
+	}
+
+	key := models.APIKey{
+		UserID:  userID,
+		Name:    req.Name,
+		Prefix:  prefix,
+		KeyHash: hash,
+		Scopes:  strings.Join(req.Scopes, ","),
+	}
+	if req.ExpiresInDays > 0 {
+		expires := time.Now().AddDate(0, 0, req.ExpiresInDays)
+		key.ExpiresAt = &expires
+	}
+
+	if err := database.DB.Create(&key).Error; err != nil {
+		logger.Error("Failed to create API key", "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to create API key")
+		return
+	}
+
+	logger.Info("API key created", "user_id", userID, "key_id", key.ID, "scopes", key.Scopes)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(APIKeyResponse{APIKey: key, Key: plaintext})
+}
+
+// RotateAPIKey replaces a key's secret. The old secret stops working
+// immediately; name, scopes and expiry carry over.
+func RotateAPIKey(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserID(r)
+	if err != nil {
+		writeJSONError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	key, ok := findUserAPIKey(w, r, userID)
+	if !ok {
+		return
+	}
+	if key.RevokedAt != nil {
+		writeJSONError(w, http.StatusConflict, "API key is revoked")
+		return
+	}
+
+	plaintext, prefix, hash, err := middleware.GenerateAPIKey()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to generate key")
+		return
+	}
+
+	key.Prefix = prefix
+	key.KeyHash = hash
+	key.LastUsedAt = nil
+	if err := database.DB.Save(&key).Error; err != nil {
+		logger.Error("Failed to rotate API key", "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to rotate API key")
+		return
+	}
+
+	logger.Info("API key rotated", "user_id", userID, "key_id", key.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(APIKeyResponse{APIKey: key, Key: plaintext})
+}
+
+// RevokeAPIKey permanently disables a key. The row is kept for auditing.
+func RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserID(r)
+	if err != nil {
+		writeJSONError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	key, ok := findUserAPIKey(w, r, userID)
+	if !ok {
+		return
+	}
+
+	if key.RevokedAt == nil {
+		now := time.Now()
+		key.RevokedAt = &now
+		if err := database.DB.Save(&key).Error; err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to revoke API key")
+			return
+		}
+		logger.Info("API key revoked", "user_id", userID, "key_id", key.ID)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func findUserAPIKey(w http.ResponseWriter, r *http.Request, userID uint) (models.APIKey, bool) {
+	var key models.APIKey
+	keyID, err := strconv.ParseUint(chi.URLParam(r, "key_id"), 10, 32)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid key ID")
+		return key, false
+	}
+	if err := database.DB.Where("id = ? AND user_id = ?", keyID, userID).First(&key).Error; err != nil {
+		writeJSONError(w, http.StatusNotFound, "API key not found")
+		return key, false
+	}
+	return key, true
+}
@@ -0,0 +1,196 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/pmitra96/pateproject/database"
+	"github.com/pmitra96/pateproject/logger"
+	"github.com/pmitra96/pateproject/models"
+	"github.com/pmitra96/pateproject/services"
+	"gorm.io/gorm"
+)
+
+type CreateIngredientAliasRequest struct {
+	Alias        string `json:"alias"`
+	IngredientID uint   `json:"ingredient_id"`
+}
+
+type CreateBrandAliasRequest struct {
+	Alias   string `json:"alias"`
+	BrandID uint   `json:"brand_id"`
+}
+
+// GetIngredientAliases lists ingredient aliases, optionally for one ingredient.
+func GetIngredientAliases(w http.ResponseWriter, r *http.Request) {
+	query := database.DB.Preload("Ingredient").Order("alias")
+	if id := r.URL.Query().Get("ingredient_id"); id != "" {
+		query = query.Where("ingredient_id = ?", id)
+	}
+
+	var aliases []models.IngredientAlias
+	query.Find(&aliases)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(aliases)
+}
+
+// CreateIngredientAlias points an alternative name at an ingredient. A name
+// that is already an ingredient of its own has to be merged instead.
+func CreateIngredientAlias(w http.ResponseWriter, r *http.Request) {
+	var req CreateIngredientAliasRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	alias := strings.ToLower(strings.TrimSpace(req.Alias))
+	if alias == "" || req.IngredientID == 0 {
+		writeJSONError(w, http.StatusBadRequest, "alias and ingredient_id are required")
+		return
+	}
+
+	var ingredient models.Ingredient
+	if err := database.DB.First(&ingredient, req.IngredientID).Error; err != nil {
+		writeJSONError(w, http.StatusNotFound, "Ingredient not found")
+		return
+	}
+	var existing models.Ingredient
+	if err := database.DB.Where("LOWER(name) = ? AND id <> ?", alias, ingredient.ID).First(&existing).Error; err == nil {
+		writeJSONError(w, http.StatusConflict, "An ingredient with this name exists; merge it instead (id "+strconv.Itoa(int(existing.ID))+")")
+		return
+	}
+
+	record := models.IngredientAlias{Alias: alias, IngredientID: ingredient.ID}
+	if err := database.DB.Create(&record).Error; err != nil {
+		writeJSONError(w, http.StatusConflict, "Alias already exists")
+		return
+	}
+	record.Ingredient = ingredient
+
+	logger.Info("Ingredient alias created", "alias", alias, "ingredient", ingredient.Name)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(record)
+}
+
+// DeleteIngredientAlias removes an ingredient alias.
+func DeleteIngredientAlias(w http.ResponseWriter, r *http.Request) {
+	result := database.DB.Delete(&models.IngredientAlias{}, chi.URLParam(r, "alias_id"))
+	if result.Error != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to delete alias")
+		return
+	}
+	if result.RowsAffected == 0 {
+		writeJSONError(w, http.StatusNotFound, "Alias not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetBrandAliases lists brand aliases, optionally for one brand.
+func GetBrandAliases(w http.ResponseWriter, r *http.Request) {
+	query := database.DB.Preload("Brand").Order("alias")
+	if id := r.URL.Query().Get("brand_id"); id != "" {
+		query = query.Where("brand_id = ?", id)
+	}
+
+	var aliases []models.BrandAlias
+	query.Find(&aliases)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(aliases)
+}
+
+// CreateBrandAlias points a spelling or sub-brand at a brand. Aliases are
+// also used to spot brands in product names when the LLM is unavailable.
+func CreateBrandAlias(w http.ResponseWriter, r *http.Request) {
+	var req CreateBrandAliasRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	alias := strings.ToLower(strings.TrimSpace(req.Alias))
+	if alias == "" || req.BrandID == 0 {
+		writeJSONError(w, http.StatusBadRequest, "alias and brand_id are required")
+		return
+	}
+
+	var brand models.Brand
+	if err := database.DB.First(&brand, req.BrandID).Error; err != nil {
+		writeJSONError(w, http.StatusNotFound, "Brand not found")
+		return
+	}
+
+	record := models.BrandAlias{Alias: alias, BrandID: brand.ID}
+	if err := database.DB.Create(&record).Error; err != nil {
+		writeJSONError(w, http.StatusConflict, "Alias already exists")
+		return
+	}
+	record.Brand = brand
+
+	logger.Info("Brand alias created", "alias", alias, "brand", brand.Name)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(record)
+}
+
+// DeleteBrandAlias removes a brand alias.
+func DeleteBrandAlias(w http.ResponseWriter, r *http.Request) {
+	result := database.DB.Delete(&models.BrandAlias{}, chi.URLParam(r, "alias_id"))
+	if result.Error != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to delete alias")
+		return
+	}
+	if result.RowsAffected == 0 {
+		writeJSONError(w, http.StatusNotFound, "Alias not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// MergeIngredient folds the ingredient in the path into into_id, e.g. a
+// duplicate "Curd" into "Yogurt".
+func MergeIngredient(w http.ResponseWriter, r *http.Request) {
+	fromID, err := strconv.ParseUint(chi.URLParam(r, "ingredient_id"), 10, 32)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid ingredient ID")
+		return
+	}
+
+	var req struct {
+		IntoID uint `json:"into_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.IntoID == 0 {
+		writeJSONError(w, http.StatusBadRequest, "into_id is required")
+		return
+	}
+
+	err = services.MergeIngredient(database.DB, uint(fromID), req.IntoID)
+	switch {
+	case errors.Is(err, services.ErrMergeIntoSelf):
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	case errors.Is(err, services.ErrUnitMismatch):
+		writeJSONError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		writeJSONError(w, http.StatusNotFound, "Ingredient not found")
+		return
+	case err != nil:
+		logger.Error("Ingredient merge failed", "from", fromID, "into", req.IntoID, "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to merge ingredients")
+		return
+	}
+
+	var into models.Ingredient
+	database.DB.First(&into, req.IntoID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(into)
+}
@@ -0,0 +1,87 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/pmitra96/pateproject/database"
+	"github.com/pmitra96/pateproject/logger"
+	"github.com/pmitra96/pateproject/models"
+	"github.com/pmitra96/pateproject/services"
+)
+
+// categoryFilter reads ?category= (ID, slug or name) and returns the
+// ingredient IDs filed under it, or nil when no filter was given.
+func categoryFilter(w http.ResponseWriter, r *http.Request) ([]uint, bool) {
+	ref := r.URL.Query().Get("category")
+	if ref == "" {
+		return nil, true
+	}
+
+	ids, err := services.IngredientIDsInCategory(database.DB, ref)
+	if errors.Is(err, services.ErrUnknownCategory) {
+		http.Error(w, "Unknown category", http.StatusBadRequest)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, "Failed to resolve category", http.StatusInternalServerError)
+		return nil, false
+	}
+	if ids == nil {
+		ids = []uint{}
+	}
+	return ids, true
+}
+
+// GetCategories returns the ingredient category tree.
+func GetCategories(w http.ResponseWriter, r *http.Request) {
+	tree, err := services.CategoryTree(database.DB)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to load categories")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tree)
+}
+
+// UpdateIngredientCategory corrects the category of an ingredient. The
+// assignment is marked manual so ingestion never overrides it.
+func UpdateIngredientCategory(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserID(r)
+	if err != nil {
+		writeJSONError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var ingredient models.Ingredient
+	if err := database.DB.First(&ingredient, chi.URLParam(r, "ingredient_id")).Error; err != nil {
+		writeJSONError(w, http.StatusNotFound, "Ingredient not found")
+		return
+	}
+
+	var req struct {
+		Category string `json:"category"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Category == "" {
+		writeJSONError(w, http.StatusBadRequest, "category is required")
+		return
+	}
+
+	category, err := services.FindCategory(database.DB, req.Category)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Unknown category")
+		return
+	}
+	if err := services.SetCategory(database.DB, &ingredient, category); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to update category")
+		return
+	}
+
+	logger.Info("Ingredient category corrected", "user_id", userID, "ingredient", ingredient.Name, "category", category.Name)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ingredient)
+}
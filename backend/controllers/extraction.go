@@ -3,31 +3,47 @@ package controllers
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"io"
 	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/pmitra96/pateproject/config"
 	"github.com/pmitra96/pateproject/extractor"
 	"github.com/pmitra96/pateproject/logger"
 )
 
+// ExtractItems extracts an uploaded invoice. With ?debug=1 the response
+// includes per-row diagnostics from the PDF table parser.
 func ExtractItems(w http.ResponseWriter, r *http.Request) {
 	logger.Info("Received extraction request")
 
+	result, ok := extractUploadedInvoice(w, r, "image")
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// extractUploadedInvoice reads the invoice uploaded in a multipart form
+// field and extracts it, writing the error response itself on failure.
+func extractUploadedInvoice(w http.ResponseWriter, r *http.Request, field string) (*extractor.ExtractionResult, bool) {
 	// Parse multipart form
 	err := r.ParseMultipartForm(10 << 20) // 10 MB limit
 	if err != nil {
 		http.Error(w, "Failed to parse form", http.StatusBadRequest)
-		return
+		return nil, false
 	}
 
-	file, fh, err := r.FormFile("image")
+	file, fh, err := r.FormFile(field)
 	if err != nil {
 		http.Error(w, "Error retrieving file", http.StatusBadRequest)
-		return
+		return nil, false
 	}
 	defer file.Close()
 
@@ -37,7 +53,7 @@ func ExtractItems(w http.ResponseWriter, r *http.Request) {
 	tempFile, err := os.CreateTemp(tempDir, "upload-*"+ext)
 	if err != nil {
 		http.Error(w, "Failed to create temp file", http.StatusInternalServerError)
-		return
+		return nil, false
 	}
 	defer os.Remove(tempFile.Name())
 	defer tempFile.Close()
@@ -46,24 +62,40 @@ func ExtractItems(w http.ResponseWriter, r *http.Request) {
 	_, err = io.Copy(tempFile, file)
 	if err != nil {
 		http.Error(w, "Failed to save file", http.StatusInternalServerError)
-		return
+		return nil, false
 	}
 
-	pythonURL := config.GetEnv("PYTHON_EXTRACTOR_URL", "http://localhost:8081")
-	result, err := callPythonExtractor(pythonURL, tempFile.Name(), fh.Filename)
-
-	if err != nil {
-		http.Error(w, "Failed to extract data: "+err.Error(), http.StatusInternalServerError)
-		return
+	// PDFs are parsed natively; the Python service handles images and
+	// invoices the Go parsers cannot read.
+	var result *extractor.ExtractionResult
+	var diagnostics []extractor.RowDiagnostic
+	if strings.EqualFold(ext, ".pdf") {
+		result, err = extractor.DiagnoseInvoice(tempFile.Name())
+		if err != nil {
+			logger.Warn("Native invoice parsing failed", "file", fh.Filename, "error", err)
+		}
+		if result != nil {
+			diagnostics = result.Diagnostics
+		}
+	}
+	if result == nil || len(result.Items) == 0 {
+		pythonURL := config.GetEnv("PYTHON_EXTRACTOR_URL", "http://localhost:8081")
+		result, err = callPythonExtractor(pythonURL, tempFile.Name(), fh.Filename)
+		if err != nil {
+			http.Error(w, "Failed to extract data: "+err.Error(), http.StatusInternalServerError)
+			return nil, false
+		}
+	}
+	result.Diagnostics = nil
+	if r.URL.Query().Get("debug") == "1" {
+		result.Diagnostics = diagnostics
 	}
 
-	logger.Info("Extraction completed successfully", "provider", result.Provider, "items_found", len(result.Items))
+	logger.Info("Extraction completed successfully", "provider", result.Provider, "order_id", result.OrderID, "invoice_number", result.InvoiceNumber, "items_found", len(result.Items))
 	for _, item := range result.Items {
 		logger.Info("Item found", "name", item.Name, "count", item.Count, "unit_val", item.UnitValue, "unit", item.Unit)
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(result)
+	return result, true
 }
 
 func callPythonExtractor(baseURL string, filePath string, originalFilename string) (*extractor.ExtractionResult, error) {
@@ -105,7 +137,7 @@ func callPythonExtractor(baseURL string, filePath string, originalFilename strin
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, err
+		return nil, fmt.Errorf("python extractor returned status: %d", resp.StatusCode)
 	}
 
 	// Parse response
@@ -0,0 +1,203 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/pmitra96/pateproject/database"
+	"github.com/pmitra96/pateproject/services"
+)
+
+func forecastForRequest(w http.ResponseWriter, r *http.Request) ([]services.StockForecast, bool) {
+	userID, err := getUserID(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	ingredientIDs, ok := categoryFilter(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	settings := services.ForecastSettingsFor(database.DB, userID)
+	forecasts, err := services.ForecastPantry(database.DB, userID, settings, time.Now())
+	if err != nil {
+		http.Error(w, "Failed to forecast pantry", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	if ingredientIDs != nil {
+		inCategory := make(map[uint]bool, len(ingredientIDs))
+		for _, id := range ingredientIDs {
+			inCategory[id] = true
+		}
+		filtered := forecasts[:0]
+		for _, f := range forecasts {
+			if inCategory[f.IngredientID] {
+				filtered = append(filtered, f)
+			}
+		}
+		forecasts = filtered
+	}
+	return forecasts, true
+}
+
+// GetPantryForecast returns usage and days-until-out for every pantry item.
+func GetPantryForecast(w http.ResponseWriter, r *http.Request) {
+	forecasts, ok := forecastForRequest(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(forecasts)
+}
+
+// GetLowStock returns pantry items that are out, below their minimum, or
+// forecast to run out within the lead time.
+func GetLowStock(w http.ResponseWriter, r *http.Request) {
+	forecasts, ok := forecastForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	lowStock := []services.StockForecast{}
+	for _, f := range forecasts {
+		if f.Low {
+			lowStock = append(lowStock, f)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lowStock)
+}
+
+// GetLowStockByCategory summarises low stock per top-level category
+// (dairy, staples, ...), most affected first.
+func GetLowStockByCategory(w http.ResponseWriter, r *http.Request) {
+	forecasts, ok := forecastForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	summary, err := services.LowStockByCategory(database.DB, forecasts)
+	if err != nil {
+		http.Error(w, "Failed to group by category", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summary)
+}
+
+// ReorderItem is one line of the reorder list.
+type ReorderItem struct {
+	PantryItemID      uint     `json:"pantry_item_id"`
+	Ingredient        string   `json:"ingredient"`
+	ItemID            uint     `json:"item_id"`
+	ItemName          string   `json:"item_name"`
+	Unit              string   `json:"unit"`
+	Reason            string   `json:"reason"`
+	DaysUntilOut      *float64 `json:"days_until_out"`
+	SuggestedQuantity float64  `json:"suggested_quantity"`
+	SuggestedPacks    int      `json:"suggested_packs,omitempty"`
+	PackSize          float64  `json:"pack_size,omitempty"`
+
+	CheapestSource *services.SourcePrice `json:"cheapest_source,omitempty"` // From recent orders
+}
+
+// GetReorderList suggests what to buy, soonest to run out first.
+func GetReorderList(w http.ResponseWriter, r *http.Request) {
+	forecasts, ok := forecastForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var ingredientIDs []uint
+	for _, f := range forecasts {
+		if f.Low {
+			ingredientIDs = append(ingredientIDs, f.IngredientID)
+		}
+	}
+	userID, _ := getUserID(r)
+	cheapest, err := services.FindCheapestSources(database.DB, userID, ingredientIDs, time.Now())
+	if err != nil {
+		http.Error(w, "Failed to compare prices", http.StatusInternalServerError)
+		return
+	}
+
+	list := []ReorderItem{}
+	for _, f := range forecasts {
+		if !f.Low {
+			continue
+		}
+		list = append(list, ReorderItem{
+			PantryItemID:      f.PantryItemID,
+			Ingredient:        f.Ingredient,
+			ItemID:            f.ItemID,
+			ItemName:          f.ItemName,
+			Unit:              f.Unit,
+			Reason:            f.Reason,
+			DaysUntilOut:      f.DaysUntilOut,
+			SuggestedQuantity: f.SuggestedQuantity,
+			SuggestedPacks:    f.SuggestedPacks,
+			PackSize:          f.PackSize,
+			CheapestSource:    cheapest.For(f.IngredientID, nil),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// UpdatePantryThreshold sets per-item reorder thresholds. Null clears a
+// value back to the user's default.
+func UpdatePantryThreshold(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserID(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	itemID, err := strconv.Atoi(chi.URLParam(r, "item_id"))
+	if err != nil {
+		http.Error(w, "Invalid item ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		MinQuantity     *float64 `json:"min_quantity"`
+		ReorderLeadDays *float64 `json:"reorder_lead_days"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid body", http.StatusBadRequest)
+		return
+	}
+	if (req.MinQuantity != nil && *req.MinQuantity < 0) || (req.ReorderLeadDays != nil && *req.ReorderLeadDays < 0) {
+		http.Error(w, "Thresholds cannot be negative", http.StatusBadRequest)
+		return
+	}
+
+	pantryItem, err := findUserPantryItem(userID, itemID)
+	if err != nil {
+		http.Error(w, "Item not found in pantry", http.StatusNotFound)
+		return
+	}
+
+	err = database.DB.Model(&pantryItem).Updates(map[string]interface{}{
+		"min_quantity":      req.MinQuantity,
+		"reorder_lead_days": req.ReorderLeadDays,
+	}).Error
+	if err != nil {
+		http.Error(w, "Failed to update", http.StatusInternalServerError)
+		return
+	}
+	pantryItem.MinQuantity = req.MinQuantity
+	pantryItem.ReorderLeadDays = req.ReorderLeadDays
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pantryItem)
+}
@@ -0,0 +1,173 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/pmitra96/pateproject/database"
+	"github.com/pmitra96/pateproject/logger"
+	"github.com/pmitra96/pateproject/middleware"
+	"github.com/pmitra96/pateproject/models"
+	"gorm.io/gorm"
+)
+
+type LinkIdentityRequest struct {
+	// Token is a bearer token from the provider being linked.
+	Token string `json:"token"`
+}
+
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
+
+// GetCurrentUser returns the signed-in user and their linked identities.
+// A 409 tells the client the login must be linked to an existing account.
+func GetCurrentUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserID(r)
+	if errors.Is(err, errAccountLinkRequired) {
+		writeJSONError(w, http.StatusConflict, "An account with this email already exists. Sign in with your original provider and link this one.")
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var user models.User
+	if err := database.DB.Preload("Identities").First(&user, userID).Error; err != nil {
+		writeJSONError(w, http.StatusNotFound, "User not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+// GetIdentities lists the identities linked to the current user.
+func GetIdentities(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserID(r)
+	if err != nil {
+		writeJSONError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var identities []models.UserIdentity
+	database.DB.Where("user_id = ?", userID).Order("created_at").Find(&identities)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(identities)
+}
+
+// LinkIdentity attaches a second provider to the current user. The caller
+// must be signed in with an identity already on the account and present a
+// valid token from the provider being linked.
+func LinkIdentity(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserID(r)
+	if err != nil {
+		writeJSONError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req LinkIdentityRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		writeJSONError(w, http.StatusBadRequest, "token is required")
+		return
+	}
+
+	auth := middleware.DefaultAuthenticator()
+	linked, err := auth.Authenticate(req.Token)
+	if err != nil {
+		logger.Warn("Rejected link token", "user_id", userID, "error", err)
+		writeJSONError(w, http.StatusUnauthorized, "Invalid link token")
+		return
+	}
+
+	provider, ok := auth.Providers.ForName(linked.Provider)
+	if !ok {
+		writeJSONError(w, http.StatusBadRequest, "Unknown provider")
+		return
+	}
+	policy := provider.LinkPolicy()
+	if !policy.Linkable {
+		writeJSONError(w, http.StatusForbidden, "Provider "+linked.Provider+" cannot be linked")
+		return
+	}
+	if policy.RequireVerifiedEmail && !linked.EmailVerified {
+		writeJSONError(w, http.StatusForbidden, "Provider "+linked.Provider+" requires a verified email to link")
+		return
+	}
+
+	var existing models.UserIdentity
+	err = database.DB.Where("provider = ? AND external_id = ?", linked.Provider, linked.Subject).First(&existing).Error
+	if err == nil {
+		if existing.UserID == userID {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(existing)
+			return
+		}
+		writeJSONError(w, http.StatusConflict, "This identity is already linked to another account")
+		return
+	} else if err != gorm.ErrRecordNotFound {
+		writeJSONError(w, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	identity := models.UserIdentity{
+		UserID:     userID,
+		Provider:   linked.Provider,
+		ExternalID: linked.Subject,
+		Email:      linked.Email,
+	}
+	if err := database.DB.Create(&identity).Error; err != nil {
+		logger.Error("Failed to link identity", "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to link identity")
+		return
+	}
+
+	logger.Info("Linked identity", "user_id", userID, "provider", linked.Provider, "external_id", linked.Subject)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(identity)
+}
+
+// UnlinkIdentity removes a linked identity. The last identity on an account
+// cannot be removed, otherwise the user could never sign in again.
+func UnlinkIdentity(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserID(r)
+	if err != nil {
+		writeJSONError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	identityID, err := strconv.ParseUint(chi.URLParam(r, "identity_id"), 10, 32)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid identity ID")
+		return
+	}
+
+	var count int64
+	database.DB.Model(&models.UserIdentity{}).Where("user_id = ?", userID).Count(&count)
+	if count <= 1 {
+		writeJSONError(w, http.StatusConflict, "Cannot remove the only identity on the account")
+		return
+	}
+
+	result := database.DB.Where("id = ? AND user_id = ?", identityID, userID).Delete(&models.UserIdentity{})
+	if result.Error != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to unlink identity")
+		return
+	}
+	if result.RowsAffected == 0 {
+		writeJSONError(w, http.StatusNotFound, "Identity not found")
+		return
+	}
+
+	logger.Info("Unlinked identity", "user_id", userID, "identity_id", identityID)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -4,11 +4,13 @@ import (
 	"encoding/json"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/pmitra96/pateproject/database"
 	"github.com/pmitra96/pateproject/llm"
 	"github.com/pmitra96/pateproject/logger"
 	"github.com/pmitra96/pateproject/models"
+	"github.com/pmitra96/pateproject/services"
 )
 
 type StoryRequest struct {
@@ -103,26 +105,23 @@ func SuggestMealPersonalized(w http.ResponseWriter, r *http.Request) {
 
 	// Fetch authoritative pantry data (with nutrition) from DB
 	var dbPantryItems []models.PantryItem
-	if err := database.DB.Preload("Item").Preload("Ingredient").Where("user_id = ?", userID).Find(&dbPantryItems).Error; err != nil {
+	if err := database.DB.Preload("Item.Ingredient").Preload("Item.Nutrients").Preload("Ingredient.Category").Where("user_id = ?", userID).Find(&dbPantryItems).Error; err != nil {
 		logger.Error("Failed to fetch pantry for suggestions", "error", err)
 	}
 
 	// Create a map for quick lookup
 	// Keying by Ingredient Name as that's what likely matches req.Inventory names
-	pantryMap := make(map[string]models.Item)
+	pantryMap := make(map[string]models.PantryItem)
 	for _, p := range dbPantryItems {
-		pantryMap[strings.ToLower(p.Ingredient.Name)] = p.Item
+		pantryMap[strings.ToLower(p.Ingredient.Name)] = p
 	}
 
-	// Enrich request inventory with nutrition data
-	for i := range req.Inventory {
-		if item, ok := pantryMap[strings.ToLower(req.Inventory[i].Name)]; ok {
-			req.Inventory[i].Calories = item.Calories
-			req.Inventory[i].Protein = item.Protein
-			req.Inventory[i].Fat = item.Fat
-			req.Inventory[i].Carbs = item.Carbs
-		}
+	// Soonest best-before per pantry item, so suggestions use it up first
+	bestBefore, err := services.EarliestBestBefore(database.DB, userID)
+	if err != nil {
+		logger.Error("Failed to fetch pantry batches for suggestions", "error", err)
 	}
+	now := time.Now()
 
 	// Fetch user preferences
 	var userPrefs models.UserPreferences
@@ -135,9 +134,57 @@ func SuggestMealPersonalized(w http.ResponseWriter, r *http.Request) {
 			State:             userPrefs.State,
 			City:              userPrefs.City,
 			PreferredCuisines: cuisines,
+			Diet:              userPrefs.Diet,
+			AvoidAllergens:    splitList(userPrefs.AvoidAllergens),
 		}
 	}
 
+	// Enrich request inventory with nutrition and expiry data, leaving out
+	// what the user's diet or allergies rule out
+	inventory := req.Inventory[:0]
+	for _, inv := range req.Inventory {
+		if p, ok := pantryMap[strings.ToLower(inv.Name)]; ok {
+			if fits, why := services.FitsDiet(p.Item.DietProfile, userPrefs.Diet); !fits {
+				logger.Info("Leaving pantry item out of suggestions", "item", inv.Name, "reason", why)
+				continue
+			}
+			if avoided := services.AvoidedAllergens(p.Item.DietProfile, userPrefs.AvoidAllergens); len(avoided) > 0 {
+				logger.Info("Leaving pantry item out of suggestions", "item", inv.Name, "allergens", avoided)
+				continue
+			}
+
+			// Nutrition per 100 g, 100 ml or piece, as the pantry counts it
+			basis := services.DefaultBasis(p.Item.Unit)
+			macros, err := services.ComputeMacros(&p.Item, basis.Amount, basis.Unit)
+			if err != nil {
+				basis = services.BasisOf(&p.Item)
+				macros, _ = services.ComputeMacros(&p.Item, basis.Amount, basis.Unit)
+			}
+			inv.Calories = macros.Calories
+			inv.Protein = macros.Protein
+			inv.Fat = macros.Fat
+			inv.Carbs = macros.Carbs
+			inv.NutritionPer = basis.String()
+			inv.Allergens = p.Item.Allergens
+			if p.Ingredient.Category != nil {
+				inv.Category = p.Ingredient.Category.Name
+			}
+
+			if bb, ok := bestBefore[p.ID]; ok && bb.Sub(now) <= defaultExpiringWithin {
+				days := int(bb.Sub(now).Hours() / 24)
+				inv.ExpiresInDays = &days
+			}
+		}
+		inventory = append(inventory, inv)
+	}
+	req.Inventory = inventory
+	if len(req.Inventory) == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "No inventory items fit your diet and allergies"})
+		return
+	}
+
 	// Fetch dish samples based on preferred cuisines
 	var dishSamples []llm.DishSampleInfo
 	if preferencesInfo != nil && len(preferencesInfo.PreferredCuisines) > 0 {
@@ -3,14 +3,16 @@ package controllers
 import (
 	"encoding/json"
 	"net/http"
-	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/pmitra96/pateproject/database"
 	"github.com/pmitra96/pateproject/logger"
 	"github.com/pmitra96/pateproject/models"
+	"github.com/pmitra96/pateproject/services"
+	"github.com/pmitra96/pateproject/units"
 )
 
 type LogMealRequest struct {
@@ -21,6 +23,9 @@ type LogMealRequest struct {
 	Fat         float64  `json:"fat"`
 	Carbs       float64  `json:"carbs"`
 	WasOverride bool     `json:"was_override"`
+	// Resolutions pins ingredients to pantry items, keyed by the ingredient
+	// string as sent, e.g. {"1 tbsp Oil": 12}.
+	Resolutions map[string]uint `json:"resolutions,omitempty"`
 }
 
 type LogMealResponse struct {
@@ -46,48 +51,16 @@ func LogMeal(w http.ResponseWriter, r *http.Request) {
 
 	updatedItems := []string{}
 
-	// Track macros for the meal - use PROVIDED values only
-	// Track macros for the meal - use PROVIDED values only
-	// The user explicitly requested to NEVER sum up from pantry items.
+	// Track macros for the meal - use PROVIDED values when there are any.
+	// Only a meal logged without macros is summed up from the pantry items
+	// its ingredients come from, scaled to each ingredient's amount.
 	var totalCalories, totalProtein, totalCarbs, totalFat, totalFiber float64
 
 	totalCalories = req.Calories
 	totalProtein = req.Protein
 	totalFat = req.Fat
 	totalCarbs = req.Carbs
-	totalFat = req.Fat
-	totalCarbs = req.Carbs
-
-	// Parse each ingredient and reduce pantry quantity
-	// Ingredients are in format like "100g Paneer", "2 Eggs", "1 cup Rice"
-	for _, ingredient := range req.Ingredients {
-		ingredientName, quantity, unit := parseIngredient(ingredient)
-		if ingredientName == "" {
-			continue
-		}
-
-		// Find matching pantry item by ingredient name (fuzzy match)
-		var pantryItems []models.PantryItem
-		database.DB.Preload("Ingredient").Preload("Item").Where("user_id = ?", userID).Find(&pantryItems)
-
-		for _, pi := range pantryItems {
-			if matchesIngredient(pi.Ingredient.Name, ingredientName) {
-				// Convert quantity to base units if needed
-				reduction := convertToBaseUnit(quantity, unit, pi.Ingredient.Name)
-
-				// Reduce the quantity
-				newQty := pi.DerivedQuantity - reduction
-				if newQty < 0 {
-					newQty = 0
-				}
-
-				database.DB.Model(&pi).Update("derived_quantity", newQty)
-				updatedItems = append(updatedItems, pi.Ingredient.Name)
-				logger.Info("Reduced pantry item", "ingredient", pi.Ingredient.Name, "reduction", reduction, "new_qty", newQty)
-				break
-			}
-		}
-	}
+	fromPantry := totalCalories == 0 && totalProtein == 0 && totalFat == 0 && totalCarbs == 0
 
 	// Save ingredients as JSON
 	ingredientsJSON, _ := json.Marshal(req.Ingredients)
@@ -118,14 +91,78 @@ func LogMeal(w http.ResponseWriter, r *http.Request) {
 
 	logger.Info("Meal logged to history", "meal_log_id", mealLog.ID, "calories", totalCalories, "protein", totalProtein)
 
+	// Parse each ingredient and reduce pantry quantity
+	// Ingredients are in format like "100g Paneer", "2 Eggs", "1 cup Rice"
+	var pantryItems []models.PantryItem
+	database.DB.Preload("Ingredient").Preload("Item.Ingredient").Preload("Item.Nutrients").Where("user_id = ?", userID).Find(&pantryItems)
+
+	ambiguous := []services.MatchResult{}
+	unmatched := []string{}
+	for _, ingredient := range req.Ingredients {
+		ingredientName, quantity, unit := parseIngredient(ingredient)
+		if ingredientName == "" {
+			continue
+		}
+
+		var pi *models.PantryItem
+		if id, ok := req.Resolutions[ingredient]; ok {
+			pi = findPantryItem(pantryItems, id)
+		} else {
+			match := services.MatchPantryItems(ingredientName, pantryItems)
+			switch {
+			case match.Best != nil:
+				pi = findPantryItem(pantryItems, match.Best.PantryItemID)
+			case len(match.Candidates) > 0:
+				// Don't guess between items; the client asks the user and
+				// resolves via /meals/{meal_id}/resolve.
+				match.Query = ingredient
+				ambiguous = append(ambiguous, match)
+				continue
+			}
+		}
+		if pi == nil {
+			unmatched = append(unmatched, ingredient)
+			continue
+		}
+
+		if fromPantry {
+			if macros, err := services.ComputeMacros(&pi.Item, quantity, unit); err != nil {
+				logger.Warn("Cannot work out ingredient nutrition", "ingredient", ingredient, "error", err)
+			} else {
+				totalCalories += macros.Calories
+				totalProtein += macros.Protein
+				totalCarbs += macros.Carbs
+				totalFat += macros.Fat
+				totalFiber += macros.Fiber
+			}
+		}
+
+		if consumeIngredient(pi, &mealLog, ingredient, quantity, unit) {
+			updatedItems = append(updatedItems, pi.Ingredient.Name)
+		}
+	}
+
+	if fromPantry && totalCalories > 0 {
+		database.DB.Model(&mealLog).Updates(map[string]interface{}{
+			"calories": totalCalories,
+			"protein":  totalProtein,
+			"carbs":    totalCarbs,
+			"fat":      totalFat,
+			"fiber":    totalFiber,
+		})
+		logger.Info("Meal macros computed from pantry", "meal_log_id", mealLog.ID, "calories", totalCalories, "protein", totalProtein)
+	}
+
 	// Compute post-log state
 	newState, _ := ComputeRemainingDayState(userID, time.Now())
 
 	resp := struct {
-		Status    string   `json:"status"`
-		Message   string   `json:"message"`
-		Updated   []string `json:"updated_items"`
-		MealLogID uint     `json:"meal_log_id"`
+		Status    string                 `json:"status"`
+		Message   string                 `json:"message"`
+		Updated   []string               `json:"updated_items"`
+		Ambiguous []services.MatchResult `json:"ambiguous"`
+		Unmatched []string               `json:"unmatched"`
+		MealLogID uint                   `json:"meal_log_id"`
 		Macros    struct {
 			Calories float64 `json:"calories"`
 			Protein  float64 `json:"protein"`
@@ -138,6 +175,8 @@ func LogMeal(w http.ResponseWriter, r *http.Request) {
 		Status:         "success",
 		Message:        "Meal logged successfully",
 		Updated:        updatedItems,
+		Ambiguous:      ambiguous,
+		Unmatched:      unmatched,
 		MealLogID:      mealLog.ID,
 		RemainingState: newState,
 	}
@@ -152,147 +191,127 @@ func LogMeal(w http.ResponseWriter, r *http.Request) {
 }
 
 // parseIngredient extracts quantity, unit, and name from ingredient strings
-// Examples: "100g Paneer" -> ("Paneer", 100, "g"), "2 Eggs" -> ("Eggs", 2, "pcs")
+// Examples: "100g Paneer" -> ("paneer", 100, "g"), "2 Eggs" -> ("eggs", 2, "pcs"),
+// "1/2 cup Rice" -> ("rice", 0.5, "cup"). Names with a catalog alias come
+// back as the canonical ingredient ("1 cup Dahi" -> "yogurt").
 func parseIngredient(ingredient string) (name string, quantity float64, unit string) {
-	ingredient = strings.TrimSpace(ingredient)
-
-	// Pattern: number + optional unit + name
-	// Examples: "100g Paneer", "2 Eggs", "1 cup Rice", "200ml Milk"
-	pattern := regexp.MustCompile(`^(\d+(?:\.\d+)?)\s*(g|kg|ml|l|cup|cups|tbsp|tsp|pcs|pc|pieces?)?\s*(.+)$`)
-	matches := pattern.FindStringSubmatch(strings.ToLower(ingredient))
+	quantity, unit, name = units.ParseLeading(ingredient)
+	if canonical, ok := services.LookupIngredientAlias(database.DB, name); ok {
+		name = canonical.Name
+	}
+	return strings.ToLower(name), quantity, unit
+}
 
-	if len(matches) >= 4 {
-		qty, _ := strconv.ParseFloat(matches[1], 64)
-		unit = matches[2]
-		name = strings.TrimSpace(matches[3])
+// consumeIngredient takes a meal ingredient out of a pantry item, converted
+// to the unit the pantry item is tracked in, oldest batches first.
+func consumeIngredient(pi *models.PantryItem, mealLog *models.MealLog, ingredient string, quantity float64, unit string) bool {
+	removed, err := services.ApplyMovement(database.DB, pi, services.Movement{
+		Delta:     -quantity,
+		Unit:      unit,
+		Source:    models.MovementSourceMealLog,
+		MealLogID: &mealLog.ID,
+		Note:      ingredient,
+	})
+	if err != nil {
+		logger.Warn("Skipping pantry reduction", "ingredient", pi.Ingredient.Name, "error", err)
+		return false
+	}
+	logger.Info("Reduced pantry item", "ingredient", pi.Ingredient.Name, "reduction", -removed, "new_qty", pi.DerivedQuantity)
+	return true
+}
 
-		// Default unit to pcs if not specified
-		if unit == "" {
-			unit = "pcs"
+func findPantryItem(pantryItems []models.PantryItem, id uint) *models.PantryItem {
+	for i := range pantryItems {
+		if pantryItems[i].ID == id {
+			return &pantryItems[i]
 		}
-
-		return name, qty, unit
 	}
-
-	// Fallback: just return the whole string as name with qty=1
-	return ingredient, 1, "pcs"
+	return nil
 }
 
-// matchesIngredient checks if pantry ingredient matches the parsed ingredient name
-func matchesIngredient(pantryName, ingredientName string) bool {
-	pantryLower := strings.ToLower(pantryName)
-	ingredientLower := strings.ToLower(ingredientName)
+// ResolveMealIngredient applies an ingredient that LogMeal reported as
+// ambiguous to the pantry item the user picked.
+func ResolveMealIngredient(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserID(r)
+	if err != nil || userID == 0 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
 
-	// Exact match
-	if pantryLower == ingredientLower {
-		return true
+	var mealLog models.MealLog
+	if err := database.DB.Where("id = ? AND user_id = ?", chi.URLParam(r, "meal_id"), userID).First(&mealLog).Error; err != nil {
+		http.Error(w, "Meal log not found", http.StatusNotFound)
+		return
 	}
 
-	// Full substring match
-	if strings.Contains(pantryLower, ingredientLower) {
-		return true
+	var req struct {
+		Ingredient   string `json:"ingredient"`
+		PantryItemID uint   `json:"pantry_item_id"`
 	}
-	if strings.Contains(ingredientLower, pantryLower) {
-		return true
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Ingredient == "" || req.PantryItemID == 0 {
+		http.Error(w, "ingredient and pantry_item_id are required", http.StatusBadRequest)
+		return
 	}
 
-	// Keyword-based matching: extract key food words and match
-	// Common ingredient keywords to extract (last word is usually the ingredient)
-	ingredientWords := strings.Fields(ingredientLower)
-	pantryWords := strings.Fields(pantryLower)
-
-	// Check if any significant word from pantry matches ingredient words
-	for _, pw := range pantryWords {
-		if len(pw) < 3 { // Skip short words like "of", "in", etc.
-			continue
-		}
-		// Skip common modifiers
-		if isModifierWord(pw) {
-			continue
-		}
-		for _, iw := range ingredientWords {
-			if len(iw) < 3 {
-				continue
-			}
-			if isModifierWord(iw) {
-				continue
-			}
-			// Match if words are similar (handles typos like "broccoli" vs "broccoll")
-			if pw == iw || strings.HasPrefix(pw, iw) || strings.HasPrefix(iw, pw) {
-				return true
-			}
-			// Levenshtein-like: if differ by 1-2 chars, still match (for typos)
-			if len(pw) > 4 && len(iw) > 4 && similarWord(pw, iw) {
-				return true
-			}
+	var ingredients []string
+	json.Unmarshal([]byte(mealLog.Ingredients), &ingredients)
+	found := false
+	for _, ingredient := range ingredients {
+		if ingredient == req.Ingredient {
+			found = true
+			break
 		}
 	}
-
-	return false
-}
-
-// isModifierWord returns true for words that describe but don't identify ingredients
-func isModifierWord(word string) bool {
-	modifiers := map[string]bool{
-		"organic": true, "fresh": true, "raw": true, "cooked": true,
-		"firm": true, "soft": true, "whole": true, "sliced": true,
-		"diced": true, "minced": true, "chopped": true, "natural": true,
-		"premium": true, "artisanal": true, "homemade": true,
+	if !found {
+		http.Error(w, "Ingredient is not part of this meal", http.StatusBadRequest)
+		return
 	}
-	return modifiers[word]
-}
 
-// similarWord checks if two words differ by at most 2 characters (for typos)
-func similarWord(a, b string) bool {
-	if len(a) != len(b) && (len(a)-len(b) > 2 || len(b)-len(a) > 2) {
-		return false
+	var applied int64
+	database.DB.Model(&models.PantryMovement{}).
+		Where("meal_log_id = ? AND source = ? AND note = ?", mealLog.ID, models.MovementSourceMealLog, req.Ingredient).
+		Count(&applied)
+	if applied > 0 {
+		http.Error(w, "Ingredient already taken from the pantry", http.StatusConflict)
+		return
 	}
 
-	// Check if they share a common prefix of at least 5 chars
-	minLen := len(a)
-	if len(b) < minLen {
-		minLen = len(b)
-	}
-	if minLen < 5 {
-		return a == b
+	var pi models.PantryItem
+	if err := database.DB.Preload("Ingredient").Preload("Item").Where("id = ? AND user_id = ?", req.PantryItemID, userID).First(&pi).Error; err != nil {
+		http.Error(w, "Item not found in pantry", http.StatusNotFound)
+		return
 	}
 
-	commonPrefix := 0
-	for i := 0; i < minLen; i++ {
-		if a[i] == b[i] {
-			commonPrefix++
-		} else {
-			break
-		}
+	_, quantity, unit := parseIngredient(req.Ingredient)
+	if !consumeIngredient(&pi, &mealLog, req.Ingredient, quantity, unit) {
+		http.Error(w, "Cannot convert ingredient to the pantry item's unit", http.StatusBadRequest)
+		return
 	}
 
-	return commonPrefix >= minLen-2
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pi)
 }
 
-// convertToBaseUnit converts quantity to grams or ml based on units
-func convertToBaseUnit(quantity float64, unit string, ingredientName string) float64 {
-	switch strings.ToLower(unit) {
-	case "kg":
-		return quantity * 1000
-	case "l":
-		return quantity * 1000
-	case "cup", "cups":
-		// Approximate: 1 cup = 240ml for liquids, 150g for dry
-		if strings.Contains(strings.ToLower(ingredientName), "milk") ||
-			strings.Contains(strings.ToLower(ingredientName), "water") ||
-			strings.Contains(strings.ToLower(ingredientName), "juice") {
-			return quantity * 240
-		}
-		return quantity * 150
-	case "tbsp":
-		return quantity * 15
-	case "tsp":
-		return quantity * 5
-	case "pcs", "pc", "piece", "pieces", "":
-		return quantity
-	default:
-		return quantity
+// MatchPantryIngredient previews which pantry items an ingredient would be
+// taken from, e.g. GET /pantry/match?q=1 tbsp oil.
+func MatchPantryIngredient(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserID(r)
+	if err != nil || userID == 0 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	name, _, _ := parseIngredient(r.URL.Query().Get("q"))
+	if name == "" {
+		http.Error(w, "q is required", http.StatusBadRequest)
+		return
 	}
+
+	var pantryItems []models.PantryItem
+	database.DB.Preload("Ingredient").Where("user_id = ?", userID).Find(&pantryItems)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(services.MatchPantryItems(name, pantryItems))
 }
 
 // GetMealHistory returns all logged meals for the user
@@ -335,30 +354,64 @@ func DeleteMealLog(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Parse ingredients from the meal log
-	var ingredients []string
-	json.Unmarshal([]byte(mealLog.Ingredients), &ingredients)
-
-	// Restore pantry quantities
+	// Restore pantry quantities by reversing what the meal took out
 	restoredItems := []string{}
-	for _, ingredient := range ingredients {
-		ingredientName, quantity, unit := parseIngredient(ingredient)
-		if ingredientName == "" {
+	var movements []models.PantryMovement
+	database.DB.Where("meal_log_id = ? AND source = ?", mealLog.ID, models.MovementSourceMealLog).Find(&movements)
+
+	for _, m := range movements {
+		var pi models.PantryItem
+		if err := database.DB.Preload("Ingredient").Preload("Item").Where("id = ? AND user_id = ?", m.PantryItemID, userID).First(&pi).Error; err != nil {
 			continue
 		}
+		if _, err := services.ApplyMovement(database.DB, &pi, services.Movement{
+			Delta:     -m.Delta,
+			Unit:      m.Unit,
+			Source:    models.MovementSourceMealLog,
+			MealLogID: &mealLog.ID,
+			BatchID:   m.BatchID,
+			Note:      "meal deleted",
+		}); err != nil {
+			logger.Warn("Skipping pantry restoration", "ingredient", pi.Ingredient.Name, "error", err)
+			continue
+		}
+		restoredItems = append(restoredItems, pi.Ingredient.Name)
+		logger.Info("Restored pantry item", "ingredient", pi.Ingredient.Name, "restoration", -m.Delta, "new_qty", pi.DerivedQuantity)
+	}
+
+	// Meals logged before the pantry ledger existed have no movements;
+	// re-parse their ingredients instead.
+	if len(movements) == 0 {
+		var ingredients []string
+		json.Unmarshal([]byte(mealLog.Ingredients), &ingredients)
 
-		var pantryItems []models.PantryItem
-		database.DB.Preload("Ingredient").Where("user_id = ?", userID).Find(&pantryItems)
-
-		for _, pi := range pantryItems {
-			if matchesIngredient(pi.Ingredient.Name, ingredientName) {
-				restoration := convertToBaseUnit(quantity, unit, pi.Ingredient.Name)
-				newQty := pi.DerivedQuantity + restoration
-				database.DB.Model(&pi).Update("derived_quantity", newQty)
-				restoredItems = append(restoredItems, pi.Ingredient.Name)
-				logger.Info("Restored pantry item", "ingredient", pi.Ingredient.Name, "restoration", restoration, "new_qty", newQty)
-				break
+		for _, ingredient := range ingredients {
+			ingredientName, quantity, unit := parseIngredient(ingredient)
+			if ingredientName == "" {
+				continue
+			}
+
+			var pantryItems []models.PantryItem
+			database.DB.Preload("Ingredient").Preload("Item").Where("user_id = ?", userID).Find(&pantryItems)
+
+			match := services.MatchPantryItems(ingredientName, pantryItems)
+			if match.Best == nil {
+				continue
+			}
+			pi := findPantryItem(pantryItems, match.Best.PantryItemID)
+			restored, err := services.ApplyMovement(database.DB, pi, services.Movement{
+				Delta:     quantity,
+				Unit:      unit,
+				Source:    models.MovementSourceMealLog,
+				MealLogID: &mealLog.ID,
+				Note:      "meal deleted: " + ingredient,
+			})
+			if err != nil {
+				logger.Warn("Skipping pantry restoration", "ingredient", pi.Ingredient.Name, "error", err)
+				continue
 			}
+			restoredItems = append(restoredItems, pi.Ingredient.Name)
+			logger.Info("Restored pantry item", "ingredient", pi.Ingredient.Name, "restoration", restored, "new_qty", pi.DerivedQuantity)
 		}
 	}
 
@@ -0,0 +1,122 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/pmitra96/pateproject/database"
+	"github.com/pmitra96/pateproject/logger"
+	"github.com/pmitra96/pateproject/models"
+	"github.com/pmitra96/pateproject/services"
+	"gorm.io/gorm"
+)
+
+type AmendOrderRequest struct {
+	Items  []services.OrderLineChange `json:"items"`
+	Reason string                     `json:"reason"` // e.g. "not delivered", "refund"
+}
+
+type CancelOrderRequest struct {
+	Reason string `json:"reason"`
+}
+
+// AmendOrder adjusts or removes order lines and reverses their pantry effect.
+func AmendOrder(w http.ResponseWriter, r *http.Request) {
+	order, ok := findUserOrder(w, r)
+	if !ok {
+		return
+	}
+
+	var req AmendOrderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if len(req.Items) == 0 {
+		writeJSONError(w, http.StatusBadRequest, "items is required")
+		return
+	}
+	for _, change := range req.Items {
+		if change.OrderItemID == 0 || (change.Quantity == nil && !change.Remove) {
+			writeJSONError(w, http.StatusBadRequest, "Each item needs order_item_id and quantity or remove")
+			return
+		}
+	}
+
+	err := services.AmendOrder(database.DB, order, req.Items, req.Reason)
+	switch {
+	case errors.Is(err, services.ErrOrderCancelled):
+		writeJSONError(w, http.StatusConflict, err.Error())
+		return
+	case errors.Is(err, services.ErrOrderItemNotFound):
+		writeJSONError(w, http.StatusNotFound, err.Error())
+		return
+	case errors.Is(err, services.ErrUnitMismatch):
+		writeJSONError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	case err != nil:
+		logger.Error("Failed to amend order", "order_id", order.ID, "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to amend order")
+		return
+	}
+
+	logger.Info("Order amended", "order_id", order.ID, "user_id", order.UserID, "lines", len(req.Items), "reason", req.Reason)
+	writeOrder(w, order.ID)
+}
+
+// CancelOrder cancels an order and takes its items back out of the pantry.
+func CancelOrder(w http.ResponseWriter, r *http.Request) {
+	order, ok := findUserOrder(w, r)
+	if !ok {
+		return
+	}
+
+	var req CancelOrderRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+	}
+
+	err := services.CancelOrder(database.DB, order, req.Reason)
+	if errors.Is(err, services.ErrUnitMismatch) {
+		writeJSONError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	if err != nil {
+		logger.Error("Failed to cancel order", "order_id", order.ID, "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to cancel order")
+		return
+	}
+
+	logger.Info("Order cancelled", "order_id", order.ID, "user_id", order.UserID, "reason", req.Reason)
+	writeOrder(w, order.ID)
+}
+
+func findUserOrder(w http.ResponseWriter, r *http.Request) (*models.Order, bool) {
+	userID, err := getUserID(r)
+	if err != nil {
+		writeJSONError(w, http.StatusUnauthorized, "Unauthorized")
+		return nil, false
+	}
+
+	var order models.Order
+	if err := database.DB.Where("id = ? AND user_id = ?", chi.URLParam(r, "order_id"), userID).First(&order).Error; err != nil {
+		writeJSONError(w, http.StatusNotFound, "Order not found")
+		return nil, false
+	}
+	return &order, true
+}
+
+func writeOrder(w http.ResponseWriter, orderID uint) {
+	var order models.Order
+	database.DB.Preload("OrderItems.Item.Ingredient").Preload("OrderItems.Item.Brand").
+		Preload("StatusChanges", func(db *gorm.DB) *gorm.DB { return db.Order("created_at, id") }).
+		First(&order, orderID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(order)
+}
@@ -0,0 +1,180 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/pmitra96/pateproject/database"
+	"github.com/pmitra96/pateproject/logger"
+	"github.com/pmitra96/pateproject/models"
+	"github.com/pmitra96/pateproject/services"
+)
+
+const defaultExpiringWithin = 3 * 24 * time.Hour
+
+// parseWithin accepts a Go duration or a number of days ("3d").
+func parseWithin(s string) (time.Duration, error) {
+	if s == "" {
+		return defaultExpiringWithin, nil
+	}
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.ParseFloat(days, 64)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n * 24 * float64(time.Hour)), nil
+	}
+	return time.ParseDuration(s)
+}
+
+// GetExpiringBatches lists batches whose best-before date falls within the
+// window (default 3d), including ones already past it.
+func GetExpiringBatches(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserID(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	within, err := parseWithin(r.URL.Query().Get("within"))
+	if err != nil || within < 0 {
+		http.Error(w, "Invalid within, use e.g. 3d or 36h", http.StatusBadRequest)
+		return
+	}
+
+	batches, err := services.ExpiringBatches(database.DB, userID, within, time.Now())
+	if err != nil {
+		http.Error(w, "Failed to load batches", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(batches)
+}
+
+// GetPantryBatches lists a pantry item's batches, oldest first.
+func GetPantryBatches(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserID(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	itemID, err := strconv.Atoi(chi.URLParam(r, "item_id"))
+	if err != nil {
+		http.Error(w, "Invalid item ID", http.StatusBadRequest)
+		return
+	}
+
+	pantryItem, err := findUserPantryItem(userID, itemID)
+	if err != nil {
+		http.Error(w, "Item not found in pantry", http.StatusNotFound)
+		return
+	}
+
+	var batches []models.PantryBatch
+	database.DB.Where("pantry_item_id = ?", pantryItem.ID).Order("purchased_at, id").Find(&batches)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(batches)
+}
+
+// UpdatePantryBatch sets a batch's best-before date by hand.
+func UpdatePantryBatch(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserID(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	batch, ok := findUserBatch(w, r, userID)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		BestBefore *time.Time `json:"best_before"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid body", http.StatusBadRequest)
+		return
+	}
+
+	batch.BestBefore = req.BestBefore
+	batch.BestBeforeSource = models.BestBeforeManual
+	if err := database.DB.Save(&batch).Error; err != nil {
+		http.Error(w, "Failed to update batch", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(batch)
+}
+
+// DiscardPantryBatch removes what is left of a batch as waste or expiry.
+func DiscardPantryBatch(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserID(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	batch, ok := findUserBatch(w, r, userID)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Reason string `json:"reason"`
+		Note   string `json:"note"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+	if req.Reason == "" {
+		req.Reason = models.MovementSourceExpiry
+	}
+	if req.Reason != models.MovementSourceWaste && req.Reason != models.MovementSourceExpiry {
+		http.Error(w, "reason must be waste or expiry", http.StatusBadRequest)
+		return
+	}
+
+	var pantryItem models.PantryItem
+	if err := database.DB.Preload("Ingredient").Preload("Item").First(&pantryItem, batch.PantryItemID).Error; err != nil {
+		http.Error(w, "Item not found in pantry", http.StatusNotFound)
+		return
+	}
+
+	removed, err := services.ApplyMovement(database.DB, &pantryItem, services.Movement{
+		Delta:   -batch.Remaining,
+		Unit:    batch.Unit,
+		Source:  req.Reason,
+		BatchID: &batch.ID,
+		Note:    req.Note,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	logger.Info("Discarded pantry batch", "user_id", userID, "batch_id", batch.ID, "reason", req.Reason, "quantity", -removed)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pantryItem)
+}
+
+func findUserBatch(w http.ResponseWriter, r *http.Request, userID uint) (models.PantryBatch, bool) {
+	var batch models.PantryBatch
+	batchID, err := strconv.ParseUint(chi.URLParam(r, "batch_id"), 10, 32)
+	if err != nil {
+		http.Error(w, "Invalid batch ID", http.StatusBadRequest)
+		return batch, false
+	}
+	if err := database.DB.Where("id = ? AND user_id = ?", batchID, userID).First(&batch).Error; err != nil {
+		http.Error(w, "Batch not found", http.StatusNotFound)
+		return batch, false
+	}
+	return batch, true
+}
@@ -3,10 +3,12 @@ package controllers
 import (
 	"encoding/json"
 	"net/http"
+	"strings"
 
 	"github.com/pmitra96/pateproject/database"
 	"github.com/pmitra96/pateproject/logger"
 	"github.com/pmitra96/pateproject/models"
+	"github.com/pmitra96/pateproject/services"
 )
 
 type UserPreferencesRequest struct {
@@ -14,6 +16,12 @@ type UserPreferencesRequest struct {
 	State             string   `json:"state"`
 	City              string   `json:"city"`
 	PreferredCuisines []string `json:"preferred_cuisines"`
+	// Reorder thresholds; omitted values are left unchanged.
+	ReorderLeadDays  *float64 `json:"reorder_lead_days"`
+	ReorderCoverDays *float64 `json:"reorder_cover_days"`
+	// Diet and allergies; omitted values are left unchanged.
+	Diet           *string  `json:"diet"`
+	AvoidAllergens []string `json:"avoid_allergens"`
 }
 
 type UserPreferencesResponse struct {
@@ -22,6 +30,10 @@ type UserPreferencesResponse struct {
 	State             string   `json:"state"`
 	City              string   `json:"city"`
 	PreferredCuisines []string `json:"preferred_cuisines"`
+	ReorderLeadDays   float64  `json:"reorder_lead_days"`
+	ReorderCoverDays  float64  `json:"reorder_cover_days"`
+	Diet              string   `json:"diet"`
+	AvoidAllergens    []string `json:"avoid_allergens"`
 }
 
 // GetUserPreferences fetches user preferences
@@ -41,9 +53,13 @@ func GetUserPreferences(w http.ResponseWriter, r *http.Request) {
 
 	if result.Error != nil {
 		// Return empty preferences if not found
+		defaults := services.DefaultForecastSettings()
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(UserPreferencesResponse{
 			PreferredCuisines: []string{},
+			ReorderLeadDays:   defaults.LeadDays,
+			ReorderCoverDays:  defaults.CoverDays,
+			AvoidAllergens:    []string{},
 		})
 		return
 	}
@@ -63,6 +79,10 @@ func GetUserPreferences(w http.ResponseWriter, r *http.Request) {
 		State:             prefs.State,
 		City:              prefs.City,
 		PreferredCuisines: cuisines,
+		ReorderLeadDays:   prefs.ReorderLeadDays,
+		ReorderCoverDays:  prefs.ReorderCoverDays,
+		Diet:              prefs.Diet,
+		AvoidAllergens:    splitList(prefs.AvoidAllergens),
 	})
 }
 
@@ -86,6 +106,28 @@ func UpdateUserPreferences(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if (req.ReorderLeadDays != nil && *req.ReorderLeadDays < 0) || (req.ReorderCoverDays != nil && *req.ReorderCoverDays < 0) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Reorder days cannot be negative"})
+		return
+	}
+
+	if req.Diet != nil && !services.IsDiet(*req.Diet) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Unknown diet"})
+		return
+	}
+	for _, a := range req.AvoidAllergens {
+		if !services.IsAllergen(a) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Unknown allergen: " + a})
+			return
+		}
+	}
+
 	// Serialize cuisines to JSON
 	cuisinesJSON, _ := json.Marshal(req.PreferredCuisines)
 
@@ -101,6 +143,20 @@ func UpdateUserPreferences(w http.ResponseWriter, r *http.Request) {
 			City:              req.City,
 			PreferredCuisines: string(cuisinesJSON),
 		}
+		defaults := services.DefaultForecastSettings()
+		prefs.ReorderLeadDays, prefs.ReorderCoverDays = defaults.LeadDays, defaults.CoverDays
+		if req.ReorderLeadDays != nil {
+			prefs.ReorderLeadDays = *req.ReorderLeadDays
+		}
+		if req.ReorderCoverDays != nil {
+			prefs.ReorderCoverDays = *req.ReorderCoverDays
+		}
+		if req.Diet != nil {
+			prefs.Diet = *req.Diet
+		}
+		if req.AvoidAllergens != nil {
+			prefs.AvoidAllergens = strings.Join(req.AvoidAllergens, ",")
+		}
 		if err := database.DB.Create(&prefs).Error; err != nil {
 			logger.Error("Failed to create user preferences", "error", err)
 			w.Header().Set("Content-Type", "application/json")
@@ -114,6 +170,18 @@ func UpdateUserPreferences(w http.ResponseWriter, r *http.Request) {
 		prefs.State = req.State
 		prefs.City = req.City
 		prefs.PreferredCuisines = string(cuisinesJSON)
+		if req.ReorderLeadDays != nil {
+			prefs.ReorderLeadDays = *req.ReorderLeadDays
+		}
+		if req.ReorderCoverDays != nil {
+			prefs.ReorderCoverDays = *req.ReorderCoverDays
+		}
+		if req.Diet != nil {
+			prefs.Diet = *req.Diet
+		}
+		if req.AvoidAllergens != nil {
+			prefs.AvoidAllergens = strings.Join(req.AvoidAllergens, ",")
+		}
 		if err := database.DB.Save(&prefs).Error; err != nil {
 			logger.Error("Failed to update user preferences", "error", err)
 			w.Header().Set("Content-Type", "application/json")
@@ -132,5 +200,21 @@ func UpdateUserPreferences(w http.ResponseWriter, r *http.Request) {
 		State:             prefs.State,
 		City:              prefs.City,
 		PreferredCuisines: req.PreferredCuisines,
+		ReorderLeadDays:   prefs.ReorderLeadDays,
+		ReorderCoverDays:  prefs.ReorderCoverDays,
+		Diet:              prefs.Diet,
+		AvoidAllergens:    splitList(prefs.AvoidAllergens),
 	})
 }
+
+// splitList splits a comma-separated list stored on a model, dropping
+// empty entries.
+func splitList(list string) []string {
+	values := []string{}
+	for _, v := range strings.Split(list, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
@@ -290,6 +290,10 @@ func CheckFoodPermissionHandler(w http.ResponseWriter, r *http.Request) {
 		Fat      float64 `json:"fat"`
 		Carbs    float64 `json:"carbs"`
 		Name     string  `json:"name"` // Fallback name
+		// A catalog item and amount, e.g. a pantry item about to be eaten
+		ItemID   uint    `json:"item_id"`
+		Quantity float64 `json:"quantity"`
+		Unit     string  `json:"unit"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -308,7 +312,24 @@ func CheckFoodPermissionHandler(w http.ResponseWriter, r *http.Request) {
 	// 2. Determine FoodEstimate
 	var food services.FoodEstimate
 
-	if req.Query != "" {
+	if req.ItemID != 0 {
+		var item models.Item
+		if err := database.DB.Preload("Ingredient").Preload("Nutrients").First(&item, req.ItemID).Error; err != nil {
+			http.Error(w, "Item not found", http.StatusNotFound)
+			return
+		}
+		quantity, unit := req.Quantity, req.Unit
+		if quantity <= 0 {
+			basis := services.BasisOf(&item)
+			quantity, unit = basis.Amount, basis.Unit
+		}
+		estimated, err := services.EstimateForItem(&item, quantity, unit)
+		if err != nil {
+			http.Error(w, "Cannot work out nutrition for that amount: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		food = *estimated
+	} else if req.Query != "" {
 		// Use automatic estimation
 		ns := services.NewNutritionService()
 		estimated, err := ns.EstimateNutritionFromQuery(req.Query)
@@ -329,8 +350,13 @@ func CheckFoodPermissionHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// 3. Check permission
-	result := services.CheckFoodPermission(state, food)
+	// 3. Check permission, against the user's diet and allergies first
+	var prefs *models.UserPreferences
+	var userPrefs models.UserPreferences
+	if err := database.DB.Where("user_id = ?", userID).First(&userPrefs).Error; err == nil {
+		prefs = &userPrefs
+	}
+	result := services.CheckFoodPermissionForUser(state, prefs, food)
 
 	// 4. Compute Simulated State
 	// Clone current state to simulate impact
@@ -0,0 +1,202 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/pmitra96/pateproject/database"
+	"github.com/pmitra96/pateproject/jobs"
+	"github.com/pmitra96/pateproject/logger"
+	"github.com/pmitra96/pateproject/models"
+	"github.com/pmitra96/pateproject/services"
+	"github.com/pmitra96/pateproject/units"
+)
+
+// UpdateExtractionReviewRequest corrects the split of a product name, or
+// the quantity and unit of a line held back for a unit mismatch. Only the
+// fields that are set are changed.
+type UpdateExtractionReviewRequest struct {
+	Ingredient *string  `json:"ingredient"`
+	Brand      *string  `json:"brand"`
+	Product    *string  `json:"product"`
+	Category   *string  `json:"category"`
+	Quantity   *float64 `json:"quantity"`
+	Unit       *string  `json:"unit"`
+}
+
+// GetExtractionReviews lists the user's extraction reviews, pending by default.
+func GetExtractionReviews(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserID(r)
+	if err != nil {
+		writeJSONError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = models.ReviewPending
+	}
+
+	query := database.DB.Where("user_id = ?", userID).Order("created_at DESC")
+	if status != "all" {
+		query = query.Where("status = ?", status)
+	}
+	if orderID := r.URL.Query().Get("order_id"); orderID != "" {
+		query = query.Where("order_id = ?", orderID)
+	}
+
+	var reviews []models.ExtractionReview
+	query.Find(&reviews)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reviews)
+}
+
+// UpdateExtractionReview edits a pending review before it is approved.
+func UpdateExtractionReview(w http.ResponseWriter, r *http.Request) {
+	review, ok := findPendingReview(w, r)
+	if !ok {
+		return
+	}
+
+	var req UpdateExtractionReviewRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if !applyReviewEdits(w, review, req) {
+		return
+	}
+	if err := database.DB.Save(review).Error; err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to update review")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(review)
+}
+
+// ApproveExtractionReview adds the order line to the catalog and pantry,
+// optionally applying last-minute edits from the body.
+func ApproveExtractionReview(w http.ResponseWriter, r *http.Request) {
+	review, ok := findPendingReview(w, r)
+	if !ok {
+		return
+	}
+
+	var req UpdateExtractionReviewRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+	}
+	if !applyReviewEdits(w, review, req) {
+		return
+	}
+	if review.Ingredient == "" {
+		writeJSONError(w, http.StatusBadRequest, "ingredient is required")
+		return
+	}
+
+	item, err := services.ApproveReview(database.DB, review)
+	if errors.Is(err, services.ErrReviewClosed) {
+		writeJSONError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if errors.Is(err, services.ErrUnitMismatch) {
+		writeJSONError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	if err != nil {
+		logger.Error("Failed to approve extraction review", "review_id", review.ID, "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to approve review")
+		return
+	}
+
+	if _, err := services.CheckOffPurchased(database.DB, review.UserID, review.OrderID, []uint{item.IngredientID}); err != nil {
+		logger.Error("Failed to check off shopping list items", "order_id", review.OrderID, "error", err)
+	}
+	jobs.GetWorker().Enqueue(item.ID)
+
+	logger.Info("Extraction review approved", "review_id", review.ID, "raw_name", review.RawName, "ingredient", review.Ingredient)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(review)
+}
+
+// RejectExtractionReview drops the order line without touching the catalog.
+func RejectExtractionReview(w http.ResponseWriter, r *http.Request) {
+	review, ok := findPendingReview(w, r)
+	if !ok {
+		return
+	}
+
+	if err := services.RejectReview(database.DB, review); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to reject review")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(review)
+}
+
+// findPendingReview loads the user's review from the path, writing the
+// error response itself when there is none or it was already decided.
+func findPendingReview(w http.ResponseWriter, r *http.Request) (*models.ExtractionReview, bool) {
+	userID, err := getUserID(r)
+	if err != nil {
+		writeJSONError(w, http.StatusUnauthorized, "Unauthorized")
+		return nil, false
+	}
+
+	var review models.ExtractionReview
+	if err := database.DB.Where("id = ? AND user_id = ?", chi.URLParam(r, "review_id"), userID).First(&review).Error; err != nil {
+		writeJSONError(w, http.StatusNotFound, "Review not found")
+		return nil, false
+	}
+	if review.Status != models.ReviewPending {
+		writeJSONError(w, http.StatusConflict, services.ErrReviewClosed.Error())
+		return nil, false
+	}
+	return &review, true
+}
+
+func applyReviewEdits(w http.ResponseWriter, review *models.ExtractionReview, req UpdateExtractionReviewRequest) bool {
+	if req.Ingredient != nil {
+		ingredient := strings.TrimSpace(*req.Ingredient)
+		if ingredient == "" {
+			writeJSONError(w, http.StatusBadRequest, "ingredient cannot be empty")
+			return false
+		}
+		review.Ingredient = ingredient
+	}
+	if req.Brand != nil {
+		review.Brand = strings.TrimSpace(*req.Brand)
+	}
+	if req.Product != nil {
+		review.Product = strings.TrimSpace(*req.Product)
+	}
+	if req.Category != nil {
+		review.Category = strings.TrimSpace(*req.Category)
+	}
+	if req.Quantity != nil {
+		if *req.Quantity <= 0 {
+			writeJSONError(w, http.StatusBadRequest, "quantity must be positive")
+			return false
+		}
+		review.Quantity = *req.Quantity
+	}
+	if req.Unit != nil {
+		unit := strings.TrimSpace(*req.Unit)
+		if _, ok := units.Lookup(unit); !ok || unit == "" {
+			writeJSONError(w, http.StatusBadRequest, "Unknown unit")
+			return false
+		}
+		review.Unit = unit
+	}
+	return true
+}
@@ -0,0 +1,465 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/pmitra96/pateproject/database"
+	"github.com/pmitra96/pateproject/logger"
+	"github.com/pmitra96/pateproject/models"
+	"github.com/pmitra96/pateproject/services"
+	"github.com/pmitra96/pateproject/units"
+	"gorm.io/gorm"
+)
+
+type CreateShoppingListRequest struct {
+	Name string `json:"name"`
+	// FromLowStock fills the new list from the reorder forecast.
+	FromLowStock bool `json:"from_low_stock"`
+}
+
+type ShoppingListItemRequest struct {
+	Ingredient string   `json:"ingredient"`
+	Brand      string   `json:"brand,omitempty"`
+	Quantity   *float64 `json:"quantity"`
+	Unit       string   `json:"unit"`
+	Note       string   `json:"note,omitempty"`
+	Checked    *bool    `json:"checked,omitempty"`
+}
+
+// PlannedMeal is an accepted meal suggestion whose ingredients should be
+// in stock, e.g. {"name": "Paneer Bhurji", "ingredients": ["200g Paneer", "2 Tomato"]}.
+type PlannedMeal struct {
+	Name        string   `json:"name"`
+	Ingredients []string `json:"ingredients"`
+}
+
+type PopulateShoppingListRequest struct {
+	LowStock bool          `json:"low_stock"`
+	Meals    []PlannedMeal `json:"meals"`
+}
+
+// GetShoppingLists lists the user's shopping lists, newest first.
+func GetShoppingLists(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserID(r)
+	if err != nil {
+		writeJSONError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	query := database.DB.Where("user_id = ?", userID)
+	if status := r.URL.Query().Get("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var lists []models.ShoppingList
+	query.Order("created_at desc").Find(&lists)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lists)
+}
+
+// CreateShoppingList starts a new list, optionally filled from low stock.
+func CreateShoppingList(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserID(r)
+	if err != nil {
+		writeJSONError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req CreateShoppingListRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if strings.TrimSpace(req.Name) == "" {
+		req.Name = "Shopping " + time.Now().Format("2 Jan")
+	}
+
+	list := models.ShoppingList{UserID: userID, Name: strings.TrimSpace(req.Name), Status: models.ShoppingListOpen}
+	if err := database.DB.Create(&list).Error; err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to create shopping list")
+		return
+	}
+
+	if req.FromLowStock {
+		if _, err := services.PopulateFromForecast(database.DB, userID, list.ID, time.Now()); err != nil {
+			logger.Error("Failed to populate shopping list from low stock", "list_id", list.ID, "error", err)
+		}
+	}
+
+	writeShoppingList(w, http.StatusCreated, list.ID)
+}
+
+// GetShoppingList returns a list with its items grouped by ingredient and brand.
+func GetShoppingList(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserID(r)
+	if err != nil {
+		writeJSONError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	list, ok := findUserShoppingList(w, r, userID)
+	if !ok {
+		return
+	}
+	writeShoppingList(w, http.StatusOK, list.ID)
+}
+
+// UpdateShoppingList renames a list or marks it completed.
+func UpdateShoppingList(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserID(r)
+	if err != nil {
+		writeJSONError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	list, ok := findUserShoppingList(w, r, userID)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Name   *string `json:"name"`
+		Status *string `json:"status"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if req.Name != nil && strings.TrimSpace(*req.Name) != "" {
+		list.Name = strings.TrimSpace(*req.Name)
+	}
+	if req.Status != nil {
+		if *req.Status != models.ShoppingListOpen && *req.Status != models.ShoppingListCompleted {
+			writeJSONError(w, http.StatusBadRequest, "status must be open or completed")
+			return
+		}
+		list.Status = *req.Status
+	}
+	if err := database.DB.Save(&list).Error; err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to update shopping list")
+		return
+	}
+
+	writeShoppingList(w, http.StatusOK, list.ID)
+}
+
+// DeleteShoppingList removes a list and its items.
+func DeleteShoppingList(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserID(r)
+	if err != nil {
+		writeJSONError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	list, ok := findUserShoppingList(w, r, userID)
+	if !ok {
+		return
+	}
+
+	database.DB.Where("list_id = ?", list.ID).Delete(&models.ShoppingListItem{})
+	if err := database.DB.Delete(&list).Error; err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to delete shopping list")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AddShoppingListItem adds an ingredient by name. Without a brand, the
+// brand and representative item of the matching pantry entry are used.
+func AddShoppingListItem(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserID(r)
+	if err != nil {
+		writeJSONError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	list, ok := findUserShoppingList(w, r, userID)
+	if !ok {
+		return
+	}
+
+	var req ShoppingListItemRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Ingredient) == "" {
+		writeJSONError(w, http.StatusBadRequest, "ingredient is required")
+		return
+	}
+	quantity := 1.0
+	if req.Quantity != nil {
+		quantity = *req.Quantity
+	}
+	if quantity <= 0 {
+		writeJSONError(w, http.StatusBadRequest, "quantity must be positive")
+		return
+	}
+
+	entry := shoppingEntryFor(userID, req.Ingredient)
+	if req.Brand != "" {
+		brand, err := services.ResolveBrand(database.DB, req.Brand)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to resolve brand")
+			return
+		}
+		entry.BrandID, entry.ItemID = &brand.ID, nil
+	}
+	entry.Quantity, entry.Unit = quantity, req.Unit
+	entry.Source = models.ShoppingSourceManual
+	entry.Note = req.Note
+
+	if _, err := services.AddShoppingEntry(database.DB, list.ID, entry); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to add item")
+		return
+	}
+	writeShoppingList(w, http.StatusCreated, list.ID)
+}
+
+// UpdateShoppingListItem changes an entry's quantity, unit, note or checked state.
+func UpdateShoppingListItem(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserID(r)
+	if err != nil {
+		writeJSONError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	list, ok := findUserShoppingList(w, r, userID)
+	if !ok {
+		return
+	}
+
+	var item models.ShoppingListItem
+	if err := database.DB.Where("id = ? AND list_id = ?", chi.URLParam(r, "entry_id"), list.ID).First(&item).Error; err != nil {
+		writeJSONError(w, http.StatusNotFound, "Shopping list item not found")
+		return
+	}
+
+	var req ShoppingListItemRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if req.Quantity != nil {
+		if *req.Quantity <= 0 {
+			writeJSONError(w, http.StatusBadRequest, "quantity must be positive")
+			return
+		}
+		item.Quantity = *req.Quantity
+		if req.Unit != "" {
+			item.Quantity, item.Unit = units.Normalize(*req.Quantity, req.Unit)
+		}
+	}
+	if req.Note != "" {
+		item.Note = req.Note
+	}
+	if req.Checked != nil && *req.Checked != item.Checked {
+		item.Checked = *req.Checked
+		item.CheckedOrderID = nil
+		item.CheckedAt = nil
+		if item.Checked {
+			now := time.Now()
+			item.CheckedAt = &now
+		}
+	}
+
+	if err := database.DB.Save(&item).Error; err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to update item")
+		return
+	}
+	writeShoppingList(w, http.StatusOK, list.ID)
+}
+
+// DeleteShoppingListItem removes one entry.
+func DeleteShoppingListItem(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserID(r)
+	if err != nil {
+		writeJSONError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	list, ok := findUserShoppingList(w, r, userID)
+	if !ok {
+		return
+	}
+
+	result := database.DB.Where("id = ? AND list_id = ?", chi.URLParam(r, "entry_id"), list.ID).Delete(&models.ShoppingListItem{})
+	if result.Error != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to delete item")
+		return
+	}
+	if result.RowsAffected == 0 {
+		writeJSONError(w, http.StatusNotFound, "Shopping list item not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// PopulateShoppingList adds low-stock items and/or the shortfall of planned
+// meals: what each accepted suggestion needs beyond what the pantry holds.
+func PopulateShoppingList(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserID(r)
+	if err != nil {
+		writeJSONError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	list, ok := findUserShoppingList(w, r, userID)
+	if !ok {
+		return
+	}
+
+	var req PopulateShoppingListRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if req.LowStock {
+		if _, err := services.PopulateFromForecast(database.DB, userID, list.ID, time.Now()); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to populate from low stock")
+			return
+		}
+	}
+
+	for _, meal := range req.Meals {
+		for _, entry := range mealShortfall(userID, meal) {
+			if _, err := services.AddShoppingEntry(database.DB, list.ID, entry); err != nil {
+				writeJSONError(w, http.StatusInternalServerError, "Failed to add meal ingredients")
+				return
+			}
+		}
+	}
+
+	writeShoppingList(w, http.StatusOK, list.ID)
+}
+
+// mealShortfall works out, per ingredient of the meal, how much more is
+// needed than the pantry currently holds.
+func mealShortfall(userID uint, meal PlannedMeal) []services.ShoppingEntry {
+	var pantryItems []models.PantryItem
+	database.DB.Preload("Ingredient").Preload("Item").Where("user_id = ?", userID).Find(&pantryItems)
+
+	var entries []services.ShoppingEntry
+	for _, ingredient := range meal.Ingredients {
+		name, quantity, unit := parseIngredient(ingredient)
+		if name == "" {
+			continue
+		}
+
+		// A close call between pantry items is still counted against the
+		// top one; over-buying is cheaper than a failed meal.
+		var match *models.PantryItem
+		if result := services.MatchPantryItems(name, pantryItems); len(result.Candidates) > 0 && result.Candidates[0].Score >= services.MatchConfidentScore {
+			match = findPantryItem(pantryItems, result.Candidates[0].PantryItemID)
+		}
+
+		if match == nil {
+			entry := shoppingEntryFor(userID, name)
+			entry.Quantity, entry.Unit = quantity, unit
+			entry.Source, entry.Note = models.ShoppingSourceMeal, meal.Name
+			entries = append(entries, entry)
+			continue
+		}
+
+		need, err := services.ToPantryUnit(match, quantity, unit)
+		if err != nil {
+			logger.Warn("Cannot compare meal ingredient with pantry", "ingredient", ingredient, "error", err)
+			continue
+		}
+		shortfall := need - match.EffectiveQuantity()
+		if shortfall <= 0 {
+			continue
+		}
+		itemID := match.ItemID
+		entries = append(entries, services.ShoppingEntry{
+			IngredientID: match.IngredientID,
+			BrandID:      match.Item.BrandID,
+			ItemID:       &itemID,
+			Quantity:     shortfall,
+			Unit:         services.PantryUnit(match),
+			Source:       models.ShoppingSourceMeal,
+			Note:         meal.Name,
+		})
+	}
+	return entries
+}
+
+// shoppingEntryFor resolves an ingredient name to an entry, preferring the
+// brand and representative item of the user's pantry entry for it.
+func shoppingEntryFor(userID uint, name string) services.ShoppingEntry {
+	ingredient, err := services.ResolveIngredient(database.DB, name)
+	if err != nil {
+		logger.Error("Failed to resolve ingredient", "name", name, "error", err)
+	}
+
+	entry := services.ShoppingEntry{IngredientID: ingredient.ID}
+	var pantryItem models.PantryItem
+	if err := database.DB.Preload("Item").Where("user_id = ? AND ingredient_id = ?", userID, ingredient.ID).First(&pantryItem).Error; err == nil {
+		itemID := pantryItem.ItemID
+		entry.ItemID = &itemID
+		entry.BrandID = pantryItem.Item.BrandID
+	}
+	return entry
+}
+
+func findUserShoppingList(w http.ResponseWriter, r *http.Request, userID uint) (models.ShoppingList, bool) {
+	var list models.ShoppingList
+	listID, err := strconv.ParseUint(chi.URLParam(r, "list_id"), 10, 32)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid list ID")
+		return list, false
+	}
+	if err := database.DB.Where("id = ? AND user_id = ?", listID, userID).First(&list).Error; err != nil {
+		writeJSONError(w, http.StatusNotFound, "Shopping list not found")
+		return list, false
+	}
+	return list, true
+}
+
+// shoppingListEntry is a list item with where it was cheapest recently.
+type shoppingListEntry struct {
+	models.ShoppingListItem
+	CheapestSource *services.SourcePrice `json:"cheapest_source,omitempty"`
+}
+
+type shoppingListResponse struct {
+	models.ShoppingList
+	Items []shoppingListEntry `json:"items"`
+}
+
+// writeShoppingList responds with the list, its items ordered so that
+// entries for the same ingredient and brand sit together. Unchecked items
+// are annotated with their cheapest recent source.
+func writeShoppingList(w http.ResponseWriter, status int, listID uint) {
+	var list models.ShoppingList
+	err := database.DB.Preload("Items", func(db *gorm.DB) *gorm.DB {
+		return db.Select("shopping_list_items.*").
+			Joins("JOIN ingredients ON ingredients.id = shopping_list_items.ingredient_id").
+			Order("shopping_list_items.checked, ingredients.name, shopping_list_items.brand_id")
+	}).Preload("Items.Ingredient").Preload("Items.Brand").Preload("Items.Item").First(&list, listID).Error
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to load shopping list")
+		return
+	}
+
+	var ingredientIDs []uint
+	for _, item := range list.Items {
+		if !item.Checked {
+			ingredientIDs = append(ingredientIDs, item.IngredientID)
+		}
+	}
+	cheapest, err := services.FindCheapestSources(database.DB, list.UserID, ingredientIDs, time.Now())
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to compare prices")
+		return
+	}
+
+	resp := shoppingListResponse{ShoppingList: list, Items: []shoppingListEntry{}}
+	for _, item := range list.Items {
+		entry := shoppingListEntry{ShoppingListItem: item}
+		if !item.Checked {
+			entry.CheapestSource = cheapest.For(item.IngredientID, item.BrandID)
+		}
+		resp.Items = append(resp.Items, entry)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,173 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pmitra96/pateproject/database"
+	"github.com/pmitra96/pateproject/models"
+	"github.com/pmitra96/pateproject/services"
+)
+
+const defaultSpendWindow = 12 // months
+
+// spendPeriod reads ?from= and ?to= (YYYY-MM-DD or YYYY-MM, to exclusive),
+// defaulting to the last 12 months.
+func spendPeriod(w http.ResponseWriter, r *http.Request) (time.Time, time.Time, bool) {
+	now := time.Now()
+	from := time.Date(now.Year(), now.Month()-defaultSpendWindow+1, 1, 0, 0, 0, 0, time.UTC)
+	to := now.Add(24 * time.Hour)
+
+	for _, p := range []struct {
+		name string
+		dst  *time.Time
+	}{{"from", &from}, {"to", &to}} {
+		v := r.URL.Query().Get(p.name)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			t, err = time.Parse("2006-01", v)
+		}
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, p.name+" must be YYYY-MM-DD or YYYY-MM")
+			return from, to, false
+		}
+		*p.dst = t
+	}
+	return from, to, true
+}
+
+// GetSpend groups the user's grocery spend by month (default), provider,
+// category or brand.
+func GetSpend(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserID(r)
+	if err != nil {
+		writeJSONError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	from, to, ok := spendPeriod(w, r)
+	if !ok {
+		return
+	}
+	by := r.URL.Query().Get("by")
+	if by == "" {
+		by = services.SpendByMonth
+	}
+
+	summary, err := services.Spend(database.DB, userID, by, from, to)
+	if errors.Is(err, services.ErrUnknownSpendGrouping) {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to compute spend")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summary)
+}
+
+// GetPriceTrend lists what the user paid per kg, litre or piece for an
+// ingredient (?ingredient= ID or name) over time.
+func GetPriceTrend(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserID(r)
+	if err != nil {
+		writeJSONError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	from, to, ok := spendPeriod(w, r)
+	if !ok {
+		return
+	}
+
+	ingredient, ok := ingredientFromQuery(w, r)
+	if !ok {
+		return
+	}
+
+	points, err := services.PriceTrend(database.DB, userID, ingredient.ID, from, to)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to load prices")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"ingredient_id": ingredient.ID,
+		"ingredient":    ingredient.Name,
+		"points":        points,
+	})
+}
+
+// GetPriceComparison answers "where is X cheapest": each provider's latest
+// unit price for ?ingredient=, optionally of one ?brand=, from the user's
+// recent orders and the scraped catalogue (unless ?catalogue=false).
+func GetPriceComparison(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserID(r)
+	if err != nil {
+		writeJSONError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	ingredient, ok := ingredientFromQuery(w, r)
+	if !ok {
+		return
+	}
+
+	var brand *models.Brand
+	if ref := strings.TrimSpace(r.URL.Query().Get("brand")); ref != "" {
+		var found models.Brand
+		if id, err := strconv.ParseUint(ref, 10, 32); err == nil && database.DB.First(&found, id).Error == nil {
+			brand = &found
+		} else if database.DB.Where("LOWER(name) = ?", strings.ToLower(ref)).First(&found).Error == nil {
+			brand = &found
+		} else if aliased, ok := services.LookupBrandAlias(database.DB, ref); ok {
+			brand = aliased
+		} else {
+			writeJSONError(w, http.StatusNotFound, "Brand not found")
+			return
+		}
+	}
+
+	now := time.Now()
+	withCatalogue := r.URL.Query().Get("catalogue") != "false"
+	comparison, err := services.ComparePrices(database.DB, userID, *ingredient, brand, now.Add(-services.PriceWindow()), now, withCatalogue)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to compare prices")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(comparison)
+}
+
+// ingredientFromQuery resolves ?ingredient= as an ingredient ID, a name or
+// a catalog alias.
+func ingredientFromQuery(w http.ResponseWriter, r *http.Request) (*models.Ingredient, bool) {
+	ref := strings.TrimSpace(r.URL.Query().Get("ingredient"))
+	if ref == "" {
+		writeJSONError(w, http.StatusBadRequest, "ingredient is required")
+		return nil, false
+	}
+
+	var ingredient models.Ingredient
+	if id, err := strconv.ParseUint(ref, 10, 32); err == nil {
+		if database.DB.First(&ingredient, id).Error == nil {
+			return &ingredient, true
+		}
+	} else if database.DB.Where("LOWER(name) = ?", strings.ToLower(ref)).First(&ingredient).Error == nil {
+		return &ingredient, true
+	} else if aliased, ok := services.LookupIngredientAlias(database.DB, ref); ok {
+		return aliased, true
+	}
+
+	writeJSONError(w, http.StatusNotFound, "Ingredient not found")
+	return nil, false
+}
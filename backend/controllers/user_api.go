@@ -1,11 +1,11 @@
 package controllers
 
 import (
-	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
-	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/pmitra96/pateproject/database"
@@ -13,99 +13,91 @@ import (
 	"github.com/pmitra96/pateproject/logger"
 	"github.com/pmitra96/pateproject/middleware"
 	"github.com/pmitra96/pateproject/models"
+	"github.com/pmitra96/pateproject/services"
+	"github.com/pmitra96/pateproject/units"
+	"gorm.io/gorm"
 )
 
+// errAccountLinkRequired means the caller's identity is new but its email
+// already belongs to a user; they must sign in with that user's provider
+// and link this identity explicitly.
+var errAccountLinkRequired = errors.New("account exists for this email; link required")
+
+// getUserID resolves the caller to a local user. API keys and signed
+// webhook sources act on behalf of their owner; OAuth principals are mapped through UserIdentity, with a
+// user and identity auto-provisioned on first login.
 func getUserID(r *http.Request) (uint, error) {
-	val := r.Context().Value(middleware.UserContextKey)
-	if val == nil {
-		return 0, http.ErrNoCookie
+	if key, ok := middleware.APIKeyFromContext(r.Context()); ok {
+		return key.UserID, nil
+	}
+	if source, ok := middleware.WebhookSourceFromContext(r.Context()); ok {
+		return source.UserID, nil
 	}
-	idStr, ok := val.(string)
+
+	principal, ok := middleware.PrincipalFromContext(r.Context())
 	if !ok {
 		return 0, http.ErrNoCookie
 	}
 
-	var claims map[string]interface{}
-	searchIDs := []string{idStr}
-
-	// If it looks like a JWT, we need the "sub" claim
-	if strings.Contains(idStr, ".") {
-		parts := strings.Split(idStr, ".")
-		if len(parts) >= 2 {
-			payload, err := base64.RawURLEncoding.DecodeString(parts[1])
-			if err == nil {
-				if err := json.Unmarshal(payload, &claims); err == nil {
-					if sub, ok := claims["sub"].(string); ok {
-						searchIDs = append(searchIDs, sub)
-					}
-				}
-			}
-		}
+	// 1. Known identity
+	var identity models.UserIdentity
+	if err := database.DB.Where("provider = ? AND external_id = ?", principal.Provider, principal.Subject).First(&identity).Error; err == nil {
+		return identity.UserID, nil
+	} else if err != gorm.ErrRecordNotFound {
+		return 0, err
 	}
 
-	// 1. Try UserIdentities table (exact match or extracted sub)
-	var identity models.UserIdentity
-	for _, sid := range searchIDs {
-		if err := database.DB.Where("external_id = ?", sid).First(&identity).Error; err == nil {
-			return identity.UserID, nil
+	// 2. Never auto-link to an existing user by email; that has to go
+	// through the authenticated link flow (POST /auth/identities/link).
+	var user models.User
+	if principal.Email != "" {
+		if err := database.DB.Where("email = ?", principal.Email).First(&user).Error; err == nil {
+			logger.Warn("Login with unlinked identity for existing email", "provider", principal.Provider, "user_id", user.ID)
+			return 0, errAccountLinkRequired
 		}
 	}
 
-	// 2. Auto-Provision: If we have valid claims from a JWT but no identity, create a user
-	if sub, ok := claims["sub"].(string); ok {
-		email, _ := claims["email"].(string)
-		name, _ := claims["name"].(string)
-		if name == "" {
-			name = "New User"
-		}
+	// 3. Auto-Provision
+	name := principal.Name
+	if name == "" {
+		name = "New User"
+	}
+	user = models.User{Email: principal.Email, Name: name}
 
-		// Check if user already exists by email (to avoid duplicates if they link multiple providers later)
-		var user models.User
-		if email != "" {
-			if err := database.DB.Where("email = ?", email).First(&user).Error; err != nil {
-				// Create new user
-				user = models.User{
-					Email: email,
-					Name:  name,
-				}
-				if err := database.DB.Create(&user).Error; err != nil {
-					logger.Error("Failed to auto-provision user", "error", err)
-					return 0, err
-				}
-			}
-		} else {
-			// No email? Create a guest-like user
-			user = models.User{Name: name}
-			database.DB.Create(&user)
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&user).Error; err != nil {
+			return err
 		}
-
-		// Create identity
 		identity = models.UserIdentity{
 			UserID:     user.ID,
-			Provider:   "google", // Assuming google for now as per frontend
-			ExternalID: sub,
-		}
-		if err := database.DB.Create(&identity).Error; err != nil {
-			logger.Error("Failed to create user identity", "error", err)
-			return 0, err
+			Provider:   principal.Provider,
+			ExternalID: principal.Subject,
+			Email:      principal.Email,
 		}
-		logger.Info("Auto-provisioned new user", "user_id", user.ID, "external_id", sub)
-		return user.ID, nil
-	}
-
-	// 3. Try numeric ID (legacy/internal)
-	if id, err := strconv.Atoi(idStr); err == nil && len(idStr) < 10 {
-		return uint(id), nil
+		return tx.Create(&identity).Error
+	})
+	if err != nil {
+		logger.Error("Failed to auto-provision user", "error", err)
+		return 0, err
 	}
-
-	return 0, http.ErrNoCookie
+	logger.Info("Auto-provisioned new user", "user_id", user.ID, "provider", principal.Provider, "external_id", principal.Subject)
+	return user.ID, nil
 }
 
 func GetPantry(w http.ResponseWriter, r *http.Request) {
 	userID, _ := getUserID(r)
 
+	query := database.DB.Preload("Ingredient.Category").Preload("Item.Brand").Where("user_id = ?", userID)
+	ingredientIDs, ok := categoryFilter(w, r)
+	if !ok {
+		return
+	}
+	if ingredientIDs != nil {
+		query = query.Where("ingredient_id IN ?", ingredientIDs)
+	}
+
 	var pantryItems []models.PantryItem
-	if err := database.DB.Preload("Ingredient").Preload("Item.Brand").Where("user_id = ?", userID).Find(&pantryItems).Error; err != nil {
+	if err := query.Find(&pantryItems).Error; err != nil {
 		http.Error(w, "Failed to fetch pantry", http.StatusInternalServerError)
 		return
 	}
@@ -145,22 +137,54 @@ func UpdatePantryItem(w http.ResponseWriter, r *http.Request) {
 
 	var req struct {
 		ManualQuantity *float64 `json:"manual_quantity"`
+		// Reason is recorded on the ledger: manual (default), waste or expiry.
+		Reason string `json:"reason"`
+		Note   string `json:"note"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid body", http.StatusBadRequest)
 		return
 	}
 
-	var pantryItem models.PantryItem
-	// We first try to find by ItemID (as the frontend sends it)
-	// But since ItemID can change (representative item updates), we also allow ID.
-	if err := database.DB.Where("user_id = ? AND (item_id = ? OR id = ?)", userID, itemID, itemID).First(&pantryItem).Error; err != nil {
+	pantryItem, err := findUserPantryItem(userID, itemID)
+	if err != nil {
 		http.Error(w, "Item not found in pantry", http.StatusNotFound)
 		return
 	}
 
-	pantryItem.ManualQuantity = req.ManualQuantity
-	if err := database.DB.Save(&pantryItem).Error; err != nil {
+	if req.ManualQuantity == nil {
+		// Clearing a legacy override falls back to the ledger quantity.
+		pantryItem.ManualQuantity = nil
+		if err := database.DB.Model(&pantryItem).Update("manual_quantity", nil).Error; err != nil {
+			http.Error(w, "Failed to update", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(pantryItem)
+		return
+	}
+
+	reason := req.Reason
+	if reason == "" {
+		reason = models.MovementSourceManual
+	}
+	switch reason {
+	case models.MovementSourceManual:
+	case models.MovementSourceWaste, models.MovementSourceExpiry:
+		if *req.ManualQuantity > pantryItem.EffectiveQuantity() {
+			http.Error(w, "Waste and expiry can only reduce stock", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "reason must be manual, waste or expiry", http.StatusBadRequest)
+		return
+	}
+	if *req.ManualQuantity < 0 {
+		http.Error(w, "Quantity cannot be negative", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := services.SetQuantity(database.DB, &pantryItem, *req.ManualQuantity, reason, req.Note); err != nil {
 		http.Error(w, "Failed to update", http.StatusInternalServerError)
 		return
 	}
@@ -169,8 +193,17 @@ func UpdatePantryItem(w http.ResponseWriter, r *http.Request) {
 }
 
 func GetItems(w http.ResponseWriter, r *http.Request) {
+	query := database.DB
+	ingredientIDs, ok := categoryFilter(w, r)
+	if !ok {
+		return
+	}
+	if ingredientIDs != nil {
+		query = query.Where("ingredient_id IN ?", ingredientIDs)
+	}
+
 	var items []models.Item
-	database.DB.Find(&items)
+	query.Preload("Nutrients").Find(&items)
 	json.NewEncoder(w).Encode(items)
 }
 
@@ -190,31 +223,119 @@ func CreateItem(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(item)
 }
 
-func GetOrders(w http.ResponseWriter, r *http.Request) {
-	userID, _ := getUserID(r)
-	var orders []models.Order
-	database.DB.Preload("OrderItems.Item.Ingredient").Preload("OrderItems.Item.Brand").Where("user_id = ?", userID).Find(&orders)
-	json.NewEncoder(w).Encode(orders)
+// GetItemByBarcode returns the catalog item with a barcode. A product only
+// in the Open Food Facts mirror is returned unsaved (id 0); it joins the
+// catalog when it is added to the pantry.
+func GetItemByBarcode(w http.ResponseWriter, r *http.Request) {
+	item, err := services.LookupItemByBarcode(database.DB, chi.URLParam(r, "code"))
+	if !writeBarcodeError(w, err) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(item)
 }
 
-func GetLowStock(w http.ResponseWriter, r *http.Request) {
-	userID, _ := getUserID(r)
+// writeBarcodeError writes the response for a failed barcode lookup and
+// reports whether the lookup succeeded.
+func writeBarcodeError(w http.ResponseWriter, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, services.ErrInvalidGTIN):
+		writeJSONError(w, http.StatusBadRequest, "Invalid barcode")
+	case errors.Is(err, services.ErrUnknownBarcode):
+		writeJSONError(w, http.StatusNotFound, "Unknown barcode")
+	default:
+		writeJSONError(w, http.StatusInternalServerError, "Failed to look up barcode")
+	}
+	return false
+}
 
-	// Complex logic typically, doing simple iteration for now or SQL query.
-	// "Reorder suggestions are computed dynamically"
-	// Let's say low stock is < 2 units.
+// ItemNutritionResponse is an item's nutrition: macros per ServingWeight of
+// ServingUnit, other nutrients, allergens and diet flags.
+type ItemNutritionResponse struct {
+	ItemID            uint                  `json:"item_id"`
+	Name              string                `json:"name"`
+	Calories          float64               `json:"calories"`
+	Protein           float64               `json:"protein"`
+	Carbs             float64               `json:"carbs"`
+	Fat               float64               `json:"fat"`
+	Fiber             float64               `json:"fiber"`
+	ServingWeight     float64               `json:"serving_weight"`
+	ServingUnit       string                `json:"serving_unit"`
+	NutritionVerified bool                  `json:"nutrition_verified"`
+	NutritionProvider string                `json:"nutrition_provider,omitempty"`
+	Nutrients         []models.ItemNutrient `json:"nutrients"`
+	models.DietProfile
+}
 
-	var pantryItems []models.PantryItem
-	database.DB.Preload("Item").Where("user_id = ?", userID).Find(&pantryItems)
+// GetItemNutrition returns an item's full nutrition profile.
+func GetItemNutrition(w http.ResponseWriter, r *http.Request) {
+	itemID, err := strconv.Atoi(chi.URLParam(r, "item_id"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid item ID")
+		return
+	}
 
-	var lowStock []models.PantryItem
-	for _, p := range pantryItems {
-		if p.EffectiveQuantity() < 2.0 {
-			lowStock = append(lowStock, p)
+	var item models.Item
+	if err := database.DB.Preload("Nutrients", func(db *gorm.DB) *gorm.DB {
+		return db.Order("code")
+	}).First(&item, itemID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			writeJSONError(w, http.StatusNotFound, "Item not found")
+			return
 		}
+		writeJSONError(w, http.StatusInternalServerError, "Failed to fetch item")
+		return
 	}
 
-	json.NewEncoder(w).Encode(lowStock)
+	nutrients := item.Nutrients
+	if nutrients == nil {
+		nutrients = []models.ItemNutrient{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ItemNutritionResponse{
+		ItemID:            item.ID,
+		Name:              item.Name,
+		Calories:          item.Calories,
+		Protein:           item.Protein,
+		Carbs:             item.Carbs,
+		Fat:               item.Fat,
+		Fiber:             item.Fiber,
+		ServingWeight:     item.ServingWeight,
+		ServingUnit:       item.ServingUnit,
+		NutritionVerified: item.NutritionVerified,
+		NutritionProvider: item.NutritionProvider,
+		Nutrients:         nutrients,
+		DietProfile:       item.DietProfile,
+	})
+}
+
+// GetItemNutritionSources lists where an item's nutrition was looked up,
+// the source its macros come from first.
+func GetItemNutritionSources(w http.ResponseWriter, r *http.Request) {
+	itemID, err := strconv.Atoi(chi.URLParam(r, "item_id"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid item ID")
+		return
+	}
+
+	sources, err := services.NutritionSources(database.DB, uint(itemID))
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to fetch nutrition sources")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sources)
+}
+
+func GetOrders(w http.ResponseWriter, r *http.Request) {
+	userID, _ := getUserID(r)
+	var orders []models.Order
+	database.DB.Preload("OrderItems.Item.Ingredient").Preload("OrderItems.Item.Brand").Preload("StatusChanges").Where("user_id = ?", userID).Find(&orders)
+	json.NewEncoder(w).Encode(orders)
 }
 
 func DeletePantryItem(w http.ResponseWriter, r *http.Request) {
@@ -230,12 +351,13 @@ func DeletePantryItem(w http.ResponseWriter, r *http.Request) {
 	logger.Info("Deleting pantry item", "user_id", userID, "item_id", itemID)
 
 	var pantryItem models.PantryItem
-	if err := database.DB.Where("user_id = ? AND item_id = ?", userID, itemID).First(&pantryItem).Error; err != nil {
+	if err := database.DB.Preload("Item").Preload("Ingredient").Where("user_id = ? AND item_id = ?", userID, itemID).First(&pantryItem).Error; err != nil {
 		http.Error(w, "Item not found in pantry", http.StatusNotFound)
 		return
 	}
 
-	if err := database.DB.Delete(&pantryItem).Error; err != nil {
+	// The ledger is kept: the stock is closed out and the item soft-deleted
+	if err := services.RemovePantryItem(database.DB, &pantryItem); err != nil {
 		http.Error(w, "Failed to delete item", http.StatusInternalServerError)
 		return
 	}
@@ -261,7 +383,19 @@ func BulkDeletePantryItems(w http.ResponseWriter, r *http.Request) {
 
 	logger.Info("Bulk deleting pantry items", "user_id", userID, "count", len(req.ItemIDs))
 
-	if err := database.DB.Where("user_id = ? AND item_id IN ?", userID, req.ItemIDs).Delete(&models.PantryItem{}).Error; err != nil {
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var pantryItems []models.PantryItem
+		if err := tx.Preload("Item").Preload("Ingredient").Where("user_id = ? AND item_id IN ?", userID, req.ItemIDs).Find(&pantryItems).Error; err != nil {
+			return err
+		}
+		for i := range pantryItems {
+			if err := services.RemovePantryItem(tx, &pantryItems[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
 		http.Error(w, "Failed to delete items", http.StatusInternalServerError)
 		return
 	}
@@ -277,9 +411,15 @@ func AddPantryItem(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req struct {
-		Name     string  `json:"name"`
+		Name string `json:"name"`
+		// Barcode identifies the product instead of Name.
+		Barcode  string  `json:"barcode"`
 		Quantity float64 `json:"quantity"`
 		Unit     string  `json:"unit"`
+		// BestBefore overrides the default shelf life for this batch.
+		BestBefore *time.Time `json:"best_before"`
+		// Category (ID, slug or name) files the ingredient by hand.
+		Category string `json:"category"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -287,55 +427,83 @@ func AddPantryItem(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.Name == "" || req.Quantity <= 0 {
+	if (req.Name == "" && req.Barcode == "") || req.Quantity <= 0 {
 		http.Error(w, "Invalid input", http.StatusBadRequest)
 		return
 	}
 
-	// 1. Find or Create Ingredient
-	var ingredient models.Ingredient
-	if err := database.DB.Where("name = ?", req.Name).First(&ingredient).Error; err != nil {
-		ingredient = models.Ingredient{Name: req.Name}
-		database.DB.Create(&ingredient)
+	// A scanned barcode names the product and its ingredient
+	var item models.Item
+	if req.Barcode != "" {
+		item, err = services.ItemForBarcode(database.DB, req.Barcode)
+		if !writeBarcodeError(w, err) {
+			return
+		}
+		req.Name = item.Name
+		if req.Unit == "" {
+			req.Unit = item.Unit
+		}
 	}
 
-	// 2. Find or Create Item (simple default item for manual entry)
-	var item models.Item
-	if err := database.DB.Where("name = ?", req.Name).First(&item).Error; err != nil {
-		// Create new item if not exists
-		item = models.Item{
-			Name:         req.Name,
-			IngredientID: ingredient.ID,
-			Unit:         req.Unit,
+	quantity, unit := units.Normalize(req.Quantity, req.Unit)
+
+	// 1. Find or Create Ingredient (via catalog aliases)
+	var ingredient models.Ingredient
+	if item.ID != 0 {
+		ingredient = item.Ingredient
+	} else {
+		ingredient, err = services.ResolveIngredient(database.DB, req.Name)
+		if err != nil {
+			http.Error(w, "Failed to resolve ingredient", http.StatusInternalServerError)
+			return
 		}
-		if err := database.DB.Create(&item).Error; err != nil {
-			http.Error(w, "Failed to create item", http.StatusInternalServerError)
+	}
+	if req.Category != "" {
+		category, err := services.FindCategory(database.DB, req.Category)
+		if err != nil {
+			http.Error(w, "Unknown category", http.StatusBadRequest)
 			return
 		}
+		services.SetCategory(database.DB, &ingredient, category)
+	} else if ingredient.CategoryID == nil {
+		services.AssignCategory(database.DB, &ingredient, "")
 	}
 
-	// 3. Update or Create PantryItem
-	var pantryItem models.PantryItem
-	if err := database.DB.Where("user_id = ? AND ingredient_id = ?", userID, ingredient.ID).First(&pantryItem).Error; err == nil {
-		// Update existing
-		newQty := req.Quantity
-		if pantryItem.ManualQuantity != nil {
-			newQty += *pantryItem.ManualQuantity
-		} else {
-			newQty += pantryItem.DerivedQuantity
-		}
-		pantryItem.ManualQuantity = &newQty
-		database.DB.Save(&pantryItem)
-	} else {
-		// Create new
-		qty := req.Quantity
-		pantryItem = models.PantryItem{
-			UserID:         userID,
-			IngredientID:   ingredient.ID,
-			ItemID:         item.ID,
-			ManualQuantity: &qty,
+	// 2. Find or Create Item (simple default item for manual entry), unless found by barcode
+	if item.ID == 0 {
+		if err := database.DB.Where("name = ?", req.Name).First(&item).Error; err != nil {
+			// Create new item if not exists
+			item = models.Item{
+				Name:         req.Name,
+				IngredientID: ingredient.ID,
+				Unit:         unit,
+			}
+			if err := database.DB.Create(&item).Error; err != nil {
+				http.Error(w, "Failed to create item", http.StatusInternalServerError)
+				return
+			}
 		}
-		database.DB.Create(&pantryItem)
+	}
+
+	// 3. Update or Create PantryItem, under the resolved ingredient
+	stocked := item
+	stocked.IngredientID, stocked.Ingredient = ingredient.ID, ingredient
+	pantryItem, err := services.PantryItemFor(database.DB, userID, stocked)
+	if err != nil {
+		http.Error(w, "Failed to create pantry item", http.StatusInternalServerError)
+		return
+	}
+
+	// Add to stock as a new batch, in the unit the pantry item is tracked in
+	if _, err := services.ApplyMovement(database.DB, pantryItem, services.Movement{
+		Delta:      quantity,
+		Unit:       unit,
+		Source:     models.MovementSourceManual,
+		Note:       "added manually",
+		BestBefore: req.BestBefore,
+	}); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
 	// Trigger nutrition worker for the item
@@ -348,3 +516,50 @@ func AddPantryItem(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(pantryItem)
 }
+
+// findUserPantryItem looks a pantry item up by its representative item ID
+// (as the frontend sends it) or, since that can change, by its own ID.
+func findUserPantryItem(userID uint, id int) (models.PantryItem, error) {
+	var pantryItem models.PantryItem
+	err := database.DB.Preload("Ingredient").Preload("Item").
+		Where("user_id = ? AND (item_id = ? OR id = ?)", userID, id, id).First(&pantryItem).Error
+	return pantryItem, err
+}
+
+// GetPantryHistory returns the stock movements behind a pantry item's quantity.
+func GetPantryHistory(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserID(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	itemID, err := strconv.Atoi(chi.URLParam(r, "item_id"))
+	if err != nil {
+		http.Error(w, "Invalid item ID", http.StatusBadRequest)
+		return
+	}
+
+	pantryItem, err := findUserPantryItem(userID, itemID)
+	if err != nil {
+		http.Error(w, "Item not found in pantry", http.StatusNotFound)
+		return
+	}
+
+	entries, err := services.PantryHistory(database.DB, &pantryItem)
+	if err != nil {
+		http.Error(w, "Failed to load history", http.StatusInternalServerError)
+		return
+	}
+	ledgerQuantity, _ := services.RecomputeDerivedQuantity(database.DB, &pantryItem)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"pantry_item_id":     pantryItem.ID,
+		"ingredient":         pantryItem.Ingredient.Name,
+		"unit":               services.PantryUnit(&pantryItem),
+		"effective_quantity": pantryItem.EffectiveQuantity(),
+		"ledger_quantity":    ledgerQuantity,
+		"movements":          entries,
+	})
+}
@@ -0,0 +1,177 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/pmitra96/pateproject/config"
+	"github.com/pmitra96/pateproject/database"
+	"github.com/pmitra96/pateproject/logger"
+	"github.com/pmitra96/pateproject/middleware"
+	"github.com/pmitra96/pateproject/models"
+	"gorm.io/gorm"
+)
+
+type CreateWebhookSourceRequest struct {
+	Name string `json:"name"`
+}
+
+// WebhookSourceResponse is returned on creation and rotation; Secret is the
+// only time the new signing secret is shown.
+type WebhookSourceResponse struct {
+	models.WebhookSource
+	Secret string `json:"secret"`
+}
+
+// GetWebhookSources lists the current user's webhook sources.
+func GetWebhookSources(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserID(r)
+	if err != nil {
+		writeJSONError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var sources []models.WebhookSource
+	database.DB.Preload("Secrets").Where("user_id = ?", userID).Order("created_at desc").Find(&sources)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sources)
+}
+
+// CreateWebhookSource registers a new signed webhook sender.
+func CreateWebhookSource(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserID(r)
+	if err != nil {
+		writeJSONError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req CreateWebhookSourceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	idBytes := make([]byte, 8)
+	if _, err := rand.Read(idBytes); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to generate source ID")
+		return
+	}
+	plaintext, err := middleware.GenerateWebhookSecret()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to generate secret")
+		return
+	}
+
+	source := models.WebhookSource{
+		UserID:   userID,
+		PublicID: "src_" + hex.EncodeToString(idBytes),
+		Name:     req.Name,
+		Secrets:  []models.WebhookSecret{{Secret: plaintext}},
+	}
+	if err := database.DB.Create(&source).Error; err != nil {
+		logger.Error("Failed to create webhook source", "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to create webhook source")
+		return
+	}
+
+	logger.Info("Webhook source created", "user_id", userID, "source", source.PublicID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(WebhookSourceResponse{WebhookSource: source, Secret: plaintext})
+}
+
+// RotateWebhookSecret issues a new signing secret. Secrets that were active
+// stay valid for WEBHOOK_ROTATION_GRACE (default 24h) so the sender can
+// switch over without dropping requests.
+func RotateWebhookSecret(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserID(r)
+	if err != nil {
+		writeJSONError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	source, ok := findUserWebhookSource(w, r, userID)
+	if !ok {
+		return
+	}
+
+	grace, err := time.ParseDuration(config.GetEnv("WEBHOOK_ROTATION_GRACE", "24h"))
+	if err != nil || grace < 0 {
+		grace = 24 * time.Hour
+	}
+
+	plaintext, err := middleware.GenerateWebhookSecret()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to generate secret")
+		return
+	}
+
+	now := time.Now()
+	graceEnd := now.Add(grace)
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.WebhookSecret{}).
+			Where("source_id = ? AND (expires_at IS NULL OR expires_at > ?)", source.ID, graceEnd).
+			Update("expires_at", graceEnd).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.WebhookSecret{SourceID: source.ID, Secret: plaintext}).Error
+	})
+	if err != nil {
+		logger.Error("Failed to rotate webhook secret", "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to rotate secret")
+		return
+	}
+
+	database.DB.Preload("Secrets").First(&source, source.ID)
+	logger.Info("Webhook secret rotated", "user_id", userID, "source", source.PublicID, "grace", grace)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(WebhookSourceResponse{WebhookSource: source, Secret: plaintext})
+}
+
+// DisableWebhookSource stops accepting webhooks from a source.
+func DisableWebhookSource(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserID(r)
+	if err != nil {
+		writeJSONError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	source, ok := findUserWebhookSource(w, r, userID)
+	if !ok {
+		return
+	}
+
+	if source.DisabledAt == nil {
+		now := time.Now()
+		source.DisabledAt = &now
+		if err := database.DB.Save(&source).Error; err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to disable webhook source")
+			return
+		}
+		logger.Info("Webhook source disabled", "user_id", userID, "source", source.PublicID)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func findUserWebhookSource(w http.ResponseWriter, r *http.Request, userID uint) (models.WebhookSource, bool) {
+	var source models.WebhookSource
+	sourceID, err := strconv.ParseUint(chi.URLParam(r, "source_id"), 10, 32)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid source ID")
+		return source, false
+	}
+	if err := database.DB.Where("id = ? AND user_id = ?", sourceID, userID).First(&source).Error; err != nil {
+		writeJSONError(w, http.StatusNotFound, "Webhook source not found")
+		return source, false
+	}
+	return source, true
+}
@@ -37,15 +37,45 @@ func InitDB() {
 
 	// Migration logic
 	log.Println("Running migrations...")
+
+	// Nutrition sources used to be appended on every refetch; keep the
+	// selected, else newest, per item and provider before the unique index
+	if DB.Migrator().HasTable(&models.NutritionSource{}) {
+		err = DB.Exec(`DELETE FROM nutrition_sources a USING nutrition_sources b
+			WHERE a.item_id = b.item_id AND a.provider = b.provider
+			AND (b.selected, b.fetched_at, b.id) > (a.selected, a.fetched_at, a.id)`).Error
+		if err != nil {
+			log.Fatal("Failed to remove duplicate nutrition sources: ", err)
+		}
+	}
+
 	err = DB.AutoMigrate(
 		&models.User{},
 		&models.UserIdentity{},
+		&models.APIKey{},
+		&models.WebhookSource{},
+		&models.WebhookSecret{},
+		&models.WebhookNonce{},
+		&models.IngredientCategory{},
 		&models.Ingredient{},
 		&models.Brand{},
+		&models.IngredientAlias{},
+		&models.BrandAlias{},
 		&models.Item{},
+		&models.ItemNutrient{},
+		&models.NutritionSource{},
+		&models.OpenFoodFactsProduct{},
 		&models.Order{},
 		&models.OrderItem{},
+		&models.OrderStatusChange{},
+		&models.ExtractionReview{},
+		&models.IngestionJob{},
+		&models.IngestionJobStep{},
 		&models.PantryItem{},
+		&models.PantryMovement{},
+		&models.PantryBatch{},
+		&models.ShoppingList{},
+		&models.ShoppingListItem{},
 		&models.Goal{},
 		&models.MealLog{},
 		&models.Conversation{},
@@ -58,5 +88,12 @@ func InitDB() {
 	if err != nil {
 		log.Fatal("Failed to migrate database: ", err)
 	}
+
+	// Full-text search over the Open Food Facts mirror (services.SearchOpenFoodFacts)
+	err = DB.Exec(`CREATE INDEX IF NOT EXISTS idx_open_food_facts_products_search ON open_food_facts_products
+		USING GIN (to_tsvector('simple', brands || ' ' || product_name))`).Error
+	if err != nil {
+		log.Fatal("Failed to create Open Food Facts search index: ", err)
+	}
 	log.Println("Migrations completed")
 }
@@ -0,0 +1,185 @@
+package extractor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ErrNoItems is returned when no line items could be read from an invoice.
+var ErrNoItems = errors.New("no line items found in invoice")
+
+// InvoiceParser reads one provider's invoice layout.
+type InvoiceParser interface {
+	// Provider is the name orders from this parser are ingested under.
+	Provider() string
+	// Detect reports whether a page's text (lower case, without spaces) is
+	// from this provider's invoice.
+	Detect(text string) bool
+	Parse(pages []Page) (*ExtractionResult, error)
+}
+
+var parsers []InvoiceParser
+
+// Register adds a parser, tried after those already registered. It is not
+// safe for concurrent use; call it from init.
+func Register(p InvoiceParser) {
+	parsers = append(parsers, p)
+}
+
+// ParserFor picks the parser for an invoice from its first page that a
+// registered parser recognises, falling back to a generic table parser.
+func ParserFor(pages []Page) InvoiceParser {
+	for _, pg := range pages {
+		for _, p := range parsers {
+			if p.Detect(pg.Text) {
+				return p
+			}
+		}
+	}
+	return genericParser
+}
+
+// ParseInvoice extracts the order ID, date, line items (with pack size and
+// price) and charges from an invoice PDF.
+func ParseInvoice(path string) (*ExtractionResult, error) {
+	result, err := DiagnoseInvoice(path)
+	if result != nil {
+		result.Diagnostics = nil
+	}
+	return result, err
+}
+
+// DiagnoseInvoice parses an invoice PDF like ParseInvoice, keeping a
+// diagnostic for each table row that says whether it was read as a header
+// or an item, or why it was skipped.
+func DiagnoseInvoice(path string) (*ExtractionResult, error) {
+	pages, err := readPages(path)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := ParserFor(pages).Parse(pages)
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Items) == 0 {
+		return result, ErrNoItems
+	}
+	return result, nil
+}
+
+// tableParser parses invoices whose items are in a table with description
+// and quantity columns; providers differ in headers, markers and how the
+// order is labelled.
+type tableParser struct {
+	provider  string
+	markers   []string // Page text identifying the provider
+	layout    tableLayout
+	orderID   *regexp.Regexp // First group is the order ID
+	dateLabel *regexp.Regexp // Precedes the order date
+}
+
+func (p tableParser) Provider() string { return p.provider }
+
+func (p tableParser) Detect(text string) bool {
+	for _, m := range p.markers {
+		if strings.Contains(text, m) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p tableParser) Parse(pages []Page) (*ExtractionResult, error) {
+	result := &ExtractionResult{Provider: p.provider}
+	for _, pg := range pages {
+		readOrderDetails(pg.Lines(), p.orderID, p.dateLabel, result)
+	}
+	parseTable(pages, p.layout, result)
+	return result, nil
+}
+
+// readOrderDetails fills in the order ID, invoice number, date, seller and
+// delivery address hash from labelled lines, keeping the first of each.
+func readOrderDetails(lines []string, orderID, dateLabel *regexp.Regexp, result *ExtractionResult) {
+	for i, line := range lines {
+		if m := orderID.FindStringSubmatch(line); m != nil && result.OrderID == "" {
+			result.OrderID = m[1]
+		}
+		if m := invoiceNumber.FindStringSubmatch(line); m != nil && result.InvoiceNumber == "" {
+			result.InvoiceNumber = m[1]
+		}
+		if loc := dateLabel.FindStringIndex(line); loc != nil && result.OrderDate == nil {
+			if date, ok := findDate(line[loc[1]:]); ok {
+				result.OrderDate = &date
+			}
+		}
+		if m := sellerLabel.FindStringSubmatch(line); m != nil && result.Seller == "" {
+			result.Seller = strings.TrimSpace(m[1])
+		}
+		if loc := addressLabel.FindStringIndex(line); loc != nil && result.AddressHash == "" {
+			// The address runs over the rest of the line and the next two
+			address := []string{line[loc[1]:]}
+			for j := i + 1; j < len(lines) && j <= i+2; j++ {
+				address = append(address, lines[j])
+			}
+			result.AddressHash = hashAddress(strings.Join(address, " "))
+		}
+	}
+}
+
+// hashAddress hashes an address with case, spacing and punctuation removed,
+// so the same address printed differently matches without storing it.
+func hashAddress(address string) string {
+	normalized := nonAlphanumeric.ReplaceAllString(strings.ToLower(address), "")
+	if normalized == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+var (
+	anyOrderID = regexp.MustCompile(`(?i)order\s*(?:no\.?|number|id|#)\s*[:#-]?\s*([A-Z0-9][A-Z0-9-]{3,})`)
+	orderDate  = regexp.MustCompile(`(?i)(?:invoice|order)\s*date\s*[:-]?`)
+
+	invoiceNumber   = regexp.MustCompile(`(?i)invoice\s*(?:no\.?|number|#)\s*[:#-]?\s*([A-Z0-9][A-Z0-9/-]{3,})`)
+	sellerLabel     = regexp.MustCompile(`(?i)(?:sold\s*by|seller(?:\s*name)?)\s*[:-]\s*(.+)`)
+	addressLabel    = regexp.MustCompile(`(?i)(?:delivery|shipping)\s*address\s*[:-]?|(?:ship|deliver)\s*to\s*[:-]?`)
+	nonAlphanumeric = regexp.MustCompile(`[^a-z0-9]+`)
+
+	genericParser = tableParser{
+		provider:  "unknown",
+		layout:    defaultLayout,
+		orderID:   anyOrderID,
+		dateLabel: orderDate,
+	}
+)
+
+var (
+	dateToken   = regexp.MustCompile(`\d{4}-\d{2}-\d{2}|\d{1,2}[-/ ](?:\d{1,2}|[A-Za-z]{3,9})[-/ ,]+\d{2,4}|[A-Za-z]{3,9} \d{1,2},? \d{4}`)
+	dateLayouts = []string{
+		"2006-01-02", "02-01-2006", "2-1-2006", "02/01/2006", "2/1/2006", "02-01-06", "02/01/06",
+		"02-Jan-2006", "2-Jan-2006", "02 Jan 2006", "2 Jan 2006", "02-Jan-06", "02 Jan, 2006",
+		"02 January 2006", "2 January 2006", "Jan 2, 2006", "Jan 2 2006", "January 2, 2006",
+	}
+)
+
+// findDate reads the first date in s. Numeric dates are day first, as on
+// Indian invoices.
+func findDate(s string) (time.Time, bool) {
+	token := dateToken.FindString(s)
+	if token == "" {
+		return time.Time{}, false
+	}
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, token); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
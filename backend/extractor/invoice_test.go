@@ -0,0 +1,117 @@
+package extractor
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ledongthuc/pdf"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// readPageFixture reads testdata/<name>.pages.json, the text drawn on each
+// page of a synthetic invoice.
+func readPageFixture(t *testing.T, name string) []Page {
+	t.Helper()
+	data, err := os.ReadFile("testdata/" + name + ".pages.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var texts [][]pdf.Text
+	if err := json.Unmarshal(data, &texts); err != nil {
+		t.Fatalf("reading %s pages: %v", name, err)
+	}
+	pages := make([]Page, len(texts))
+	for i, page := range texts {
+		pages[i] = newPage(i+1, page)
+	}
+	return pages
+}
+
+func TestParsersGolden(t *testing.T) {
+	tests := []struct {
+		fixture  string
+		provider string
+	}{
+		{"zepto", "zepto"},
+		{"blinkit", "blinkit"},
+		{"swiggy", "swiggy"},
+		{"generic", "unknown"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			pages := readPageFixture(t, tt.fixture)
+			parser := ParserFor(pages)
+			if parser.Provider() != tt.provider {
+				t.Fatalf("parser for %s is %q, want %q", tt.fixture, parser.Provider(), tt.provider)
+			}
+			result, err := parser.Parse(pages)
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			result.Diagnostics = nil
+			got, err := json.MarshalIndent(result, "", "  ")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, '\n')
+
+			golden := "testdata/" + tt.fixture + ".golden.json"
+			if *update {
+				if err := os.WriteFile(golden, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("%s parsed as\n%s\nwant\n%s", tt.fixture, got, want)
+			}
+		})
+	}
+}
+
+func TestZeptoSplitHeader(t *testing.T) {
+	pages := readPageFixture(t, "zepto")
+	if result, _ := genericParser.Parse(pages); len(result.Items) != 0 {
+		t.Errorf("generic parser read %d items without the split header", len(result.Items))
+	}
+	result, _ := zeptoParser.Parse(pages)
+	if len(result.Items) != 2 {
+		t.Errorf("Zepto parser read %d items, want 2", len(result.Items))
+	}
+}
+
+func TestFindDate(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"12-01-2025", "2025-01-12"},
+		{": 3/2/2025", "2025-02-03"},
+		{"2025-03-03 10:15", "2025-03-03"},
+		{"05-Feb-2025", "2025-02-05"},
+		{"5 February 2025", "2025-02-05"},
+		{"Jan 20, 2025", "2025-01-20"},
+		{"12-01-25", "2025-01-12"},
+		{"soon", ""},
+	}
+	for _, tt := range tests {
+		got, ok := findDate(tt.in)
+		if tt.want == "" {
+			if ok {
+				t.Errorf("findDate(%q) = %v, want none", tt.in, got)
+			}
+			continue
+		}
+		if want, _ := time.Parse("2006-01-02", tt.want); !ok || !got.Equal(want) {
+			t.Errorf("findDate(%q) = %v, %v; want %s", tt.in, got, ok, tt.want)
+		}
+	}
+}
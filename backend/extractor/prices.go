@@ -0,0 +1,219 @@
+package extractor
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Line price fields, as named by invoice column headers.
+const (
+	priceMRP      = "mrp"
+	priceDiscount = "discount"
+	priceTaxable  = "taxable"
+	priceGST      = "gst"
+	priceAmount   = "amount"
+)
+
+// priceHeaders maps header words (lower case, without spaces or dots) to
+// the field their column holds.
+var priceHeaders = map[string]string{
+	"mrp":          priceMRP,
+	"discount":     priceDiscount,
+	"disc":         priceDiscount,
+	"taxable":      priceTaxable,
+	"taxablevalue": priceTaxable,
+	"taxableamt":   priceTaxable,
+	"gst":          priceGST,
+	"igst":         priceGST,
+	"cgst":         priceGST,
+	"sgst":         priceGST,
+	"total":        priceAmount,
+	"amount":       priceAmount,
+	"netamount":    priceAmount,
+	"totalamount":  priceAmount,
+}
+
+// maxPriceColumnGap is how far (in points) a value may sit from its header.
+const maxPriceColumnGap = 40.0
+
+type priceColumn struct {
+	field string
+	x     float64 // Centre of the header word
+}
+
+type word struct {
+	text     string
+	x, right float64
+}
+
+// rowWords joins a row's fragments into words. Many invoices place each
+// character separately, so fragments that touch are one word.
+func rowWords(row rowData) []word {
+	idx := make([]int, len(row.contents))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(a, b int) bool { return row.xCoords[idx[a]] < row.xCoords[idx[b]] })
+
+	var words []word
+	for _, i := range idx {
+		x := row.xCoords[i]
+		w := 0.0
+		if i < len(row.widths) {
+			w = row.widths[i]
+		}
+		if w <= 0 {
+			w = 4 * float64(len([]rune(row.contents[i])))
+		}
+		if n := len(words); n > 0 && x-words[n-1].right < 1 {
+			words[n-1].text += row.contents[i]
+			words[n-1].right = math.Max(words[n-1].right, x+w)
+			continue
+		}
+		words = append(words, word{text: row.contents[i], x: x, right: x + w})
+	}
+	return words
+}
+
+// findPriceColumns locates price columns from the header row: the first
+// row naming at least two of them.
+func findPriceColumns(rows []rowData) []priceColumn {
+	for _, row := range rows {
+		var cols []priceColumn
+		fields := make(map[string]bool)
+		for _, w := range rowWords(row) {
+			key := strings.NewReplacer(" ", "", ".", "").Replace(strings.ToLower(w.text))
+			if field, ok := priceHeaders[key]; ok {
+				cols = append(cols, priceColumn{field: field, x: (w.x + w.right) / 2})
+				fields[field] = true
+			}
+		}
+		if len(fields) >= 2 {
+			return cols
+		}
+	}
+	return nil
+}
+
+// readLinePrices fills an item's prices from the values right of the
+// quantity column, each assigned to the nearest header. GST columns are
+// summed (CGST + SGST); percentages are rates, not amounts, and skipped.
+func readLinePrices(block []rowData, qtyColX float64, cols []priceColumn, item *ExtractedItem) {
+	if len(cols) == 0 {
+		return
+	}
+
+	found := make(map[string]float64)
+	taken := make(map[int]bool)
+	for _, row := range block {
+		for _, w := range rowWords(row) {
+			if w.x < qtyColX+15 || strings.Contains(w.text, "%") {
+				continue
+			}
+			v, ok := parseMoney(w.text)
+			if !ok {
+				continue
+			}
+
+			centre := (w.x + w.right) / 2
+			best, bestGap := -1, maxPriceColumnGap
+			for i, c := range cols {
+				if gap := math.Abs(c.x - centre); gap < bestGap {
+					best, bestGap = i, gap
+				}
+			}
+			if best < 0 || taken[best] {
+				continue
+			}
+			taken[best] = true
+			found[cols[best].field] += v
+		}
+	}
+
+	set := func(field string, dst **float64) {
+		if v, ok := found[field]; ok {
+			*dst = &v
+		}
+	}
+	set(priceMRP, &item.MRP)
+	set(priceDiscount, &item.Discount)
+	set(priceTaxable, &item.TaxableValue)
+	set(priceGST, &item.GST)
+	set(priceAmount, &item.Amount)
+}
+
+// readCharges picks order totals and fees out of summary rows such as
+// "Delivery Fee ₹25" or "Grand Total ₹512.40".
+func readCharges(rows []rowData, result *ExtractionResult) {
+	add := func(dst **float64, v float64) {
+		if *dst == nil {
+			*dst = new(float64)
+		}
+		**dst += v
+	}
+
+	for _, row := range rows {
+		words := rowWords(row)
+		var value float64
+		found := false
+		for i := len(words) - 1; i >= 0; i-- {
+			if v, ok := parseMoney(words[i].text); ok && !strings.Contains(words[i].text, "%") {
+				value, found = math.Abs(v), true
+				break
+			}
+		}
+		if !found {
+			continue
+		}
+
+		text := strings.ReplaceAll(strings.ToLower(strings.Join(row.contents, "")), " ", "")
+		isFee := strings.Contains(text, "fee") || strings.Contains(text, "charge")
+		switch {
+		case strings.Contains(text, "delivery") && isFee:
+			add(&result.DeliveryFee, value)
+		case isFee && containsAny(text, "handling", "packaging", "packing", "smallcart", "convenience", "latenight", "surge", "platform"):
+			add(&result.OtherFees, value)
+		case containsAny(text, "coupon", "promo", "cartdiscount"):
+			add(&result.Discount, value)
+		case containsAny(text, "grandtotal", "invoicevalue", "totalamount", "amountpaid", "ordertotal", "totalpayable", "netpayable"):
+			if result.Total == nil {
+				result.Total = &value
+			}
+		case containsAny(text, "itemtotal", "subtotal"):
+			if result.ItemTotal == nil {
+				result.ItemTotal = &value
+			}
+		}
+	}
+}
+
+func containsAny(s string, subs ...string) bool {
+	for _, sub := range subs {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseMoney reads "₹1,234.50", "Rs. 25" or "-40.00".
+func parseMoney(s string) (float64, bool) {
+	s = strings.TrimSpace(s)
+	s = strings.TrimPrefix(s, "-")
+	for _, prefix := range []string{"₹", "Rs.", "Rs", "INR"} {
+		s = strings.TrimPrefix(s, prefix)
+	}
+	s = strings.ReplaceAll(s, ",", "")
+	if s == "" || !strings.ContainsAny(s, "0123456789") {
+		return 0, false
+	}
+	for _, r := range s {
+		if (r < '0' || r > '9') && r != '.' {
+			return 0, false
+		}
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	return v, err == nil
+}
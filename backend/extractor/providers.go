@@ -0,0 +1,45 @@
+package extractor
+
+import "regexp"
+
+func init() {
+	Register(zeptoParser)
+	Register(blinkitParser)
+	Register(swiggyParser)
+}
+
+// Zepto invoices are issued by Geddit Convenience and split the table
+// header over two rows, the description above the quantity and prices.
+var zeptoParser = tableParser{
+	provider: "zepto",
+	markers:  []string{"zepto", "gedditconvenience"},
+	layout: tableLayout{
+		nameHeaders: defaultLayout.nameHeaders,
+		splitHeader: true,
+	},
+	orderID:   regexp.MustCompile(`(?i)order\s*(?:no\.?|number|id)\s*[:#-]?\s*([A-Z0-9][A-Z0-9-]{3,})`),
+	dateLabel: orderDate,
+}
+
+// Blinkit (formerly Grofers) lists handling charges as a table row.
+var blinkitParser = tableParser{
+	provider: "blinkit",
+	markers:  []string{"blinkit", "grofers"},
+	layout: tableLayout{
+		nameHeaders: []string{"description", "item", "product"},
+		skipNames:   []string{"handling charge", "total"},
+	},
+	orderID:   regexp.MustCompile(`(?i)order\s*id\s*[:#-]?\s*(\d{6,})`),
+	dateLabel: orderDate,
+}
+
+// Swiggy Instamart invoices label the order with a numeric ID.
+var swiggyParser = tableParser{
+	provider: "swiggy",
+	markers:  []string{"swiggy", "instamart"},
+	layout: tableLayout{
+		nameHeaders: []string{"description", "item", "product"},
+	},
+	orderID:   regexp.MustCompile(`(?i)order\s*(?:id|no\.?)\s*[:#-]?\s*(\d{6,})`),
+	dateLabel: orderDate,
+}
@@ -0,0 +1,242 @@
+package extractor
+
+import (
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// Page is one page of an invoice PDF.
+type Page struct {
+	Number int
+	Text   string // Lower case, without spaces, for matching
+	rows   []rowData
+}
+
+// Lines returns the page's rows top to bottom, words separated by spaces.
+func (p Page) Lines() []string {
+	lines := make([]string, 0, len(p.rows))
+	for _, row := range p.rows {
+		lines = append(lines, row.text())
+	}
+	return lines
+}
+
+func readPages(path string) ([]Page, error) {
+	f, r, err := pdf.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var pages []Page
+	for i := 1; i <= r.NumPage(); i++ {
+		p := r.Page(i)
+		if p.V.IsNull() {
+			continue
+		}
+
+		pages = append(pages, newPage(i, unrotate(p, p.Content().Text)))
+	}
+	return pages, nil
+}
+
+// newPage builds a page from its text as displayed.
+func newPage(number int, texts []pdf.Text) Page {
+	var fullPageText strings.Builder
+	for _, t := range texts {
+		fullPageText.WriteString(strings.ToLower(t.S))
+	}
+	return Page{
+		Number: number,
+		Text:   strings.ReplaceAll(fullPageText.String(), " ", ""),
+		rows:   groupTextsIntoRows(texts),
+	}
+}
+
+// unrotate maps text positions on a page with /Rotate into the page as
+// displayed, so rows run left to right whatever the page's orientation.
+func unrotate(p pdf.Page, texts []pdf.Text) []pdf.Text {
+	rotate := inherited(p.V, "Rotate").Int64()
+	if rotate%360 == 0 {
+		return texts
+	}
+	box := inherited(p.V, "MediaBox")
+	width := box.Index(2).Float64() - box.Index(0).Float64()
+	height := box.Index(3).Float64() - box.Index(1).Float64()
+	return rotateTexts(texts, rotate, width, height)
+}
+
+// rotateTexts maps text positions on a width x height page turned
+// clockwise by rotate degrees (a multiple of 90) into the page as displayed.
+func rotateTexts(texts []pdf.Text, rotate int64, width, height float64) []pdf.Text {
+	rotate = ((rotate % 360) + 360) % 360
+	if rotate == 0 {
+		return texts
+	}
+
+	out := make([]pdf.Text, len(texts))
+	for i, t := range texts {
+		x, y := t.X, t.Y
+		switch rotate {
+		case 90:
+			t.X, t.Y = y, width-x
+		case 180:
+			t.X, t.Y = width-x, height-y
+		case 270:
+			t.X, t.Y = height-y, x
+		}
+		out[i] = t
+	}
+	return out
+}
+
+// inherited looks up a page attribute that may be set on a parent Pages
+// node, such as Rotate and MediaBox.
+func inherited(v pdf.Value, key string) pdf.Value {
+	for depth := 0; !v.IsNull() && depth < 32; depth++ {
+		if value := v.Key(key); !value.IsNull() {
+			return value
+		}
+		v = v.Key("Parent")
+	}
+	return pdf.Value{}
+}
+
+type rowData struct {
+	y        float64
+	contents []string
+	xCoords  []float64
+	widths   []float64
+}
+
+func (r rowData) text() string {
+	var words []string
+	for _, w := range rowWords(r) {
+		words = append(words, w.text)
+	}
+	return strings.Join(words, " ")
+}
+
+// overlaps reports whether [x, right) covers text already in the row, which
+// means it belongs to a different line that happens to sit close in y.
+func (r rowData) overlaps(x, right float64) bool {
+	if right <= x {
+		return false
+	}
+	for i, rx := range r.xCoords {
+		if i >= len(r.widths) || r.widths[i] <= 0 {
+			continue
+		}
+		overlap := math.Min(right, rx+r.widths[i]) - math.Max(x, rx)
+		if overlap > 0.5*math.Min(right-x, r.widths[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// repeats reports whether the row already has content within a point of x,
+// as when a PDF fakes bold by drawing text twice with a slight offset.
+func (r rowData) repeats(content string, x float64) bool {
+	for i, rx := range r.xCoords {
+		if r.contents[i] == content && math.Abs(rx-x) < 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// Row tolerance bounds, in points. Text within tolerance of a row's first
+// text in y joins that row.
+const (
+	minRowTolerance = 2.0
+	maxRowTolerance = 6.0
+)
+
+// rowTolerance scales with the page's typical font size, so scaled pages
+// and large print group the same way as a standard invoice.
+func rowTolerance(texts []pdf.Text) float64 {
+	var sizes []float64
+	for _, t := range texts {
+		if t.FontSize > 0 {
+			sizes = append(sizes, t.FontSize)
+		}
+	}
+	if len(sizes) == 0 {
+		return minRowTolerance
+	}
+	sort.Float64s(sizes)
+	return math.Max(minRowTolerance, math.Min(maxRowTolerance, 0.3*sizes[len(sizes)/2]))
+}
+
+// groupTextsIntoRows groups text into rows, top to bottom, each left to
+// right.
+func groupTextsIntoRows(texts []pdf.Text) []rowData {
+	if len(texts) == 0 {
+		return nil
+	}
+
+	sorted := make([]pdf.Text, len(texts))
+	copy(sorted, texts)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Y > sorted[j].Y })
+
+	var rows []rowData
+	tolerance := rowTolerance(sorted)
+
+	for _, t := range sorted {
+		content := strings.TrimSpace(t.S)
+		if content == "" {
+			continue
+		}
+
+		// Texts are sorted by y, so only the last few rows can be in reach.
+		// Text drawn twice at the same spot (faux bold) is kept once.
+		placed := false
+		for i := len(rows) - 1; i >= 0 && rows[i].y-t.Y < tolerance; i-- {
+			if rows[i].y-t.Y < 1 && rows[i].repeats(content, t.X) {
+				placed = true
+				break
+			}
+			if rows[i].y-t.Y < 0.5 || !rows[i].overlaps(t.X, t.X+t.W) {
+				rows[i].contents = append(rows[i].contents, content)
+				rows[i].xCoords = append(rows[i].xCoords, t.X)
+				rows[i].widths = append(rows[i].widths, t.W)
+				placed = true
+				break
+			}
+		}
+
+		if !placed {
+			rows = append(rows, rowData{
+				y:        t.Y,
+				contents: []string{content},
+				xCoords:  []float64{t.X},
+				widths:   []float64{t.W},
+			})
+		}
+	}
+
+	for i := range rows {
+		sortRowByX(&rows[i])
+	}
+	return rows
+}
+
+func sortRowByX(r *rowData) {
+	idx := make([]int, len(r.contents))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(a, b int) bool { return r.xCoords[idx[a]] < r.xCoords[idx[b]] })
+
+	contents := make([]string, len(idx))
+	xs := make([]float64, len(idx))
+	widths := make([]float64, len(idx))
+	for n, i := range idx {
+		contents[n], xs[n], widths[n] = r.contents[i], r.xCoords[i], r.widths[i]
+	}
+	r.contents, r.xCoords, r.widths = contents, xs, widths
+}
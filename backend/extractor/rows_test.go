@@ -0,0 +1,148 @@
+package extractor
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// glyph is text at (x, y) in a 10 pt font, 5 pt wide per character.
+func glyph(x, y float64, s string) pdf.Text {
+	return pdf.Text{FontSize: 10, X: x, Y: y, W: 5 * float64(len([]rune(s))), S: s}
+}
+
+// glyphs draws s one character at a time, as many invoices do.
+func glyphs(x, y float64, s string) []pdf.Text {
+	var texts []pdf.Text
+	for i, r := range []rune(s) {
+		texts = append(texts, glyph(x+5*float64(i), y, string(r)))
+	}
+	return texts
+}
+
+// fauxBold draws each text again slightly offset.
+func fauxBold(texts []pdf.Text) []pdf.Text {
+	out := append([]pdf.Text(nil), texts...)
+	for _, t := range texts {
+		t.X += 0.3
+		t.Y += 0.2
+		out = append(out, t)
+	}
+	return out
+}
+
+func rowLines(rows []rowData) []string {
+	var lines []string
+	for _, row := range rows {
+		lines = append(lines, row.text())
+	}
+	return lines
+}
+
+func TestGroupTextsIntoRows(t *testing.T) {
+	large := func(x, y float64, s string) pdf.Text {
+		t := glyph(x, y, s)
+		t.FontSize = 20
+		return t
+	}
+
+	tests := []struct {
+		name  string
+		texts []pdf.Text
+		want  []string
+	}{
+		{
+			name:  "top to bottom, left to right",
+			texts: []pdf.Text{glyph(300, 700, "Qty"), glyph(50, 700, "Item"), glyph(50, 680, "Milk"), glyph(300, 680, "2")},
+			want:  []string{"Item Qty", "Milk 2"},
+		},
+		{
+			name:  "characters placed one by one join into words",
+			texts: append(glyphs(50, 700, "Milk"), glyphs(300, 700, "12")...),
+			want:  []string{"Milk 12"},
+		},
+		{
+			name:  "baselines a little apart share a row",
+			texts: []pdf.Text{glyph(50, 700, "Milk"), glyph(300, 698.5, "2")},
+			want:  []string{"Milk 2"},
+		},
+		{
+			name:  "close lines that overlap stay apart",
+			texts: []pdf.Text{glyph(50, 700, "Amul Taaza"), glyph(50, 697.5, "Toned Milk")},
+			want:  []string{"Amul Taaza", "Toned Milk"},
+		},
+		{
+			name:  "large print widens the tolerance",
+			texts: []pdf.Text{large(50, 700, "Milk"), large(300, 695, "2")},
+			want:  []string{"Milk 2"},
+		},
+		{
+			name:  "faux bold words are kept once",
+			texts: fauxBold([]pdf.Text{glyph(50, 700, "Milk"), glyph(300, 700, "2")}),
+			want:  []string{"Milk 2"},
+		},
+		{
+			name:  "faux bold characters are kept once",
+			texts: fauxBold(append(glyphs(50, 700, "Milk"), glyphs(300, 700, "2")...)),
+			want:  []string{"Milk 2"},
+		},
+		{
+			name:  "repeated letters are not faux bold",
+			texts: glyphs(50, 700, "Coffee"),
+			want:  []string{"Coffee"},
+		},
+		{
+			name:  "blank text is dropped",
+			texts: []pdf.Text{glyph(50, 700, " "), glyph(50, 680, "Milk")},
+			want:  []string{"Milk"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rowLines(groupTextsIntoRows(tt.texts)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("rows = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// A4 portrait, in points
+const pageWidth, pageHeight = 595.0, 842.0
+
+// drawRotated places text as displayed onto a page turned clockwise by
+// rotate degrees, the inverse of rotateTexts.
+func drawRotated(texts []pdf.Text, rotate int64) []pdf.Text {
+	out := make([]pdf.Text, len(texts))
+	for i, t := range texts {
+		x, y := t.X, t.Y
+		switch ((rotate % 360) + 360) % 360 {
+		case 90:
+			t.X, t.Y = pageWidth-y, x
+		case 180:
+			t.X, t.Y = pageWidth-x, pageHeight-y
+		case 270:
+			t.X, t.Y = y, pageHeight-x
+		}
+		out[i] = t
+	}
+	return out
+}
+
+func TestRotateTexts(t *testing.T) {
+	// On a page turned a quarter clockwise, text drawn up the page's left
+	// edge reads left to right along the top.
+	got := rotateTexts([]pdf.Text{glyph(100, 500, "Milk")}, 90, pageWidth, pageHeight)
+	if got[0].X != 500 || got[0].Y != 495 {
+		t.Errorf("quarter turn put text at (%v, %v), want (500, 495)", got[0].X, got[0].Y)
+	}
+
+	table := append(glyphs(50, 500, "Item"), glyph(300, 500, "Qty"), glyph(50, 480, "Milk"), glyph(300, 480, "2"))
+	want := []string{"Item Qty", "Milk 2"}
+	for _, rotate := range []int64{0, 90, 180, 270, -90, 450} {
+		texts := rotateTexts(drawRotated(table, rotate), rotate, pageWidth, pageHeight)
+		if got := rowLines(groupTextsIntoRows(texts)); !reflect.DeepEqual(got, want) {
+			t.Errorf("rotated %d: rows = %q, want %q", rotate, got, want)
+		}
+	}
+}
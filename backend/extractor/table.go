@@ -0,0 +1,300 @@
+package extractor
+
+import (
+	"strconv"
+	"strings"
+)
+
+// tableLayout describes an invoice's item table for parseTable.
+type tableLayout struct {
+	nameHeaders []string // Header words of the description column
+	skipNames   []string // Lines that are charges, not items (lower case)
+	splitHeader bool     // The header may run over two rows
+}
+
+var defaultLayout = tableLayout{nameHeaders: []string{"description", "item"}}
+
+// Row statuses in diagnostics.
+const (
+	RowHeader  = "header"
+	RowItem    = "item"
+	RowSkipped = "skipped"
+)
+
+// RowDiagnostic records what the table parser made of one row, to debug
+// invoices that extract badly.
+type RowDiagnostic struct {
+	Page   int     `json:"page"`
+	Y      float64 `json:"y"`
+	Text   string  `json:"text"`
+	Status string  `json:"status"`
+	Reason string  `json:"reason,omitempty"`
+}
+
+// tableGeometry is where an item table's columns are. It carries over to
+// pages that continue the table without repeating the header.
+type tableGeometry struct {
+	nameColX, qtyColX float64
+	priceCols         []priceColumn
+	serialCol         bool // Items are numbered left of the description
+}
+
+// parseTable reads the item table and charges of each page. It finds the
+// description and quantity columns from the header and reads each numbered
+// block of rows below it as one item, so names wrapped over several rows
+// stay one item. A table still open at the end of a page continues on the
+// next one, header or not.
+func parseTable(pages []Page, layout tableLayout, result *ExtractionResult) {
+	var open *tableGeometry
+	for _, pg := range pages {
+		readCharges(pg.rows, result)
+
+		geom, found := findTableHeader(pg.rows, layout)
+		if !found {
+			if open == nil {
+				for _, row := range pg.rows {
+					result.diagnose(pg, row, RowSkipped, "no item table header on this page")
+				}
+				continue
+			}
+			geom = *open
+		}
+
+		if parseTableRows(pg, geom, layout, found, result) {
+			open = &geom
+		} else {
+			open = nil
+		}
+	}
+}
+
+func isNameHeader(cleanRowText string, layout tableLayout) bool {
+	for _, h := range layout.nameHeaders {
+		if strings.Contains(cleanRowText, h) {
+			return true
+		}
+	}
+	return false
+}
+
+func isTableHeaderRow(cleanRowText string, layout tableLayout) bool {
+	return (isNameHeader(cleanRowText, layout) && strings.Contains(cleanRowText, "qty")) ||
+		(isNameHeader(cleanRowText, layout) && strings.Contains(cleanRowText, "mrp")) ||
+		(strings.Contains(cleanRowText, "hsn") && strings.Contains(cleanRowText, "qty"))
+}
+
+func cleanText(row rowData) string {
+	return strings.ReplaceAll(strings.ToLower(strings.Join(row.contents, " ")), " ", "")
+}
+
+func findTableHeader(rows []rowData, layout tableLayout) (tableGeometry, bool) {
+	var geom tableGeometry
+
+	// Find header columns - handle split headers (e.g., Zepto has Description and Qty on different rows)
+	for _, row := range rows {
+		// Remove spaces for matching (PDF has char-by-char text)
+		cleanRowText := cleanText(row)
+
+		// Look for Description column, where its header word starts
+		if isNameHeader(cleanRowText, layout) {
+			if len(row.xCoords) > 0 && geom.nameColX == 0 {
+				for _, w := range rowWords(row) {
+					if isNameHeader(strings.ToLower(w.text), layout) {
+						geom.nameColX = w.x
+						break
+					}
+				}
+				// Fallback to first X if not found
+				if geom.nameColX == 0 {
+					geom.nameColX = row.xCoords[0]
+				}
+			}
+		}
+
+		// Look for Qty column - find actual position of "Q" or "q"
+		if strings.Contains(cleanRowText, "qty") || strings.Contains(cleanRowText, "quantity") {
+			if len(row.xCoords) > 0 && geom.qtyColX == 0 {
+				// Find the X position where "qty" text actually starts
+				for i, content := range row.contents {
+					lowerContent := strings.ToLower(content)
+					if lowerContent == "q" || strings.HasPrefix(lowerContent, "q") {
+						geom.qtyColX = row.xCoords[i]
+						break
+					}
+				}
+				// Fallback: if not found, use first X that's significantly to the right
+				if geom.qtyColX == 0 {
+					for i, x := range row.xCoords {
+						if x > geom.nameColX+50 { // At least 50 units to the right
+							lowerContent := strings.ToLower(row.contents[i])
+							if strings.Contains(lowerContent, "q") || strings.Contains(lowerContent, "t") || strings.Contains(lowerContent, "y") {
+								geom.qtyColX = x
+								break
+							}
+						}
+					}
+				}
+				// Final fallback
+				if geom.qtyColX == 0 {
+					geom.qtyColX = row.xCoords[0]
+				}
+			}
+		}
+	}
+
+	if geom.nameColX == 0 || geom.qtyColX == 0 {
+		return geom, false
+	}
+
+	geom.priceCols = findPriceColumns(rows)
+	for _, row := range rows {
+		if n, ok := serialNumber(row); ok && n > 0 && row.xCoords[0] < geom.nameColX-5 {
+			geom.serialCol = true
+			break
+		}
+	}
+	return geom, true
+}
+
+// serialNumber reads a row's leading item number (1, 2, 3, ...).
+func serialNumber(row rowData) (int, bool) {
+	if len(row.contents) == 0 {
+		return 0, false
+	}
+	num, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(row.contents[0]), "."))
+	return num, err == nil && num > 0 && num < 100
+}
+
+// isItemStart reports whether a row begins a new item. With a serial
+// number column only numbers in that column count, so a wrapped name
+// starting with a number ("2 x 200 g") continues the item above. Without
+// one each row with a quantity starts an item and the rows below it
+// without one continue its name.
+func (g tableGeometry) isItemStart(row rowData) bool {
+	if !g.serialCol {
+		return g.hasQuantity(row)
+	}
+	_, ok := serialNumber(row)
+	return ok && row.xCoords[0] < g.nameColX-5
+}
+
+// hasQuantity reports whether a row has a number in the quantity column.
+func (g tableGeometry) hasQuantity(row rowData) bool {
+	for i, content := range row.contents {
+		x := row.xCoords[i]
+		if x >= g.qtyColX-5 && x < g.qtyColX+15 {
+			if q, err := parseQty(content); err == nil && q > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseTableRows reads the items on one page and reports whether the table
+// is still open (no totals row) at the end of the page. Without a header
+// the page continues a table from the previous page: rows before the first
+// item, such as a repeated letterhead, are skipped.
+func parseTableRows(pg Page, geom tableGeometry, layout tableLayout, hasHeader bool, result *ExtractionResult) bool {
+	var block []rowData
+	var blockDiags []int
+	pastHeaders := !hasHeader
+	waitingForItem := !hasHeader
+
+	addItem := func() {
+		if len(block) == 0 {
+			return
+		}
+		defer func() { block, blockDiags = nil, nil }()
+
+		item := extractItemFromBlock(block, geom.nameColX, geom.qtyColX, geom.priceCols)
+		reason := ""
+		switch {
+		case item == nil:
+			reason = "no name and quantity in the item's rows"
+		default:
+			for _, skip := range layout.skipNames {
+				if strings.EqualFold(item.Name, skip) {
+					reason = "charge line, not an item"
+				}
+			}
+		}
+		for n, i := range blockDiags {
+			switch {
+			case reason != "":
+				result.Diagnostics[i].Status, result.Diagnostics[i].Reason = RowSkipped, reason
+			case n == 0:
+				result.Diagnostics[i].Reason = "item: " + item.Name
+			default:
+				result.Diagnostics[i].Reason = "continues the item above"
+			}
+		}
+		if reason == "" {
+			result.Items = append(result.Items, *item)
+		}
+	}
+
+	prevText, prevDiag := "", -1
+	for _, row := range pg.rows {
+		cleanRowText := cleanText(row)
+
+		header := isTableHeaderRow(cleanRowText, layout)
+		if !header && layout.splitHeader && !pastHeaders && prevDiag >= 0 &&
+			isTableHeaderRow(prevText+cleanRowText, layout) {
+			// The row above starts the header
+			result.Diagnostics[prevDiag].Status, result.Diagnostics[prevDiag].Reason = RowHeader, ""
+			header = true
+		}
+		if header {
+			pastHeaders = true
+			result.diagnose(pg, row, RowHeader, "")
+			continue
+		}
+
+		// Only process rows after headers
+		if !pastHeaders {
+			prevText, prevDiag = cleanRowText, result.diagnose(pg, row, RowSkipped, "above the item table")
+			continue
+		}
+
+		// Totals end the table
+		if strings.Contains(cleanRowText, "total") || strings.Contains(cleanRowText, "subtotal") {
+			addItem()
+			result.diagnose(pg, row, RowSkipped, "totals: end of the item table")
+			return false
+		}
+
+		itemStart := geom.isItemStart(row)
+		if waitingForItem {
+			if !itemStart {
+				result.diagnose(pg, row, RowSkipped, "before the first item on a continued page")
+				continue
+			}
+			waitingForItem = false
+		}
+
+		if itemStart {
+			addItem()
+		}
+		if len(row.contents) > 0 {
+			block = append(block, row)
+			blockDiags = append(blockDiags, result.diagnose(pg, row, RowItem, ""))
+		}
+	}
+
+	// Process any remaining block
+	addItem()
+	return pastHeaders
+}
+
+// diagnose records what happened to a row and returns its index.
+func (r *ExtractionResult) diagnose(pg Page, row rowData, status, reason string) int {
+	r.Diagnostics = append(r.Diagnostics, RowDiagnostic{
+		Page:   pg.Number,
+		Y:      row.y,
+		Text:   row.text(),
+		Status: status,
+		Reason: reason,
+	})
+	return len(r.Diagnostics) - 1
+}
@@ -0,0 +1,181 @@
+package extractor
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// Columns of the synthetic invoice table.
+const (
+	serialX = 20.0
+	nameX   = 50.0
+	qtyX    = 300.0
+)
+
+type cell struct {
+	x float64
+	s string
+}
+
+func row(y float64, cells ...cell) []pdf.Text {
+	var texts []pdf.Text
+	for _, c := range cells {
+		texts = append(texts, glyph(c.x, y, c.s))
+	}
+	return texts
+}
+
+func testPage(number int, rows ...[]pdf.Text) Page {
+	var texts []pdf.Text
+	for _, r := range rows {
+		texts = append(texts, r...)
+	}
+	return newPage(number, texts)
+}
+
+// A numbered two-item table, closed by its total.
+var (
+	tableHeader = row(700, cell{serialX, "#"}, cell{nameX, "Description"}, cell{qtyX, "Qty"})
+	milkRow     = row(680, cell{serialX, "1"}, cell{nameX, "Amul Taaza Toned Milk (500 ml)"}, cell{qtyX, "2"})
+	eggsRow     = row(660, cell{serialX, "2"}, cell{nameX, "Farm Eggs 6 pcs"}, cell{qtyX, "1"})
+	totalRow    = row(640, cell{nameX, "Item Total"}, cell{qtyX, "3"})
+	milk        = line{"Amul Taaza Toned Milk", 2, 500, "ml"}
+	eggs        = line{"Farm Eggs", 1, 6, "pcs"}
+)
+
+type line struct {
+	name  string
+	count float64
+	size  float64
+	unit  string
+}
+
+func linesOf(items []ExtractedItem) []line {
+	var lines []line
+	for _, it := range items {
+		lines = append(lines, line{it.Name, it.Count, it.UnitValue, it.Unit})
+	}
+	return lines
+}
+
+func flatten(rows ...[]pdf.Text) []pdf.Text {
+	var texts []pdf.Text
+	for _, r := range rows {
+		texts = append(texts, r...)
+	}
+	return texts
+}
+
+func TestParseTable(t *testing.T) {
+	tests := []struct {
+		name  string
+		pages []Page
+		want  []line
+	}{
+		{
+			name:  "one page",
+			pages: []Page{testPage(1, tableHeader, milkRow, eggsRow, totalRow)},
+			want:  []line{milk, eggs},
+		},
+		{
+			name: "table continues on page 2 without a header",
+			pages: []Page{
+				testPage(1, row(780, cell{nameX, "Tax Invoice"}), tableHeader, milkRow),
+				testPage(2,
+					row(780, cell{nameX, "Tax Invoice"}),
+					row(760, cell{nameX, "Page 2 of 2"}),
+					row(680, cell{serialX, "2"}, cell{nameX, "Farm Eggs 6 pcs"}, cell{qtyX, "1"}),
+					row(660, cell{nameX, "Item Total"}, cell{qtyX, "3"}),
+				),
+			},
+			want: []line{milk, eggs},
+		},
+		{
+			name: "a closed table does not continue",
+			pages: []Page{
+				testPage(1, tableHeader, milkRow, totalRow),
+				testPage(2, row(700, cell{serialX, "1"}, cell{nameX, "Rate Us On The App"}, cell{qtyX, "5"})),
+			},
+			want: []line{milk},
+		},
+		{
+			name: "wrapped name whose second line starts with a number",
+			pages: []Page{testPage(1, tableHeader,
+				row(680, cell{serialX, "1"}, cell{nameX, "Amul Taaza Toned Milk"}, cell{qtyX, "1"}),
+				row(670, cell{nameX, "2 x 500 ml"}),
+				eggsRow, totalRow,
+			)},
+			want: []line{{"Amul Taaza Toned Milk", 1, 1000, "ml"}, eggs},
+		},
+		{
+			name: "wrapped name without a serial column",
+			pages: []Page{testPage(1,
+				row(700, cell{nameX, "Description"}, cell{qtyX, "Qty"}),
+				row(680, cell{nameX, "Amul Taaza Toned Milk"}, cell{qtyX, "1"}),
+				row(670, cell{nameX, "2 x 500 ml"}),
+				row(660, cell{nameX, "Farm Eggs 6 pcs"}, cell{qtyX, "1"}),
+				totalRow,
+			)},
+			want: []line{{"Amul Taaza Toned Milk", 1, 1000, "ml"}, eggs},
+		},
+		{
+			name:  "faux bold",
+			pages: []Page{newPage(1, fauxBold(flatten(tableHeader, milkRow, eggsRow, totalRow)))},
+			want:  []line{milk, eggs},
+		},
+		{
+			name: "rotated page",
+			pages: []Page{newPage(1, rotateTexts(
+				drawRotated(flatten(tableHeader, milkRow, eggsRow, totalRow), 90), 90, pageWidth, pageHeight,
+			))},
+			want: []line{milk, eggs},
+		},
+		{
+			name:  "no header",
+			pages: []Page{testPage(1, milkRow, eggsRow)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var result ExtractionResult
+			parseTable(tt.pages, defaultLayout, &result)
+			if got := linesOf(result.Items); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("items = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTableRowsDiagnoses(t *testing.T) {
+	pages := []Page{
+		testPage(1, tableHeader, milkRow),
+		testPage(2,
+			row(780, cell{nameX, "Tax Invoice"}),
+			row(680, cell{serialX, "2"}, cell{nameX, "Farm Eggs 6 pcs"}, cell{qtyX, "1"}),
+			row(660, cell{nameX, "Item Total"}, cell{qtyX, "3"}),
+		),
+	}
+	var result ExtractionResult
+	parseTable(pages, defaultLayout, &result)
+
+	type diag struct {
+		page           int
+		status, reason string
+	}
+	var got []diag
+	for _, d := range result.Diagnostics {
+		got = append(got, diag{d.Page, d.Status, d.Reason})
+	}
+	want := []diag{
+		{1, RowHeader, ""},
+		{1, RowItem, "item: Amul Taaza Toned Milk"},
+		{2, RowSkipped, "before the first item on a continued page"},
+		{2, RowItem, "item: Farm Eggs"},
+		{2, RowSkipped, "totals: end of the item table"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("diagnostics = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,394 @@
+package jobs
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pmitra96/pateproject/database"
+	"github.com/pmitra96/pateproject/llm"
+	"github.com/pmitra96/pateproject/logger"
+	"github.com/pmitra96/pateproject/models"
+	"github.com/pmitra96/pateproject/services"
+	"gorm.io/gorm"
+)
+
+const (
+	// maxIngestAttempts is how many times a job is tried before it fails.
+	maxIngestAttempts = 5
+	// ingestRetryDelay is doubled after every failed attempt.
+	ingestRetryDelay = 30 * time.Second
+	// ingestPollInterval is how often the queue is checked for retries and
+	// jobs submitted while the channel was full.
+	ingestPollInterval = 15 * time.Second
+	// ingestLease is how long a running job stays claimed without a
+	// heartbeat before another instance may take it over.
+	ingestLease = 2 * time.Minute
+	// ingestHeartbeat is how often a running job's lease is renewed.
+	ingestHeartbeat = 30 * time.Second
+)
+
+var ingestSteps = []string{models.IngestStepExtract, models.IngestStepRecord, models.IngestStepFollowUp}
+
+// IngestionWorker processes order ingestion jobs from the ingestion_jobs
+// table. The table is the queue; the channel only wakes the worker early.
+type IngestionWorker struct {
+	wake chan uint
+}
+
+var (
+	ingestionWorker     *IngestionWorker
+	ingestionWorkerOnce sync.Once
+)
+
+// GetIngestionWorker returns the singleton IngestionWorker, starting it and
+// resuming jobs left unfinished by a stopped instance.
+func GetIngestionWorker() *IngestionWorker {
+	ingestionWorkerOnce.Do(func() {
+		ingestionWorker = &IngestionWorker{wake: make(chan uint, 100)}
+		go ingestionWorker.run()
+		logger.Info("Ingestion worker started")
+	})
+	return ingestionWorker
+}
+
+// Submit stores an order as a queued ingestion job and wakes the worker.
+func (w *IngestionWorker) Submit(userID uint, payload services.OrderPayload) (models.IngestionJob, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return models.IngestionJob{}, err
+	}
+
+	job := models.IngestionJob{
+		UserID:          userID,
+		Provider:        payload.Provider,
+		ExternalOrderID: payload.ExternalOrderID,
+		EmailMessageID:  payload.EmailMessageID,
+		InvoiceNumber:   payload.InvoiceNumber,
+		Payload:         string(body),
+		Status:          models.JobQueued,
+		Step:            ingestSteps[0],
+		NextRunAt:       time.Now(),
+	}
+	for _, name := range ingestSteps {
+		job.Steps = append(job.Steps, models.IngestionJobStep{Name: name, Status: models.JobQueued})
+	}
+	if err := database.DB.Create(&job).Error; err != nil {
+		return job, err
+	}
+
+	select {
+	case w.wake <- job.ID:
+	default:
+		// The poller will pick it up
+	}
+	return job, nil
+}
+
+// resume requeues running jobs whose lease has lapsed: their instance
+// stopped without finishing them. Jobs another live instance is running
+// keep renewing their lease and are left alone.
+func (w *IngestionWorker) resume() {
+	now := time.Now()
+	result := database.DB.Model(&models.IngestionJob{}).
+		Where("status = ? AND (locked_until IS NULL OR locked_until < ?)", models.JobRunning, now).
+		Updates(map[string]interface{}{"status": models.JobQueued, "next_run_at": now, "locked_until": nil})
+	if result.Error != nil {
+		logger.Error("Failed to resume ingestion jobs", "error", result.Error)
+	} else if result.RowsAffected > 0 {
+		logger.Info("Resuming interrupted ingestion jobs", "count", result.RowsAffected)
+	}
+}
+
+func (w *IngestionWorker) run() {
+	ticker := time.NewTicker(ingestPollInterval)
+	defer ticker.Stop()
+
+	w.processDue()
+	for {
+		select {
+		case id := <-w.wake:
+			w.process(id)
+		case <-ticker.C:
+			w.processDue()
+		}
+	}
+}
+
+// processDue runs every queued job that is due, after requeueing jobs
+// abandoned by a stopped instance.
+func (w *IngestionWorker) processDue() {
+	w.resume()
+	var ids []uint
+	database.DB.Model(&models.IngestionJob{}).
+		Where("status = ? AND next_run_at <= ?", models.JobQueued, time.Now()).
+		Order("id").Pluck("id", &ids)
+	for _, id := range ids {
+		w.process(id)
+	}
+}
+
+func (w *IngestionWorker) process(jobID uint) {
+	// Claim the job so a wake-up, a poll or another instance cannot also
+	// run it, and hold the lease until it is done.
+	claim := database.DB.Model(&models.IngestionJob{}).
+		Where("id = ? AND status = ?", jobID, models.JobQueued).
+		Updates(map[string]interface{}{
+			"status":       models.JobRunning,
+			"attempts":     gorm.Expr("attempts + 1"),
+			"locked_until": time.Now().Add(ingestLease),
+		})
+	if claim.Error != nil || claim.RowsAffected == 0 {
+		return
+	}
+	stop := heartbeat(jobID)
+	defer stop()
+
+	var job models.IngestionJob
+	if err := database.DB.Preload("Steps").First(&job, jobID).Error; err != nil {
+		logger.Error("Failed to load ingestion job", "job_id", jobID, "error", err)
+		return
+	}
+	logger.Info("Processing ingestion job", "job_id", job.ID, "step", job.Step, "attempt", job.Attempts)
+
+	var payload services.OrderPayload
+	if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+		// Retrying cannot fix a payload that does not parse.
+		job.Attempts = maxIngestAttempts
+		w.fail(&job, job.Step, err)
+		return
+	}
+
+	for _, name := range ingestSteps {
+		step := findStep(&job, name)
+		if step == nil {
+			job.Steps = append(job.Steps, models.IngestionJobStep{JobID: job.ID, Name: name})
+			step = &job.Steps[len(job.Steps)-1]
+		} else if step.Status == models.JobCompleted {
+			continue
+		}
+
+		now := time.Now()
+		step.Status = models.JobRunning
+		step.Attempts++
+		step.StartedAt = &now
+		job.Step = name
+		database.DB.Save(step)
+		database.DB.Model(&job).Update("step", name)
+
+		if err := w.runStep(&job, step, payload); err != nil {
+			w.fail(&job, name, err)
+			return
+		}
+		if job.Status == models.JobCompleted {
+			return
+		}
+	}
+	w.complete(&job)
+}
+
+// heartbeat renews a running job's lease until stop is called.
+func heartbeat(jobID uint) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(ingestHeartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				err := database.DB.Model(&models.IngestionJob{}).
+					Where("id = ? AND status = ?", jobID, models.JobRunning).
+					Update("locked_until", time.Now().Add(ingestLease)).Error
+				if err != nil {
+					logger.Warn("Failed to renew ingestion job lease", "job_id", jobID, "error", err)
+				}
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// runStep runs one step. Each step saves its own result together with its
+// completed status, so a crash in between repeats the step rather than
+// skipping it. Results are copied onto job only once their transaction has
+// committed, so a rolled-back step leaves nothing behind for fail to save.
+func (w *IngestionWorker) runStep(job *models.IngestionJob, step *models.IngestionJobStep, payload services.OrderPayload) error {
+	switch step.Name {
+	case models.IngestStepExtract:
+		extractions := services.ExtractOrderItems(database.DB, llm.NewClient(), payload.Items)
+		body, err := json.Marshal(extractions)
+		if err != nil {
+			return err
+		}
+		err = database.DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(job).Update("extractions", string(body)).Error; err != nil {
+				return err
+			}
+			return finishStep(tx, step)
+		})
+		if err != nil {
+			return err
+		}
+		job.Extractions = string(body)
+		return nil
+
+	case models.IngestStepRecord:
+		extractions := make(map[string]llm.PantryItemExtraction)
+		if job.Extractions != "" {
+			if err := json.Unmarshal([]byte(job.Extractions), &extractions); err != nil {
+				return err
+			}
+		}
+		var orderID uint
+		var pendingReview int
+		duplicate := false
+		err := database.DB.Transaction(func(tx *gorm.DB) error {
+			existing, err := services.FindIngestedOrder(tx, job.UserID, payload)
+			if err != nil {
+				return err
+			}
+			if existing != nil {
+				// Ingested by another job since this one was submitted.
+				logger.Warn("Duplicate order skipped", "job_id", job.ID, "order_id", existing.ID)
+				orderID, duplicate = existing.ID, true
+				err := tx.Model(job).Updates(map[string]interface{}{
+					"order_id":     existing.ID,
+					"status":       models.JobCompleted,
+					"last_error":   fmt.Sprintf("duplicate of order %d", existing.ID),
+					"completed_at": time.Now(),
+					"locked_until": nil,
+				}).Error
+				if err != nil {
+					return err
+				}
+				// Nothing left to follow up on
+				for _, s := range job.Steps {
+					if s.Status == models.JobCompleted {
+						continue
+					}
+					if err := finishStep(tx, &s); err != nil {
+						return err
+					}
+				}
+				return nil
+			}
+
+			order, pending, err := services.RecordOrder(tx, job.UserID, payload, extractions)
+			if err != nil {
+				return err
+			}
+			if err := tx.Model(job).Updates(map[string]interface{}{"order_id": order.ID, "pending_review": pending}).Error; err != nil {
+				return err
+			}
+			orderID, pendingReview = order.ID, pending
+			logger.Info("Order created successfully", "order_id", order.ID, "user_id", job.UserID, "job_id", job.ID)
+			return finishStep(tx, step)
+		})
+		if err != nil {
+			return err
+		}
+
+		job.OrderID = &orderID
+		if duplicate {
+			now := time.Now()
+			job.Status = models.JobCompleted
+			job.LastError = fmt.Sprintf("duplicate of order %d", orderID)
+			job.CompletedAt = &now
+			job.LockedUntil = nil
+		} else {
+			job.PendingReview = pendingReview
+		}
+		return nil
+
+	case models.IngestStepFollowUp:
+		if job.OrderID == nil {
+			return finishStep(database.DB, step)
+		}
+		var items []models.Item
+		database.DB.Joins("JOIN order_items ON order_items.item_id = items.id").
+			Where("order_items.order_id = ?", *job.OrderID).Distinct().Find(&items)
+
+		ingredientIDs := make([]uint, 0, len(items))
+		for _, item := range items {
+			ingredientIDs = append(ingredientIDs, item.IngredientID)
+		}
+		if _, err := services.CheckOffPurchased(database.DB, job.UserID, *job.OrderID, ingredientIDs); err != nil {
+			return err
+		}
+		// Already verified items are skipped by the nutrition worker
+		nutritionWorker := GetWorker()
+		for _, item := range items {
+			if !item.NutritionVerified {
+				nutritionWorker.Enqueue(item.ID)
+			}
+		}
+		return finishStep(database.DB, step)
+	}
+	return fmt.Errorf("unknown ingestion step %q", step.Name)
+}
+
+func (w *IngestionWorker) complete(job *models.IngestionJob) {
+	now := time.Now()
+	job.Status = models.JobCompleted
+	job.LastError = ""
+	job.CompletedAt = &now
+	job.LockedUntil = nil
+	if err := database.DB.Omit("Steps").Save(job).Error; err != nil {
+		logger.Error("Failed to complete ingestion job", "job_id", job.ID, "error", err)
+		return
+	}
+	logger.Info("Ingestion job completed", "job_id", job.ID, "order_id", job.OrderID, "pending_review", job.PendingReview)
+}
+
+// fail records a failed attempt and schedules a retry with exponential
+// backoff, or gives up after maxIngestAttempts.
+func (w *IngestionWorker) fail(job *models.IngestionJob, stepName string, err error) {
+	if step := findStep(job, stepName); step != nil {
+		now := time.Now()
+		step.Status = models.JobFailed
+		step.Error = err.Error()
+		step.FinishedAt = &now
+		database.DB.Save(step)
+	}
+
+	job.LastError = err.Error()
+	if job.Attempts >= maxIngestAttempts {
+		job.Status = models.JobFailed
+		logger.Error("Ingestion job failed", "job_id", job.ID, "step", stepName, "attempts", job.Attempts, "error", err)
+	} else {
+		job.Status = models.JobQueued
+		job.NextRunAt = time.Now().Add(ingestRetryDelay << (job.Attempts - 1))
+		logger.Warn("Ingestion job step failed, retrying", "job_id", job.ID, "step", stepName, "attempt", job.Attempts, "retry_at", job.NextRunAt, "error", err)
+	}
+	// Only the retry state: whatever the failed step changed was rolled back
+	result := database.DB.Model(job).Updates(map[string]interface{}{
+		"status":       job.Status,
+		"attempts":     job.Attempts,
+		"last_error":   job.LastError,
+		"next_run_at":  job.NextRunAt,
+		"locked_until": nil,
+	})
+	if result.Error != nil {
+		logger.Error("Failed to save ingestion job", "job_id", job.ID, "error", result.Error)
+	}
+}
+
+func finishStep(tx *gorm.DB, step *models.IngestionJobStep) error {
+	now := time.Now()
+	step.Status = models.JobCompleted
+	step.Error = ""
+	step.FinishedAt = &now
+	return tx.Save(step).Error
+}
+
+func findStep(job *models.IngestionJob, name string) *models.IngestionJobStep {
+	for i := range job.Steps {
+		if job.Steps[i].Name == name {
+			return &job.Steps[i]
+		}
+	}
+	return nil
+}
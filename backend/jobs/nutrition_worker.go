@@ -31,6 +31,7 @@ type NutritionUpdate struct {
 	Fat      float64 `json:"fat"`
 	Fiber    float64 `json:"fiber"`
 	Verified bool    `json:"nutrition_verified"`
+	Provider string  `json:"nutrition_provider,omitempty"`
 }
 
 var (
@@ -122,6 +123,7 @@ func (w *NutritionWorker) processJob(job NutritionJob) {
 		Fat:      item.Fat,
 		Fiber:    item.Fiber,
 		Verified: item.NutritionVerified,
+		Provider: item.NutritionProvider,
 	}
 
 	w.subMux.RLock()
@@ -123,18 +123,62 @@ type InventoryItem struct {
 	Name     string  `json:"name"`
 	Quantity float64 `json:"quantity"`
 	Unit     string  `json:"unit"`
-	// Nutrition per 100g/ml or per unit
-	Calories float64 `json:"calories"`
-	Protein  float64 `json:"protein"`
-	Fat      float64 `json:"fat"`
-	Carbs    float64 `json:"carbs"`
+	// Nutrition per NutritionPer, e.g. "100 g" or "1 pcs"
+	Calories     float64 `json:"calories"`
+	Protein      float64 `json:"protein"`
+	Fat          float64 `json:"fat"`
+	Carbs        float64 `json:"carbs"`
+	NutritionPer string  `json:"nutrition_per,omitempty"`
+	// ExpiresInDays is set when some of the stock spoils soon.
+	ExpiresInDays *int `json:"expires_in_days,omitempty"`
+	// Category is the ingredient's category, e.g. "Paneer & Tofu".
+	Category string `json:"category,omitempty"`
+	// Allergens the item is known to contain, e.g. "gluten,lactose".
+	Allergens string `json:"allergens,omitempty"`
 }
 
+// inventoryLine formats one pantry entry for a prompt, flagging stock that
+// should be used up first.
+func inventoryLine(item InventoryItem, format string) string {
+	line := fmt.Sprintf("- %s: "+format+" %s", item.Name, item.Quantity, item.Unit)
+	if item.Category != "" {
+		line += " [" + item.Category + "]"
+	}
+	if item.NutritionPer != "" && item.Calories > 0 {
+		line += fmt.Sprintf(" (per %s: %.0f kcal, %.1fg protein, %.1fg fat, %.1fg carbs)", item.NutritionPer, item.Calories, item.Protein, item.Fat, item.Carbs)
+	}
+	if item.Allergens != "" {
+		line += " (contains " + strings.ReplaceAll(item.Allergens, ",", ", ") + ")"
+	}
+	if item.ExpiresInDays != nil {
+		if *item.ExpiresInDays <= 0 {
+			line += " (expires today, use first)"
+		} else {
+			line += fmt.Sprintf(" (use within %d days)", *item.ExpiresInDays)
+		}
+	}
+	return line + "\n"
+}
+
+// Where an extraction came from.
+const (
+	SourceLLM       = "llm"
+	SourceHeuristic = "heuristic"
+	SourceAlias     = "alias"
+)
+
+// defaultLLMConfidence is assumed when the model omits a confidence.
+const defaultLLMConfidence = 0.75
+
 type PantryItemExtraction struct {
 	Ingredient string  `json:"ingredient"`
 	Brand      *string `json:"brand"`
 	Product    *string `json:"product"`
+	Category   string  `json:"category,omitempty"`
 	Nutrition  any     `json:"nutrition"`
+	// Confidence (0-1) that the split is right; Source says who made it.
+	Confidence float64 `json:"confidence"`
+	Source     string  `json:"source,omitempty"`
 }
 
 func (c *Client) ExtractPantryItemInfo(rawName string) (*PantryItemExtraction, error) {
@@ -180,11 +224,18 @@ IMPORTANT: Return ONLY valid JSON in this exact format:
 	return &extraction, nil
 }
 
-func (c *Client) ExtractPantryItemsBatch(rawNames []string) ([]PantryItemExtraction, error) {
+// ExtractPantryItemsBatch splits raw names into ingredient, brand and
+// product. When categories are given, each item is also filed under one.
+func (c *Client) ExtractPantryItemsBatch(rawNames []string, categories []string) ([]PantryItemExtraction, error) {
 	if len(rawNames) == 0 {
 		return nil, nil
 	}
 
+	categoryRule := ""
+	if len(categories) > 0 {
+		categoryRule = fmt.Sprintf("\n- category: exactly one of: %s. Return null if none fits.", strings.Join(categories, "; "))
+	}
+
 	itemsList := strings.Join(rawNames, "\n- ")
 	prompt := fmt.Sprintf(`Split these raw pantry item names into structured fields. Return a JSON array of objects.
 
@@ -194,14 +245,15 @@ Items:
 Rules for each object:
 - ingredient: the canonical, brand-agnostic ingredient name (e.g., "Milk", "Curd", "Bread"). Must not contain brand names.
 - brand: the brand or manufacturer name (e.g., "Amul", "Akshayakalpa"). Return null if not present.
-- product: the brand-specific product name WITHOUT the brand (e.g., "Taaza Toned Milk", "Artisanal Organic Set Curd").
+- product: the brand-specific product name WITHOUT the brand (e.g., "Taaza Toned Milk", "Artisanal Organic Set Curd").%s
+- confidence: a number from 0 to 1 for how sure you are of the ingredient and brand split. Use below 0.5 for names you cannot identify.
 - nutrition: always return null.
 
 Format:
 [
-  {"ingredient": "...", "brand": "...", "product": "...", "nutrition": null},
+  {"ingredient": "...", "brand": "...", "product": "...", "category": "...", "confidence": 0.9, "nutrition": null},
   ...
-]`, itemsList)
+]`, itemsList, categoryRule)
 
 	resp, err := c.Chat([]Message{
 		{Role: "system", Content: "You are a grocery data expert. You specialize in normalizing item names into canonical ingredients and brands. Always return valid JSON only."},
@@ -226,21 +278,35 @@ Format:
 		return nil, fmt.Errorf("failed to parse batch extraction JSON: %w", err)
 	}
 
+	for i := range extractions {
+		extractions[i].Source = SourceLLM
+		switch {
+		case strings.TrimSpace(extractions[i].Ingredient) == "":
+			extractions[i].Confidence = 0
+		case extractions[i].Confidence <= 0:
+			extractions[i].Confidence = defaultLLMConfidence
+		case extractions[i].Confidence > 1:
+			extractions[i].Confidence = 1
+		}
+	}
+
 	return extractions, nil
 }
 
 // ExtractHeuristic provides a basic rule-based split when LLM is unavailable.
-func (c *Client) ExtractHeuristic(rawName string) *PantryItemExtraction {
+// knownBrands are lower-case brand names and aliases from the catalog,
+// longest first.
+func (c *Client) ExtractHeuristic(rawName string, knownBrands []string) *PantryItemExtraction {
 	lowerName := strings.ToLower(rawName)
 
-	commonBrands := []string{"amul", "mooz", "akshayakalpa", "mother dairy", "milky mist", "britannia", "nestle", "urban platter", "dehaat", "honest farms", "hen fruit", "blinkit", "zepto", "swiggy", "instamart", "tata sampann", "tata", "fortune", "aashirvaad", "dabur", "haldiram", "epigamia"}
 	commonIngredients := []string{"milk", "curd", "tofu", "bread", "egg", "eggs", "paneer", "butter", "cheese", "tomato", "potato", "onion", "broccoli", "peanuts", "atta", "wheat", "rice", "kala chana", "chana", "dal", "moong", "masoor", "besan", "sugar", "salt", "oil", "ghee"}
 
 	var foundBrand *string
 	var foundIngredient string = rawName // Default to raw name
+	confidence := 0.2
 
 	// 1. Try to find a brand
-	for _, brand := range commonBrands {
+	for _, brand := range knownBrands {
 		if strings.Contains(lowerName, brand) {
 			b := strings.Title(brand)
 			foundBrand = &b
@@ -252,9 +318,14 @@ func (c *Client) ExtractHeuristic(rawName string) *PantryItemExtraction {
 	for _, ing := range commonIngredients {
 		if strings.Contains(lowerName, ing) {
 			foundIngredient = strings.Title(ing)
+			confidence = 0.5
 			break
 		}
 	}
+	// Substring matches are only trustworthy when the brand is known too.
+	if foundBrand != nil && foundIngredient != rawName {
+		confidence = 0.7
+	}
 
 	// 3. Simple product name: strip the brand if found
 	productName := rawName
@@ -268,6 +339,8 @@ func (c *Client) ExtractHeuristic(rawName string) *PantryItemExtraction {
 		Brand:      foundBrand,
 		Product:    &productName,
 		Nutrition:  nil,
+		Confidence: confidence,
+		Source:     SourceHeuristic,
 	}
 }
 
@@ -281,6 +354,8 @@ type UserPreferencesInfo struct {
 	State             string   `json:"state"`
 	City              string   `json:"city"`
 	PreferredCuisines []string `json:"preferred_cuisines"`
+	Diet              string   `json:"diet,omitempty"`
+	AvoidAllergens    []string `json:"avoid_allergens,omitempty"`
 }
 
 type DishSampleInfo struct {
@@ -294,13 +369,14 @@ type DishSampleInfo struct {
 func (c *Client) SuggestMeals(inventory []InventoryItem) (string, error) {
 	items := ""
 	for _, item := range inventory {
-		items += fmt.Sprintf("- %s: %.2f %s\n", item.Name, item.Quantity, item.Unit)
+		items += inventoryLine(item, "%.2f")
 	}
 
 	prompt := fmt.Sprintf(`I have the following ingredients in my pantry:
 %s
 
 Suggest 3 meals I can cook using these ingredients. You can assume I have basic spices (salt, pepper, oil, turmeric, chili powder).
+Use up ingredients marked "use within" or "expires today" first.
 For each meal, provide:
 1. Name
 2. Ingredients needed (with quantities)
@@ -327,7 +403,7 @@ func (c *Client) SuggestMealsPersonalized(inventory []InventoryItem, goals []Goa
 	// Build inventory list
 	var items string
 	for _, item := range inventory {
-		items += fmt.Sprintf("- %s: %.0f %s\n", item.Name, item.Quantity, item.Unit)
+		items += inventoryLine(item, "%.0f")
 	}
 
 	// Build goals list
@@ -370,6 +446,12 @@ func (c *Client) SuggestMealsPersonalized(inventory []InventoryItem, goals []Goa
 		if len(preferences.PreferredCuisines) > 0 {
 			preferencesText += "\nPreferred Cuisines: " + strings.Join(preferences.PreferredCuisines, ", ")
 		}
+		if preferences.Diet != "" {
+			preferencesText += "\nDiet (strict, every meal must fit it): " + preferences.Diet
+		}
+		if len(preferences.AvoidAllergens) > 0 {
+			preferencesText += "\nAllergies (never use ingredients containing these): " + strings.Join(preferences.AvoidAllergens, ", ")
+		}
 	}
 
 	// Build dish samples context
@@ -418,6 +500,7 @@ IMPORTANT QUALITY GUIDELINES - Self-evaluate before responding:
 - Calorie estimates must be ACCURATE for portion sizes
 - Protein values must match the actual ingredients used
 - Prioritize dishes from user's preferred cuisines when possible
+- Prefer ingredients marked "use within" or "expires today" so they don't go to waste
 
 IMPORTANT RULES:
 1. All meal portions MUST be calculated for EXACTLY 1 serving (for one person).
@@ -463,7 +546,7 @@ Set "confidence" (1-10) based on how well you followed the quality guidelines.`,
 	fmt.Println("\n========== LLM PROMPT ==========")
 	fmt.Println("SYSTEM:", messages[0].Content)
 	fmt.Println("\nUSER:", messages[1].Content)
-	fmt.Println("================================\n")
+	fmt.Print("================================\n\n")
 
 	// Step 1: Generate with self-evaluation
 	initialResponse, err := c.Chat(messages)
@@ -0,0 +1,39 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/pmitra96/pateproject/config"
+	"github.com/pmitra96/pateproject/logger"
+)
+
+// RequireAdmin restricts a route to signed-in users whose verified email is
+// listed in ADMIN_EMAILS (comma-separated). Use after OAuthMiddleware.
+func RequireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := PrincipalFromContext(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !principal.EmailVerified || !isAdminEmail(principal.Email) {
+			logger.Warn("Rejected non-admin request", "provider", principal.Provider, "subject", principal.Subject, "path", r.URL.Path)
+			http.Error(w, "Forbidden: admin only", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func isAdminEmail(email string) bool {
+	if email == "" {
+		return false
+	}
+	for _, admin := range splitList(config.GetEnv("ADMIN_EMAILS", "")) {
+		if strings.EqualFold(admin, email) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,122 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pmitra96/pateproject/models"
+)
+
+// memoryAPIKeys is an APIKeyStore over a map of key hashes.
+type memoryAPIKeys struct {
+	keys    map[string]*models.APIKey
+	touched map[uint]time.Time
+}
+
+func (m *memoryAPIKeys) Lookup(hash string) (*models.APIKey, error) {
+	key, ok := m.keys[hash]
+	if !ok {
+		return nil, errors.New("record not found")
+	}
+	copied := *key
+	return &copied, nil
+}
+
+func (m *memoryAPIKeys) Touch(key *models.APIKey, now time.Time) error {
+	m.touched[key.ID] = now
+	m.keys[key.KeyHash].LastUsedAt = &now
+	return nil
+}
+
+// useAPIKeys installs store as the key store for the rest of the test.
+func useAPIKeys(t *testing.T, keys ...models.APIKey) *memoryAPIKeys {
+	t.Helper()
+	store := &memoryAPIKeys{keys: make(map[string]*models.APIKey), touched: make(map[uint]time.Time)}
+	for i := range keys {
+		store.keys[keys[i].KeyHash] = &keys[i]
+	}
+	previous := apiKeys
+	apiKeys = store
+	t.Cleanup(func() { apiKeys = previous })
+	return store
+}
+
+// serveWithKey runs a request with an X-API-Key through RequireScope and
+// returns the response and the key the handler saw.
+func serveWithKey(scope, raw string) (*httptest.ResponseRecorder, *models.APIKey) {
+	var seen *models.APIKey
+	handler := RequireScope(scope)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen, _ = APIKeyFromContext(r.Context())
+	}))
+	r := httptest.NewRequest(http.MethodPost, "/ingest/order", nil)
+	r.Header.Set("X-API-Key", raw)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w, seen
+}
+
+func TestRequireScopeRejects(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+	useAPIKeys(t,
+		models.APIKey{ID: 1, UserID: 7, KeyHash: HashAPIKey("pk_revoked"), Scopes: ScopeIngestOrders, RevokedAt: &past},
+		models.APIKey{ID: 2, UserID: 7, KeyHash: HashAPIKey("pk_expired"), Scopes: ScopeIngestOrders, ExpiresAt: &past},
+		models.APIKey{ID: 3, UserID: 7, KeyHash: HashAPIKey("pk_unscoped"), Scopes: "other:scope", ExpiresAt: &future},
+	)
+
+	tests := []struct {
+		name string
+		key  string
+	}{
+		{"unknown key", "pk_unknown"},
+		{"revoked key", "pk_revoked"},
+		{"expired key", "pk_expired"},
+		{"missing scope", "pk_unscoped"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, seen := serveWithKey(ScopeIngestOrders, tt.key)
+			if w.Code != http.StatusForbidden {
+				t.Errorf("status = %d, want %d", w.Code, http.StatusForbidden)
+			}
+			if seen != nil {
+				t.Error("handler ran for a rejected key")
+			}
+		})
+	}
+}
+
+func TestRequireScopeActsAsOwner(t *testing.T) {
+	future := time.Now().Add(time.Hour)
+	store := useAPIKeys(t, models.APIKey{
+		ID:        1,
+		UserID:    7,
+		KeyHash:   HashAPIKey("pk_live"),
+		Scopes:    "other:scope, " + ScopeIngestOrders,
+		ExpiresAt: &future,
+	})
+
+	w, seen := serveWithKey(ScopeIngestOrders, "pk_live")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	if seen == nil || seen.UserID != 7 {
+		t.Fatalf("handler saw key %+v, want the one owned by user 7", seen)
+	}
+	if seen.LastUsedAt == nil {
+		t.Error("key in the context has no last use")
+	}
+	first, ok := store.touched[1]
+	if !ok {
+		t.Fatal("last_used_at not recorded")
+	}
+
+	// A second request within the minute does not write again
+	serveWithKey(ScopeIngestOrders, "pk_live")
+	if store.touched[1] != first {
+		t.Error("last_used_at written twice within a minute")
+	}
+}
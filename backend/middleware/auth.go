@@ -4,62 +4,161 @@ import (
 	"context"
 	"net/http"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/pmitra96/pateproject/config"
+	"github.com/pmitra96/pateproject/logger"
 )
 
 type contextKey string
 
-const UserContextKey contextKey = "user_id"
+// PrincipalContextKey holds the *Principal of an authenticated request.
+const PrincipalContextKey contextKey = "principal"
 
-// OAuthMiddleware simulates OAuth protection.
-// In a real app, this would validate a JWT token or session.
-// For now, checks for 'Authorization: Bearer <user_id>' for simplicity/mocking
-// OR calls a real provider (but we don't have one configured).
-// We'll require a header "X-User-ID" for testing/MVP as per instructions "Focus on correctness over polish".
-// Actually, strict OAuth implies a flow, but validating the token is the backend part.
-// I'll assume the frontend sends a mock token or real token.
-// Let's use a simple mock: "Authorization: Bearer <user_id>" where user_id acts as the token.
-func OAuthMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			http.Error(w, "Unauthorized: No Authorization header", http.StatusUnauthorized)
-			return
+// Principal is the verified identity behind a request's bearer token.
+type Principal struct {
+	Provider      string                 `json:"provider"`
+	Issuer        string                 `json:"iss"`
+	Subject       string                 `json:"sub"`
+	Email         string                 `json:"email,omitempty"`
+	EmailVerified bool                   `json:"email_verified"`
+	Name          string                 `json:"name,omitempty"`
+	ExpiresAt     time.Time              `json:"exp"`
+	Claims        map[string]interface{} `json:"-"`
+}
+
+// PrincipalFromContext returns the principal set by OAuthMiddleware.
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(PrincipalContextKey).(*Principal)
+	return p, ok && p != nil
+}
+
+const (
+	googleJWKSURL = "https://www.googleapis.com/oauth2/v3/certs"
+	appleJWKSURL  = "https://appleid.apple.com/auth/keys"
+)
+
+var (
+	defaultAuthenticator     *Authenticator
+	defaultAuthenticatorOnce sync.Once
+)
+
+// DefaultAuthenticator builds the process-wide authenticator from the
+// environment. Each configured provider contributes a trusted issuer and
+// its claim mapping:
+//   - GOOGLE_CLIENT_ID trusts Google ID tokens issued for that client.
+//   - APPLE_CLIENT_ID trusts Sign in with Apple ID tokens.
+//   - GITHUB_CLIENT_ID / GITHUB_CLIENT_SECRET accept GitHub OAuth app tokens.
+//   - OIDC_ISSUER / OIDC_AUDIENCE with OIDC_JWKS_URL or OIDC_JWKS_FILE trusts
+//     a generic OIDC issuer (a local JWKS file works offline). OIDC_PROVIDER
+//     names it, OIDC_EMAIL_CLAIM / OIDC_NAME_CLAIM adjust the claim mapping
+//     and OIDC_TRUST_EMAIL=true honours its email_verified claim.
+func DefaultAuthenticator() *Authenticator {
+	defaultAuthenticatorOnce.Do(func() {
+		v := NewVerifier()
+		reg := NewProviderRegistry()
+
+		if clientID := config.GetEnv("GOOGLE_CLIENT_ID", ""); clientID != "" {
+			google := NewGoogleProvider()
+			keys := NewRemoteKeySet(config.GetEnv("GOOGLE_JWKS_URL", googleJWKSURL))
+			for _, iss := range google.Issuers() {
+				v.AddIssuer(IssuerConfig{Issuer: iss, Audiences: splitList(clientID), Keys: keys})
+			}
+			reg.Register(google)
+		}
+
+		if clientID := config.GetEnv("APPLE_CLIENT_ID", ""); clientID != "" {
+			apple := NewAppleProvider()
+			keys := NewRemoteKeySet(config.GetEnv("APPLE_JWKS_URL", appleJWKSURL))
+			for _, iss := range apple.Issuers() {
+				v.AddIssuer(IssuerConfig{Issuer: iss, Audiences: splitList(clientID), Keys: keys})
+			}
+			reg.Register(apple)
 		}
 
-		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			http.Error(w, "Unauthorized: Invalid Authorization format", http.StatusUnauthorized)
-			return
+		if clientID := config.GetEnv("GITHUB_CLIENT_ID", ""); clientID != "" {
+			secret := config.GetEnv("GITHUB_CLIENT_SECRET", "")
+			if secret == "" {
+				logger.Error("GITHUB_CLIENT_ID set without GITHUB_CLIENT_SECRET, ignoring")
+			} else {
+				reg.Register(NewGitHubProvider(clientID, secret))
+			}
 		}
 
-		// In a real system, verify token here.
-		// For MVP, we treat the token as the UserID.
-		// If UserID is not a number, we might fail or handle mapping.
-		// Prompt says: "Authenticated via OAuth".
-		// We'll trust the token IS the userID for this exercise
-		// or map a hardcoded 'demo-token' to user 1.
-		userID := parts[1]
-
-		// Set user_id in context
-		ctx := context.WithValue(r.Context(), UserContextKey, userID)
-		next.ServeHTTP(w, r.WithContext(ctx))
+		if issuer := config.GetEnv("OIDC_ISSUER", ""); issuer != "" {
+			var keys KeySource
+			if path := config.GetEnv("OIDC_JWKS_FILE", ""); path != "" {
+				keys = NewFileKeySet(path)
+			} else if url := config.GetEnv("OIDC_JWKS_URL", ""); url != "" {
+				keys = NewRemoteKeySet(url)
+			}
+			audiences := splitList(config.GetEnv("OIDC_AUDIENCE", ""))
+			if keys == nil || len(audiences) == 0 {
+				logger.Error("OIDC_ISSUER set without OIDC_AUDIENCE and a JWKS source, ignoring", "issuer", issuer)
+			} else {
+				v.AddIssuer(IssuerConfig{Issuer: issuer, Audiences: audiences, Keys: keys})
+				reg.Register(NewOIDCProvider(
+					config.GetEnv("OIDC_PROVIDER", "oidc"),
+					issuer,
+					config.GetEnv("OIDC_EMAIL_CLAIM", "email"),
+					config.GetEnv("OIDC_NAME_CLAIM", "name"),
+					config.GetEnv("OIDC_TRUST_EMAIL", "") == "true",
+				))
+			}
+		}
+
+		if len(reg.byName) == 0 {
+			logger.Warn("No identity providers configured; all authenticated requests will be rejected")
+		} else {
+			logger.Info("Token verifier configured", "issuers", v.Issuers())
+		}
+		defaultAuthenticator = &Authenticator{Verifier: v, Providers: reg}
 	})
+	return defaultAuthenticator
 }
 
-// APIKeyMiddleware protecs ingestion endpoints.
-// Checks X-API-Key header.
-func APIKeyMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		apiKey := r.Header.Get("X-API-Key")
-		expectedKey := config.GetEnv("INGESTION_API_KEY", "secret-key")
+// OAuthMiddleware requires a valid bearer token from a configured identity
+// provider and stores the resulting Principal in the request context.
+func OAuthMiddleware(next http.Handler) http.Handler {
+	return NewOAuthMiddleware(DefaultAuthenticator())(next)
+}
 
-		if apiKey != expectedKey {
-			http.Error(w, "Forbidden: Invalid API Key", http.StatusForbidden)
-			return
-		}
+// NewOAuthMiddleware returns an OAuthMiddleware using the given authenticator.
+func NewOAuthMiddleware(a *Authenticator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authHeader := r.Header.Get("Authorization")
+			if authHeader == "" {
+				http.Error(w, "Unauthorized: No Authorization header", http.StatusUnauthorized)
+				return
+			}
 
-		next.ServeHTTP(w, r)
-	})
+			parts := strings.Split(authHeader, " ")
+			if len(parts) != 2 || parts[0] != "Bearer" {
+				http.Error(w, "Unauthorized: Invalid Authorization format", http.StatusUnauthorized)
+				return
+			}
+
+			principal, err := a.Authenticate(parts[1])
+			if err != nil {
+				logger.Warn("Rejected bearer token", "error", err, "path", r.URL.Path)
+				http.Error(w, "Unauthorized: Invalid token", http.StatusUnauthorized)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), PrincipalContextKey, principal)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func splitList(raw string) []string {
+	var out []string
+	for _, s := range strings.Split(raw, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
 }
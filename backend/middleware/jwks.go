@@ -0,0 +1,252 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pmitra96/pateproject/logger"
+)
+
+// ErrUnknownKey is returned when no key in the set matches the token's kid.
+var ErrUnknownKey = errors.New("signing key not found")
+
+// KeySource resolves a public key by its key ID ("kid").
+type KeySource interface {
+	Key(kid string) (crypto.PublicKey, error)
+}
+
+// JWK is a single JSON Web Key as published in a JWKS document.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg,omitempty"`
+	Use string `json:"use,omitempty"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// EC
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set document.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// PublicKey converts the JWK into a usable crypto public key.
+func (k JWK) PublicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RSA modulus: %w", err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RSA exponent: %w", err)
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("unsupported EC curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EC x: %w", err)
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EC y: %w", err)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+// ParseJWKS decodes a JWKS document into a kid -> key map.
+// Keys that cannot be decoded or are not meant for signatures are skipped.
+func ParseJWKS(data []byte) (map[string]crypto.PublicKey, error) {
+	var set JWKS
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("failed to parse JWKS: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		pub, err := k.PublicKey()
+		if err != nil {
+			logger.Warn("Skipping unusable JWK", "kid", k.Kid, "error", err)
+			continue
+		}
+		keys[k.Kid] = pub
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("JWKS contains no usable signing keys")
+	}
+	return keys, nil
+}
+
+// StaticKeySet serves keys from a fixed in-memory map.
+type StaticKeySet map[string]crypto.PublicKey
+
+// Key implements KeySource.
+func (s StaticKeySet) Key(kid string) (crypto.PublicKey, error) {
+	if key, ok := s[kid]; ok {
+		return key, nil
+	}
+	return nil, ErrUnknownKey
+}
+
+// cachedKeySet holds the shared caching and rotation logic for key sets
+// that are loaded from somewhere (file, URL). When a token references a kid
+// we don't know, the set is reloaded so rotated keys are picked up, but no
+// more often than minRefresh to avoid hammering the source.
+type cachedKeySet struct {
+	load       func() (map[string]crypto.PublicKey, time.Duration, error)
+	minRefresh time.Duration
+
+	mu        sync.RWMutex
+	keys      map[string]crypto.PublicKey
+	expiresAt time.Time
+	fetchedAt time.Time
+}
+
+func (c *cachedKeySet) Key(kid string) (crypto.PublicKey, error) {
+	c.mu.RLock()
+	key, ok := c.keys[kid]
+	fresh := time.Now().Before(c.expiresAt)
+	c.mu.RUnlock()
+
+	if ok && fresh {
+		return key, nil
+	}
+
+	if err := c.refresh(!ok); err != nil {
+		// Serve a stale key rather than locking everyone out when the source is down.
+		if ok {
+			logger.Warn("JWKS refresh failed, using cached key", "kid", kid, "error", err)
+			return key, nil
+		}
+		return nil, err
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if key, ok := c.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, ErrUnknownKey
+}
+
+func (c *cachedKeySet) refresh(unknownKid bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Another goroutine may have refreshed while we waited for the lock.
+	if time.Now().Before(c.expiresAt) && !unknownKid {
+		return nil
+	}
+	if unknownKid && time.Since(c.fetchedAt) < c.minRefresh {
+		return nil
+	}
+
+	keys, ttl, err := c.load()
+	c.fetchedAt = time.Now()
+	if err != nil {
+		return err
+	}
+	c.keys = keys
+	c.expiresAt = c.fetchedAt.Add(ttl)
+	logger.Info("JWKS loaded", "keys", len(keys), "ttl", ttl)
+	return nil
+}
+
+// NewRemoteKeySet returns a KeySource backed by a JWKS URL. Keys are cached
+// for the max-age advertised by the server (default one hour).
+func NewRemoteKeySet(url string) KeySource {
+	client := &http.Client{Timeout: 5 * time.Second}
+	return &cachedKeySet{
+		minRefresh: time.Minute,
+		load: func() (map[string]crypto.PublicKey, time.Duration, error) {
+			resp, err := client.Get(url)
+			if err != nil {
+				return nil, 0, fmt.Errorf("JWKS request failed: %w", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusOK {
+				return nil, 0, fmt.Errorf("JWKS endpoint returned status: %d", resp.StatusCode)
+			}
+
+			var raw json.RawMessage
+			if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+				return nil, 0, fmt.Errorf("failed to decode JWKS response: %w", err)
+			}
+			keys, err := ParseJWKS(raw)
+			if err != nil {
+				return nil, 0, err
+			}
+			return keys, cacheMaxAge(resp.Header.Get("Cache-Control"), time.Hour), nil
+		},
+	}
+}
+
+// NewFileKeySet returns a KeySource backed by a JWKS file on disk. The file
+// is re-read every five minutes, or sooner when an unknown kid shows up.
+func NewFileKeySet(path string) KeySource {
+	return &cachedKeySet{
+		minRefresh: 5 * time.Second,
+		load: func() (map[string]crypto.PublicKey, time.Duration, error) {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, 0, fmt.Errorf("failed to read JWKS file: %w", err)
+			}
+			keys, err := ParseJWKS(data)
+			if err != nil {
+				return nil, 0, err
+			}
+			return keys, 5 * time.Minute, nil
+		},
+	}
+}
+
+// cacheMaxAge extracts max-age from a Cache-Control header.
+func cacheMaxAge(header string, fallback time.Duration) time.Duration {
+	for _, directive := range strings.Split(header, ",") {
+		directive = strings.TrimSpace(directive)
+		if v, ok := strings.CutPrefix(directive, "max-age="); ok {
+			if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+				return time.Duration(secs) * time.Second
+			}
+		}
+	}
+	return fallback
+}
@@ -0,0 +1,243 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// Token verification errors. They are deliberately coarse; the detailed
+// reason is wrapped for logging but never echoed back to the client.
+var (
+	ErrMalformedToken   = errors.New("malformed token")
+	ErrInvalidSignature = errors.New("invalid token signature")
+	ErrUnsupportedAlg   = errors.New("unsupported signing algorithm")
+	ErrUntrustedIssuer  = errors.New("untrusted token issuer")
+	ErrInvalidAudience  = errors.New("token audience mismatch")
+	ErrTokenExpired     = errors.New("token expired")
+	ErrTokenNotYetValid = errors.New("token not yet valid")
+)
+
+// IssuerConfig describes one trusted token issuer.
+type IssuerConfig struct {
+	Issuer    string
+	Audiences []string
+	Keys      KeySource
+}
+
+// Verifier validates signed JWTs (RS256/384/512, ES256/384) against a set
+// of trusted issuers and turns them into a Principal.
+type Verifier struct {
+	issuers map[string]IssuerConfig
+	leeway  time.Duration
+	now     func() time.Time
+}
+
+// NewVerifier creates a Verifier trusting the given issuers.
+func NewVerifier(issuers ...IssuerConfig) *Verifier {
+	v := &Verifier{
+		issuers: make(map[string]IssuerConfig),
+		leeway:  time.Minute,
+		now:     time.Now,
+	}
+	for _, iss := range issuers {
+		v.AddIssuer(iss)
+	}
+	return v
+}
+
+// AddIssuer registers (or replaces) a trusted issuer.
+func (v *Verifier) AddIssuer(cfg IssuerConfig) {
+	v.issuers[cfg.Issuer] = cfg
+}
+
+// Issuers returns the configured issuer identifiers.
+func (v *Verifier) Issuers() []string {
+	out := make([]string, 0, len(v.issuers))
+	for iss := range v.issuers {
+		out = append(out, iss)
+	}
+	return out
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Typ string `json:"typ"`
+}
+
+// Verify checks the token's signature, issuer, audience and validity window
+// and returns the authenticated principal.
+func (v *Verifier) Verify(token string) (*Principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformedToken
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: header: %v", ErrMalformedToken, err)
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: claims: %v", ErrMalformedToken, err)
+	}
+
+	issuer, _ := claims["iss"].(string)
+	cfg, ok := v.issuers[issuer]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUntrustedIssuer, issuer)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature encoding", ErrMalformedToken)
+	}
+
+	key, err := cfg.Keys.Key(header.Kid)
+	if err != nil {
+		return nil, fmt.Errorf("%w: kid %q: %v", ErrInvalidSignature, header.Kid, err)
+	}
+	if err := verifySignature(header.Alg, key, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, err
+	}
+
+	if !audienceMatches(claims["aud"], cfg.Audiences) {
+		return nil, ErrInvalidAudience
+	}
+
+	now := v.now()
+	exp, ok := numericClaim(claims, "exp")
+	if !ok {
+		return nil, fmt.Errorf("%w: missing exp", ErrMalformedToken)
+	}
+	if now.After(exp.Add(v.leeway)) {
+		return nil, ErrTokenExpired
+	}
+	if nbf, ok := numericClaim(claims, "nbf"); ok && now.Add(v.leeway).Before(nbf) {
+		return nil, ErrTokenNotYetValid
+	}
+	if iat, ok := numericClaim(claims, "iat"); ok && now.Add(v.leeway).Before(iat) {
+		return nil, ErrTokenNotYetValid
+	}
+
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return nil, fmt.Errorf("%w: missing sub", ErrMalformedToken)
+	}
+
+	p := &Principal{
+		Issuer:    issuer,
+		Subject:   subject,
+		ExpiresAt: exp,
+		Claims:    claims,
+	}
+	p.Email, _ = claims["email"].(string)
+	p.Name, _ = claims["name"].(string)
+	switch ev := claims["email_verified"].(type) {
+	case bool:
+		p.EmailVerified = ev
+	case string:
+		// Apple sends this as a string.
+		p.EmailVerified = ev == "true"
+	}
+	return p, nil
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func verifySignature(alg string, key crypto.PublicKey, signingInput string, sig []byte) error {
+	var h hash.Hash
+	var hashID crypto.Hash
+	switch alg {
+	case "RS256", "ES256":
+		h, hashID = sha256.New(), crypto.SHA256
+	case "RS384", "ES384":
+		h, hashID = sha512.New384(), crypto.SHA384
+	case "RS512":
+		h, hashID = sha512.New(), crypto.SHA512
+	default:
+		// Covers "none" and symmetric HS* algorithms, which we never accept.
+		return fmt.Errorf("%w: %q", ErrUnsupportedAlg, alg)
+	}
+	h.Write([]byte(signingInput))
+	digest := h.Sum(nil)
+
+	switch alg[:2] {
+	case "RS":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("%w: key type does not match %s", ErrInvalidSignature, alg)
+		}
+		if err := rsa.VerifyPKCS1v15(pub, hashID, digest, sig); err != nil {
+			return ErrInvalidSignature
+		}
+	case "ES":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("%w: key type does not match %s", ErrInvalidSignature, alg)
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return ErrInvalidSignature
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return ErrInvalidSignature
+		}
+	}
+	return nil
+}
+
+// audienceMatches accepts aud as either a string or an array of strings.
+func audienceMatches(aud interface{}, allowed []string) bool {
+	var got []string
+	switch a := aud.(type) {
+	case string:
+		got = []string{a}
+	case []interface{}:
+		for _, v := range a {
+			if s, ok := v.(string); ok {
+				got = append(got, s)
+			}
+		}
+	}
+	for _, g := range got {
+		for _, want := range allowed {
+			if g == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func numericClaim(claims map[string]interface{}, name string) (time.Time, bool) {
+	switch v := claims[name].(type) {
+	case float64:
+		return time.Unix(int64(v), 0), true
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return time.Unix(n, 0), true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,225 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testIssuer   = "https://issuer.test"
+	testAudience = "pateproject-test"
+)
+
+func newTestIssuer(t *testing.T) *LocalIssuer {
+	t.Helper()
+	li, err := NewLocalIssuer(testIssuer, testAudience)
+	if err != nil {
+		t.Fatalf("NewLocalIssuer: %v", err)
+	}
+	return li
+}
+
+func signTest(t *testing.T, li *LocalIssuer, ttl time.Duration, extra map[string]interface{}) string {
+	t.Helper()
+	token, err := li.Sign("user-1", ttl, extra)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	return token
+}
+
+// unsignedToken builds a token with the given header and claims and a
+// signature made by sign, which may be nil for an empty signature.
+func unsignedToken(t *testing.T, header jwtHeader, claims map[string]interface{}, sign func(input string) []byte) string {
+	t.Helper()
+	h, err := json.Marshal(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	var sig []byte
+	if sign != nil {
+		sig = sign(input)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestVerifyValidToken(t *testing.T) {
+	li := newTestIssuer(t)
+	v := NewVerifier(li.Config())
+
+	p, err := v.Verify(signTest(t, li, time.Hour, map[string]interface{}{
+		"email":          "a@example.com",
+		"email_verified": true,
+	}))
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if p.Issuer != testIssuer || p.Subject != "user-1" {
+		t.Errorf("principal = %s/%s, want %s/user-1", p.Issuer, p.Subject, testIssuer)
+	}
+	if p.Email != "a@example.com" || !p.EmailVerified {
+		t.Errorf("email = %q verified=%v", p.Email, p.EmailVerified)
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	li := newTestIssuer(t)
+	other := newTestIssuer(t)
+	v := NewVerifier(li.Config())
+
+	valid := signTest(t, li, time.Hour, nil)
+	otherPayload := signTest(t, li, time.Hour, map[string]interface{}{"sub": "user-2"})
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss": testIssuer,
+		"aud": testAudience,
+		"sub": "user-1",
+		"iat": now.Unix(),
+		"exp": now.Add(time.Hour).Unix(),
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{
+			name:  "signature from another payload",
+			token: valid[:strings.LastIndex(valid, ".")] + otherPayload[strings.LastIndex(otherPayload, "."):],
+			want:  ErrInvalidSignature,
+		},
+		{
+			name:  "signed by another key under the same kid",
+			token: withKid(t, other, li.kid),
+			want:  ErrInvalidSignature,
+		},
+		{
+			name:  "wrong issuer",
+			token: signTest(t, li, time.Hour, map[string]interface{}{"iss": "https://evil.test"}),
+			want:  ErrUntrustedIssuer,
+		},
+		{
+			name:  "wrong audience",
+			token: signTest(t, li, time.Hour, map[string]interface{}{"aud": "someone-else"}),
+			want:  ErrInvalidAudience,
+		},
+		{
+			name:  "expired",
+			token: signTest(t, li, -time.Hour, nil),
+			want:  ErrTokenExpired,
+		},
+		{
+			name:  "alg none",
+			token: unsignedToken(t, jwtHeader{Alg: "none", Kid: li.kid, Typ: "JWT"}, claims, nil),
+			want:  ErrUnsupportedAlg,
+		},
+		{
+			// The classic key confusion attack: HMAC keyed with the public key.
+			name: "alg HS256",
+			token: unsignedToken(t, jwtHeader{Alg: "HS256", Kid: li.kid, Typ: "JWT"}, claims, func(input string) []byte {
+				mac := hmac.New(sha256.New, li.key.PublicKey.N.Bytes())
+				mac.Write([]byte(input))
+				return mac.Sum(nil)
+			}),
+			want: ErrUnsupportedAlg,
+		},
+		{
+			name:  "malformed",
+			token: "not.a-token",
+			want:  ErrMalformedToken,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := v.Verify(tt.token)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("Verify = %v, %v; want error %v", p, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyLeeway(t *testing.T) {
+	li := newTestIssuer(t)
+	v := NewVerifier(li.Config())
+	token := signTest(t, li, time.Hour, nil)
+
+	v.now = func() time.Time { return time.Now().Add(time.Hour + 30*time.Second) }
+	if _, err := v.Verify(token); err != nil {
+		t.Errorf("within leeway: %v", err)
+	}
+	v.now = func() time.Time { return time.Now().Add(time.Hour + 2*time.Minute) }
+	if _, err := v.Verify(token); !errors.Is(err, ErrTokenExpired) {
+		t.Errorf("past leeway: got %v, want %v", err, ErrTokenExpired)
+	}
+}
+
+func TestCachedKeySetRefetchesOnUnknownKid(t *testing.T) {
+	before := newTestIssuer(t)
+	after := newTestIssuer(t)
+
+	current := before
+	loads := 0
+	keys := &cachedKeySet{
+		load: func() (map[string]crypto.PublicKey, time.Duration, error) {
+			loads++
+			data, err := json.Marshal(current.JWKS())
+			if err != nil {
+				return nil, 0, err
+			}
+			k, err := ParseJWKS(data)
+			return k, time.Hour, err
+		},
+	}
+	v := NewVerifier(IssuerConfig{Issuer: testIssuer, Audiences: []string{testAudience}, Keys: keys})
+
+	if _, err := v.Verify(signTest(t, before, time.Hour, nil)); err != nil {
+		t.Fatalf("Verify before rotation: %v", err)
+	}
+	if _, err := v.Verify(signTest(t, before, time.Hour, nil)); err != nil {
+		t.Fatalf("Verify with cached key: %v", err)
+	}
+	if loads != 1 {
+		t.Fatalf("loads = %d after two tokens with a known kid, want 1", loads)
+	}
+
+	// The issuer rotates its key; a token with the new kid triggers a refetch.
+	current = after
+	if _, err := v.Verify(signTest(t, after, time.Hour, nil)); err != nil {
+		t.Fatalf("Verify after rotation: %v", err)
+	}
+	if loads != 2 {
+		t.Fatalf("loads = %d after an unknown kid, want 2", loads)
+	}
+
+	// A kid the source doesn't have is still rejected, without hammering it.
+	keys.minRefresh = time.Hour
+	token := withKid(t, after, "unknown")
+	if _, err := v.Verify(token); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("Verify with unknown kid: %v, want %v", err, ErrInvalidSignature)
+	}
+	if loads != 2 {
+		t.Errorf("loads = %d within minRefresh, want 2", loads)
+	}
+}
+
+// withKid signs a valid token with li's key but kid in the header.
+func withKid(t *testing.T, li *LocalIssuer, kid string) string {
+	t.Helper()
+	signer := *li
+	signer.kid = kid
+	return signTest(t, &signer, time.Hour, nil)
+}
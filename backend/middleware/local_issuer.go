@@ -0,0 +1,98 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"time"
+)
+
+// LocalIssuer is an in-process token issuer for offline testing and local
+// development. It signs RS256 tokens with a freshly generated key and can
+// publish the matching JWKS, so the full verification path runs without
+// talking to Google or any other provider.
+type LocalIssuer struct {
+	Issuer   string
+	Audience string
+
+	kid string
+	key *rsa.PrivateKey
+}
+
+// NewLocalIssuer generates a signing key for the given issuer and audience.
+func NewLocalIssuer(issuer, audience string) (*LocalIssuer, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	kidBytes := make([]byte, 8)
+	if _, err := rand.Read(kidBytes); err != nil {
+		return nil, err
+	}
+	return &LocalIssuer{
+		Issuer:   issuer,
+		Audience: audience,
+		kid:      hex.EncodeToString(kidBytes),
+		key:      key,
+	}, nil
+}
+
+// JWKS returns the public half of the signing key as a JWKS document.
+func (li *LocalIssuer) JWKS() JWKS {
+	pub := li.key.PublicKey
+	return JWKS{Keys: []JWK{{
+		Kty: "RSA",
+		Kid: li.kid,
+		Alg: "RS256",
+		Use: "sig",
+		N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+	}}}
+}
+
+// Config returns an IssuerConfig that trusts this issuer.
+func (li *LocalIssuer) Config() IssuerConfig {
+	return IssuerConfig{
+		Issuer:    li.Issuer,
+		Audiences: []string{li.Audience},
+		Keys:      StaticKeySet{li.kid: &li.key.PublicKey},
+	}
+}
+
+// Sign issues a token for subject with the given extra claims. iss, aud,
+// iat and exp are filled in unless already present in extra.
+func (li *LocalIssuer) Sign(subject string, ttl time.Duration, extra map[string]interface{}) (string, error) {
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss": li.Issuer,
+		"aud": li.Audience,
+		"sub": subject,
+		"iat": now.Unix(),
+		"exp": now.Add(ttl).Unix(),
+	}
+	for k, v := range extra {
+		claims[k] = v
+	}
+
+	header, err := json.Marshal(jwtHeader{Alg: "RS256", Kid: li.kid, Typ: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, li.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
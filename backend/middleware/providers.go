@@ -0,0 +1,357 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrUnknownProvider is returned when a verified token comes from an issuer
+// that has no registered identity provider.
+var ErrUnknownProvider = errors.New("no identity provider for issuer")
+
+// LinkPolicy controls how identities from a provider may be attached to an
+// existing local user.
+type LinkPolicy struct {
+	// Linkable allows the provider to be added to an existing user through
+	// the explicit, authenticated link flow.
+	Linkable bool
+	// RequireVerifiedEmail refuses linking unless the provider asserts that
+	// the identity's email address has been verified.
+	RequireVerifiedEmail bool
+}
+
+// IdentityProvider interprets verified tokens from one login provider.
+type IdentityProvider interface {
+	// Name is the value stored in UserIdentity.Provider (google, apple, ...).
+	Name() string
+	// Issuers lists the "iss" values this provider's tokens carry.
+	Issuers() []string
+	// MapClaims fills the principal's profile fields from its raw claims.
+	MapClaims(p *Principal)
+	// LinkPolicy returns the provider's account-linking rules.
+	LinkPolicy() LinkPolicy
+}
+
+// OpaqueTokenProvider is implemented by providers whose access tokens are
+// not JWTs and have to be checked with the provider's API (e.g. GitHub).
+type OpaqueTokenProvider interface {
+	IdentityProvider
+	Authenticate(token string) (*Principal, error)
+}
+
+// ProviderRegistry maps token issuers to identity providers.
+type ProviderRegistry struct {
+	byIssuer map[string]IdentityProvider
+	byName   map[string]IdentityProvider
+	opaque   []OpaqueTokenProvider
+}
+
+// NewProviderRegistry creates an empty registry.
+func NewProviderRegistry() *ProviderRegistry {
+	return &ProviderRegistry{
+		byIssuer: make(map[string]IdentityProvider),
+		byName:   make(map[string]IdentityProvider),
+	}
+}
+
+// Register adds a provider under each of its issuers.
+func (r *ProviderRegistry) Register(p IdentityProvider) {
+	r.byName[p.Name()] = p
+	for _, iss := range p.Issuers() {
+		r.byIssuer[iss] = p
+	}
+	if op, ok := p.(OpaqueTokenProvider); ok {
+		r.opaque = append(r.opaque, op)
+	}
+}
+
+// ForIssuer returns the provider responsible for an issuer.
+func (r *ProviderRegistry) ForIssuer(issuer string) (IdentityProvider, bool) {
+	p, ok := r.byIssuer[issuer]
+	return p, ok
+}
+
+// ForName returns a provider by its stored name.
+func (r *ProviderRegistry) ForName(name string) (IdentityProvider, bool) {
+	p, ok := r.byName[name]
+	return p, ok
+}
+
+// ClaimProvider is an IdentityProvider for OIDC issuers whose ID tokens carry
+// the profile directly. Claim names are configurable per provider.
+type ClaimProvider struct {
+	ProviderName  string
+	IssuerIDs     []string
+	EmailClaim    string
+	VerifiedClaim string
+	// NameClaims are tried in order; the first non-empty one wins.
+	NameClaims []string
+	Policy     LinkPolicy
+}
+
+func (c *ClaimProvider) Name() string           { return c.ProviderName }
+func (c *ClaimProvider) Issuers() []string      { return c.IssuerIDs }
+func (c *ClaimProvider) LinkPolicy() LinkPolicy { return c.Policy }
+
+// MapClaims implements IdentityProvider.
+func (c *ClaimProvider) MapClaims(p *Principal) {
+	p.Provider = c.ProviderName
+	p.Email, _ = p.Claims[c.EmailClaim].(string)
+	p.EmailVerified = false
+	if c.VerifiedClaim != "" {
+		switch ev := p.Claims[c.VerifiedClaim].(type) {
+		case bool:
+			p.EmailVerified = ev
+		case string:
+			// Apple sends this as a string.
+			p.EmailVerified = ev == "true"
+		}
+	}
+	p.Name = ""
+	for _, claim := range c.NameClaims {
+		if name, _ := p.Claims[claim].(string); name != "" {
+			p.Name = name
+			break
+		}
+	}
+}
+
+// NewGoogleProvider maps Google ID tokens.
+func NewGoogleProvider() *ClaimProvider {
+	return &ClaimProvider{
+		ProviderName:  "google",
+		IssuerIDs:     []string{"https://accounts.google.com", "accounts.google.com"},
+		EmailClaim:    "email",
+		VerifiedClaim: "email_verified",
+		NameClaims:    []string{"name", "given_name"},
+		Policy:        LinkPolicy{Linkable: true, RequireVerifiedEmail: true},
+	}
+}
+
+// NewAppleProvider maps Sign in with Apple ID tokens. Apple never puts the
+// user's name in the token and may hand out private relay addresses, so the
+// email is informational only.
+func NewAppleProvider() *ClaimProvider {
+	return &ClaimProvider{
+		ProviderName:  "apple",
+		IssuerIDs:     []string{"https://appleid.apple.com"},
+		EmailClaim:    "email",
+		VerifiedClaim: "email_verified",
+		Policy:        LinkPolicy{Linkable: true},
+	}
+}
+
+// NewOIDCProvider maps tokens from a generic OIDC issuer.
+func NewOIDCProvider(name, issuer, emailClaim, nameClaim string, trustEmail bool) *ClaimProvider {
+	verified := ""
+	if trustEmail {
+		verified = "email_verified"
+	}
+	return &ClaimProvider{
+		ProviderName:  name,
+		IssuerIDs:     []string{issuer},
+		EmailClaim:    emailClaim,
+		VerifiedClaim: verified,
+		NameClaims:    []string{nameClaim, "preferred_username"},
+		Policy:        LinkPolicy{Linkable: true, RequireVerifiedEmail: trustEmail},
+	}
+}
+
+const githubIssuer = "https://github.com"
+
+// GitHubProvider authenticates GitHub OAuth app access tokens. GitHub does
+// not issue ID tokens for user login, so each token is checked against the
+// "check a token" API, which also proves it was issued to our OAuth app.
+// Results are cached briefly so we don't call GitHub on every request.
+type GitHubProvider struct {
+	ClientID     string
+	ClientSecret string
+	APIBaseURL   string
+
+	client    *http.Client
+	mu        sync.Mutex
+	cache     map[string]githubCacheEntry
+	cacheSize int // Most tokens cached at once
+}
+
+// Tokens cached by default; enough for every active user of one instance.
+const githubCacheSize = 10000
+
+type githubCacheEntry struct {
+	principal Principal
+	expires   time.Time
+}
+
+// NewGitHubProvider creates a GitHub provider for the given OAuth app.
+func NewGitHubProvider(clientID, clientSecret string) *GitHubProvider {
+	return &GitHubProvider{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		APIBaseURL:   "https://api.github.com",
+		client:       &http.Client{Timeout: 5 * time.Second},
+		cache:        make(map[string]githubCacheEntry),
+		cacheSize:    githubCacheSize,
+	}
+}
+
+func (g *GitHubProvider) Name() string      { return "github" }
+func (g *GitHubProvider) Issuers() []string { return []string{githubIssuer} }
+
+// LinkPolicy implements IdentityProvider. GitHub only returns the public
+// profile email, which is not guaranteed to be verified.
+func (g *GitHubProvider) LinkPolicy() LinkPolicy { return LinkPolicy{Linkable: true} }
+
+// MapClaims implements IdentityProvider.
+func (g *GitHubProvider) MapClaims(p *Principal) {
+	p.Provider = "github"
+	p.Email, _ = p.Claims["email"].(string)
+	p.Name, _ = p.Claims["name"].(string)
+	if p.Name == "" {
+		p.Name, _ = p.Claims["login"].(string)
+	}
+	p.EmailVerified = false
+}
+
+// Authenticate implements OpaqueTokenProvider.
+func (g *GitHubProvider) Authenticate(token string) (*Principal, error) {
+	sum := sha256.Sum256([]byte(token))
+	cacheKey := hex.EncodeToString(sum[:])
+
+	g.mu.Lock()
+	if entry, ok := g.cache[cacheKey]; ok && time.Now().Before(entry.expires) {
+		g.mu.Unlock()
+		p := entry.principal
+		return &p, nil
+	}
+	g.mu.Unlock()
+
+	body, _ := json.Marshal(map[string]string{"access_token": token})
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/applications/%s/token", g.APIBaseURL, g.ClientID), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(g.ClientID, g.ClientSecret)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("github token check failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: github returned status %d", ErrInvalidSignature, resp.StatusCode)
+	}
+
+	var result struct {
+		ExpiresAt *time.Time `json:"expires_at"`
+		User      struct {
+			ID    int64  `json:"id"`
+			Login string `json:"login"`
+			Name  string `json:"name"`
+			Email string `json:"email"`
+		} `json:"user"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode github response: %w", err)
+	}
+	if result.User.ID == 0 {
+		return nil, fmt.Errorf("%w: github token has no user", ErrMalformedToken)
+	}
+
+	expires := time.Now().Add(5 * time.Minute)
+	if result.ExpiresAt != nil && result.ExpiresAt.Before(expires) {
+		expires = *result.ExpiresAt
+	}
+
+	p := Principal{
+		Issuer:    githubIssuer,
+		Subject:   strconv.FormatInt(result.User.ID, 10),
+		ExpiresAt: expires,
+		Claims: map[string]interface{}{
+			"login": result.User.Login,
+			"name":  result.User.Name,
+			"email": result.User.Email,
+		},
+	}
+
+	g.mu.Lock()
+	g.cacheLocked(cacheKey, githubCacheEntry{principal: p, expires: expires})
+	g.mu.Unlock()
+
+	return &p, nil
+}
+
+// cacheLocked adds an entry, first dropping expired ones once the cache is
+// full and then, if it still is, the one that expires soonest. g.mu must be
+// held.
+func (g *GitHubProvider) cacheLocked(key string, entry githubCacheEntry) {
+	if len(g.cache) >= g.cacheSize {
+		now := time.Now()
+		for k, e := range g.cache {
+			if !now.Before(e.expires) {
+				delete(g.cache, k)
+			}
+		}
+	}
+	for len(g.cache) > 0 && len(g.cache) >= g.cacheSize {
+		soonest := ""
+		for k, e := range g.cache {
+			if soonest == "" || e.expires.Before(g.cache[soonest].expires) {
+				soonest = k
+			}
+		}
+		delete(g.cache, soonest)
+	}
+	g.cache[key] = entry
+}
+
+// Authenticator turns a bearer token into a Principal with its provider
+// resolved. JWTs go through the Verifier; anything else is offered to the
+// registered opaque-token providers.
+type Authenticator struct {
+	Verifier  *Verifier
+	Providers *ProviderRegistry
+}
+
+// Authenticate verifies the token and maps its claims through the issuer's
+// identity provider.
+func (a *Authenticator) Authenticate(token string) (*Principal, error) {
+	if strings.Count(token, ".") == 2 {
+		p, err := a.Verifier.Verify(token)
+		if err != nil {
+			return nil, err
+		}
+		provider, ok := a.Providers.ForIssuer(p.Issuer)
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", ErrUnknownProvider, p.Issuer)
+		}
+		provider.MapClaims(p)
+		return p, nil
+	}
+
+	if len(a.Providers.opaque) == 0 {
+		return nil, ErrMalformedToken
+	}
+	var lastErr error
+	for _, op := range a.Providers.opaque {
+		p, err := op.Authenticate(token)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		op.MapClaims(p)
+		return p, nil
+	}
+	return nil, lastErr
+}
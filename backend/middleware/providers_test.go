@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestGitHubCacheIsBounded(t *testing.T) {
+	checks := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		checks++
+		var body struct {
+			AccessToken string `json:"access_token"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"user": map[string]interface{}{"id": 1, "login": body.AccessToken},
+		})
+	}))
+	defer server.Close()
+
+	g := NewGitHubProvider("client", "secret")
+	g.APIBaseURL = server.URL
+	g.cacheSize = 2
+
+	for _, token := range []string{"a", "b", "c", "d"} {
+		p, err := g.Authenticate(token)
+		if err != nil {
+			t.Fatalf("Authenticate(%s): %v", token, err)
+		}
+		if login := p.Claims["login"]; login != token {
+			t.Errorf("Authenticate(%s) returned %v's principal", token, login)
+		}
+	}
+	if len(g.cache) != 2 {
+		t.Errorf("cache holds %d tokens, want at most 2", len(g.cache))
+	}
+
+	// The latest token is still cached
+	if _, err := g.Authenticate("d"); err != nil {
+		t.Fatal(err)
+	}
+	if checks != 4 {
+		t.Errorf("GitHub checked %d times, want 4", checks)
+	}
+}
+
+func TestGitHubCacheDropsExpiredEntriesFirst(t *testing.T) {
+	g := NewGitHubProvider("client", "secret")
+	g.cacheSize = 2
+	now := time.Now()
+	g.cache["expired"] = githubCacheEntry{expires: now.Add(-time.Minute)}
+	g.cache["live"] = githubCacheEntry{expires: now.Add(time.Minute)}
+
+	g.cacheLocked("new", githubCacheEntry{expires: now.Add(5 * time.Minute)})
+	if _, ok := g.cache["expired"]; ok {
+		t.Error("expired entry kept")
+	}
+	if _, ok := g.cache["live"]; !ok {
+		t.Error("live entry evicted while an expired one could go")
+	}
+}
@@ -0,0 +1,304 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pmitra96/pateproject/config"
+	"github.com/pmitra96/pateproject/database"
+	"github.com/pmitra96/pateproject/logger"
+	"github.com/pmitra96/pateproject/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Signed webhook headers. The signature is
+//
+//	v1=hex(HMAC-SHA256(secret, timestamp + "." + nonce + "." + body))
+//
+// so the timestamp and nonce are covered and cannot be swapped on replay.
+const (
+	WebhookSourceHeader    = "X-Webhook-Source"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	WebhookNonceHeader     = "X-Webhook-Nonce"
+	WebhookSignatureHeader = "X-Webhook-Signature"
+)
+
+// WebhookSourceContextKey holds the *models.WebhookSource of a verified webhook.
+const WebhookSourceContextKey contextKey = "webhook_source"
+
+const maxWebhookBody = 1 << 20
+
+// ErrNonceReused is returned by a NonceStore when a nonce was already seen.
+var ErrNonceReused = errors.New("nonce already used")
+
+// NonceStore remembers webhook nonces for at least the tolerance window.
+type NonceStore interface {
+	// Remember records the nonce, returning ErrNonceReused if it was seen before.
+	Remember(sourceID uint, nonce string, seenAt time.Time) error
+}
+
+// DBNonceStore keeps nonces in the webhook_nonces table and relies on its
+// unique index to detect replays, so it works across server instances.
+type DBNonceStore struct {
+	Retention time.Duration
+
+	mu        sync.Mutex
+	lastPurge time.Time
+}
+
+// Remember implements NonceStore.
+func (s *DBNonceStore) Remember(sourceID uint, nonce string, seenAt time.Time) error {
+	s.purge(seenAt)
+
+	result := database.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.WebhookNonce{
+		SourceID: sourceID,
+		Nonce:    nonce,
+		SeenAt:   seenAt,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNonceReused
+	}
+	return nil
+}
+
+// purge drops nonces older than the retention window, at most once a minute.
+func (s *DBNonceStore) purge(now time.Time) {
+	s.mu.Lock()
+	if now.Sub(s.lastPurge) < time.Minute {
+		s.mu.Unlock()
+		return
+	}
+	s.lastPurge = now
+	s.mu.Unlock()
+
+	database.DB.Where("seen_at < ?", now.Add(-s.Retention)).Delete(&models.WebhookNonce{})
+}
+
+// WebhookSourceStore finds webhook sources by their public ID.
+type WebhookSourceStore interface {
+	// Source returns the source with its secrets, at least those not
+	// expired at now, or gorm.ErrRecordNotFound.
+	Source(publicID string, now time.Time) (*models.WebhookSource, error)
+}
+
+// DBWebhookSourceStore reads sources from the webhook_sources table.
+type DBWebhookSourceStore struct{}
+
+// Source implements WebhookSourceStore.
+func (DBWebhookSourceStore) Source(publicID string, now time.Time) (*models.WebhookSource, error) {
+	var source models.WebhookSource
+	err := database.DB.Preload("Secrets", "expires_at IS NULL OR expires_at > ?", now).
+		Where("public_id = ?", publicID).First(&source).Error
+	if err != nil {
+		return nil, err
+	}
+	return &source, nil
+}
+
+// WebhookVerifier checks signed webhook requests.
+type WebhookVerifier struct {
+	Tolerance time.Duration
+	Sources   WebhookSourceStore
+	Nonces    NonceStore
+	Now       func() time.Time
+}
+
+var (
+	defaultWebhookVerifier     *WebhookVerifier
+	defaultWebhookVerifierOnce sync.Once
+)
+
+// DefaultWebhookVerifier uses WEBHOOK_TOLERANCE (a Go duration, default 5m)
+// and the database nonce store.
+func DefaultWebhookVerifier() *WebhookVerifier {
+	defaultWebhookVerifierOnce.Do(func() {
+		tolerance, err := time.ParseDuration(config.GetEnv("WEBHOOK_TOLERANCE", "5m"))
+		if err != nil || tolerance <= 0 {
+			logger.Warn("Invalid WEBHOOK_TOLERANCE, using 5m", "error", err)
+			tolerance = 5 * time.Minute
+		}
+		defaultWebhookVerifier = &WebhookVerifier{
+			Tolerance: tolerance,
+			Sources:   DBWebhookSourceStore{},
+			// Anything older than the tolerance is rejected on timestamp alone,
+			// so nonces only need to outlive it (with margin for clock skew).
+			Nonces: &DBNonceStore{Retention: 2 * tolerance},
+			Now:    time.Now,
+		}
+	})
+	return defaultWebhookVerifier
+}
+
+// webhookRejection carries a machine-readable reason for logging.
+type webhookRejection struct {
+	reason string
+	status int
+}
+
+func (e *webhookRejection) Error() string { return e.reason }
+
+func reject(reason string, status int) error {
+	return &webhookRejection{reason: reason, status: status}
+}
+
+// Verify authenticates a signed request and returns its source. body is
+// the raw request body exactly as received.
+func (v *WebhookVerifier) Verify(r *http.Request, body []byte) (*models.WebhookSource, error) {
+	sourceID := r.Header.Get(WebhookSourceHeader)
+	tsRaw := r.Header.Get(WebhookTimestampHeader)
+	nonce := r.Header.Get(WebhookNonceHeader)
+	sigHeader := r.Header.Get(WebhookSignatureHeader)
+
+	if sourceID == "" || tsRaw == "" || nonce == "" {
+		return nil, reject("missing_headers", http.StatusBadRequest)
+	}
+	if len(nonce) < 16 || len(nonce) > 128 {
+		return nil, reject("invalid_nonce", http.StatusBadRequest)
+	}
+
+	ts, err := strconv.ParseInt(tsRaw, 10, 64)
+	if err != nil {
+		return nil, reject("invalid_timestamp", http.StatusBadRequest)
+	}
+	now := v.Now()
+	skew := now.Sub(time.Unix(ts, 0))
+	if skew > v.Tolerance || skew < -v.Tolerance {
+		return nil, reject("timestamp_outside_tolerance", http.StatusUnauthorized)
+	}
+
+	var signatures [][]byte
+	for _, part := range strings.Split(sigHeader, ",") {
+		if hexSig, ok := strings.CutPrefix(strings.TrimSpace(part), "v1="); ok {
+			if sig, err := hex.DecodeString(hexSig); err == nil {
+				signatures = append(signatures, sig)
+			}
+		}
+	}
+	if len(signatures) == 0 {
+		return nil, reject("missing_signature", http.StatusUnauthorized)
+	}
+
+	source, err := v.Sources.Source(sourceID, now)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, reject("unknown_source", http.StatusUnauthorized)
+	} else if err != nil {
+		return nil, reject("source_lookup_failed", http.StatusInternalServerError)
+	}
+	if source.DisabledAt != nil {
+		return nil, reject("source_disabled", http.StatusForbidden)
+	}
+
+	// During rotation both the new and the old secret are active, the old
+	// one until the end of the grace period.
+	matched := false
+	for _, secret := range source.Secrets {
+		if secret.ExpiresAt != nil && !now.Before(*secret.ExpiresAt) {
+			continue
+		}
+		expected := SignWebhook(secret.Secret, tsRaw, nonce, body)
+		for _, sig := range signatures {
+			if hmac.Equal(sig, expected) {
+				matched = true
+			}
+		}
+	}
+	if !matched {
+		return nil, reject("signature_mismatch", http.StatusUnauthorized)
+	}
+
+	// Only remember the nonce once the signature is valid, otherwise anyone
+	// could burn nonces for a source.
+	if err := v.Nonces.Remember(source.ID, nonce, now); err != nil {
+		if errors.Is(err, ErrNonceReused) {
+			return nil, reject("replayed_nonce", http.StatusConflict)
+		}
+		return nil, reject("nonce_store_failed", http.StatusInternalServerError)
+	}
+
+	return source, nil
+}
+
+// SignWebhook computes the raw v1 signature for a payload.
+func SignWebhook(secret, timestamp, nonce string, body []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write([]byte(nonce))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// GenerateWebhookSecret returns a new random signing secret.
+func GenerateWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+// WebhookSourceFromContext returns the source of a verified webhook request.
+func WebhookSourceFromContext(ctx context.Context) (*models.WebhookSource, bool) {
+	s, ok := ctx.Value(WebhookSourceContextKey).(*models.WebhookSource)
+	return s, ok && s != nil
+}
+
+// SignedWebhook verifies requests that carry a webhook signature and acts
+// on behalf of the source's owner. Unsigned requests pass through untouched
+// so a following auth middleware (e.g. RequireScope) can handle them.
+func SignedWebhook(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(WebhookSignatureHeader) == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBody+1))
+		if err != nil || len(body) > maxWebhookBody {
+			logger.Warn("Webhook rejected", "reason", "body_unreadable_or_too_large", "source", r.Header.Get(WebhookSourceHeader), "path", r.URL.Path)
+			http.Error(w, "Invalid webhook body", http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body.Close()
+
+		source, err := DefaultWebhookVerifier().Verify(r, body)
+		if err != nil {
+			status := http.StatusUnauthorized
+			var rej *webhookRejection
+			if errors.As(err, &rej) {
+				status = rej.status
+			}
+			logger.Warn("Webhook rejected",
+				"reason", err.Error(),
+				"source", r.Header.Get(WebhookSourceHeader),
+				"timestamp", r.Header.Get(WebhookTimestampHeader),
+				"remote_addr", r.RemoteAddr,
+				"path", r.URL.Path,
+			)
+			http.Error(w, "Webhook rejected: "+err.Error(), status)
+			return
+		}
+
+		logger.Info("Webhook verified", "source", source.PublicID, "user_id", source.UserID)
+
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		ctx := context.WithValue(r.Context(), WebhookSourceContextKey, source)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
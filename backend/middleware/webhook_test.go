@@ -0,0 +1,227 @@
+package middleware
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/pmitra96/pateproject/models"
+	"gorm.io/gorm"
+)
+
+// memorySources is a WebhookSourceStore over a map of public IDs.
+type memorySources map[string]*models.WebhookSource
+
+func (m memorySources) Source(publicID string, now time.Time) (*models.WebhookSource, error) {
+	source, ok := m[publicID]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	copied := *source
+	return &copied, nil
+}
+
+// memoryNonces is a NonceStore that never forgets.
+type memoryNonces map[string]bool
+
+func (m memoryNonces) Remember(sourceID uint, nonce string, seenAt time.Time) error {
+	key := fmt.Sprintf("%d/%s", sourceID, nonce)
+	if m[key] {
+		return ErrNonceReused
+	}
+	m[key] = true
+	return nil
+}
+
+var webhookNow = time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func newTestWebhookVerifier(sources memorySources) *WebhookVerifier {
+	return &WebhookVerifier{
+		Tolerance: 5 * time.Minute,
+		Sources:   sources,
+		Nonces:    memoryNonces{},
+		Now:       func() time.Time { return webhookNow },
+	}
+}
+
+func signedWebhookRequest(source, secret string, ts time.Time, nonce string, body []byte) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/ingest/order", nil)
+	timestamp := strconv.FormatInt(ts.Unix(), 10)
+	r.Header.Set(WebhookSourceHeader, source)
+	r.Header.Set(WebhookTimestampHeader, timestamp)
+	r.Header.Set(WebhookNonceHeader, nonce)
+	r.Header.Set(WebhookSignatureHeader, "v1="+hex.EncodeToString(SignWebhook(secret, timestamp, nonce, body)))
+	return r
+}
+
+// rejection returns the reason and status a Verify error carries.
+func rejection(t *testing.T, err error) (string, int) {
+	t.Helper()
+	var rej *webhookRejection
+	if !errors.As(err, &rej) {
+		t.Fatalf("Verify error %v is not a rejection", err)
+	}
+	return rej.reason, rej.status
+}
+
+func TestWebhookVerify(t *testing.T) {
+	expired := webhookNow.Add(-time.Minute)
+	grace := webhookNow.Add(time.Hour)
+	disabled := webhookNow.Add(-time.Hour)
+	sources := memorySources{
+		"src_live": {ID: 1, PublicID: "src_live", Secrets: []models.WebhookSecret{{Secret: "whsec_live"}}},
+		"src_rotated": {ID: 2, PublicID: "src_rotated", Secrets: []models.WebhookSecret{
+			{Secret: "whsec_new"},
+			{Secret: "whsec_old", ExpiresAt: &grace},
+			{Secret: "whsec_older", ExpiresAt: &expired},
+		}},
+		"src_disabled": {ID: 3, PublicID: "src_disabled", DisabledAt: &disabled, Secrets: []models.WebhookSecret{{Secret: "whsec_off"}}},
+	}
+	body := []byte(`{"provider":"zepto","items":[]}`)
+	const nonce = "0123456789abcdef"
+
+	tests := []struct {
+		name    string
+		request func() *http.Request
+		body    []byte
+		reason  string // Empty when the request is accepted
+		status  int
+	}{
+		{
+			name:    "valid signature",
+			request: func() *http.Request { return signedWebhookRequest("src_live", "whsec_live", webhookNow, nonce, body) },
+			body:    body,
+		},
+		{
+			name:    "tampered body",
+			request: func() *http.Request { return signedWebhookRequest("src_live", "whsec_live", webhookNow, nonce, body) },
+			body:    []byte(`{"provider":"zepto","items":[{"name":"Gold"}]}`),
+			reason:  "signature_mismatch",
+			status:  http.StatusUnauthorized,
+		},
+		{
+			name:    "wrong secret",
+			request: func() *http.Request { return signedWebhookRequest("src_live", "whsec_guess", webhookNow, nonce, body) },
+			body:    body,
+			reason:  "signature_mismatch",
+			status:  http.StatusUnauthorized,
+		},
+		{
+			name: "timestamp too old",
+			request: func() *http.Request {
+				return signedWebhookRequest("src_live", "whsec_live", webhookNow.Add(-6*time.Minute), nonce, body)
+			},
+			body:   body,
+			reason: "timestamp_outside_tolerance",
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "timestamp in the future",
+			request: func() *http.Request {
+				return signedWebhookRequest("src_live", "whsec_live", webhookNow.Add(6*time.Minute), nonce, body)
+			},
+			body:   body,
+			reason: "timestamp_outside_tolerance",
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "timestamp within tolerance",
+			request: func() *http.Request {
+				return signedWebhookRequest("src_live", "whsec_live", webhookNow.Add(-4*time.Minute), nonce, body)
+			},
+			body: body,
+		},
+		{
+			name:    "new secret after rotation",
+			request: func() *http.Request { return signedWebhookRequest("src_rotated", "whsec_new", webhookNow, nonce, body) },
+			body:    body,
+		},
+		{
+			name:    "old secret inside the grace period",
+			request: func() *http.Request { return signedWebhookRequest("src_rotated", "whsec_old", webhookNow, nonce, body) },
+			body:    body,
+		},
+		{
+			name: "old secret after the grace period",
+			request: func() *http.Request {
+				return signedWebhookRequest("src_rotated", "whsec_older", webhookNow, nonce, body)
+			},
+			body:   body,
+			reason: "signature_mismatch",
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "disabled source",
+			request: func() *http.Request {
+				return signedWebhookRequest("src_disabled", "whsec_off", webhookNow, nonce, body)
+			},
+			body:   body,
+			reason: "source_disabled",
+			status: http.StatusForbidden,
+		},
+		{
+			name: "unknown source",
+			request: func() *http.Request {
+				return signedWebhookRequest("src_missing", "whsec_live", webhookNow, nonce, body)
+			},
+			body:   body,
+			reason: "unknown_source",
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "short nonce",
+			request: func() *http.Request {
+				return signedWebhookRequest("src_live", "whsec_live", webhookNow, "0123", body)
+			},
+			body:   body,
+			reason: "invalid_nonce",
+			status: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A fresh nonce store per case, so only the replay test replays
+			v := newTestWebhookVerifier(sources)
+			source, err := v.Verify(tt.request(), tt.body)
+			if tt.reason == "" {
+				if err != nil {
+					t.Fatalf("Verify: %v", err)
+				}
+				if source == nil || source.PublicID != tt.request().Header.Get(WebhookSourceHeader) {
+					t.Errorf("verified source = %+v", source)
+				}
+				return
+			}
+			if reason, status := rejection(t, err); reason != tt.reason || status != tt.status {
+				t.Errorf("rejected with %s (%d), want %s (%d)", reason, status, tt.reason, tt.status)
+			}
+		})
+	}
+}
+
+func TestWebhookVerifyRejectsReplayedNonce(t *testing.T) {
+	v := newTestWebhookVerifier(memorySources{
+		"src_live": {ID: 1, PublicID: "src_live", Secrets: []models.WebhookSecret{{Secret: "whsec_live"}}},
+	})
+	body := []byte(`{}`)
+	const nonce = "0123456789abcdef"
+
+	// A bad signature must not burn the nonce for the real sender
+	if _, err := v.Verify(signedWebhookRequest("src_live", "whsec_guess", webhookNow, nonce, body), body); err == nil {
+		t.Fatal("Verify accepted a bad signature")
+	}
+	if _, err := v.Verify(signedWebhookRequest("src_live", "whsec_live", webhookNow, nonce, body), body); err != nil {
+		t.Fatalf("first delivery: %v", err)
+	}
+
+	_, err := v.Verify(signedWebhookRequest("src_live", "whsec_live", webhookNow, nonce, body), body)
+	if reason, status := rejection(t, err); reason != "replayed_nonce" || status != http.StatusConflict {
+		t.Errorf("replay rejected with %s (%d), want replayed_nonce (409)", reason, status)
+	}
+}
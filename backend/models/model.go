@@ -1,6 +1,7 @@
 package models
 
 import (
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
@@ -27,17 +28,109 @@ type UserIdentity struct {
 	UserID     uint      `gorm:"not null;index" json:"user_id"`
 	Provider   string    `gorm:"size:50;not null" json:"provider"`           // google, zepto, blinkit, etc.
 	ExternalID string    `gorm:"size:255;not null;index" json:"external_id"` // Provider's user ID (e.g. Google sub)
+	Email      string    `gorm:"size:255" json:"email"`                      // Email asserted by the provider at link time
 	CreatedAt  time.Time `json:"created_at"`
 	UpdatedAt  time.Time `json:"updated_at"`
 }
 
+// APIKey is a per-user credential for machine clients (e.g. the email
+// forwarding bot). Only a hash of the key is stored.
+type APIKey struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	UserID     uint       `gorm:"not null;index" json:"user_id"`
+	Name       string     `gorm:"size:255" json:"name"`
+	Prefix     string     `gorm:"size:20;not null" json:"prefix"` // Non-secret part shown in listings (pk_xxxxxxxx)
+	KeyHash    string     `gorm:"size:64;not null;uniqueIndex" json:"-"`
+	Scopes     string     `gorm:"type:text" json:"scopes"` // Comma-separated, e.g. "ingest:orders"
+	LastUsedAt *time.Time `json:"last_used_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+// HasScope reports whether the key was granted scope.
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range strings.Split(k.Scopes, ",") {
+		if strings.TrimSpace(s) == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Active reports whether the key is neither revoked nor expired at t.
+func (k *APIKey) Active(t time.Time) bool {
+	if k.RevokedAt != nil {
+		return false
+	}
+	return k.ExpiresAt == nil || t.Before(*k.ExpiresAt)
+}
+
+// WebhookSource is a registered sender of signed ingestion webhooks (e.g.
+// the email-forwarding bot). Requests are attributed to its owner.
+type WebhookSource struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	UserID     uint       `gorm:"not null;index" json:"user_id"`
+	PublicID   string     `gorm:"size:64;not null;uniqueIndex" json:"public_id"` // Sent in X-Webhook-Source
+	Name       string     `gorm:"size:255" json:"name"`
+	DisabledAt *time.Time `json:"disabled_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+
+	Secrets []WebhookSecret `gorm:"foreignKey:SourceID" json:"secrets,omitempty"`
+}
+
+// WebhookSecret is an HMAC signing secret for a source. After rotation the
+// previous secret stays valid until ExpiresAt.
+type WebhookSecret struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	SourceID  uint       `gorm:"not null;index" json:"source_id"`
+	Secret    string     `gorm:"size:128;not null" json:"-"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// WebhookNonce records a nonce seen from a source, for replay protection.
+type WebhookNonce struct {
+	ID       uint      `gorm:"primaryKey" json:"id"`
+	SourceID uint      `gorm:"not null;uniqueIndex:idx_source_nonce" json:"source_id"`
+	Nonce    string    `gorm:"size:128;not null;uniqueIndex:idx_source_nonce" json:"nonce"`
+	SeenAt   time.Time `gorm:"not null;index" json:"seen_at"`
+}
+
+// How an ingredient's category was assigned. Manual assignments are never
+// overwritten automatically.
+const (
+	CategorySourceLLM       = "llm"
+	CategorySourceHeuristic = "heuristic"
+	CategorySourceManual    = "manual"
+)
+
 // Ingredient represents a canonical, brand-agnostic ingredient name.
 type Ingredient struct {
-	ID        uint           `gorm:"primaryKey" json:"id"`
-	Name      string         `gorm:"size:255;uniqueIndex;not null" json:"name"`
-	CreatedAt time.Time      `json:"created_at"`
-	UpdatedAt time.Time      `json:"updated_at"`
-	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+	ID             uint           `gorm:"primaryKey" json:"id"`
+	Name           string         `gorm:"size:255;uniqueIndex;not null" json:"name"`
+	CategoryID     *uint          `gorm:"index" json:"category_id"`
+	CategorySource string         `gorm:"size:20" json:"category_source,omitempty"`
+	CreatedAt      time.Time      `json:"created_at"`
+	UpdatedAt      time.Time      `json:"updated_at"`
+	DeletedAt      gorm.DeletedAt `gorm:"index" json:"-"`
+
+	Category *IngredientCategory `gorm:"foreignKey:CategoryID" json:"category,omitempty"`
+}
+
+// IngredientCategory is a node in the ingredient taxonomy, e.g.
+// Dairy, Bread & Eggs > Dairy > Paneer & Tofu. It mirrors the category
+// tree seeded on the extractor side (seed_categories.py).
+type IngredientCategory struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Name      string    `gorm:"size:255;uniqueIndex;not null" json:"name"`
+	Slug      string    `gorm:"size:255;uniqueIndex;not null" json:"slug"`
+	ParentID  *uint     `gorm:"index" json:"parent_id"`
+	IsLeaf    bool      `gorm:"default:false" json:"is_leaf"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
 }
 
 // Brand represents a manufacturer or brand name.
@@ -49,13 +142,38 @@ type Brand struct {
 	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
 }
 
+// IngredientAlias maps an alternative name ("Dahi", "Curd") to a canonical
+// ingredient. Aliases are stored lower-case.
+type IngredientAlias struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	Alias        string    `gorm:"size:255;uniqueIndex;not null" json:"alias"`
+	IngredientID uint      `gorm:"not null;index" json:"ingredient_id"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+
+	Ingredient Ingredient `gorm:"foreignKey:IngredientID" json:"ingredient,omitempty"`
+}
+
+// BrandAlias maps a spelling or sub-brand ("Amul Taaza") to a brand.
+// Aliases are stored lower-case.
+type BrandAlias struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Alias     string    `gorm:"size:255;uniqueIndex;not null" json:"alias"`
+	BrandID   uint      `gorm:"not null;index" json:"brand_id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	Brand Brand `gorm:"foreignKey:BrandID" json:"brand,omitempty"`
+}
+
 // Item represents a specific product linked to an ingredient and optionally a brand.
 type Item struct {
 	ID            uint           `gorm:"primaryKey" json:"id"`
 	Name          string         `gorm:"size:255;uniqueIndex;not null" json:"name"` // Full display name
 	IngredientID  uint           `gorm:"not null;index" json:"ingredient_id"`
 	BrandID       *uint          `gorm:"index" json:"brand_id"`
-	ProductName   string         `gorm:"size:255" json:"product_name"` // e.g., "Taaza Toned Milk"
+	ProductName   string         `gorm:"size:255" json:"product_name"`        // e.g., "Taaza Toned Milk"
+	GTIN          string         `gorm:"size:14;index" json:"gtin,omitempty"` // Barcode: EAN-13, EAN-8 or GTIN-14
 	DefaultUnitID uint           `gorm:"default:1" json:"-"`
 	Unit          string         `gorm:"size:50;not null" json:"unit"` // Normalized unit (g, ml, pcs)
 	CreatedAt     time.Time      `json:"created_at"`
@@ -65,15 +183,146 @@ type Item struct {
 	Ingredient Ingredient `gorm:"foreignKey:IngredientID" json:"ingredient,omitempty"`
 	Brand      *Brand     `gorm:"foreignKey:BrandID" json:"brand,omitempty"`
 
-	// Nutritional Info (per 100g or 100ml, or per unit if non-divisible)
+	// Nutritional Info, per ServingWeight of ServingUnit: usually 100g or
+	// 100ml, or one piece for counted items. services.ComputeMacros scales
+	// it to an amount.
 	Calories          float64 `gorm:"default:0" json:"calories"`
 	Protein           float64 `gorm:"default:0" json:"protein"`
 	Carbs             float64 `gorm:"default:0" json:"carbs"`
 	Fat               float64 `gorm:"default:0" json:"fat"`
 	Fiber             float64 `gorm:"default:0" json:"fiber"`
-	ServingWeight     float64 `gorm:"default:0" json:"serving_weight"` // Weight for which macros are defined (e.g. 100, or 5 for sachet)
-	ServingUnit       string  `gorm:"size:50" json:"serving_unit"`     // Unit of serving weight (g, ml, pcs, sachet)
+	ServingWeight     float64 `gorm:"default:0" json:"serving_weight"` // Amount the macros are for (e.g. 100, or 5 for a sachet)
+	ServingUnit       string  `gorm:"size:50" json:"serving_unit"`     // Base unit of ServingWeight (g, ml or pcs)
 	NutritionVerified bool    `gorm:"default:false" json:"nutrition_verified"`
+	NutritionProvider string  `gorm:"size:30" json:"nutrition_provider,omitempty"` // Provider of the selected NutritionSource
+	DietProfile       `gorm:"embedded"`
+
+	Nutrients []ItemNutrient `gorm:"foreignKey:ItemID" json:"nutrients,omitempty"` // Beyond the macros above
+}
+
+// DietProfile is what allergens a food contains and which diets it fits,
+// as its label or ingredients say. Flags are nil when unknown.
+type DietProfile struct {
+	Allergens   string `gorm:"type:text" json:"allergens,omitempty"` // Comma-separated Allergen codes, e.g. "gluten,lactose"
+	Vegetarian  *bool  `json:"vegetarian,omitempty"`                 // As on Indian labels: no meat, fish or egg
+	Vegan       *bool  `json:"vegan,omitempty"`
+	Jain        *bool  `json:"jain,omitempty"` // Vegetarian without onion, garlic or root vegetables
+	ContainsEgg *bool  `json:"contains_egg,omitempty"`
+}
+
+// HasAllergen reports whether the food is known to contain allergen.
+func (d DietProfile) HasAllergen(allergen string) bool {
+	for _, a := range strings.Split(d.Allergens, ",") {
+		if strings.TrimSpace(a) == allergen {
+			return true
+		}
+	}
+	return false
+}
+
+// Allergens.
+const (
+	AllergenGluten    = "gluten"
+	AllergenLactose   = "lactose" // Milk and milk products
+	AllergenNuts      = "nuts"    // Tree nuts
+	AllergenPeanuts   = "peanuts"
+	AllergenSoy       = "soy"
+	AllergenEgg       = "egg"
+	AllergenSesame    = "sesame"
+	AllergenFish      = "fish"
+	AllergenShellfish = "shellfish"
+)
+
+// ItemNutrient is an amount of one nutrient in an item beyond its macros,
+// copied from its selected NutritionSource.
+type ItemNutrient struct {
+	ID        uint      `gorm:"primaryKey" json:"-"`
+	ItemID    uint      `gorm:"not null;uniqueIndex:idx_item_nutrient" json:"-"`
+	Code      string    `gorm:"size:40;not null;uniqueIndex:idx_item_nutrient" json:"code"` // Nutrient code, e.g. NutrientSodium
+	Amount    float64   `gorm:"not null" json:"amount"`
+	Unit      string    `gorm:"size:10;not null" json:"unit"`  // g, mg or µg
+	Basis     string    `gorm:"size:20;not null" json:"basis"` // NutrientBasis the amount is for
+	UpdatedAt time.Time `json:"-"`
+}
+
+// Nutrient codes, with the unit amounts are recorded in.
+const (
+	NutrientSugars       = "sugars"        // g
+	NutrientSaturatedFat = "saturated_fat" // g
+	NutrientSodium       = "sodium"        // mg
+	NutrientIron         = "iron"          // mg
+	NutrientCalcium      = "calcium"       // mg
+	NutrientVitaminB12   = "vitamin_b12"   // µg
+)
+
+// Bases a nutrient amount is given for.
+const (
+	NutrientBasisPer100g    = "per_100g"
+	NutrientBasisPer100ml   = "per_100ml"
+	NutrientBasisPerServing = "per_serving" // The item's ServingWeight of ServingUnit
+)
+
+// Nutrition providers.
+const (
+	NutritionProviderScraper       = "scraper"
+	NutritionProviderOpenFoodFacts = "openfoodfacts"
+	NutritionProviderOFFMirror     = "openfoodfacts-local"
+	NutritionProviderLLM           = "llm"
+)
+
+// NutritionSource is the nutrition one provider found for an item, kept so
+// it is known where an item's macros came from. The item's macros are
+// copied from its selected source.
+type NutritionSource struct {
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	ItemID         uint      `gorm:"not null;uniqueIndex:idx_nutrition_source_item_provider" json:"item_id"`
+	Provider       string    `gorm:"size:30;not null;uniqueIndex:idx_nutrition_source_item_provider" json:"provider"`
+	Query          string    `gorm:"size:255" json:"query"`
+	MatchedProduct string    `gorm:"size:255" json:"matched_product,omitempty"`
+	Confidence     float64   `gorm:"not null" json:"confidence"`    // 0 to 1
+	Verified       bool      `gorm:"default:false" json:"verified"` // Read from a label, not estimated
+	Selected       bool      `gorm:"default:false" json:"selected"`
+	FetchedAt      time.Time `gorm:"index" json:"fetched_at"`
+
+	// Per ServingWeight of ServingUnit, as on Item
+	Calories      float64 `json:"calories"`
+	Protein       float64 `json:"protein"`
+	Carbs         float64 `json:"carbs"`
+	Fat           float64 `json:"fat"`
+	Fiber         float64 `json:"fiber"`
+	ServingWeight float64 `json:"serving_weight"`
+	ServingUnit   string  `gorm:"size:50" json:"serving_unit"`
+
+	Nutrients   []ItemNutrient `gorm:"serializer:json;type:text" json:"nutrients,omitempty"`
+	DietProfile `gorm:"embedded"`
+}
+
+// OpenFoodFactsProduct is a product imported from an Open Food Facts dump,
+// so nutrition can be looked up without the network. Nutrients are per
+// 100g (or 100ml).
+type OpenFoodFactsProduct struct {
+	Code          string    `gorm:"primaryKey;size:32" json:"code"` // Barcode (EAN/UPC)
+	ProductName   string    `gorm:"size:255;not null;default:''" json:"product_name"`
+	Brands        string    `gorm:"size:255;not null;default:''" json:"brands"`
+	Quantity      string    `gorm:"size:100" json:"quantity,omitempty"`     // Pack size as printed, e.g. "500 g"
+	ServingSize   string    `gorm:"size:100" json:"serving_size,omitempty"` // e.g. "30 g"
+	EnergyKcal    float64   `json:"energy_kcal"`
+	Proteins      float64   `json:"proteins"`
+	Carbohydrates float64   `json:"carbohydrates"`
+	Fat           float64   `json:"fat"`
+	Fiber         float64   `json:"fiber"`
+	ImportedAt    time.Time `json:"imported_at"`
+
+	// Grams per 100g, as in the dump
+	Sugars       float64 `json:"sugars,omitempty"`
+	SaturatedFat float64 `json:"saturated_fat,omitempty"`
+	Sodium       float64 `json:"sodium,omitempty"`
+	Iron         float64 `json:"iron,omitempty"`
+	Calcium      float64 `json:"calcium,omitempty"`
+	VitaminB12   float64 `json:"vitamin_b12,omitempty"`
+
+	AllergensTags           string `gorm:"type:text" json:"allergens_tags,omitempty"`            // Comma-separated, e.g. "en:gluten,en:milk"
+	IngredientsAnalysisTags string `gorm:"type:text" json:"ingredients_analysis_tags,omitempty"` // Comma-separated, e.g. "en:vegan,en:palm-oil-free"
 }
 
 // Order represents an ingested grocery order.
@@ -81,29 +330,205 @@ type Item struct {
 // Prompt says: Deduplicate using external_order_id OR email_message_id.
 // So we will store both and ensure uniqueness if present.
 type Order struct {
-	ID              uint           `gorm:"primaryKey" json:"id"`
-	UserID          uint           `gorm:"not null;index" json:"user_id"`
-	ExternalOrderID string         `gorm:"size:255;index" json:"external_order_id"` // Provider's ID
-	EmailMessageID  string         `gorm:"size:255;index" json:"email_message_id"`  // For email parsers
-	Provider        string         `gorm:"size:50;not null" json:"provider"`        // zepto, blinkit, instamart
-	OrderDate       time.Time      `json:"order_date"`
-	Status          string         `gorm:"size:50;default:'processed'" json:"status"`
+	ID              uint      `gorm:"primaryKey" json:"id"`
+	UserID          uint      `gorm:"not null;index" json:"user_id"`
+	ExternalOrderID string    `gorm:"size:255;index" json:"external_order_id"` // Provider's ID
+	EmailMessageID  string    `gorm:"size:255;index" json:"email_message_id"`  // For email parsers
+	InvoiceNumber   string    `gorm:"size:255;index" json:"invoice_number,omitempty"`
+	Provider        string    `gorm:"size:50;not null" json:"provider"` // zepto, blinkit, instamart
+	Seller          string    `gorm:"size:255" json:"seller,omitempty"`
+	AddressHash     string    `gorm:"size:64" json:"address_hash,omitempty"` // SHA-256 of the delivery address
+	OrderDate       time.Time `json:"order_date"`
+	Status          string    `gorm:"size:50;default:'processed'" json:"status"`
+	OrderCharges    `gorm:"embedded"`
 	CreatedAt       time.Time      `json:"created_at"`
 	UpdatedAt       time.Time      `json:"updated_at"`
 	DeletedAt       gorm.DeletedAt `gorm:"index" json:"-"`
 
-	OrderItems []OrderItem `json:"items,omitempty"`
+	OrderItems    []OrderItem         `json:"items,omitempty"`
+	StatusChanges []OrderStatusChange `json:"status_changes,omitempty"`
+}
+
+// Order statuses.
+const (
+	OrderProcessed   = "processed"
+	OrderNeedsReview = "needs_review"
+	OrderAmended     = "amended"   // Lines adjusted or removed after ingestion
+	OrderCancelled   = "cancelled" // Pantry effect reversed; kept for idempotency
+)
+
+// OrderCharges are an order's totals in rupees, as printed on the invoice.
+// Fields are nil when the invoice did not show them.
+type OrderCharges struct {
+	ItemTotal   *float64 `json:"item_total,omitempty"` // Sum of the lines
+	DeliveryFee *float64 `json:"delivery_fee,omitempty"`
+	OtherFees   *float64 `json:"other_fees,omitempty"` // Handling, packaging, small cart
+	Discount    *float64 `json:"discount,omitempty"`   // Order-level coupons
+	Total       *float64 `json:"total,omitempty"`      // Amount paid
+}
+
+// LinePrice is what an order line cost in rupees, for the whole line (not
+// per unit). Fields are nil when the invoice did not show them.
+type LinePrice struct {
+	MRP          *float64 `json:"mrp,omitempty"`
+	Discount     *float64 `json:"discount,omitempty"`
+	TaxableValue *float64 `json:"taxable_value,omitempty"`
+	GST          *float64 `json:"gst,omitempty"`    // CGST + SGST, or IGST
+	Amount       *float64 `json:"amount,omitempty"` // Paid, including tax
+}
+
+// Paid returns the amount paid for the line, derived from the other fields
+// when the invoice has no line total.
+func (p LinePrice) Paid() (float64, bool) {
+	switch {
+	case p.Amount != nil:
+		return *p.Amount, true
+	case p.TaxableValue != nil:
+		paid := *p.TaxableValue
+		if p.GST != nil {
+			paid += *p.GST
+		}
+		return paid, true
+	case p.MRP != nil:
+		paid := *p.MRP
+		if p.Discount != nil {
+			paid -= *p.Discount
+		}
+		return paid, true
+	}
+	return 0, false
+}
+
+// Scale multiplies every price by f, e.g. when part of a line is refunded.
+func (p *LinePrice) Scale(f float64) {
+	for _, v := range []*float64{p.MRP, p.Discount, p.TaxableValue, p.GST, p.Amount} {
+		if v != nil {
+			*v *= f
+		}
+	}
+}
+
+// OrderStatusChange records an amendment or status transition of an order.
+type OrderStatusChange struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	OrderID    uint      `gorm:"not null;index" json:"order_id"`
+	FromStatus string    `gorm:"size:50" json:"from_status"`
+	ToStatus   string    `gorm:"size:50;not null" json:"to_status"`
+	Reason     string    `gorm:"size:255" json:"reason,omitempty"` // e.g. "not delivered", "refund"
+	Note       string    `gorm:"type:text" json:"note,omitempty"`  // Lines changed
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Review states of a low-confidence extraction.
+const (
+	ReviewPending  = "pending"
+	ReviewApproved = "approved"
+	ReviewRejected = "rejected"
+)
+
+// Why an order line was held back for review.
+const (
+	ReviewReasonLowConfidence = "low_confidence"
+	// ReviewReasonUnitMismatch: the quantity can't be converted to the
+	// item's or pantry's unit, e.g. pieces of an ingredient tracked in grams.
+	ReviewReasonUnitMismatch = "unit_mismatch"
+)
+
+// ExtractionReview holds an order line whose ingredient/brand split was not
+// confident enough to add to the catalog, or whose quantity doesn't fit the
+// pantry's unit. Nothing (ingredient, brand, item, order item or pantry
+// stock) is created until it is approved.
+type ExtractionReview struct {
+	ID         uint    `gorm:"primaryKey" json:"id"`
+	UserID     uint    `gorm:"not null;index" json:"user_id"`
+	OrderID    uint    `gorm:"not null;index" json:"order_id"`
+	RawName    string  `gorm:"size:255;not null" json:"raw_name"`
+	Quantity   float64 `json:"quantity"`
+	Unit       string  `gorm:"size:50" json:"unit"`
+	GTIN       string  `gorm:"size:14" json:"gtin,omitempty"`
+	LinePrice  `gorm:"embedded"`
+	Ingredient string `gorm:"size:255" json:"ingredient"`
+	Brand      string `gorm:"size:255" json:"brand"`
+	Product    string `gorm:"size:255" json:"product"`
+	Category   string `gorm:"size:255" json:"category"`
+	// The split as extracted, kept so edits can be remembered as aliases.
+	ExtractedIngredient string     `gorm:"size:255" json:"extracted_ingredient"`
+	ExtractedBrand      string     `gorm:"size:255" json:"extracted_brand"`
+	Confidence          float64    `json:"confidence"`
+	Source              string     `gorm:"size:20" json:"source"` // llm, heuristic
+	Reason              string     `gorm:"size:30" json:"reason,omitempty"`
+	Status              string     `gorm:"size:20;not null;default:'pending';index" json:"status"`
+	ItemID              *uint      `json:"item_id,omitempty"` // Set on approval
+	ReviewedAt          *time.Time `json:"reviewed_at,omitempty"`
+	CreatedAt           time.Time  `json:"created_at"`
+	UpdatedAt           time.Time  `json:"updated_at"`
+}
+
+// Ingestion job and step states.
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobCompleted = "completed"
+	JobFailed    = "failed"
+)
+
+// Ingestion steps, in the order they run.
+const (
+	IngestStepExtract  = "extract"   // Split new product names (LLM, no transaction)
+	IngestStepRecord   = "record"    // Order, catalog, reviews and pantry in one transaction
+	IngestStepFollowUp = "follow_up" // Shopping list check-off and nutrition lookups
+)
+
+// IngestionJob is an order submitted to POST /ingest/order, processed in the
+// background. Each step saves its result, so a retried or resumed job picks
+// up where it stopped.
+type IngestionJob struct {
+	ID              uint       `gorm:"primaryKey" json:"id"`
+	UserID          uint       `gorm:"not null;index" json:"user_id"`
+	Provider        string     `gorm:"size:50" json:"provider"`
+	ExternalOrderID string     `gorm:"size:255;index" json:"external_order_id"`
+	EmailMessageID  string     `gorm:"size:255;index" json:"email_message_id"`
+	InvoiceNumber   string     `gorm:"size:255;index" json:"invoice_number,omitempty"`
+	Payload         string     `gorm:"type:text" json:"-"` // The request body
+	Extractions     string     `gorm:"type:text" json:"-"` // Saved by the extract step
+	Status          string     `gorm:"size:20;not null;default:'queued';index" json:"status"`
+	Step            string     `gorm:"size:20" json:"step"` // Next step to run
+	Attempts        int        `json:"attempts"`
+	LastError       string     `gorm:"type:text" json:"last_error,omitempty"`
+	OrderID         *uint      `json:"order_id,omitempty"`
+	PendingReview   int        `json:"pending_review"`
+	NextRunAt       time.Time  `gorm:"index" json:"next_run_at"`
+	LockedUntil     *time.Time `json:"-"` // Lease of the worker running it, renewed while it runs
+	CompletedAt     *time.Time `json:"completed_at,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+
+	Steps []IngestionJobStep `gorm:"foreignKey:JobID" json:"steps,omitempty"`
+}
+
+// IngestionJobStep records the progress of one step of an ingestion job.
+type IngestionJobStep struct {
+	ID         uint       `gorm:"primaryKey" json:"-"`
+	JobID      uint       `gorm:"not null;index" json:"-"`
+	Name       string     `gorm:"size:20;not null" json:"name"`
+	Status     string     `gorm:"size:20;not null;default:'queued'" json:"status"`
+	Attempts   int        `json:"attempts"`
+	Error      string     `gorm:"type:text" json:"error,omitempty"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
 }
 
 // OrderItem links an order to a canonical item.
 // It preserves the Raw Name from the provider.
 type OrderItem struct {
-	ID        uint      `gorm:"primaryKey" json:"id"`
-	OrderID   uint      `gorm:"not null;index" json:"order_id"`
-	ItemID    uint      `gorm:"not null;index" json:"item_id"`
-	RawName   string    `gorm:"size:255;not null" json:"raw_name"` // What the receipt said
-	Quantity  float64   `gorm:"not null" json:"quantity"`
-	CreatedAt time.Time `json:"created_at"`
+	ID        uint    `gorm:"primaryKey" json:"id"`
+	OrderID   uint    `gorm:"not null;index" json:"order_id"`
+	ItemID    uint    `gorm:"not null;index" json:"item_id"`
+	RawName   string  `gorm:"size:255;not null" json:"raw_name"` // What the receipt said
+	Quantity  float64 `gorm:"not null" json:"quantity"`
+	LinePrice `gorm:"embedded"`
+	CreatedAt time.Time      `json:"created_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"` // Removed by an order amendment
 
 	Item Item `gorm:"foreignKey:ItemID" json:"item,omitempty"`
 }
@@ -116,8 +541,13 @@ type PantryItem struct {
 	IngredientID    uint      `gorm:"not null;uniqueIndex:idx_user_ingredient" json:"ingredient_id"`
 	ItemID          uint      `gorm:"not null" json:"item_id"` // Representative item (most recently purchased)
 	DerivedQuantity float64   `gorm:"default:0" json:"derived_quantity"`
-	ManualQuantity  *float64  `json:"manual_quantity"`
+	ManualQuantity  *float64  `json:"manual_quantity"`   // Legacy override, folded into the ledger on the next movement
+	MinQuantity     *float64  `json:"min_quantity"`      // Always reorder below this (in the item's unit)
+	ReorderLeadDays *float64  `json:"reorder_lead_days"` // Overrides the user's lead time for this item
 	LastUpdated     time.Time `gorm:"autoUpdateTime" json:"last_updated"`
+	// Removed by the user; the ledger is kept and adding the ingredient
+	// again restores the row
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
 
 	Ingredient Ingredient `gorm:"foreignKey:IngredientID" json:"ingredient"`
 	Item       Item       `gorm:"foreignKey:ItemID" json:"item"`
@@ -131,6 +561,112 @@ func (p *PantryItem) EffectiveQuantity() float64 {
 	return p.DerivedQuantity
 }
 
+// Pantry movement sources.
+const (
+	MovementSourceOrderItem = "order_item"
+	MovementSourceMealLog   = "meal_log"
+	MovementSourceManual    = "manual"
+	MovementSourceWaste     = "waste"
+	MovementSourceExpiry    = "expiry"
+	// MovementSourceOrderAdjustment reverses or tops up an order line
+	// after an amendment or cancellation.
+	MovementSourceOrderAdjustment = "order_adjustment"
+	// MovementSourceOpening carries stock that existed before the ledger did.
+	MovementSourceOpening = "opening_balance"
+)
+
+// PantryMovement is one append-only change to a pantry item's stock.
+// Summing a pantry item's movements (converted to its unit) gives its
+// DerivedQuantity.
+type PantryMovement struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	PantryItemID uint      `gorm:"not null;index" json:"pantry_item_id"`
+	UserID       uint      `gorm:"not null;index" json:"user_id"`
+	Delta        float64   `gorm:"not null" json:"delta"`
+	Unit         string    `gorm:"size:50;not null" json:"unit"` // Unit of Delta (g, ml, pcs)
+	Source       string    `gorm:"size:30;not null;index" json:"source"`
+	OrderItemID  *uint     `gorm:"index" json:"order_item_id,omitempty"`
+	MealLogID    *uint     `gorm:"index" json:"meal_log_id,omitempty"`
+	BatchID      *uint     `gorm:"index" json:"batch_id,omitempty"`
+	Note         string    `gorm:"size:255" json:"note,omitempty"`
+	CreatedAt    time.Time `gorm:"index" json:"created_at"`
+}
+
+// Where a batch's best-before date came from.
+const (
+	BestBeforeManual  = "manual"
+	BestBeforeDefault = "default"
+)
+
+// PantryBatch is the stock from one purchase of a pantry item. Batches are
+// consumed oldest first; their remaining quantities add up to the pantry
+// item's DerivedQuantity.
+type PantryBatch struct {
+	ID               uint       `gorm:"primaryKey" json:"id"`
+	PantryItemID     uint       `gorm:"not null;index" json:"pantry_item_id"`
+	UserID           uint       `gorm:"not null;index" json:"user_id"`
+	OrderItemID      *uint      `gorm:"index" json:"order_item_id,omitempty"`
+	Quantity         float64    `gorm:"not null" json:"quantity"`        // As purchased
+	Remaining        float64    `gorm:"not null;index" json:"remaining"` // Still in stock
+	Unit             string     `gorm:"size:50;not null" json:"unit"`    // Pantry item's unit
+	PurchasedAt      time.Time  `gorm:"not null;index" json:"purchased_at"`
+	BestBefore       *time.Time `gorm:"index" json:"best_before"`          // Nil for shelf-stable goods
+	BestBeforeSource string     `gorm:"size:20" json:"best_before_source"` // manual or default
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+
+	PantryItem PantryItem `gorm:"foreignKey:PantryItemID" json:"-"`
+}
+
+// Shopping list statuses.
+const (
+	ShoppingListOpen      = "open"
+	ShoppingListCompleted = "completed"
+)
+
+// Where a shopping list entry came from.
+const (
+	ShoppingSourceManual   = "manual"
+	ShoppingSourceLowStock = "low_stock"
+	ShoppingSourceMeal     = "meal"
+)
+
+// ShoppingList is a user's list of things to buy.
+type ShoppingList struct {
+	ID        uint           `gorm:"primaryKey" json:"id"`
+	UserID    uint           `gorm:"not null;index" json:"user_id"`
+	Name      string         `gorm:"size:255;not null" json:"name"`
+	Status    string         `gorm:"size:20;default:'open';index" json:"status"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+
+	Items []ShoppingListItem `gorm:"foreignKey:ListID" json:"items,omitempty"`
+}
+
+// ShoppingListItem is one entry on a list, kept to one row per ingredient
+// and preferred brand.
+type ShoppingListItem struct {
+	ID             uint       `gorm:"primaryKey" json:"id"`
+	ListID         uint       `gorm:"not null;index" json:"list_id"`
+	IngredientID   uint       `gorm:"not null;index" json:"ingredient_id"`
+	BrandID        *uint      `gorm:"index" json:"brand_id"`
+	ItemID         *uint      `json:"item_id"` // Representative item from the pantry, if any
+	Quantity       float64    `json:"quantity"`
+	Unit           string     `gorm:"size:50" json:"unit"`
+	Source         string     `gorm:"size:20;not null" json:"source"`
+	Note           string     `gorm:"size:255" json:"note,omitempty"`
+	Checked        bool       `gorm:"default:false" json:"checked"`
+	CheckedAt      *time.Time `json:"checked_at,omitempty"`
+	CheckedOrderID *uint      `json:"checked_order_id,omitempty"` // Order that checked it off
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+
+	Ingredient Ingredient `gorm:"foreignKey:IngredientID" json:"ingredient"`
+	Brand      *Brand     `gorm:"foreignKey:BrandID" json:"brand,omitempty"`
+	Item       *Item      `gorm:"foreignKey:ItemID" json:"item,omitempty"`
+}
+
 // Goal represents a health/fitness goal set by a user.
 type Goal struct {
 	ID          uint           `gorm:"primaryKey" json:"id"`
@@ -183,11 +719,23 @@ type UserPreferences struct {
 	State             string         `gorm:"size:100" json:"state"`
 	City              string         `gorm:"size:100" json:"city"`
 	PreferredCuisines string         `gorm:"type:text" json:"preferred_cuisines"` // Comma-separated list
+	ReorderLeadDays   float64        `gorm:"default:3" json:"reorder_lead_days"`  // Flag stock that runs out within this many days
+	ReorderCoverDays  float64        `gorm:"default:7" json:"reorder_cover_days"` // Reorder enough to last this many days after that
+	Diet              string         `gorm:"size:20" json:"diet,omitempty"`       // One of the Diet constants; empty for no restriction
+	AvoidAllergens    string         `gorm:"type:text" json:"avoid_allergens"`    // Comma-separated Allergen codes
 	CreatedAt         time.Time      `json:"created_at"`
 	UpdatedAt         time.Time      `json:"updated_at"`
 	DeletedAt         gorm.DeletedAt `gorm:"index" json:"-"`
 }
 
+// Diets a user can follow.
+const (
+	DietVegetarian = "vegetarian"
+	DietEggetarian = "eggetarian" // Vegetarian, and eggs
+	DietVegan      = "vegan"
+	DietJain       = "jain"
+)
+
 // DishSample stores sample dishes based on cuisine and location
 type DishSample struct {
 	ID                       uint           `gorm:"primaryKey" json:"id"`
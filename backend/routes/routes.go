@@ -60,10 +60,12 @@ func SetupRouter() *chi.Mux {
 	// Public / Auth
 	// r.Post("/auth/login", ...) // If we had real auth
 
-	// Ingestion (API Key protected)
+	// Ingestion (signed webhook, per-user API key with ingest:orders scope, or OAuth)
 	r.Group(func(r chi.Router) {
-		r.Use(auth.APIKeyMiddleware)
+		r.Use(auth.SignedWebhook)
+		r.Use(auth.RequireScope(auth.ScopeIngestOrders))
 		r.Post("/ingest/order", controllers.IngestOrder)
+		r.Get("/ingest/jobs/{job_id}", controllers.GetIngestionJob)
 	})
 
 	// LLM Routes (public for now, add auth as needed)
@@ -73,16 +75,87 @@ func SetupRouter() *chi.Mux {
 	// User Routes (OAuth/UserContext protected)
 	r.Group(func(r chi.Router) {
 		r.Use(auth.OAuthMiddleware)
+
+		// Account & linked identities
+		r.Get("/auth/me", controllers.GetCurrentUser)
+		r.Get("/auth/identities", controllers.GetIdentities)
+		r.Post("/auth/identities/link", controllers.LinkIdentity)
+		r.Delete("/auth/identities/{identity_id}", controllers.UnlinkIdentity)
+
+		// API keys for machine clients
+		r.Get("/api-keys", controllers.GetAPIKeys)
+		r.Post("/api-keys", controllers.CreateAPIKey)
+		r.Post("/api-keys/{key_id}/rotate", controllers.RotateAPIKey)
+		r.Delete("/api-keys/{key_id}", controllers.RevokeAPIKey)
+
+		// Signed webhook sources
+		r.Get("/webhook-sources", controllers.GetWebhookSources)
+		r.Post("/webhook-sources", controllers.CreateWebhookSource)
+		r.Post("/webhook-sources/{source_id}/rotate", controllers.RotateWebhookSecret)
+		r.Delete("/webhook-sources/{source_id}", controllers.DisableWebhookSource)
+
+		// Low-confidence extractions awaiting review
+		r.Get("/ingest/reviews", controllers.GetExtractionReviews)
+		r.Patch("/ingest/reviews/{review_id}", controllers.UpdateExtractionReview)
+		r.Post("/ingest/reviews/{review_id}/approve", controllers.ApproveExtractionReview)
+		r.Post("/ingest/reviews/{review_id}/reject", controllers.RejectExtractionReview)
+
+		// Ingredient and brand catalog (ADMIN_EMAILS only)
+		r.Route("/admin", func(r chi.Router) {
+			r.Use(auth.RequireAdmin)
+			r.Get("/ingredient-aliases", controllers.GetIngredientAliases)
+			r.Post("/ingredient-aliases", controllers.CreateIngredientAlias)
+			r.Delete("/ingredient-aliases/{alias_id}", controllers.DeleteIngredientAlias)
+			r.Get("/brand-aliases", controllers.GetBrandAliases)
+			r.Post("/brand-aliases", controllers.CreateBrandAlias)
+			r.Delete("/brand-aliases/{alias_id}", controllers.DeleteBrandAlias)
+			r.Post("/ingredients/{ingredient_id}/merge", controllers.MergeIngredient)
+		})
+
 		r.Get("/pantry", controllers.GetPantry)
 		r.Post("/pantry/add", controllers.AddPantryItem)
 		r.Patch("/pantry/{item_id}", controllers.UpdatePantryItem)
 		r.Delete("/pantry/{item_id}", controllers.DeletePantryItem)
 		r.Post("/pantry/bulk-delete", controllers.BulkDeletePantryItems)
 		r.Get("/pantry/low-stock", controllers.GetLowStock)
+		r.Get("/pantry/low-stock/categories", controllers.GetLowStockByCategory)
+		r.Get("/pantry/match", controllers.MatchPantryIngredient)
+		r.Get("/pantry/forecast", controllers.GetPantryForecast)
+		r.Get("/pantry/reorder", controllers.GetReorderList)
+		r.Put("/pantry/{item_id}/threshold", controllers.UpdatePantryThreshold)
+		r.Get("/pantry/{item_id}/history", controllers.GetPantryHistory)
+		r.Get("/pantry/expiring", controllers.GetExpiringBatches)
+		r.Get("/pantry/{item_id}/batches", controllers.GetPantryBatches)
+		r.Patch("/pantry/batches/{batch_id}", controllers.UpdatePantryBatch)
+		r.Post("/pantry/batches/{batch_id}/discard", controllers.DiscardPantryBatch)
+		r.Get("/categories", controllers.GetCategories)
+		r.Put("/ingredients/{ingredient_id}/category", controllers.UpdateIngredientCategory)
 		r.Get("/items", controllers.GetItems)
 		r.Post("/items", controllers.CreateItem)
 		r.Post("/items/extract", controllers.ExtractItems)
+		r.Get("/items/by-barcode/{code}", controllers.GetItemByBarcode)
+		r.Get("/items/{item_id}/nutrition", controllers.GetItemNutrition)
+		r.Get("/items/{item_id}/nutrition-sources", controllers.GetItemNutritionSources)
 		r.Get("/orders", controllers.GetOrders)
+		r.Post("/orders/upload", controllers.UploadOrder)
+		r.Patch("/orders/{order_id}", controllers.AmendOrder)
+		r.Post("/orders/{order_id}/cancel", controllers.CancelOrder)
+
+		// Spend
+		r.Get("/spend", controllers.GetSpend)
+		r.Get("/spend/price-trend", controllers.GetPriceTrend)
+		r.Get("/spend/compare", controllers.GetPriceComparison)
+
+		// Shopping lists
+		r.Get("/shopping-lists", controllers.GetShoppingLists)
+		r.Post("/shopping-lists", controllers.CreateShoppingList)
+		r.Get("/shopping-lists/{list_id}", controllers.GetShoppingList)
+		r.Patch("/shopping-lists/{list_id}", controllers.UpdateShoppingList)
+		r.Delete("/shopping-lists/{list_id}", controllers.DeleteShoppingList)
+		r.Post("/shopping-lists/{list_id}/items", controllers.AddShoppingListItem)
+		r.Patch("/shopping-lists/{list_id}/items/{entry_id}", controllers.UpdateShoppingListItem)
+		r.Delete("/shopping-lists/{list_id}/items/{entry_id}", controllers.DeleteShoppingListItem)
+		r.Post("/shopping-lists/{list_id}/populate", controllers.PopulateShoppingList)
 
 		// Goals
 		r.Get("/goals", controllers.GetGoals)
@@ -93,6 +166,7 @@ func SetupRouter() *chi.Mux {
 		r.Post("/meals/log", controllers.LogMeal)
 		r.Get("/meals", controllers.GetMealHistory)
 		r.Delete("/meals/{meal_id}", controllers.DeleteMealLog)
+		r.Post("/meals/{meal_id}/resolve", controllers.ResolveMealIngredient)
 
 		// LLM with auth (for personalized suggestions)
 		r.Post("/llm/suggest-meal-personalized", controllers.SuggestMealPersonalized)
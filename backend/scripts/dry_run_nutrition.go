@@ -47,5 +47,6 @@ func main() {
 		fmt.Printf("Fats:     %.2f g\n", item.Fat)
 		fmt.Printf("Fiber:    %.2f g\n", item.Fiber)
 		fmt.Printf("Verified: %v\n", item.NutritionVerified)
+		fmt.Printf("Source:   %s\n", item.NutritionProvider)
 	}
 }
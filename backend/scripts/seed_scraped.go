@@ -9,6 +9,8 @@ import (
 
 	"github.com/pmitra96/pateproject/database"
 	"github.com/pmitra96/pateproject/models"
+	"github.com/pmitra96/pateproject/services"
+	"github.com/pmitra96/pateproject/units"
 	"gorm.io/gorm/clause"
 )
 
@@ -34,6 +36,7 @@ type ScrapedProduct struct {
 	NutritionInfo map[string]interface{} `json:"nutrition_info"`
 	Weight        string                 `json:"weight"`
 	Unit          string                 `json:"unit"`
+	Barcode       string                 `json:"barcode"`
 }
 
 func main() {
@@ -108,6 +111,11 @@ func main() {
 		protein := getFloat(p.NutritionInfo, "protein")
 		carbs := getFloat(p.NutritionInfo, "carbohydrates")
 		fat := getFloat(p.NutritionInfo, "fat")
+		// The scraper scales listings to 100 g, or 100 ml for liquids
+		basis := services.Per100g
+		if dim, _ := units.DimensionOf(p.Unit); dim == units.Volume {
+			basis = services.Per100ml
+		}
 
 		// 4. Create/Update Item
 		item := models.Item{
@@ -120,7 +128,17 @@ func main() {
 			Protein:           protein,
 			Carbs:             carbs,
 			Fat:               fat,
+			ServingWeight:     basis.Amount,
+			ServingUnit:       basis.Unit,
 			NutritionVerified: true,
+			NutritionProvider: models.NutritionProviderScraper,
+		}
+		if p.Barcode != "" {
+			if gtin, err := services.NormalizeGTIN(p.Barcode); err == nil {
+				item.GTIN = gtin
+			} else {
+				fmt.Printf("Ignoring invalid barcode %s for %s\n", p.Barcode, p.Name)
+			}
 		}
 
 		// Use OnConflict to update if Name already exists
@@ -0,0 +1,380 @@
+package services
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/pmitra96/pateproject/llm"
+	"github.com/pmitra96/pateproject/logger"
+	"github.com/pmitra96/pateproject/models"
+	"github.com/pmitra96/pateproject/units"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrMergeIntoSelf is returned when an ingredient is merged into itself.
+var ErrMergeIntoSelf = errors.New("cannot merge an ingredient into itself")
+
+// defaultBrands seed brand detection for names the catalog has not seen.
+var defaultBrands = []string{"amul", "mooz", "akshayakalpa", "mother dairy", "milky mist", "britannia", "nestle", "urban platter", "dehaat", "honest farms", "hen fruit", "blinkit", "zepto", "swiggy", "instamart", "tata sampann", "tata", "fortune", "aashirvaad", "dabur", "haldiram", "epigamia"}
+
+// LookupIngredientAlias returns the ingredient an alias points to.
+func LookupIngredientAlias(db *gorm.DB, name string) (*models.Ingredient, bool) {
+	var alias models.IngredientAlias
+	err := db.Preload("Ingredient").Where("alias = ?", strings.ToLower(strings.TrimSpace(name))).First(&alias).Error
+	if err != nil || alias.Ingredient.ID == 0 {
+		return nil, false
+	}
+	return &alias.Ingredient, true
+}
+
+// ResolveIngredient finds the canonical ingredient for a name, creating it
+// if needed. Catalog aliases win over the built-in normalisation, so a bad
+// mapping can be fixed with an alias rather than a code change.
+func ResolveIngredient(db *gorm.DB, name string) (models.Ingredient, error) {
+	if ingredient, ok := LookupIngredientAlias(db, name); ok {
+		return *ingredient, nil
+	}
+	normalized := normalizeIngredientName(name)
+	if ingredient, ok := LookupIngredientAlias(db, normalized); ok {
+		return *ingredient, nil
+	}
+
+	var ingredient models.Ingredient
+	err := db.Where("LOWER(name) = ?", strings.ToLower(normalized)).
+		FirstOrCreate(&ingredient, models.Ingredient{Name: normalized}).Error
+	return ingredient, err
+}
+
+// LookupBrandAlias returns the brand an alias points to.
+func LookupBrandAlias(db *gorm.DB, name string) (*models.Brand, bool) {
+	var alias models.BrandAlias
+	err := db.Preload("Brand").Where("alias = ?", strings.ToLower(strings.TrimSpace(name))).First(&alias).Error
+	if err != nil || alias.Brand.ID == 0 {
+		return nil, false
+	}
+	return &alias.Brand, true
+}
+
+// ResolveBrand finds a brand by name or alias, creating it if needed.
+func ResolveBrand(db *gorm.DB, name string) (models.Brand, error) {
+	name = strings.TrimSpace(name)
+	if brand, ok := LookupBrandAlias(db, name); ok {
+		return *brand, nil
+	}
+
+	var brand models.Brand
+	err := db.Where("LOWER(name) = ?", strings.ToLower(name)).FirstOrCreate(&brand, models.Brand{Name: name}).Error
+	return brand, err
+}
+
+// ErrUnknownBarcode is returned for a valid barcode that is neither in the
+// catalog nor in the Open Food Facts mirror.
+var ErrUnknownBarcode = errors.New("unknown barcode")
+
+// FindItemByGTIN returns the catalog item with a barcode.
+func FindItemByGTIN(db *gorm.DB, code string) (*models.Item, bool) {
+	gtin, err := NormalizeGTIN(code)
+	if err != nil {
+		return nil, false
+	}
+	var item models.Item
+	if err := db.Preload("Ingredient").Preload("Brand").Where("gtin = ?", gtin).First(&item).Error; err != nil {
+		return nil, false
+	}
+	return &item, true
+}
+
+// SetItemGTIN records a barcode on an item that has none yet. Barcodes from
+// orders and scraped listings are not trusted, so invalid ones are logged
+// and ignored rather than failing the caller.
+func SetItemGTIN(db *gorm.DB, item *models.Item, code string) error {
+	if code == "" || item.GTIN != "" {
+		return nil
+	}
+	gtin, err := NormalizeGTIN(code)
+	if err != nil {
+		logger.Warn("Ignoring invalid barcode", "item", item.Name, "gtin", code)
+		return nil
+	}
+	if err := db.Model(&models.Item{}).Where("id = ?", item.ID).Update("gtin", gtin).Error; err != nil {
+		return err
+	}
+	item.GTIN = gtin
+	return nil
+}
+
+// ItemForBarcode finds the catalog item for a barcode, adding it from the
+// Open Food Facts mirror when the catalog does not have it yet.
+func ItemForBarcode(db *gorm.DB, code string) (models.Item, error) {
+	gtin, err := NormalizeGTIN(code)
+	if err != nil {
+		return models.Item{}, err
+	}
+	if item, ok := FindItemByGTIN(db, gtin); ok {
+		return *item, nil
+	}
+	product, ok := LookupOpenFoodFactsBarcode(db, gtin)
+	if !ok {
+		return models.Item{}, ErrUnknownBarcode
+	}
+	name, unit := barcodeItemName(product)
+
+	var item models.Item
+	err = db.Transaction(func(tx *gorm.DB) error {
+		// The product may be in the catalog under its name, without a barcode
+		err := tx.Preload("Ingredient").Where("name = ?", name).First(&item).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			ext := *llm.NewClient().ExtractHeuristic(name, KnownBrands(tx))
+			ApplyCatalogAliases(tx, name, &ext)
+			item, err = CreateCatalogItem(tx, name, unit, ext)
+		}
+		if err != nil {
+			return err
+		}
+		return SetItemGTIN(tx, &item, gtin)
+	})
+	return item, err
+}
+
+// LookupItemByBarcode is ItemForBarcode without writes: a product that is
+// only in the Open Food Facts mirror comes back as an unsaved item (ID 0)
+// with its catalog name, unit and barcode.
+func LookupItemByBarcode(db *gorm.DB, code string) (models.Item, error) {
+	gtin, err := NormalizeGTIN(code)
+	if err != nil {
+		return models.Item{}, err
+	}
+	if item, ok := FindItemByGTIN(db, gtin); ok {
+		return *item, nil
+	}
+	product, ok := LookupOpenFoodFactsBarcode(db, gtin)
+	if !ok {
+		return models.Item{}, ErrUnknownBarcode
+	}
+	name, unit := barcodeItemName(product)
+
+	var item models.Item
+	if err := db.Preload("Ingredient").Preload("Brand").Where("name = ?", name).First(&item).Error; err == nil {
+		return item, nil
+	}
+	return models.Item{Name: name, Unit: units.NormalizeUnit(unit), GTIN: gtin}, nil
+}
+
+// barcodeItemName is the catalog name and unit for an Open Food Facts
+// product: its name, prefixed by its brand unless the name has it.
+func barcodeItemName(product *models.OpenFoodFactsProduct) (string, string) {
+	// Brands are a comma-separated list, owner first
+	brand, _, _ := strings.Cut(product.Brands, ",")
+	name := strings.TrimSpace(product.ProductName)
+	if brand = strings.TrimSpace(brand); brand != "" && !strings.Contains(strings.ToLower(name), strings.ToLower(brand)) {
+		name = brand + " " + name
+	}
+	_, unit := units.PackSize(product.Quantity)
+	return name, unit
+}
+
+// KnownBrands lists lower-case brand names and aliases for spotting a brand
+// in a product name, longest first so "tata sampann" wins over "tata".
+func KnownBrands(db *gorm.DB) []string {
+	seen := make(map[string]bool)
+	var names []string
+	add := func(n string) {
+		n = strings.ToLower(strings.TrimSpace(n))
+		if n != "" && !seen[n] {
+			seen[n] = true
+			names = append(names, n)
+		}
+	}
+
+	for _, b := range defaultBrands {
+		add(b)
+	}
+	var brands []models.Brand
+	db.Find(&brands)
+	for _, b := range brands {
+		add(b.Name)
+	}
+	var aliases []models.BrandAlias
+	db.Find(&aliases)
+	for _, a := range aliases {
+		add(a.Alias)
+	}
+
+	sort.SliceStable(names, func(i, j int) bool { return len(names[i]) > len(names[j]) })
+	return names
+}
+
+// MergeIngredient folds a duplicate ingredient into another in one
+// transaction: items, shopping list entries and aliases are re-pointed, a
+// user's pantry stock for both is combined (batches and ledger included),
+// and the old name becomes an alias of the surviving ingredient.
+func MergeIngredient(db *gorm.DB, fromID, intoID uint) error {
+	if fromID == intoID {
+		return ErrMergeIntoSelf
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		var from, into models.Ingredient
+		if err := tx.First(&from, fromID).Error; err != nil {
+			return err
+		}
+		if err := tx.First(&into, intoID).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Model(&models.Item{}).Where("ingredient_id = ?", from.ID).Update("ingredient_id", into.ID).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.ShoppingListItem{}).Where("ingredient_id = ?", from.ID).Update("ingredient_id", into.ID).Error; err != nil {
+			return err
+		}
+
+		var pantryItems []models.PantryItem
+		// Removed pantry items too, so their ledgers follow the ingredient
+		if err := tx.Unscoped().Preload("Ingredient").Preload("Item").Where("ingredient_id = ?", from.ID).Find(&pantryItems).Error; err != nil {
+			return err
+		}
+		for i := range pantryItems {
+			if err := mergePantryItem(tx, &pantryItems[i], into); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Model(&models.IngredientAlias{}).Where("ingredient_id = ?", from.ID).Update("ingredient_id", into.ID).Error; err != nil {
+			return err
+		}
+		alias := models.IngredientAlias{Alias: strings.ToLower(from.Name), IngredientID: into.ID}
+		if err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "alias"}},
+			DoUpdates: clause.AssignmentColumns([]string{"ingredient_id", "updated_at"}),
+		}).Create(&alias).Error; err != nil {
+			return err
+		}
+
+		// Hard delete so the unique name is free; the alias keeps it resolvable.
+		if err := tx.Unscoped().Delete(&from).Error; err != nil {
+			return err
+		}
+		logger.Info("Merged ingredient", "from", from.Name, "into", into.Name, "pantry_items", len(pantryItems))
+		return nil
+	})
+}
+
+// mergePantryItem moves a pantry item onto another ingredient. If the user
+// already stocks that ingredient, batches and movements are moved across
+// and the quantities added up. Either may have been removed by the user;
+// the result is removed only if both were.
+func mergePantryItem(tx *gorm.DB, pi *models.PantryItem, into models.Ingredient) error {
+	var target models.PantryItem
+	err := tx.Unscoped().Preload("Ingredient").Preload("Item").
+		Where("user_id = ? AND ingredient_id = ?", pi.UserID, into.ID).First(&target).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return tx.Unscoped().Model(pi).Update("ingredient_id", into.ID).Error
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := ensureOpeningBalance(tx, pi); err != nil {
+		return err
+	}
+	if err := ensureOpeningBalance(tx, &target); err != nil {
+		return err
+	}
+	// Stock that can't be converted stops the merge; used-up batches are
+	// kept as they are
+	quantity := 0.0
+	if pi.DerivedQuantity != 0 {
+		if quantity, err = ToPantryUnit(&target, pi.DerivedQuantity, PantryUnit(pi)); err != nil {
+			return err
+		}
+	}
+	if err := convertBatches(tx, pi, PantryUnit(&target)); err != nil {
+		if !errors.Is(err, ErrUnitMismatch) || pi.DerivedQuantity != 0 {
+			return err
+		}
+		logger.Warn("Merging empty pantry batches without unit conversion", "from", pi.ID, "into", target.ID, "error", err)
+	}
+
+	if err := tx.Model(&models.PantryBatch{}).Where("pantry_item_id = ?", pi.ID).Update("pantry_item_id", target.ID).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&models.PantryMovement{}).Where("pantry_item_id = ?", pi.ID).Update("pantry_item_id", target.ID).Error; err != nil {
+		return err
+	}
+
+	target.DerivedQuantity += quantity
+	if !pi.DeletedAt.Valid {
+		target.DeletedAt = gorm.DeletedAt{}
+	}
+	if err := tx.Unscoped().Omit(clause.Associations).Save(&target).Error; err != nil {
+		return err
+	}
+	// Hard delete: its batches and ledger now belong to target.
+	return tx.Unscoped().Delete(pi).Error
+}
+
+// normalizeIngredientName cleans up ingredient names (e.g., "Soya Tofu" -> "Tofu")
+func normalizeIngredientName(name string) string {
+	name = strings.TrimSpace(name)
+	lower := strings.ToLower(name)
+
+	// Direct mappings for common redundancies or typos
+	replacements := map[string]string{
+		"soya tofu": "Tofu",
+		"soy tofu":  "Tofu",
+		"broccoll":  "Broccoli", // Fix known typo
+		"brocoli":   "Broccoli",
+	}
+
+	if val, ok := replacements[lower]; ok {
+		return val
+	}
+	// Standardize regional names (dahi/curd -> Yogurt)
+	if canonical, ok := CanonicalIngredient(lower); ok {
+		return strings.Title(canonical)
+	}
+
+	// Remove common prefixes/suffixes if present
+	// e.g. "Fresh Tomato" -> "Tomato"
+	words := strings.Fields(name)
+	if len(words) > 1 {
+		// extensive list of modifiers to strip from start
+		modifiers := []string{
+			"fresh", "organic", "raw", "premium", "natural",
+			"farm", "whole", "sliced", "chopped", "diced",
+		}
+
+		cleanWords := []string{}
+		for _, w := range words {
+			isMod := false
+			wLower := strings.ToLower(w)
+			for _, m := range modifiers {
+				if wLower == m {
+					isMod = true
+					break
+				}
+			}
+			if !isMod {
+				cleanWords = append(cleanWords, w)
+			}
+		}
+
+		// If we stripped everything, revert to original
+		if len(cleanWords) == 0 {
+			return name
+		}
+
+		// If we reduced it to just 1 word, capitalize it
+		if len(cleanWords) == 1 {
+			return strings.Title(strings.ToLower(cleanWords[0]))
+		}
+
+		// Reassemble
+		return strings.Join(cleanWords, " ")
+	}
+
+	return strings.Title(lower)
+}
@@ -0,0 +1,56 @@
+package services
+
+import (
+	"os"
+	"testing"
+
+	"github.com/pmitra96/pateproject/database"
+	"github.com/pmitra96/pateproject/models"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// testDB opens TEST_DATABASE_URL, migrates the given models and returns a
+// transaction, also installed as database.DB, that is rolled back when the
+// test ends. Tests that need Postgres are skipped without it.
+func testDB(t *testing.T, tables ...interface{}) *gorm.DB {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("open test database: %v", err)
+	}
+	if err := db.AutoMigrate(tables...); err != nil {
+		t.Fatalf("migrate test database: %v", err)
+	}
+
+	tx := db.Begin()
+	previous := database.DB
+	database.DB = tx
+	t.Cleanup(func() {
+		database.DB = previous
+		tx.Rollback()
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return tx
+}
+
+// testItem saves an item under a new ingredient.
+func testItem(t *testing.T, db *gorm.DB, name, unit string) *models.Item {
+	t.Helper()
+	ingredient := models.Ingredient{Name: name}
+	if err := db.Create(&ingredient).Error; err != nil {
+		t.Fatalf("create ingredient: %v", err)
+	}
+	item := models.Item{Name: name, IngredientID: ingredient.ID, Unit: unit}
+	if err := db.Create(&item).Error; err != nil {
+		t.Fatalf("create item: %v", err)
+	}
+	return &item
+}
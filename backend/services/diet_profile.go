@@ -0,0 +1,267 @@
+package services
+
+import (
+	"math"
+	"strings"
+	"unicode"
+
+	"github.com/pmitra96/pateproject/models"
+)
+
+// offNutrients are the mirror's extra nutrients, in grams per 100g or 100ml, with
+// the code and unit each is recorded under on an item.
+var offNutrients = []struct {
+	code, unit string
+	scale      float64 // From grams
+	value      func(models.OpenFoodFactsProduct) float64
+}{
+	{models.NutrientSugars, "g", 1, func(p models.OpenFoodFactsProduct) float64 { return p.Sugars }},
+	{models.NutrientSaturatedFat, "g", 1, func(p models.OpenFoodFactsProduct) float64 { return p.SaturatedFat }},
+	{models.NutrientSodium, "mg", 1e3, func(p models.OpenFoodFactsProduct) float64 { return p.Sodium }},
+	{models.NutrientIron, "mg", 1e3, func(p models.OpenFoodFactsProduct) float64 { return p.Iron }},
+	{models.NutrientCalcium, "mg", 1e3, func(p models.OpenFoodFactsProduct) float64 { return p.Calcium }},
+	{models.NutrientVitaminB12, "µg", 1e6, func(p models.OpenFoodFactsProduct) float64 { return p.VitaminB12 }},
+}
+
+// openFoodFactsNutrients reads an Open Food Facts product's nutrients
+// beyond the macros, on the product's basis. Zero is left out: the dumps
+// do not tell it apart from a value that was never entered.
+func openFoodFactsNutrients(p models.OpenFoodFactsProduct, basis NutritionBasis) []models.ItemNutrient {
+	var nutrients []models.ItemNutrient
+	for _, n := range offNutrients {
+		if v := n.value(p); v > 0 {
+			nutrients = append(nutrients, models.ItemNutrient{
+				Code:   n.code,
+				Amount: math.Round(v*n.scale*1000) / 1000,
+				Unit:   n.unit,
+				Basis:  basis.Code(),
+			})
+		}
+	}
+	return nutrients
+}
+
+// offAllergens maps Open Food Facts allergen tags to ours.
+var offAllergens = map[string]string{
+	"en:gluten":       models.AllergenGluten,
+	"en:milk":         models.AllergenLactose,
+	"en:nuts":         models.AllergenNuts,
+	"en:peanuts":      models.AllergenPeanuts,
+	"en:soybeans":     models.AllergenSoy,
+	"en:eggs":         models.AllergenEgg,
+	"en:sesame-seeds": models.AllergenSesame,
+	"en:fish":         models.AllergenFish,
+	"en:crustaceans":  models.AllergenShellfish,
+	"en:molluscs":     models.AllergenShellfish,
+}
+
+// openFoodFactsDiet reads an Open Food Facts product's allergens and its
+// ingredients analysis (en:vegan, en:non-vegetarian, ...). Open Food
+// Facts counts eggs as vegetarian; the egg allergen overrules that.
+func openFoodFactsDiet(p models.OpenFoodFactsProduct) models.DietProfile {
+	var allergens []string
+	for _, tag := range strings.Split(p.AllergensTags, ",") {
+		if a, ok := offAllergens[strings.ToLower(strings.TrimSpace(tag))]; ok {
+			allergens = appendUnique(allergens, a)
+		}
+	}
+	diet := models.DietProfile{Allergens: strings.Join(allergens, ",")}
+
+	for _, tag := range strings.Split(p.IngredientsAnalysisTags, ",") {
+		switch strings.ToLower(strings.TrimSpace(tag)) {
+		case "en:vegan":
+			diet.Vegan = flag(true)
+		case "en:non-vegan":
+			diet.Vegan = flag(false)
+		case "en:vegetarian":
+			diet.Vegetarian = flag(true)
+		case "en:non-vegetarian":
+			diet.Vegetarian = flag(false)
+		}
+	}
+	settleDiet(&diet)
+	return diet
+}
+
+// textAllergens are words on ingredient and allergen lists that name each
+// allergen. Words match whole, or as plurals.
+var textAllergens = []struct {
+	allergen string
+	words    []string
+}{
+	{models.AllergenGluten, []string{"gluten", "wheat", "barley", "rye", "oat", "maida", "atta"}},
+	{models.AllergenLactose, []string{"milk", "lactose", "dairy", "whey", "casein", "cheese", "paneer", "ghee", "butter"}},
+	{models.AllergenPeanuts, []string{"peanut", "groundnut"}},
+	{models.AllergenNuts, []string{"nut", "almond", "cashew", "walnut", "pistachio", "hazelnut"}},
+	{models.AllergenSoy, []string{"soy", "soya", "soybean"}},
+	{models.AllergenEgg, []string{"egg"}},
+	{models.AllergenSesame, []string{"sesame"}},
+	{models.AllergenFish, []string{"fish"}},
+	{models.AllergenShellfish, []string{"shellfish", "crustacean", "prawn", "shrimp", "crab", "lobster", "mollusc"}},
+}
+
+// plantDairy are words that, before "milk" or "butter", make it a plant
+// product rather than dairy: "coconut milk", "peanut butter".
+var plantDairy = map[string]bool{
+	"coconut": true, "almond": true, "cashew": true, "peanut": true, "soy": true,
+	"soya": true, "oat": true, "rice": true, "cocoa": true, "shea": true,
+}
+
+// scraperDiet reads a scraped listing's allergen information ("Contains
+// wheat and milk. May contain traces of nuts.") and dietary preference
+// ("Veg", "Non-Veg", "Egg", "Vegan", "Jain"). Traces are not counted.
+func scraperDiet(allergenInfo, preference string) models.DietProfile {
+	text := strings.ToLower(allergenInfo)
+	if i := strings.Index(text, "may contain"); i >= 0 {
+		text = text[:i]
+	}
+	words := map[string]bool{}
+	fields := strings.FieldsFunc(text, func(r rune) bool { return !unicode.IsLetter(r) })
+	for i, w := range fields {
+		if (w == "milk" || w == "butter") && i > 0 && plantDairy[fields[i-1]] {
+			continue
+		}
+		words[w] = true
+	}
+
+	var allergens []string
+	for _, a := range textAllergens {
+		for _, w := range a.words {
+			if words[w] || words[w+"s"] || words[w+"es"] {
+				allergens = appendUnique(allergens, a.allergen)
+				break
+			}
+		}
+	}
+	diet := models.DietProfile{Allergens: strings.Join(allergens, ",")}
+
+	pref := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, preference)
+	switch {
+	case pref == "":
+	case strings.Contains(pref, "nonveg"):
+		diet.Vegetarian = flag(false)
+	case strings.Contains(pref, "vegan"):
+		diet.Vegan = flag(true)
+	case strings.Contains(pref, "jain"):
+		diet.Jain = flag(true)
+	case strings.Contains(pref, "eggless"):
+		diet.ContainsEgg = flag(false)
+	case strings.Contains(pref, "egg"):
+		diet.ContainsEgg = flag(true)
+	case strings.Contains(pref, "veg"):
+		diet.Vegetarian = flag(true)
+	}
+	settleDiet(&diet)
+	return diet
+}
+
+// settleDiet fills in the flags that follow from the others and from the
+// allergens, e.g. a vegan food is vegetarian and has no egg, and a food
+// with egg is neither.
+func settleDiet(d *models.DietProfile) {
+	if d.HasAllergen(models.AllergenEgg) {
+		d.ContainsEgg = flag(true)
+	}
+	animal := d.HasAllergen(models.AllergenFish) || d.HasAllergen(models.AllergenShellfish)
+	if animal || isTrue(d.ContainsEgg) {
+		d.Vegetarian = flag(false)
+	}
+	if animal || isTrue(d.ContainsEgg) || d.HasAllergen(models.AllergenLactose) {
+		d.Vegan = flag(false)
+	}
+
+	if isTrue(d.Jain) || isTrue(d.Vegan) {
+		d.Vegetarian = flag(true)
+	}
+	if isTrue(d.Vegetarian) {
+		d.ContainsEgg = flag(false)
+	}
+	if d.Vegetarian != nil && !*d.Vegetarian {
+		d.Vegan = flag(false)
+		d.Jain = flag(false)
+	}
+}
+
+// FitsDiet reports whether a food may be eaten on a diet (one of the Diet
+// constants on UserPreferences), and if not, why. Foods whose flags are
+// unknown fit.
+func FitsDiet(food models.DietProfile, diet string) (bool, string) {
+	switch diet {
+	case models.DietVegetarian:
+		if food.Vegetarian != nil && !*food.Vegetarian {
+			return false, "not vegetarian"
+		}
+	case models.DietEggetarian:
+		if food.Vegetarian != nil && !*food.Vegetarian && !isTrue(food.ContainsEgg) {
+			return false, "not vegetarian"
+		}
+	case models.DietVegan:
+		if food.Vegan != nil && !*food.Vegan {
+			return false, "not vegan"
+		}
+	case models.DietJain:
+		if food.Jain != nil && !*food.Jain {
+			return false, "not Jain"
+		}
+	}
+	return true, ""
+}
+
+// AvoidedAllergens returns the allergens in a food that are in avoid, a
+// comma-separated list.
+func AvoidedAllergens(food models.DietProfile, avoid string) []string {
+	var found []string
+	for _, a := range strings.Split(avoid, ",") {
+		if a = strings.TrimSpace(a); a != "" && food.HasAllergen(a) {
+			found = append(found, a)
+		}
+	}
+	return found
+}
+
+// IsDiet reports whether diet is one of the Diet constants, or empty for
+// no restriction.
+func IsDiet(diet string) bool {
+	switch diet {
+	case "", models.DietVegetarian, models.DietEggetarian, models.DietVegan, models.DietJain:
+		return true
+	}
+	return false
+}
+
+// IsAllergen reports whether allergen is one of the Allergen constants.
+func IsAllergen(allergen string) bool {
+	for _, a := range allergenCodes() {
+		if a == allergen {
+			return true
+		}
+	}
+	return false
+}
+
+// allergenCodes are the Allergen constants.
+func allergenCodes() []string {
+	codes := make([]string, 0, len(textAllergens))
+	for _, a := range textAllergens {
+		codes = append(codes, a.allergen)
+	}
+	return codes
+}
+
+func flag(b bool) *bool { return &b }
+
+func isTrue(b *bool) bool { return b != nil && *b }
+
+func appendUnique(list []string, s string) []string {
+	for _, v := range list {
+		if v == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
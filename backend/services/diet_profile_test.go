@@ -0,0 +1,150 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/pmitra96/pateproject/models"
+)
+
+// dietFlags is a DietProfile's flags as "yes", "no" or "" for unknown, for
+// comparing in tests.
+type dietFlags struct{ vegetarian, vegan, jain, egg string }
+
+func flagsOf(d models.DietProfile) dietFlags {
+	s := func(b *bool) string {
+		switch {
+		case b == nil:
+			return ""
+		case *b:
+			return "yes"
+		}
+		return "no"
+	}
+	return dietFlags{s(d.Vegetarian), s(d.Vegan), s(d.Jain), s(d.ContainsEgg)}
+}
+
+func TestScraperDietPreference(t *testing.T) {
+	tests := []struct {
+		preference string
+		want       dietFlags
+	}{
+		{"", dietFlags{}},
+		{"Non-Veg", dietFlags{vegetarian: "no", vegan: "no", jain: "no"}},
+		{"Veg", dietFlags{vegetarian: "yes", egg: "no"}},
+		{"Egg", dietFlags{vegetarian: "no", vegan: "no", jain: "no", egg: "yes"}},
+		{"Eggless", dietFlags{egg: "no"}},
+		{"Jain", dietFlags{vegetarian: "yes", jain: "yes", egg: "no"}},
+		{"Vegan", dietFlags{vegetarian: "yes", vegan: "yes", egg: "no"}},
+	}
+	for _, tt := range tests {
+		if got := flagsOf(scraperDiet("", tt.preference)); got != tt.want {
+			t.Errorf("scraperDiet(%q) = %+v, want %+v", tt.preference, got, tt.want)
+		}
+	}
+}
+
+func TestScraperDietAllergens(t *testing.T) {
+	tests := []struct {
+		info string
+		want string
+	}{
+		{"Contains wheat and milk.", "gluten,lactose"},
+		{"Contains wheat. May contain traces of nuts and milk.", "gluten"},
+		{"MAY CONTAIN peanuts", ""},
+		{"Ingredients: eggs, prawns, sesame seeds", "egg,sesame,shellfish"},
+		{"Coconut milk, sugar", ""},
+		{"Almond milk (water, almonds)", "nuts"},
+		{"Peanut butter, salt", "peanuts"},
+		{"Cocoa butter, milk solids", "lactose"},
+		{"Butter, salt", "lactose"},
+		{"Soya milk", "soy"},
+	}
+	for _, tt := range tests {
+		if got := scraperDiet(tt.info, "").Allergens; got != tt.want {
+			t.Errorf("scraperDiet(%q) allergens = %q, want %q", tt.info, got, tt.want)
+		}
+	}
+
+	// Plant milk does not make a vegan product non-vegan
+	if diet := scraperDiet("Coconut milk, sugar", "Vegan"); !isTrue(diet.Vegan) {
+		t.Errorf("vegan coconut milk product = %+v", flagsOf(diet))
+	}
+}
+
+func TestSettleDiet(t *testing.T) {
+	tests := []struct {
+		name string
+		diet models.DietProfile
+		want dietFlags
+	}{
+		{"egg allergen", models.DietProfile{Allergens: "egg"}, dietFlags{vegetarian: "no", vegan: "no", jain: "no", egg: "yes"}},
+		{"fish", models.DietProfile{Allergens: "fish"}, dietFlags{vegetarian: "no", vegan: "no", jain: "no"}},
+		{"milk", models.DietProfile{Allergens: "lactose"}, dietFlags{vegan: "no"}},
+		{"vegan", models.DietProfile{Vegan: flag(true)}, dietFlags{vegetarian: "yes", vegan: "yes", egg: "no"}},
+		{"jain", models.DietProfile{Jain: flag(true)}, dietFlags{vegetarian: "yes", jain: "yes", egg: "no"}},
+		{"vegan label with milk", models.DietProfile{Allergens: "lactose", Vegan: flag(true)}, dietFlags{vegan: "no"}},
+		{"vegetarian label with egg", models.DietProfile{Allergens: "egg", Vegetarian: flag(true)}, dietFlags{vegetarian: "no", vegan: "no", jain: "no", egg: "yes"}},
+		{"not vegetarian", models.DietProfile{Vegetarian: flag(false)}, dietFlags{vegetarian: "no", vegan: "no", jain: "no"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diet := tt.diet
+			settleDiet(&diet)
+			if got := flagsOf(diet); got != tt.want {
+				t.Errorf("settled = %+v, want %+v", got, tt.want)
+			}
+			// Settling is idempotent
+			again := diet
+			settleDiet(&again)
+			if flagsOf(again) != flagsOf(diet) {
+				t.Errorf("settling twice = %+v, want %+v", flagsOf(again), flagsOf(diet))
+			}
+		})
+	}
+}
+
+func TestFitsDiet(t *testing.T) {
+	unknown := models.DietProfile{}
+	egg := models.DietProfile{Allergens: "egg"}
+	fish := models.DietProfile{Allergens: "fish"}
+	paneer := models.DietProfile{Allergens: "lactose", Vegetarian: flag(true)}
+	settleDiet(&egg)
+	settleDiet(&fish)
+	settleDiet(&paneer)
+
+	tests := []struct {
+		name string
+		food models.DietProfile
+		diet string
+		want bool
+	}{
+		{"no diet", fish, "", true},
+		{"unknown food fits", unknown, models.DietVegan, true},
+		{"egg on vegetarian", egg, models.DietVegetarian, false},
+		{"egg on eggetarian", egg, models.DietEggetarian, true},
+		{"fish on eggetarian", fish, models.DietEggetarian, false},
+		{"paneer on vegetarian", paneer, models.DietVegetarian, true},
+		{"paneer on vegan", paneer, models.DietVegan, false},
+		{"egg on Jain", egg, models.DietJain, false},
+	}
+	for _, tt := range tests {
+		fits, reason := FitsDiet(tt.food, tt.diet)
+		if fits != tt.want {
+			t.Errorf("%s: FitsDiet = %v (%q), want %v", tt.name, fits, reason, tt.want)
+		}
+		if !fits && reason == "" {
+			t.Errorf("%s: no reason given", tt.name)
+		}
+	}
+}
+
+func TestAvoidedAllergens(t *testing.T) {
+	food := models.DietProfile{Allergens: "gluten,lactose"}
+	if got := AvoidedAllergens(food, "nuts, gluten,,lactose"); !reflect.DeepEqual(got, []string{"gluten", "lactose"}) {
+		t.Errorf("AvoidedAllergens = %v, want [gluten lactose]", got)
+	}
+	if got := AvoidedAllergens(food, ""); got != nil {
+		t.Errorf("AvoidedAllergens with nothing avoided = %v", got)
+	}
+}
@@ -0,0 +1,318 @@
+package services
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/pmitra96/pateproject/models"
+	"github.com/pmitra96/pateproject/units"
+	"gorm.io/gorm"
+)
+
+// Why a pantry item is flagged for reorder.
+const (
+	ReasonOutOfStock   = "out_of_stock"
+	ReasonRunsOutSoon  = "runs_out_soon"
+	ReasonBelowMinimum = "below_minimum"
+)
+
+// Where a daily usage estimate came from.
+const (
+	UsageFromMeals  = "meals"
+	UsageFromOrders = "orders"
+)
+
+// ForecastSettings control when stock is flagged and how much to reorder.
+type ForecastSettings struct {
+	LeadDays     float64 // Flag stock that runs out within this many days
+	CoverDays    float64 // Reorder enough to last this many days beyond the lead time
+	LookbackDays float64 // History window for usage estimates
+}
+
+// DefaultForecastSettings are used for users without preferences.
+func DefaultForecastSettings() ForecastSettings {
+	return ForecastSettings{LeadDays: 3, CoverDays: 7, LookbackDays: 60}
+}
+
+// ForecastSettingsFor reads the user's reorder preferences.
+func ForecastSettingsFor(db *gorm.DB, userID uint) ForecastSettings {
+	settings := DefaultForecastSettings()
+	var prefs models.UserPreferences
+	if err := db.Where("user_id = ?", userID).First(&prefs).Error; err == nil {
+		if prefs.ReorderLeadDays > 0 {
+			settings.LeadDays = prefs.ReorderLeadDays
+		}
+		if prefs.ReorderCoverDays > 0 {
+			settings.CoverDays = prefs.ReorderCoverDays
+		}
+	}
+	return settings
+}
+
+// minUsageWindowDays keeps a burst of activity on a new item from looking
+// like a very high daily rate.
+const minUsageWindowDays = 7.0
+
+// StockForecast is the outlook for one pantry item. Quantities are in Unit.
+type StockForecast struct {
+	PantryItemID uint     `json:"pantry_item_id"`
+	IngredientID uint     `json:"ingredient_id"`
+	Ingredient   string   `json:"ingredient"`
+	ItemID       uint     `json:"item_id"`
+	ItemName     string   `json:"item_name"`
+	Unit         string   `json:"unit"`
+	Quantity     float64  `json:"quantity"`
+	DailyUsage   float64  `json:"daily_usage"`
+	UsageSource  string   `json:"usage_source,omitempty"`
+	DaysUntilOut *float64 `json:"days_until_out"` // Nil when usage is unknown
+	LeadDays     float64  `json:"lead_days"`
+	MinQuantity  *float64 `json:"min_quantity,omitempty"`
+	PackSize     float64  `json:"pack_size,omitempty"` // Typical purchase, from past orders
+	Low          bool     `json:"low"`
+	Reason       string   `json:"reason,omitempty"`
+
+	SuggestedQuantity float64 `json:"suggested_quantity,omitempty"`
+	SuggestedPacks    int     `json:"suggested_packs,omitempty"`
+}
+
+// ForecastPantry estimates daily usage for each of the user's pantry items
+// from meal consumption (the ledger) and purchase cadence (order history),
+// predicts when each runs out and suggests reorder quantities.
+func ForecastPantry(db *gorm.DB, userID uint, settings ForecastSettings, now time.Time) ([]StockForecast, error) {
+	var pantryItems []models.PantryItem
+	if err := db.Preload("Ingredient").Preload("Item").Where("user_id = ?", userID).Find(&pantryItems).Error; err != nil {
+		return nil, err
+	}
+
+	since := now.Add(-time.Duration(settings.LookbackDays * 24 * float64(time.Hour)))
+	mealUsage, err := mealUsageRates(db, userID, pantryItems, since, now)
+	if err != nil {
+		return nil, err
+	}
+	orderUsage, packSizes, err := orderUsageRates(db, userID, pantryItems, since)
+	if err != nil {
+		return nil, err
+	}
+
+	forecasts := make([]StockForecast, 0, len(pantryItems))
+	for i := range pantryItems {
+		pi := &pantryItems[i]
+		f := StockForecast{
+			PantryItemID: pi.ID,
+			IngredientID: pi.IngredientID,
+			Ingredient:   pi.Ingredient.Name,
+			ItemID:       pi.ItemID,
+			ItemName:     pi.Item.Name,
+			Unit:         PantryUnit(pi),
+			Quantity:     pi.EffectiveQuantity(),
+			LeadDays:     settings.LeadDays,
+			MinQuantity:  pi.MinQuantity,
+			PackSize:     packSizes[pi.ID],
+		}
+		if pi.ReorderLeadDays != nil {
+			f.LeadDays = *pi.ReorderLeadDays
+		}
+
+		// Take the higher estimate so we err on the side of not running out.
+		if rate := mealUsage[pi.ID]; rate > 0 {
+			f.DailyUsage, f.UsageSource = rate, UsageFromMeals
+		}
+		if rate := orderUsage[pi.ID]; rate > f.DailyUsage {
+			f.DailyUsage, f.UsageSource = rate, UsageFromOrders
+		}
+		if f.DailyUsage > 0 {
+			days := f.Quantity / f.DailyUsage
+			f.DaysUntilOut = &days
+		}
+
+		switch {
+		case f.Quantity <= 0:
+			f.Low, f.Reason = true, ReasonOutOfStock
+		case f.MinQuantity != nil && f.Quantity < *f.MinQuantity:
+			f.Low, f.Reason = true, ReasonBelowMinimum
+		case f.DaysUntilOut != nil && *f.DaysUntilOut <= f.LeadDays:
+			f.Low, f.Reason = true, ReasonRunsOutSoon
+		}
+		if f.Low {
+			suggestReorder(&f, settings)
+		}
+
+		forecasts = append(forecasts, f)
+	}
+
+	sort.SliceStable(forecasts, func(i, j int) bool {
+		a, b := forecasts[i].DaysUntilOut, forecasts[j].DaysUntilOut
+		if a == nil || b == nil {
+			return a != nil
+		}
+		return *a < *b
+	})
+	return forecasts, nil
+}
+
+// suggestReorder sizes a reorder to last through the lead and cover days,
+// rounded up to whole packs when a typical pack size is known.
+func suggestReorder(f *StockForecast, settings ForecastSettings) {
+	target := f.DailyUsage * (f.LeadDays + settings.CoverDays)
+	if f.MinQuantity != nil {
+		target += *f.MinQuantity
+	}
+	need := target - f.Quantity
+
+	if f.PackSize <= 0 {
+		if need > 0 {
+			f.SuggestedQuantity = need
+		}
+		return
+	}
+	packs := int(math.Ceil(need / f.PackSize))
+	if packs < 1 {
+		packs = 1
+	}
+	f.SuggestedPacks = packs
+	f.SuggestedQuantity = float64(packs) * f.PackSize
+}
+
+// mealUsageRates is the net amount meals took out of each pantry item per
+// day over the window.
+func mealUsageRates(db *gorm.DB, userID uint, pantryItems []models.PantryItem, since, now time.Time) (map[uint]float64, error) {
+	var movements []models.PantryMovement
+	err := db.Where("user_id = ? AND created_at >= ?", userID, since).Order("created_at").Find(&movements).Error
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[uint]*models.PantryItem, len(pantryItems))
+	for i := range pantryItems {
+		byID[pantryItems[i].ID] = &pantryItems[i]
+	}
+
+	consumed := make(map[uint]float64)
+	firstSeen := make(map[uint]time.Time)
+	for _, m := range movements {
+		pi, ok := byID[m.PantryItemID]
+		if !ok {
+			continue
+		}
+		if _, seen := firstSeen[pi.ID]; !seen {
+			firstSeen[pi.ID] = m.CreatedAt
+		}
+		if m.Source == models.MovementSourceMealLog {
+			consumed[pi.ID] -= toPantryUnitLoose(pi, m.Delta, m.Unit)
+		}
+	}
+
+	rates := make(map[uint]float64)
+	for id, total := range consumed {
+		if total <= 0 {
+			continue
+		}
+		days := math.Max(now.Sub(firstSeen[id]).Hours()/24, minUsageWindowDays)
+		rates[id] = total / days
+	}
+	return rates, nil
+}
+
+// orderUsageRates infers usage from how often an ingredient is bought: what
+// was bought before the latest order was used up over the time between the
+// first and latest order. It also returns the typical pack size per item.
+func orderUsageRates(db *gorm.DB, userID uint, pantryItems []models.PantryItem, since time.Time) (map[uint]float64, map[uint]float64, error) {
+	var rows []struct {
+		models.OrderItem
+		OrderDate time.Time
+	}
+	err := db.Table("order_items").
+		Select("order_items.*, orders.order_date").
+		Joins("JOIN orders ON orders.id = order_items.order_id AND orders.deleted_at IS NULL").
+		Where("orders.user_id = ? AND orders.order_date >= ?", userID, since).
+		Where("orders.status <> ? AND order_items.deleted_at IS NULL AND order_items.quantity > 0", models.OrderCancelled).
+		Order("orders.order_date").
+		Find(&rows).Error
+	if err != nil {
+		return nil, nil, err
+	}
+
+	itemIDs := make([]uint, 0, len(rows))
+	for _, r := range rows {
+		itemIDs = append(itemIDs, r.ItemID)
+	}
+	var items []models.Item
+	if len(itemIDs) > 0 {
+		db.Where("id IN ?", itemIDs).Find(&items)
+	}
+	itemByID := make(map[uint]models.Item, len(items))
+	for _, it := range items {
+		itemByID[it.ID] = it
+	}
+
+	byIngredient := make(map[uint]*models.PantryItem, len(pantryItems))
+	for i := range pantryItems {
+		byIngredient[pantryItems[i].IngredientID] = &pantryItems[i]
+	}
+
+	type purchase struct {
+		at       time.Time
+		quantity float64
+		pack     float64
+	}
+	purchases := make(map[uint][]purchase)
+	for _, r := range rows {
+		item, ok := itemByID[r.ItemID]
+		if !ok {
+			continue
+		}
+		pi, ok := byIngredient[item.IngredientID]
+		if !ok {
+			continue
+		}
+		p := purchase{at: r.OrderDate, quantity: toPantryUnitLoose(pi, r.Quantity, item.Unit)}
+		// Prefer the pack size printed in the product name ("Milk 500 ml").
+		if size, unit := units.PackSize(item.Name); unit != units.Piece || size != 1 {
+			if v, err := ToPantryUnit(pi, size, unit); err == nil {
+				p.pack = v
+			}
+		}
+		if p.pack <= 0 {
+			p.pack = p.quantity
+		}
+		purchases[pi.ID] = append(purchases[pi.ID], p)
+	}
+
+	rates := make(map[uint]float64)
+	packs := make(map[uint]float64)
+	for id, ps := range purchases {
+		sizes := make([]float64, len(ps))
+		for i, p := range ps {
+			sizes[i] = p.pack
+		}
+		packs[id] = median(sizes)
+
+		first, last := ps[0].at, ps[len(ps)-1].at
+		days := last.Sub(first).Hours() / 24
+		if days < 1 {
+			continue
+		}
+		total := 0.0
+		for _, p := range ps {
+			if p.at.Before(last) {
+				total += p.quantity
+			}
+		}
+		rates[id] = total / days
+	}
+	return rates, packs, nil
+}
+
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
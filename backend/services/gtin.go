@@ -0,0 +1,69 @@
+package services
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrInvalidGTIN is returned for a barcode that is not a GTIN or whose
+// check digit is wrong.
+var ErrInvalidGTIN = errors.New("invalid barcode")
+
+// NormalizeGTIN validates a barcode (EAN-8, UPC-A, EAN-13 or GTIN-14) and
+// returns it in the form items are stored under: spaces and dashes removed,
+// and UPC-A and GTIN-14 with a leading zero written as EAN-13, so the same
+// product scanned or printed either way matches.
+func NormalizeGTIN(code string) (string, error) {
+	code = strings.Map(func(r rune) rune {
+		if r == ' ' || r == '-' {
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(code))
+
+	switch len(code) {
+	case 8, 12, 13, 14:
+	default:
+		return "", ErrInvalidGTIN
+	}
+	for _, r := range code {
+		if r < '0' || r > '9' {
+			return "", ErrInvalidGTIN
+		}
+	}
+	if !validCheckDigit(code) {
+		return "", ErrInvalidGTIN
+	}
+
+	switch {
+	case len(code) == 12:
+		code = "0" + code
+	case len(code) == 14 && code[0] == '0':
+		code = code[1:]
+	}
+	return code, nil
+}
+
+// validCheckDigit checks the GS1 mod-10 check digit: from the right,
+// excluding the check digit, digits are weighted 3, 1, 3, ...
+func validCheckDigit(code string) bool {
+	sum := 0
+	for i := len(code) - 2; i >= 0; i-- {
+		d := int(code[i] - '0')
+		if (len(code)-2-i)%2 == 0 {
+			d *= 3
+		}
+		sum += d
+	}
+	return (10-sum%10)%10 == int(code[len(code)-1]-'0')
+}
+
+// gtinVariants are the forms a normalised GTIN may have been stored under
+// elsewhere, e.g. UPC-A as 12 digits in an Open Food Facts dump.
+func gtinVariants(gtin string) []string {
+	variants := []string{gtin}
+	if len(gtin) == 13 && gtin[0] == '0' {
+		variants = append(variants, gtin[1:])
+	}
+	return variants
+}
@@ -0,0 +1,78 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/pmitra96/pateproject/models"
+)
+
+func TestNormalizeGTIN(t *testing.T) {
+	tests := []struct {
+		name string
+		code string
+		want string
+		err  error
+	}{
+		{"EAN-8", "96385074", "96385074", nil},
+		{"EAN-13", "4006381333931", "4006381333931", nil},
+		{"UPC-A padded to EAN-13", "036000291452", "0036000291452", nil},
+		{"GTIN-14 with a leading zero", "00036000291452", "0036000291452", nil},
+		{"GTIN-14", "10036000291459", "10036000291459", nil},
+		{"spaces and dashes", " 4006-3813 33931 ", "4006381333931", nil},
+		{"bad check digit", "4006381333932", "", ErrInvalidGTIN},
+		{"bad UPC-A check digit", "036000291453", "", ErrInvalidGTIN},
+		{"non-digit", "40063813339A1", "", ErrInvalidGTIN},
+		{"wrong length", "4006381", "", ErrInvalidGTIN},
+		{"empty", "", "", ErrInvalidGTIN},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NormalizeGTIN(tt.code)
+			if got != tt.want || !errors.Is(err, tt.err) {
+				t.Errorf("NormalizeGTIN(%q) = %q, %v; want %q, %v", tt.code, got, err, tt.want, tt.err)
+			}
+		})
+	}
+}
+
+func TestGTINVariants(t *testing.T) {
+	if got := gtinVariants("0036000291452"); len(got) != 2 || got[1] != "036000291452" {
+		t.Errorf("variants of a padded UPC-A = %v, want the 12-digit form too", got)
+	}
+	if got := gtinVariants("4006381333931"); len(got) != 1 {
+		t.Errorf("variants of an EAN-13 = %v, want only itself", got)
+	}
+}
+
+func TestLookupItemByBarcodeIsReadOnly(t *testing.T) {
+	db := testDB(t, &models.IngredientCategory{}, &models.Ingredient{}, &models.Brand{},
+		&models.Item{}, &models.OpenFoodFactsProduct{})
+	product := models.OpenFoodFactsProduct{
+		Code:        "8909990000019",
+		ProductName: "Paneer Cubes",
+		Brands:      "Zorvik Dairy",
+		Quantity:    "200 g",
+		EnergyKcal:  265,
+	}
+	if err := db.Create(&product).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	item, err := LookupItemByBarcode(db, "8909990000019")
+	if err != nil {
+		t.Fatalf("LookupItemByBarcode: %v", err)
+	}
+	if item.ID != 0 || item.Name != "Zorvik Dairy Paneer Cubes" || item.Unit != "g" || item.GTIN != "8909990000019" {
+		t.Errorf("item = %+v, want an unsaved Zorvik Dairy Paneer Cubes in g", item)
+	}
+	var count int64
+	db.Model(&models.Item{}).Where("gtin = ?", "8909990000019").Count(&count)
+	if count != 0 {
+		t.Errorf("lookup saved %d items", count)
+	}
+
+	if _, err := LookupItemByBarcode(db, "4006381333931"); !errors.Is(err, ErrUnknownBarcode) {
+		t.Errorf("unknown barcode: got %v, want %v", err, ErrUnknownBarcode)
+	}
+}
@@ -0,0 +1,535 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pmitra96/pateproject/config"
+	"github.com/pmitra96/pateproject/llm"
+	"github.com/pmitra96/pateproject/logger"
+	"github.com/pmitra96/pateproject/models"
+	"github.com/pmitra96/pateproject/units"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrReviewClosed is returned when a review that was already approved or
+// rejected is changed again.
+var ErrReviewClosed = errors.New("review is no longer pending")
+
+// defaultReviewThreshold keeps heuristic splits (at most 0.7) out of the
+// catalog unless both a brand and an ingredient were recognised.
+const defaultReviewThreshold = 0.7
+
+// ReviewThreshold is the lowest extraction confidence added to the catalog
+// without review (INGEST_REVIEW_THRESHOLD).
+func ReviewThreshold() float64 {
+	threshold, err := strconv.ParseFloat(config.GetEnv("INGEST_REVIEW_THRESHOLD", ""), 64)
+	if err != nil || threshold < 0 || threshold > 1 {
+		return defaultReviewThreshold
+	}
+	return threshold
+}
+
+// OrderLine is one product on an ingested order.
+type OrderLine struct {
+	RawName  string  `json:"raw_name"`
+	Quantity float64 `json:"quantity"` // In Unit; normalised to g, ml or pcs on ingest
+	Unit     string  `json:"unit"`
+	GTIN     string  `json:"gtin,omitempty"` // Barcode, when the source has it
+	models.LinePrice
+}
+
+// OrderPayload is an order as submitted to POST /ingest/order.
+type OrderPayload struct {
+	ExternalOrderID string      `json:"external_order_id"`
+	EmailMessageID  string      `json:"email_message_id"`
+	InvoiceNumber   string      `json:"invoice_number"`
+	Provider        string      `json:"provider"`
+	Seller          string      `json:"seller"`
+	AddressHash     string      `json:"address_hash"`
+	OrderDate       time.Time   `json:"order_date"`
+	Items           []OrderLine `json:"items"`
+	models.OrderCharges
+}
+
+// HasIdentifier reports whether the payload carries an ID to deduplicate on.
+func (p OrderPayload) HasIdentifier() bool {
+	return p.ExternalOrderID != "" || p.EmailMessageID != "" || p.InvoiceNumber != ""
+}
+
+// sameOrder narrows a query on orders or ingestion jobs to the payload's
+// order: the same external order ID or invoice number, or else the same
+// email message ID.
+func sameOrder(query *gorm.DB, payload OrderPayload) *gorm.DB {
+	switch {
+	case payload.ExternalOrderID != "" && payload.InvoiceNumber != "":
+		return query.Where("(external_order_id = ? OR invoice_number = ?)", payload.ExternalOrderID, payload.InvoiceNumber)
+	case payload.ExternalOrderID != "":
+		return query.Where("external_order_id = ?", payload.ExternalOrderID)
+	case payload.InvoiceNumber != "":
+		return query.Where("invoice_number = ?", payload.InvoiceNumber)
+	}
+	return query.Where("email_message_id = ?", payload.EmailMessageID)
+}
+
+// FindIngestedOrder returns the user's order with the payload's external
+// order ID, invoice number or email message ID, if it was already ingested.
+func FindIngestedOrder(db *gorm.DB, userID uint, payload OrderPayload) (*models.Order, error) {
+	query := sameOrder(db.Where("user_id = ? AND provider = ?", userID, payload.Provider), payload)
+
+	var order models.Order
+	err := query.First(&order).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
+// FindPendingIngestion returns the user's queued or running ingestion job
+// for the payload's order, if there is one.
+func FindPendingIngestion(db *gorm.DB, userID uint, payload OrderPayload) (*models.IngestionJob, error) {
+	query := db.Where("user_id = ? AND provider = ? AND status IN ?", userID, payload.Provider, []string{models.JobQueued, models.JobRunning})
+
+	var job models.IngestionJob
+	err := sameOrder(query, payload).First(&job).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// ExtractOrderItems splits the product names the catalog has not seen into
+// ingredient, brand and product. It only reads from the database, so it can
+// run outside a transaction while the LLM is slow.
+func ExtractOrderItems(db *gorm.DB, client *llm.Client, lines []OrderLine) map[string]llm.PantryItemExtraction {
+	var rawNames []string
+	seen := make(map[string]bool)
+	for _, line := range lines {
+		// Products the catalog knows by barcode need no extraction
+		if _, ok := FindItemByGTIN(db, line.GTIN); ok {
+			continue
+		}
+		if !seen[line.RawName] {
+			seen[line.RawName] = true
+			rawNames = append(rawNames, line.RawName)
+		}
+	}
+
+	var known []string
+	db.Model(&models.Item{}).Where("name IN ?", rawNames).Pluck("name", &known)
+	for _, name := range known {
+		delete(seen, name)
+	}
+	var missing []string
+	for _, name := range rawNames {
+		if seen[name] {
+			missing = append(missing, name)
+		}
+	}
+
+	extractions := make(map[string]llm.PantryItemExtraction, len(missing))
+	if len(missing) == 0 {
+		return extractions
+	}
+
+	logger.Info("Performing batch LLM extraction", "count", len(missing))
+	batch, err := client.ExtractPantryItemsBatch(missing, LeafCategoryNames(db))
+	if err != nil || len(batch) != len(missing) {
+		logger.Warn("Batch LLM extraction failed or returned mismatched count, using heuristics", "error", err)
+		batch = make([]llm.PantryItemExtraction, len(missing))
+		brands := KnownBrands(db)
+		for i, name := range missing {
+			batch[i] = *client.ExtractHeuristic(name, brands)
+		}
+	}
+	for i, name := range missing {
+		ext := batch[i]
+		ApplyCatalogAliases(db, name, &ext)
+		extractions[name] = ext
+	}
+	return extractions
+}
+
+// RecordOrder creates the order and adds its lines to the catalog and the
+// pantry. Lines whose extraction is below the review threshold are queued
+// for review instead. Names missing from extractions (added to the catalog
+// since they were extracted) are looked up again.
+func RecordOrder(tx *gorm.DB, userID uint, payload OrderPayload, extractions map[string]llm.PantryItemExtraction) (models.Order, int, error) {
+	order := models.Order{
+		UserID:          userID,
+		ExternalOrderID: payload.ExternalOrderID,
+		EmailMessageID:  payload.EmailMessageID,
+		InvoiceNumber:   payload.InvoiceNumber,
+		Provider:        payload.Provider,
+		Seller:          payload.Seller,
+		AddressHash:     payload.AddressHash,
+		OrderDate:       payload.OrderDate,
+		Status:          models.OrderProcessed,
+		OrderCharges:    payload.OrderCharges,
+	}
+	if err := tx.Create(&order).Error; err != nil {
+		return order, 0, err
+	}
+
+	threshold := ReviewThreshold()
+	itemMap := make(map[string]models.Item)
+	pendingReview := 0
+	for _, line := range payload.Items {
+		item, ok := itemMap[line.RawName]
+		if !ok {
+			// A barcode identifies the product whatever the line calls it
+			var err error
+			if found, byBarcode := FindItemByGTIN(tx, line.GTIN); byBarcode {
+				item = *found
+			} else {
+				err = tx.Preload("Ingredient").Where("name = ?", line.RawName).First(&item).Error
+			}
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				ext, extracted := extractions[line.RawName]
+				if !extracted {
+					ext = *llm.NewClient().ExtractHeuristic(line.RawName, KnownBrands(tx))
+					ApplyCatalogAliases(tx, line.RawName, &ext)
+				}
+				if ext.Confidence < threshold {
+					if err := QueueReview(tx, userID, order.ID, line, ext, models.ReviewReasonLowConfidence); err != nil {
+						return order, 0, err
+					}
+					pendingReview++
+					continue
+				}
+				item, err = CreateCatalogItem(tx, line.RawName, line.Unit, ext)
+			}
+			if err != nil {
+				return order, 0, err
+			}
+			if err := SetItemGTIN(tx, &item, line.GTIN); err != nil {
+				return order, 0, err
+			}
+			itemMap[line.RawName] = item
+		}
+
+		// A savepoint, so a line whose units don't fit the pantry can be
+		// held back for review without losing the rest of the order
+		err := tx.Transaction(func(tx *gorm.DB) error {
+			return RecordPurchase(tx, userID, order, item, line)
+		})
+		if errors.Is(err, ErrUnitMismatch) {
+			logger.Warn("Order line units do not fit the pantry, queued for review", "raw_name", line.RawName, "error", err)
+			if err := queueUnitReview(tx, userID, order.ID, line, item); err != nil {
+				return order, 0, err
+			}
+			pendingReview++
+			continue
+		}
+		if err != nil {
+			return order, 0, err
+		}
+	}
+
+	if pendingReview > 0 {
+		order.Status = models.OrderNeedsReview
+		if err := tx.Model(&order).Update("status", order.Status).Error; err != nil {
+			return order, 0, err
+		}
+		logger.Info("Low-confidence extractions queued for review", "order_id", order.ID, "count", pendingReview)
+	}
+	return order, pendingReview, nil
+}
+
+// ApplyCatalogAliases marks an extraction confirmed when its ingredient is a
+// known alias, i.e. a person has already mapped that name.
+func ApplyCatalogAliases(db *gorm.DB, rawName string, ext *llm.PantryItemExtraction) {
+	for _, name := range []string{ext.Ingredient, rawName} {
+		if ingredient, ok := LookupIngredientAlias(db, name); ok {
+			ext.Ingredient = ingredient.Name
+			ext.Source = llm.SourceAlias
+			ext.Confidence = 1
+			return
+		}
+	}
+}
+
+// CreateCatalogItem adds the ingredient, brand and item for a product name
+// to the global catalog.
+func CreateCatalogItem(tx *gorm.DB, rawName, unit string, ext llm.PantryItemExtraction) (models.Item, error) {
+	ingredient, err := ResolveIngredient(tx, ext.Ingredient)
+	if err != nil {
+		return models.Item{}, err
+	}
+	if ingredient.CategoryID == nil {
+		if err := AssignCategory(tx, &ingredient, ext.Category); err != nil {
+			logger.Warn("Failed to categorise ingredient", "ingredient", ingredient.Name, "error", err)
+		}
+	}
+
+	var brandID *uint
+	if ext.Brand != nil && *ext.Brand != "" {
+		brand, err := ResolveBrand(tx, *ext.Brand)
+		if err != nil {
+			return models.Item{}, err
+		}
+		brandID = &brand.ID
+	}
+
+	productName := ""
+	if ext.Product != nil {
+		productName = *ext.Product
+	}
+
+	item := models.Item{
+		Name:         rawName,
+		IngredientID: ingredient.ID,
+		BrandID:      brandID,
+		ProductName:  productName,
+		Unit:         units.NormalizeUnit(unit),
+	}
+	if err := tx.Create(&item).Error; err != nil {
+		return models.Item{}, err
+	}
+	item.Ingredient = ingredient
+	return item, nil
+}
+
+// RecordPurchase adds an order line to the order and the user's pantry.
+// Quantities are normalised to g, ml or pcs and converted to the item's unit;
+// a line that can't be converted fails with ErrUnitMismatch.
+func RecordPurchase(tx *gorm.DB, userID uint, order models.Order, item models.Item, line OrderLine) error {
+	rawName := line.RawName
+	quantity, unit := units.Normalize(line.Quantity, line.Unit)
+	if unit != item.Unit {
+		// The item was first seen with a different unit (e.g. eggs by
+		// count, now by weight); record the quantity in the item's unit.
+		converted, err := units.Convert(quantity, unit, item.Unit, item.Ingredient.Name)
+		if err != nil {
+			return fmt.Errorf("%w: %s to %s for %s: %v", ErrUnitMismatch, unit, item.Unit, rawName, err)
+		}
+		quantity = converted
+	}
+
+	orderItem := models.OrderItem{
+		OrderID:   order.ID,
+		ItemID:    item.ID,
+		RawName:   rawName,
+		Quantity:  quantity,
+		LinePrice: line.LinePrice,
+	}
+	if err := tx.Create(&orderItem).Error; err != nil {
+		return err
+	}
+
+	pantryItem, err := PantryItemFor(tx, userID, item)
+	if err != nil {
+		return err
+	}
+
+	// Moves the pantry item to the most recent specific item (brand/product)
+	return AddPurchase(tx, pantryItem, item, quantity, orderItem.ID, order.OrderDate)
+}
+
+// PantryItemFor returns the user's pantry item for item's ingredient, which
+// pantry state is aggregated by, creating it if needed or restoring one the
+// user removed. Item.Ingredient must be preloaded.
+func PantryItemFor(tx *gorm.DB, userID uint, item models.Item) (*models.PantryItem, error) {
+	var pantryItem models.PantryItem
+	err := tx.Unscoped().Preload("Item").Preload("Ingredient").Where("user_id = ? AND ingredient_id = ?", userID, item.IngredientID).First(&pantryItem).Error
+	if err == nil && pantryItem.DeletedAt.Valid {
+		if err := tx.Unscoped().Model(&pantryItem).Update("deleted_at", nil).Error; err != nil {
+			return nil, err
+		}
+		pantryItem.DeletedAt = gorm.DeletedAt{}
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		pantryItem = models.PantryItem{
+			UserID:       userID,
+			IngredientID: item.IngredientID,
+			ItemID:       item.ID,
+		}
+		if err := tx.Create(&pantryItem).Error; err != nil {
+			return nil, err
+		}
+		pantryItem.Item = item
+		pantryItem.Ingredient = item.Ingredient
+	} else if err != nil {
+		return nil, err
+	}
+	return &pantryItem, nil
+}
+
+// QueueReview holds back an order line for review, usually because its
+// extraction is not confident enough for the catalog; reason is one of the
+// ReviewReason constants.
+func QueueReview(tx *gorm.DB, userID, orderID uint, line OrderLine, ext llm.PantryItemExtraction, reason string) error {
+	review := models.ExtractionReview{
+		UserID:              userID,
+		OrderID:             orderID,
+		RawName:             line.RawName,
+		Quantity:            line.Quantity,
+		Unit:                line.Unit,
+		GTIN:                line.GTIN,
+		LinePrice:           line.LinePrice,
+		Ingredient:          ext.Ingredient,
+		Category:            ext.Category,
+		ExtractedIngredient: ext.Ingredient,
+		Confidence:          ext.Confidence,
+		Source:              ext.Source,
+		Status:              models.ReviewPending,
+		Reason:              reason,
+	}
+	if ext.Brand != nil {
+		review.Brand = *ext.Brand
+		review.ExtractedBrand = *ext.Brand
+	}
+	if ext.Product != nil {
+		review.Product = *ext.Product
+	}
+	return tx.Create(&review).Error
+}
+
+// queueUnitReview holds back an order line for a known item whose quantity
+// can't be converted to the item's or the pantry's unit, so the user can
+// correct the quantity and unit or reject the line.
+func queueUnitReview(tx *gorm.DB, userID, orderID uint, line OrderLine, item models.Item) error {
+	ext := llm.PantryItemExtraction{Ingredient: item.Ingredient.Name, Confidence: 1}
+	if item.ProductName != "" {
+		ext.Product = &item.ProductName
+	}
+	return QueueReview(tx, userID, orderID, line, ext, models.ReviewReasonUnitMismatch)
+}
+
+// ApproveReview adds a reviewed order line to the catalog and the pantry.
+// If the reviewer changed the ingredient or brand, the extracted names are
+// remembered as aliases of the corrected ones so later orders map directly.
+func ApproveReview(db *gorm.DB, review *models.ExtractionReview) (models.Item, error) {
+	if review.Status != models.ReviewPending {
+		return models.Item{}, ErrReviewClosed
+	}
+
+	var item models.Item
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var order models.Order
+		if err := tx.First(&order, review.OrderID).Error; err != nil {
+			return err
+		}
+
+		// Another order may already have added this product.
+		var err error
+		if found, byBarcode := FindItemByGTIN(tx, review.GTIN); byBarcode {
+			item = *found
+		} else {
+			err = tx.Preload("Ingredient").Where("name = ?", review.RawName).First(&item).Error
+		}
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			ext := llm.PantryItemExtraction{
+				Ingredient: review.Ingredient,
+				Category:   review.Category,
+			}
+			if review.Brand != "" {
+				ext.Brand = &review.Brand
+			}
+			if review.Product != "" {
+				ext.Product = &review.Product
+			}
+			item, err = CreateCatalogItem(tx, review.RawName, review.Unit, ext)
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := SetItemGTIN(tx, &item, review.GTIN); err != nil {
+			return err
+		}
+
+		line := OrderLine{RawName: review.RawName, Quantity: review.Quantity, Unit: review.Unit, GTIN: review.GTIN, LinePrice: review.LinePrice}
+		if err := RecordPurchase(tx, review.UserID, order, item, line); err != nil {
+			return err
+		}
+		if err := rememberCorrections(tx, review, item); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		review.Status = models.ReviewApproved
+		review.ItemID = &item.ID
+		review.ReviewedAt = &now
+		if err := tx.Save(review).Error; err != nil {
+			return err
+		}
+		return SyncOrderReviewStatus(tx, order.ID)
+	})
+	return item, err
+}
+
+// RejectReview drops an order line; nothing is added to the catalog.
+func RejectReview(db *gorm.DB, review *models.ExtractionReview) error {
+	if review.Status != models.ReviewPending {
+		return ErrReviewClosed
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		review.Status = models.ReviewRejected
+		review.ReviewedAt = &now
+		if err := tx.Save(review).Error; err != nil {
+			return err
+		}
+		return SyncOrderReviewStatus(tx, review.OrderID)
+	})
+}
+
+// SyncOrderReviewStatus marks an order as needing review while any of its
+// lines are pending, and processed once none are.
+func SyncOrderReviewStatus(tx *gorm.DB, orderID uint) error {
+	var pending int64
+	if err := tx.Model(&models.ExtractionReview{}).
+		Where("order_id = ? AND status = ?", orderID, models.ReviewPending).
+		Count(&pending).Error; err != nil {
+		return err
+	}
+	status := models.OrderProcessed
+	if pending > 0 {
+		status = models.OrderNeedsReview
+	}
+	// Amended and cancelled orders keep their status
+	return tx.Model(&models.Order{}).
+		Where("id = ? AND status IN ?", orderID, []string{models.OrderProcessed, models.OrderNeedsReview}).
+		Update("status", status).Error
+}
+
+// rememberCorrections aliases the extracted names to the approved ingredient
+// and brand. Names that are catalog entries of their own are left alone so
+// correcting "butter" to "peanut butter" once does not remap all butter.
+func rememberCorrections(tx *gorm.DB, review *models.ExtractionReview, item models.Item) error {
+	extracted := strings.ToLower(strings.TrimSpace(review.ExtractedIngredient))
+	if extracted != "" && !strings.EqualFold(extracted, strings.TrimSpace(review.Ingredient)) {
+		var existing int64
+		tx.Model(&models.Ingredient{}).Where("LOWER(name) = ?", extracted).Count(&existing)
+		if existing == 0 {
+			alias := models.IngredientAlias{Alias: extracted, IngredientID: item.IngredientID}
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&alias).Error; err != nil {
+				return err
+			}
+		}
+	}
+
+	extracted = strings.ToLower(strings.TrimSpace(review.ExtractedBrand))
+	if extracted != "" && item.BrandID != nil && !strings.EqualFold(extracted, strings.TrimSpace(review.Brand)) {
+		var existing int64
+		tx.Model(&models.Brand{}).Where("LOWER(name) = ?", extracted).Count(&existing)
+		if existing == 0 {
+			alias := models.BrandAlias{Alias: extracted, BrandID: *item.BrandID}
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&alias).Error; err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
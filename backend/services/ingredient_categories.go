@@ -0,0 +1,490 @@
+package services
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pmitra96/pateproject/logger"
+	"github.com/pmitra96/pateproject/models"
+	"gorm.io/gorm"
+)
+
+// ErrUnknownCategory is returned when a category reference matches nothing.
+var ErrUnknownCategory = errors.New("unknown category")
+
+// defaultCategories uses the markdown layout of all_categories.md, which
+// the extractor's seed_categories.py reads: "# 1. Group", "## 1.1 Category"
+// and "- Leaf" lines.
+const defaultCategories = `
+# 1. Fresh Produce
+## 1.1 Fresh Vegetables
+- Leafy Greens
+- Root Vegetables
+- Onions & Aromatics
+- Everyday Vegetables
+- Exotic Vegetables
+## 1.2 Fresh Fruits
+- Everyday Fruits
+- Seasonal Fruits
+## 1.3 Herbs
+- Fresh Herbs
+# 2. Dairy, Bread & Eggs
+## 2.1 Dairy
+- Milk
+- Curd & Yogurt
+- Paneer & Tofu
+- Butter & Ghee
+- Cheese
+- Cream
+## 2.2 Eggs
+- Farm Eggs
+## 2.3 Bakery
+- Bread & Buns
+# 3. Staples
+## 3.1 Atta, Rice & Grains
+- Atta & Flours
+- Rice
+- Millets & Oats
+- Poha & Sooji
+## 3.2 Pulses
+- Dals & Lentils
+- Whole Pulses
+## 3.3 Oils & Spices
+- Cooking Oils
+- Whole Spices
+- Powdered Spices
+- Salt & Sugar
+# 4. Meat & Seafood
+## 4.1 Meat
+- Chicken
+- Mutton
+## 4.2 Seafood
+- Fish & Prawns
+# 5. Packaged Foods
+## 5.1 Dry Fruits & Nuts
+- Nuts & Seeds
+- Dry Fruits
+## 5.2 Ready to Cook
+- Breakfast Cereals
+- Noodles & Pasta
+- Sauces & Spreads
+- Biscuits & Snacks
+# 6. Beverages
+## 6.1 Drinks
+- Tea & Coffee
+- Juices
+`
+
+// categoryKeywords classify ingredients into leaf categories by the words of
+// their normalised name. Earlier rules win, so "coriander powder" is a
+// powdered spice rather than a herb.
+var categoryKeywords = []struct {
+	leaf     string
+	keywords []string
+}{
+	{"Powdered Spices", []string{"powder", "masala", "turmeric"}},
+	{"Sauces & Spreads", []string{"sauce", "ketchup", "jam", "spread", "mayonnaise", "pickle", "chutney"}},
+	{"Juices", []string{"juice"}},
+	{"Biscuits & Snacks", []string{"biscuit", "cookie", "chip", "namkeen", "bhujia"}},
+	{"Breakfast Cereals", []string{"cornflake", "muesli", "granola", "cereal"}},
+	{"Noodles & Pasta", []string{"noodle", "pasta", "macaroni", "spaghetti", "vermicelli"}},
+	{"Curd & Yogurt", []string{"yogurt", "raita"}},
+	{"Paneer & Tofu", []string{"paneer", "tofu"}},
+	{"Butter & Ghee", []string{"butter", "ghee"}},
+	{"Cheese", []string{"cheese", "mozzarella", "cheddar"}},
+	{"Cream", []string{"cream"}},
+	{"Milk", []string{"milk", "buttermilk", "lassi"}},
+	{"Farm Eggs", []string{"egg"}},
+	{"Bread & Buns", []string{"bread", "bun", "pav", "loaf"}},
+	{"Chicken", []string{"chicken"}},
+	{"Mutton", []string{"mutton", "lamb", "keema", "goat"}},
+	{"Fish & Prawns", []string{"fish", "prawn", "shrimp", "salmon", "rohu"}},
+	{"Atta & Flours", []string{"atta", "flour", "maida", "besan"}},
+	{"Rice", []string{"rice", "basmati"}},
+	{"Millets & Oats", []string{"oat", "millet", "ragi", "jowar", "bajra", "quinoa"}},
+	{"Poha & Sooji", []string{"poha", "sooji"}},
+	{"Dals & Lentils", []string{"dal", "dhal", "lentil", "moong", "masoor", "toor", "urad"}},
+	{"Whole Pulses", []string{"chana", "chickpea", "rajma", "lobia", "bean"}},
+	{"Cooking Oils", []string{"oil"}},
+	{"Whole Spices", []string{"cumin", "mustard", "clove", "cardamom", "cinnamon", "fenugreek", "peppercorn"}},
+	{"Salt & Sugar", []string{"salt", "sugar", "jaggery", "honey"}},
+	{"Nuts & Seeds", []string{"peanut", "almond", "cashew", "walnut", "pistachio", "seed"}},
+	{"Dry Fruits", []string{"raisin", "date", "fig", "apricot"}},
+	{"Tea & Coffee", []string{"tea", "coffee"}},
+	{"Leafy Greens", []string{"spinach", "lettuce", "kale", "amaranth"}},
+	{"Fresh Herbs", []string{"coriander", "mint", "pudina", "basil", "curry"}},
+	{"Onions & Aromatics", []string{"onion", "garlic", "ginger", "shallot"}},
+	{"Root Vegetables", []string{"potato", "carrot", "beetroot", "radish", "yam"}},
+	{"Exotic Vegetables", []string{"broccoli", "zucchini", "mushroom", "avocado", "asparagus", "celery"}},
+	{"Everyday Vegetables", []string{"tomato", "capsicum", "cucumber", "cauliflower", "cabbage", "okra", "eggplant", "pea", "gourd", "pumpkin", "chilli", "chili"}},
+	{"Seasonal Fruits", []string{"mango", "strawberry", "berry", "watermelon", "guava", "litchi"}},
+	{"Everyday Fruits", []string{"banana", "apple", "orange", "papaya", "grape", "pomegranate", "lemon", "lime"}},
+}
+
+// CategorySeed is one parsed line of the category markdown.
+type CategorySeed struct {
+	Name   string
+	Parent string
+	IsLeaf bool
+}
+
+var (
+	categoryGroupRegex = regexp.MustCompile(`^#\s+\d+\.\s+(.+)`)
+	categoryRegex      = regexp.MustCompile(`^##\s+[\d.]+\s+(.+)`)
+	categoryLeafRegex  = regexp.MustCompile(`^-\s+(.+)`)
+)
+
+// ParseCategoryMarkdown reads the category tree in the same way as the
+// extractor's seed_categories.py.
+func ParseCategoryMarkdown(text string) []CategorySeed {
+	var seeds []CategorySeed
+	var group, category string
+
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if m := categoryGroupRegex.FindStringSubmatch(line); m != nil {
+			group, category = strings.TrimSpace(m[1]), ""
+			seeds = append(seeds, CategorySeed{Name: group})
+			continue
+		}
+		if m := categoryRegex.FindStringSubmatch(line); m != nil {
+			category = strings.TrimSpace(m[1])
+			seeds = append(seeds, CategorySeed{Name: category, Parent: group})
+			continue
+		}
+		if m := categoryLeafRegex.FindStringSubmatch(line); m != nil {
+			parent := category
+			if parent == "" {
+				parent = group
+			}
+			if parent != "" {
+				seeds = append(seeds, CategorySeed{Name: strings.TrimSpace(m[1]), Parent: parent, IsLeaf: true})
+			}
+		}
+	}
+	return seeds
+}
+
+// SeedCategories inserts categories that don't exist yet, keyed by name.
+// Parents must come before their children, as they do in the markdown.
+func SeedCategories(db *gorm.DB, seeds []CategorySeed) (int, error) {
+	var existing []models.IngredientCategory
+	if err := db.Find(&existing).Error; err != nil {
+		return 0, err
+	}
+	byName := make(map[string]models.IngredientCategory, len(existing))
+	slugs := make(map[string]bool, len(existing))
+	for _, c := range existing {
+		byName[c.Name] = c
+		slugs[c.Slug] = true
+	}
+
+	count := 0
+	for _, seed := range seeds {
+		slug := categorySlug(seed.Name)
+		if _, ok := byName[seed.Name]; ok || slugs[slug] {
+			continue
+		}
+		category := models.IngredientCategory{Name: seed.Name, Slug: slug, IsLeaf: seed.IsLeaf}
+		if parent, ok := byName[seed.Parent]; ok {
+			category.ParentID = &parent.ID
+		}
+		if err := db.Create(&category).Error; err != nil {
+			return count, err
+		}
+		byName[seed.Name] = category
+		slugs[slug] = true
+		count++
+	}
+	return count, nil
+}
+
+// SeedDefaultCategories seeds the built-in tree, then the file named by
+// path (the extractor's all_categories.md) if one is given.
+func SeedDefaultCategories(db *gorm.DB, path string) {
+	seeds := ParseCategoryMarkdown(defaultCategories)
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			logger.Warn("Cannot read category file, using built-in categories", "path", path, "error", err)
+		} else {
+			seeds = append(seeds, ParseCategoryMarkdown(string(data))...)
+		}
+	}
+
+	count, err := SeedCategories(db, seeds)
+	if err != nil {
+		logger.Error("Failed to seed ingredient categories", "error", err)
+		return
+	}
+	if count > 0 {
+		logger.Info("Seeded ingredient categories", "count", count)
+	}
+}
+
+// categorySlug turns "Dairy, Bread & Eggs" into "dairy-bread-eggs".
+func categorySlug(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	return strings.TrimSuffix(b.String(), "-")
+}
+
+// ClassifyIngredient guesses the leaf category of an ingredient from its
+// name, or "" when no rule applies.
+func ClassifyIngredient(name string) string {
+	words := strings.Fields(NormalizeIngredient(name))
+	for _, rule := range categoryKeywords {
+		for _, kw := range rule.keywords {
+			for _, w := range words {
+				if w == kw {
+					return rule.leaf
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// FindCategory resolves a category by ID, slug or name.
+func FindCategory(db *gorm.DB, ref string) (*models.IngredientCategory, error) {
+	ref = strings.TrimSpace(ref)
+	var category models.IngredientCategory
+	query := db.Where("slug = ? OR LOWER(name) = ?", categorySlug(ref), strings.ToLower(ref))
+	if id, err := strconv.ParseUint(ref, 10, 32); err == nil {
+		query = db.Where("id = ?", id)
+	}
+	if err := query.First(&category).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUnknownCategory
+		}
+		return nil, err
+	}
+	return &category, nil
+}
+
+// AssignCategory sets an ingredient's category from a suggestion (usually
+// the LLM's), falling back to the keyword rules. Manually assigned
+// categories are left alone.
+func AssignCategory(db *gorm.DB, ingredient *models.Ingredient, suggested string) error {
+	if ingredient.CategorySource == models.CategorySourceManual {
+		return nil
+	}
+
+	source := models.CategorySourceLLM
+	var category *models.IngredientCategory
+	err := ErrUnknownCategory
+	if suggested != "" {
+		category, err = FindCategory(db, suggested)
+	}
+	if errors.Is(err, ErrUnknownCategory) {
+		if leaf := ClassifyIngredient(ingredient.Name); leaf != "" {
+			source = models.CategorySourceHeuristic
+			category, err = FindCategory(db, leaf)
+		}
+	}
+	if errors.Is(err, ErrUnknownCategory) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// Don't let a heuristic guess replace an earlier LLM assignment.
+	if ingredient.CategoryID != nil && source == models.CategorySourceHeuristic && ingredient.CategorySource == models.CategorySourceLLM {
+		return nil
+	}
+
+	ingredient.CategoryID, ingredient.CategorySource = &category.ID, source
+	ingredient.Category = category
+	return db.Model(ingredient).Updates(map[string]interface{}{"category_id": category.ID, "category_source": source}).Error
+}
+
+// SetCategory is a user's correction of an ingredient's category.
+func SetCategory(db *gorm.DB, ingredient *models.Ingredient, category *models.IngredientCategory) error {
+	ingredient.CategoryID, ingredient.Category = &category.ID, category
+	ingredient.CategorySource = models.CategorySourceManual
+	return db.Model(ingredient).Updates(map[string]interface{}{"category_id": category.ID, "category_source": models.CategorySourceManual}).Error
+}
+
+// LeafCategoryNames lists the categories ingredients are assigned to, for
+// prompting the LLM.
+func LeafCategoryNames(db *gorm.DB) []string {
+	var names []string
+	db.Model(&models.IngredientCategory{}).Where("is_leaf = ?", true).Order("name").Pluck("name", &names)
+	return names
+}
+
+// CategoryIDsWithin returns the category and all its descendants.
+func CategoryIDsWithin(db *gorm.DB, rootID uint) ([]uint, error) {
+	var all []models.IngredientCategory
+	if err := db.Find(&all).Error; err != nil {
+		return nil, err
+	}
+	children := make(map[uint][]uint)
+	for _, c := range all {
+		if c.ParentID != nil {
+			children[*c.ParentID] = append(children[*c.ParentID], c.ID)
+		}
+	}
+
+	ids := []uint{rootID}
+	for i := 0; i < len(ids); i++ {
+		ids = append(ids, children[ids[i]]...)
+	}
+	return ids, nil
+}
+
+// IngredientIDsInCategory returns the ingredients filed anywhere under a
+// category reference (ID, slug or name).
+func IngredientIDsInCategory(db *gorm.DB, ref string) ([]uint, error) {
+	category, err := FindCategory(db, ref)
+	if err != nil {
+		return nil, err
+	}
+	categoryIDs, err := CategoryIDsWithin(db, category.ID)
+	if err != nil {
+		return nil, err
+	}
+	var ids []uint
+	err = db.Model(&models.Ingredient{}).Where("category_id IN ?", categoryIDs).Pluck("id", &ids).Error
+	return ids, err
+}
+
+// CategoryNode is a category with its subcategories.
+type CategoryNode struct {
+	models.IngredientCategory
+	Children []CategoryNode `json:"children,omitempty"`
+}
+
+// CategoryTree returns the whole taxonomy, roots first, sorted by name.
+func CategoryTree(db *gorm.DB) ([]CategoryNode, error) {
+	var all []models.IngredientCategory
+	if err := db.Order("id").Find(&all).Error; err != nil {
+		return nil, err
+	}
+	children := make(map[uint][]models.IngredientCategory)
+	var roots []models.IngredientCategory
+	for _, c := range all {
+		if c.ParentID == nil {
+			roots = append(roots, c)
+		} else {
+			children[*c.ParentID] = append(children[*c.ParentID], c)
+		}
+	}
+
+	var build func([]models.IngredientCategory) []CategoryNode
+	build = func(cs []models.IngredientCategory) []CategoryNode {
+		nodes := make([]CategoryNode, len(cs))
+		for i, c := range cs {
+			nodes[i] = CategoryNode{IngredientCategory: c, Children: build(children[c.ID])}
+		}
+		return nodes
+	}
+	return build(roots), nil
+}
+
+// RootCategories maps every category to the top-level group it sits under.
+func RootCategories(db *gorm.DB) (map[uint]models.IngredientCategory, error) {
+	var all []models.IngredientCategory
+	if err := db.Find(&all).Error; err != nil {
+		return nil, err
+	}
+	byID := make(map[uint]models.IngredientCategory, len(all))
+	for _, c := range all {
+		byID[c.ID] = c
+	}
+
+	roots := make(map[uint]models.IngredientCategory, len(all))
+	for _, c := range all {
+		root := c
+		for depth := 0; root.ParentID != nil && depth < len(all); depth++ {
+			parent, ok := byID[*root.ParentID]
+			if !ok {
+				break
+			}
+			root = parent
+		}
+		roots[c.ID] = root
+	}
+	return roots, nil
+}
+
+// CategoryStock summarises low stock across one top-level category.
+type CategoryStock struct {
+	CategoryID uint     `json:"category_id"`
+	Category   string   `json:"category"`
+	Slug       string   `json:"slug"`
+	Total      int      `json:"total"`
+	Low        int      `json:"low"`
+	LowItems   []string `json:"low_items"`
+}
+
+// LowStockByCategory groups forecasts by top-level category, most low
+// items first. Uncategorised ingredients are grouped under "Other".
+func LowStockByCategory(db *gorm.DB, forecasts []StockForecast) ([]CategoryStock, error) {
+	ingredientIDs := make([]uint, len(forecasts))
+	for i, f := range forecasts {
+		ingredientIDs[i] = f.IngredientID
+	}
+	var ingredients []models.Ingredient
+	if len(ingredientIDs) > 0 {
+		if err := db.Where("id IN ?", ingredientIDs).Find(&ingredients).Error; err != nil {
+			return nil, err
+		}
+	}
+	roots, err := RootCategories(db)
+	if err != nil {
+		return nil, err
+	}
+
+	rootOf := make(map[uint]models.IngredientCategory, len(ingredients))
+	for _, ing := range ingredients {
+		if ing.CategoryID != nil {
+			rootOf[ing.ID] = roots[*ing.CategoryID]
+		}
+	}
+
+	groups := make(map[uint]*CategoryStock)
+	var order []uint
+	for _, f := range forecasts {
+		root := rootOf[f.IngredientID]
+		g, ok := groups[root.ID]
+		if !ok {
+			g = &CategoryStock{CategoryID: root.ID, Category: root.Name, Slug: root.Slug, LowItems: []string{}}
+			if root.ID == 0 {
+				g.Category, g.Slug = "Other", "other"
+			}
+			groups[root.ID] = g
+			order = append(order, root.ID)
+		}
+		g.Total++
+		if f.Low {
+			g.Low++
+			g.LowItems = append(g.LowItems, f.Ingredient)
+		}
+	}
+
+	result := make([]CategoryStock, 0, len(order))
+	for _, id := range order {
+		result = append(result, *groups[id])
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Low > result[j].Low })
+	return result, nil
+}
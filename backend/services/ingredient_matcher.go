@@ -0,0 +1,283 @@
+package services
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/pmitra96/pateproject/models"
+)
+
+// Match scores run from 0 (unrelated) to 1 (same ingredient).
+const (
+	// MatchCandidateScore is the lowest score offered to the user as a
+	// possible match.
+	MatchCandidateScore = 0.4
+	// MatchConfidentScore is the lowest score applied without asking.
+	MatchConfidentScore = 0.6
+	// MatchAmbiguityMargin: when the runner-up scores within this of the
+	// best match, the user has to choose.
+	MatchAmbiguityMargin = 0.1
+)
+
+// minTokenSimilarity drops weak word pairs entirely, so "oil" gets no
+// credit from "boiled".
+const minTokenSimilarity = 0.75
+
+// ingredientSynonyms maps regional and alternative names to one canonical
+// name. Multi-word keys are matched as whole phrases.
+var ingredientSynonyms = map[string]string{
+	"dahi":              "yogurt",
+	"curd":              "yogurt",
+	"yoghurt":           "yogurt",
+	"soya tofu":         "tofu",
+	"soy tofu":          "tofu",
+	"cilantro":          "coriander",
+	"dhania":            "coriander",
+	"garbanzo":          "chickpea",
+	"kabuli chana":      "chickpea",
+	"bell pepper":       "capsicum",
+	"brinjal":           "eggplant",
+	"aubergine":         "eggplant",
+	"baingan":           "eggplant",
+	"courgette":         "zucchini",
+	"bhindi":            "okra",
+	"ladyfinger":        "okra",
+	"lady finger":       "okra",
+	"scallion":          "spring onion",
+	"green onion":       "spring onion",
+	"aloo":              "potato",
+	"pyaz":              "onion",
+	"tamatar":           "tomato",
+	"palak":             "spinach",
+	"gobi":              "cauliflower",
+	"methi":             "fenugreek",
+	"haldi":             "turmeric",
+	"jeera":             "cumin",
+	"clarified butter":  "ghee",
+	"whole wheat flour": "atta",
+	"all purpose flour": "maida",
+	"semolina":          "sooji",
+	"rava":              "sooji",
+	"gram flour":        "besan",
+	"cottage cheese":    "paneer",
+	"broccoll":          "broccoli",
+	"brocoli":           "broccoli",
+}
+
+// ingredientModifiers describe but don't identify an ingredient.
+var ingredientModifiers = map[string]bool{
+	"organic": true, "fresh": true, "raw": true, "cooked": true,
+	"firm": true, "soft": true, "whole": true, "sliced": true,
+	"diced": true, "minced": true, "chopped": true, "natural": true,
+	"premium": true, "artisanal": true, "homemade": true, "farm": true,
+	"boiled": true, "grated": true, "of": true, "and": true,
+}
+
+// CanonicalIngredient returns the canonical name for a known synonym,
+// e.g. "dahi" -> "yogurt".
+func CanonicalIngredient(name string) (string, bool) {
+	canonical, ok := ingredientSynonyms[strings.ToLower(strings.TrimSpace(name))]
+	return canonical, ok
+}
+
+// NormalizeIngredient reduces a name to lower-case singular words without
+// punctuation or modifiers, with synonyms replaced by their canonical name:
+// "Fresh Tomatoes" -> "tomato", "Amul Dahi" -> "amul yogurt".
+func NormalizeIngredient(name string) string {
+	cleaned := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return ' '
+	}, name)
+
+	words := strings.Fields(cleaned)
+	for i, w := range words {
+		words[i] = singular(w)
+	}
+	// Synonyms first: some contain a modifier ("whole wheat flour")
+	words = replaceSynonyms(words)
+	var kept []string
+	for _, w := range words {
+		if !ingredientModifiers[w] {
+			kept = append(kept, w)
+		}
+	}
+	if len(kept) == 0 {
+		kept = words
+	}
+	return strings.Join(kept, " ")
+}
+
+// replaceSynonyms swaps the longest synonym phrase starting at each word.
+func replaceSynonyms(words []string) []string {
+	var out []string
+	for i := 0; i < len(words); {
+		matched := false
+		for n := min(3, len(words)-i); n >= 1; n-- {
+			if canonical, ok := ingredientSynonyms[strings.Join(words[i:i+n], " ")]; ok {
+				out = append(out, canonical)
+				i += n
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			out = append(out, words[i])
+			i++
+		}
+	}
+	return out
+}
+
+// singular strips common English plural endings ("tomatoes", "berries",
+// "eggs"). It only needs to be consistent, not grammatical.
+func singular(w string) string {
+	switch {
+	case len(w) > 4 && strings.HasSuffix(w, "ies"):
+		return w[:len(w)-3] + "y"
+	case len(w) > 4 && strings.HasSuffix(w, "oes"):
+		return w[:len(w)-2]
+	case len(w) > 3 && strings.HasSuffix(w, "s") &&
+		!strings.HasSuffix(w, "ss") && !strings.HasSuffix(w, "us") && !strings.HasSuffix(w, "is"):
+		return w[:len(w)-1]
+	}
+	return w
+}
+
+// IngredientScore rates how likely two names refer to the same ingredient.
+// Words are paired by edit distance, which tolerates typos ("panner"),
+// and the score is the harmonic mean of how much of each name is covered,
+// so "oil" scores well against "olive oil" but not "boiled eggs".
+func IngredientScore(a, b string) float64 {
+	na, nb := NormalizeIngredient(a), NormalizeIngredient(b)
+	if na == "" || nb == "" {
+		return 0
+	}
+	if na == nb {
+		return 1
+	}
+
+	wa, wb := strings.Fields(na), strings.Fields(nb)
+	recall, precision := coverage(wa, wb), coverage(wb, wa)
+	if recall+precision == 0 {
+		return 0
+	}
+	return 2 * recall * precision / (recall + precision)
+}
+
+// coverage is the mean best similarity of each word in from to any word in to.
+func coverage(from, to []string) float64 {
+	total := 0.0
+	for _, f := range from {
+		best := 0.0
+		for _, t := range to {
+			if s := wordSimilarity(f, t); s > best {
+				best = s
+			}
+		}
+		total += best
+	}
+	return total / float64(len(from))
+}
+
+func wordSimilarity(a, b string) float64 {
+	if a == b {
+		return 1
+	}
+	// Short words must match exactly; one edit changes them completely.
+	if len(a) < 4 || len(b) < 4 {
+		return 0
+	}
+	longest := max(len(a), len(b))
+	s := 1 - float64(editDistance(a, b))/float64(longest)
+	if s < minTokenSimilarity {
+		return 0
+	}
+	return s
+}
+
+// editDistance is the optimal string alignment distance: insertions,
+// deletions, substitutions and adjacent transpositions ("panner"/"paneer").
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	d := make([][]int, len(ra)+1)
+	for i := range d {
+		d[i] = make([]int, len(rb)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(ra)][len(rb)]
+}
+
+// IngredientMatch is a pantry item that may be what a meal ingredient
+// refers to.
+type IngredientMatch struct {
+	PantryItemID uint    `json:"pantry_item_id"`
+	IngredientID uint    `json:"ingredient_id"`
+	ItemID       uint    `json:"item_id"`
+	Ingredient   string  `json:"ingredient"`
+	Score        float64 `json:"score"`
+}
+
+// MatchResult ranks pantry candidates for one ingredient. Best is set only
+// when the top candidate is confident and clearly ahead of the rest.
+type MatchResult struct {
+	Query      string            `json:"query"`
+	Candidates []IngredientMatch `json:"candidates"`
+	Best       *IngredientMatch  `json:"best,omitempty"`
+	Ambiguous  bool              `json:"ambiguous"`
+}
+
+// MatchPantryItems scores every pantry item (Ingredient preloaded) against
+// an ingredient name and returns the plausible ones, best first.
+func MatchPantryItems(query string, pantryItems []models.PantryItem) MatchResult {
+	result := MatchResult{Query: query, Candidates: []IngredientMatch{}}
+	for _, pi := range pantryItems {
+		score := IngredientScore(query, pi.Ingredient.Name)
+		if score < MatchCandidateScore {
+			continue
+		}
+		result.Candidates = append(result.Candidates, IngredientMatch{
+			PantryItemID: pi.ID,
+			IngredientID: pi.IngredientID,
+			ItemID:       pi.ItemID,
+			Ingredient:   pi.Ingredient.Name,
+			Score:        score,
+		})
+	}
+	sort.SliceStable(result.Candidates, func(i, j int) bool {
+		return result.Candidates[i].Score > result.Candidates[j].Score
+	})
+
+	if len(result.Candidates) == 0 || result.Candidates[0].Score < MatchConfidentScore {
+		return result
+	}
+	top := result.Candidates[0]
+	if len(result.Candidates) > 1 {
+		next := result.Candidates[1]
+		// An exact name match wins outright; otherwise a close runner-up
+		// means we cannot tell which item was meant.
+		if next.Score >= MatchConfidentScore && top.Score-next.Score < MatchAmbiguityMargin && !(top.Score == 1 && next.Score < 1) {
+			result.Ambiguous = true
+			return result
+		}
+	}
+	result.Best = &top
+	return result
+}
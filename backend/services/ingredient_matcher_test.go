@@ -0,0 +1,100 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/pmitra96/pateproject/models"
+)
+
+func TestNormalizeIngredient(t *testing.T) {
+	tests := map[string]string{
+		"Fresh Tomatoes":       "tomato",
+		"Amul Dahi":            "amul yogurt",
+		"Curd":                 "yogurt",
+		"Yoghurt":              "yogurt",
+		"Soya Tofu":            "tofu",
+		"Boiled Eggs":          "egg",
+		"Whole Wheat Flour":    "atta",
+		"Organic":              "organic",
+		"Mixed Berries (500g)": "mixed berry 500g",
+	}
+	for in, want := range tests {
+		if got := NormalizeIngredient(in); got != want {
+			t.Errorf("NormalizeIngredient(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestIngredientScore(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		min, max float64
+	}{
+		{"oil", "boiled eggs", 0, 0},
+		{"oil", "Olive Oil", MatchConfidentScore, 0.99},
+		{"dahi", "Curd", 1, 1},
+		{"dahi", "Yogurt", 1, 1},
+		{"curd", "Greek Yogurt", MatchConfidentScore, 0.99},
+		{"panner", "Paneer", MatchConfidentScore, 0.99},
+		{"tomato", "Tomatoes", 1, 1},
+		{"egg", "Eggplant", 0, 0},
+		{"milk", "Paneer", 0, 0},
+	}
+	for _, tt := range tests {
+		got := IngredientScore(tt.a, tt.b)
+		if got < tt.min || got > tt.max {
+			t.Errorf("IngredientScore(%q, %q) = %.2f, want between %.2f and %.2f", tt.a, tt.b, got, tt.min, tt.max)
+		}
+	}
+}
+
+func pantryOf(names ...string) []models.PantryItem {
+	items := make([]models.PantryItem, len(names))
+	for i, name := range names {
+		id := uint(i + 1)
+		items[i] = models.PantryItem{ID: id, IngredientID: id, ItemID: id, Ingredient: models.Ingredient{ID: id, Name: name}}
+	}
+	return items
+}
+
+func TestMatchPantryItems(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		pantry     []models.PantryItem
+		best       string // Empty when nothing should be applied
+		ambiguous  bool
+		candidates int
+	}{
+		{"oil is not boiled eggs", "oil", pantryOf("Boiled Eggs", "Rice"), "", false, 0},
+		{"oil picks the only oil", "oil", pantryOf("Boiled Eggs", "Mustard Oil"), "Mustard Oil", false, 1},
+		{"dahi finds curd", "dahi", pantryOf("Curd", "Milk"), "Curd", false, 1},
+		{"curd finds yogurt", "curd", pantryOf("Milk", "Yogurt"), "Yogurt", false, 1},
+		{"typo", "panner", pantryOf("Paneer", "Peas"), "Paneer", false, 1},
+		{"exact name wins over a close one", "olive oil", pantryOf("Olive Oil", "Oil"), "Olive Oil", false, 2},
+		{"two oils are ambiguous", "oil", pantryOf("Olive Oil", "Mustard Oil"), "", true, 2},
+		{"nothing plausible", "saffron", pantryOf("Paneer", "Rice"), "", false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := MatchPantryItems(tt.query, tt.pantry)
+			if len(result.Candidates) != tt.candidates {
+				t.Errorf("candidates = %+v, want %d", result.Candidates, tt.candidates)
+			}
+			if result.Ambiguous != tt.ambiguous {
+				t.Errorf("ambiguous = %v, want %v", result.Ambiguous, tt.ambiguous)
+			}
+			switch {
+			case tt.best == "" && result.Best != nil:
+				t.Errorf("guessed %q", result.Best.Ingredient)
+			case tt.best != "" && (result.Best == nil || result.Best.Ingredient != tt.best):
+				t.Errorf("best = %+v, want %q", result.Best, tt.best)
+			}
+			for i := 1; i < len(result.Candidates); i++ {
+				if result.Candidates[i].Score > result.Candidates[i-1].Score {
+					t.Errorf("candidates not ranked: %+v", result.Candidates)
+				}
+			}
+		})
+	}
+}
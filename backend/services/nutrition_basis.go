@@ -0,0 +1,228 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/pmitra96/pateproject/models"
+	"github.com/pmitra96/pateproject/units"
+)
+
+// NutritionBasis is the amount of a food nutrition values are given for:
+// 100 g, 100 ml, one piece, or a serving such as a 30 g sachet. The unit is
+// always a base unit (g, ml or pcs).
+type NutritionBasis struct {
+	Amount float64 `json:"amount"`
+	Unit   string  `json:"unit"`
+}
+
+// Common bases.
+var (
+	Per100g  = NutritionBasis{Amount: 100, Unit: units.Gram}
+	Per100ml = NutritionBasis{Amount: 100, Unit: units.Millilitre}
+	PerPiece = NutritionBasis{Amount: 1, Unit: units.Piece}
+)
+
+// NewNutritionBasis normalises an amount in any known unit ("0.1 kg") to a
+// basis, reporting false for unknown units and amounts that are not
+// positive.
+func NewNutritionBasis(amount float64, unit string) (NutritionBasis, bool) {
+	if amount <= 0 {
+		return NutritionBasis{}, false
+	}
+	if _, ok := units.Lookup(unit); !ok || unit == "" {
+		return NutritionBasis{}, false
+	}
+	value, base := units.Normalize(amount, unit)
+	return NutritionBasis{Amount: value, Unit: base}, true
+}
+
+// DefaultBasis is the basis for a food tracked in unit when nothing says
+// otherwise: 100 g or 100 ml, or one piece for counted foods.
+func DefaultBasis(unit string) NutritionBasis {
+	switch dim, _ := units.DimensionOf(unit); dim {
+	case units.Count:
+		return PerPiece
+	case units.Volume:
+		return Per100ml
+	default:
+		return Per100g
+	}
+}
+
+// BasisOf returns the basis an item's macros are given for: its
+// ServingWeight of ServingUnit, or the default for its unit on items
+// recorded before every source had a basis.
+func BasisOf(item *models.Item) NutritionBasis {
+	if b, ok := NewNutritionBasis(item.ServingWeight, item.ServingUnit); ok {
+		return b
+	}
+	return DefaultBasis(item.Unit)
+}
+
+// Code is the ItemNutrient basis for amounts given on b.
+func (b NutritionBasis) Code() string {
+	switch b {
+	case Per100g:
+		return models.NutrientBasisPer100g
+	case Per100ml:
+		return models.NutrientBasisPer100ml
+	default:
+		return models.NutrientBasisPerServing
+	}
+}
+
+func (b NutritionBasis) String() string {
+	return fmt.Sprintf("%g %s", b.Amount, b.Unit)
+}
+
+// nutrientBasis is the basis an ItemNutrient is given on, where serving is
+// the basis of the item's or source's macros.
+func nutrientBasis(n models.ItemNutrient, serving NutritionBasis) NutritionBasis {
+	switch n.Basis {
+	case models.NutrientBasisPer100g:
+		return Per100g
+	case models.NutrientBasisPer100ml:
+		return Per100ml
+	default:
+		return serving
+	}
+}
+
+// ingredientOf is the name looked up in the density and piece weight
+// tables for an item.
+func ingredientOf(item *models.Item) string {
+	if item.Ingredient.Name != "" {
+		return item.Ingredient.Name
+	}
+	return item.Name
+}
+
+// convertForItem converts an amount of an item between units. Pieces of a
+// packaged item with no typical piece weight are whole packs, weighing
+// what the name says ("Amul Butter 100 g").
+func convertForItem(item *models.Item, value float64, from, to string) (float64, error) {
+	ingredient := ingredientOf(item)
+	converted, err := units.Convert(value, from, to, ingredient)
+	if !errors.Is(err, units.ErrIncompatible) {
+		return converted, err
+	}
+
+	size, sizeUnit := units.PackSize(item.Name)
+	if sizeUnit == units.Piece {
+		return 0, err
+	}
+	fromDim, _ := units.DimensionOf(from)
+	toDim, _ := units.DimensionOf(to)
+	switch {
+	case fromDim == units.Count:
+		packs, perr := units.Convert(value, from, units.Piece, ingredient)
+		if perr != nil {
+			return 0, perr
+		}
+		return units.Convert(packs*size, sizeUnit, to, ingredient)
+	case toDim == units.Count:
+		amount, cerr := units.Convert(value, from, sizeUnit, ingredient)
+		if cerr != nil {
+			return 0, cerr
+		}
+		packs := amount / size
+		return units.Convert(packs, units.Piece, to, ingredient)
+	}
+	return 0, err
+}
+
+// rebase returns the factor that turns values given per from into values
+// per to, for an item.
+func rebase(item *models.Item, from, to NutritionBasis) (float64, error) {
+	if from == to {
+		return 1, nil
+	}
+	amount, err := convertForItem(item, to.Amount, to.Unit, from.Unit)
+	if err != nil {
+		return 0, err
+	}
+	return amount / from.Amount, nil
+}
+
+// normalizeSourceBasis gives a source an explicit basis and puts its
+// nutrients on the same one where they can be converted. Sources recorded
+// before providers set a basis are taken to be per 100g for Open Food
+// Facts, and otherwise on the default basis for the item's unit, which is
+// what the LLM was asked for.
+func normalizeSourceBasis(item *models.Item, source *models.NutritionSource) {
+	basis, ok := NewNutritionBasis(source.ServingWeight, source.ServingUnit)
+	if !ok {
+		switch source.Provider {
+		case models.NutritionProviderOpenFoodFacts, models.NutritionProviderOFFMirror:
+			basis = Per100g
+		default:
+			basis = DefaultBasis(item.Unit)
+		}
+	}
+	source.ServingWeight, source.ServingUnit = basis.Amount, basis.Unit
+
+	for i, n := range source.Nutrients {
+		from := nutrientBasis(n, basis)
+		factor, err := rebase(item, from, basis)
+		if err != nil {
+			continue
+		}
+		source.Nutrients[i].Amount = roundNutrition(n.Amount * factor)
+		source.Nutrients[i].Basis = basis.Code()
+	}
+}
+
+// Macros are the nutrition in an amount of food.
+type Macros struct {
+	Calories float64 `json:"calories"`
+	Protein  float64 `json:"protein"`
+	Carbs    float64 `json:"carbs"`
+	Fat      float64 `json:"fat"`
+	Fiber    float64 `json:"fiber"`
+	Sugars   float64 `json:"sugars,omitempty"` // g
+	Sodium   float64 `json:"sodium,omitempty"` // mg
+}
+
+// ComputeMacros returns the nutrition in quantity of unit of an item, from
+// its macros on BasisOf(item) and its nutrients on theirs, e.g. 2 pcs of an
+// item given per 100 g through the typical piece or pack weight. It fails
+// when the unit is unknown or can't be converted to the item's basis.
+// Nutrients are counted when loaded on the item.
+func ComputeMacros(item *models.Item, quantity float64, unit string) (Macros, error) {
+	basis := BasisOf(item)
+	amount, err := convertForItem(item, quantity, unit, basis.Unit)
+	if err != nil {
+		return Macros{}, err
+	}
+	f := amount / basis.Amount
+	m := Macros{
+		Calories: roundNutrition(item.Calories * f),
+		Protein:  roundNutrition(item.Protein * f),
+		Carbs:    roundNutrition(item.Carbs * f),
+		Fat:      roundNutrition(item.Fat * f),
+		Fiber:    roundNutrition(item.Fiber * f),
+	}
+
+	for _, n := range item.Nutrients {
+		nb := nutrientBasis(n, basis)
+		amount, err := convertForItem(item, quantity, unit, nb.Unit)
+		if err != nil {
+			continue
+		}
+		v := roundNutrition(n.Amount * amount / nb.Amount)
+		switch n.Code {
+		case models.NutrientSugars:
+			m.Sugars = v
+		case models.NutrientSodium:
+			m.Sodium = v
+		}
+	}
+	return m, nil
+}
+
+// roundNutrition rounds to two decimals, trimming conversion noise.
+func roundNutrition(v float64) float64 {
+	return math.Round(v*100) / 100
+}
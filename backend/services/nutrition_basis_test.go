@@ -0,0 +1,130 @@
+package services
+
+import (
+	"math"
+	"testing"
+
+	"github.com/pmitra96/pateproject/models"
+)
+
+func TestComputeMacros(t *testing.T) {
+	eggs := &models.Item{
+		Name: "Farm Eggs", Unit: "pcs", Ingredient: models.Ingredient{Name: "Egg"},
+		Calories: 143, Protein: 12.6, ServingWeight: 100, ServingUnit: "g",
+	}
+	butter := &models.Item{
+		Name: "Amul Butter 100 g", Unit: "pcs", Ingredient: models.Ingredient{Name: "Butter"},
+		Calories: 722, Fat: 80, ServingWeight: 100, ServingUnit: "g",
+	}
+	milk := &models.Item{
+		Name: "Toned Milk", Unit: "ml", Ingredient: models.Ingredient{Name: "Milk"},
+		Calories: 58, Protein: 3.1, ServingWeight: 100, ServingUnit: "ml",
+	}
+	sachet := &models.Item{
+		Name: "Protein Oats", Unit: "g", Ingredient: models.Ingredient{Name: "Oats"},
+		Calories: 120, Protein: 6, ServingWeight: 30, ServingUnit: "g",
+	}
+	legacy := &models.Item{Name: "Banana", Unit: "pcs", Ingredient: models.Ingredient{Name: "Banana"}, Calories: 105}
+
+	tests := []struct {
+		name     string
+		item     *models.Item
+		quantity float64
+		unit     string
+		calories float64
+	}{
+		{"pieces through the piece weight", eggs, 2, "pcs", 143},
+		{"dozen through the piece weight", eggs, 1, "dozen", 858},
+		{"grams on a per-100g item", eggs, 50, "g", 71.5},
+		{"pack size from the name", butter, 1, "pcs", 722},
+		{"half a pack", butter, 0.5, "pcs", 361},
+		{"grams of a pack item", butter, 10, "g", 72.2},
+		{"millilitres per 100ml", milk, 250, "ml", 145},
+		{"litres per 100ml", milk, 1, "l", 580},
+		{"per serving", sachet, 90, "g", 360},
+		{"kilograms per serving", sachet, 0.3, "kg", 1200},
+		{"legacy item on its default basis", legacy, 2, "pcs", 210},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := ComputeMacros(tt.item, tt.quantity, tt.unit)
+			if err != nil {
+				t.Fatalf("ComputeMacros: %v", err)
+			}
+			if math.Abs(m.Calories-tt.calories) > 0.01 {
+				t.Errorf("calories = %v, want %v", m.Calories, tt.calories)
+			}
+		})
+	}
+}
+
+func TestComputeMacrosIncompatibleUnits(t *testing.T) {
+	salt := &models.Item{
+		Name: "Iodised Salt", Unit: "g", Ingredient: models.Ingredient{Name: "Salt"},
+		Calories: 0, ServingWeight: 100, ServingUnit: "g",
+	}
+	if _, err := ComputeMacros(salt, 2, "pcs"); err == nil {
+		t.Error("pieces of an item with no piece or pack weight converted")
+	}
+	if _, err := ComputeMacros(salt, 2, "handful"); err == nil {
+		t.Error("unknown unit converted")
+	}
+}
+
+func TestComputeMacrosNutrientsOnTheirOwnBasis(t *testing.T) {
+	// Macros per egg, sodium per 100 g
+	eggs := &models.Item{
+		Name: "Farm Eggs", Unit: "pcs", Ingredient: models.Ingredient{Name: "Egg"},
+		Calories: 72, ServingWeight: 1, ServingUnit: "pcs",
+		Nutrients: []models.ItemNutrient{
+			{Code: models.NutrientSodium, Amount: 140, Unit: "mg", Basis: models.NutrientBasisPer100g},
+			{Code: models.NutrientSugars, Amount: 0.2, Unit: "g", Basis: models.NutrientBasisPerServing},
+		},
+	}
+	m, err := ComputeMacros(eggs, 2, "pcs")
+	if err != nil {
+		t.Fatalf("ComputeMacros: %v", err)
+	}
+	if m.Calories != 144 || m.Sodium != 140 || m.Sugars != 0.4 {
+		t.Errorf("macros = %+v, want 144 kcal, 140 mg sodium and 0.4 g sugars", m)
+	}
+}
+
+func TestRebase(t *testing.T) {
+	eggs := &models.Item{Name: "Farm Eggs", Unit: "pcs", Ingredient: models.Ingredient{Name: "Egg"}}
+	tests := []struct {
+		name     string
+		from, to NutritionBasis
+		want     float64
+	}{
+		{"same basis", Per100g, Per100g, 1},
+		{"per 100 g to per egg", Per100g, PerPiece, 0.5},
+		{"per egg to per 100 g", PerPiece, Per100g, 2},
+		{"per 100 g to a 30 g serving", Per100g, NutritionBasis{Amount: 30, Unit: "g"}, 0.3},
+	}
+	for _, tt := range tests {
+		got, err := rebase(eggs, tt.from, tt.to)
+		if err != nil || math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s: rebase = %v, %v; want %v", tt.name, got, err, tt.want)
+		}
+	}
+
+	salt := &models.Item{Name: "Salt", Unit: "g", Ingredient: models.Ingredient{Name: "Salt"}}
+	if _, err := rebase(salt, Per100g, PerPiece); err == nil {
+		t.Error("rebased salt onto pieces")
+	}
+}
+
+func TestNewNutritionBasis(t *testing.T) {
+	if b, ok := NewNutritionBasis(0.1, "kg"); !ok || b != Per100g {
+		t.Errorf("0.1 kg = %v, %v; want %v", b, ok, Per100g)
+	}
+	for _, bad := range []struct {
+		amount float64
+		unit   string
+	}{{0, "g"}, {-1, "g"}, {100, ""}, {100, "handful"}} {
+		if b, ok := NewNutritionBasis(bad.amount, bad.unit); ok {
+			t.Errorf("NewNutritionBasis(%v, %q) = %v, want none", bad.amount, bad.unit, b)
+		}
+	}
+}
@@ -0,0 +1,601 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pmitra96/pateproject/config"
+	"github.com/pmitra96/pateproject/database"
+	"github.com/pmitra96/pateproject/llm"
+	"github.com/pmitra96/pateproject/logger"
+	"github.com/pmitra96/pateproject/models"
+	"github.com/pmitra96/pateproject/units"
+	"gorm.io/gorm"
+)
+
+// NutritionProvider looks up nutrition for an item from one source.
+type NutritionProvider interface {
+	// Name is the provider recorded on its NutritionSource records.
+	Name() string
+	// Lookup returns the provider's best match for the item, with its
+	// confidence, or an error when it has none.
+	Lookup(item *models.Item) (*models.NutritionSource, error)
+}
+
+const defaultNutritionProviders = "openfoodfacts-local,scraper,openfoodfacts,llm"
+
+// NutritionProvidersFromEnv builds the provider chain named in
+// NUTRITION_PROVIDERS, in order, skipping unknown names.
+func NutritionProvidersFromEnv(llmClient *llm.Client) []NutritionProvider {
+	var providers []NutritionProvider
+	for _, name := range strings.Split(config.GetEnv("NUTRITION_PROVIDERS", defaultNutritionProviders), ",") {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case models.NutritionProviderScraper:
+			providers = append(providers, ScraperNutrition{BaseURL: config.GetEnv("PYTHON_EXTRACTOR_URL", "http://localhost:8081")})
+		case models.NutritionProviderOFFMirror:
+			providers = append(providers, OFFMirrorNutrition{DB: database.DB})
+		case models.NutritionProviderOpenFoodFacts:
+			providers = append(providers, OpenFoodFactsNutrition{})
+		case models.NutritionProviderLLM:
+			providers = append(providers, LLMNutrition{Client: llmClient})
+		case "":
+		default:
+			logger.Warn("Unknown nutrition provider, skipping", "provider", name)
+		}
+	}
+	return providers
+}
+
+// Base confidence of each provider's best possible match. Label data from
+// a matching product beats an estimate; how well the matched product's
+// name fits the query scales it down.
+const (
+	barcodeConfidence       = 1.0 // The same barcode is the same product
+	scraperConfidence       = 0.95
+	openFoodFactsConfidence = 0.9
+	llmConfidence           = 0.3
+)
+
+// matchConfidence scales a provider's base confidence by how well the
+// matched product name fits the query. A search hit that shares no words
+// with the query still counts for half, since the search engine chose it.
+func matchConfidence(base float64, query, product string) float64 {
+	if product == "" {
+		return base * 0.5
+	}
+	return base * (0.5 + 0.5*IngredientScore(query, product))
+}
+
+// brandQuery is the item's product name, prefixed with its brand unless the
+// name already has it.
+func brandQuery(item *models.Item) string {
+	cleanProductName := strings.TrimSpace(item.ProductName)
+	brandName := ""
+	if item.Brand != nil {
+		brandName = strings.TrimSpace(item.Brand.Name)
+	}
+
+	query := cleanProductName
+	if brandName != "" && !strings.Contains(strings.ToLower(cleanProductName), strings.ToLower(brandName)) {
+		query = brandName + " " + cleanProductName
+	}
+	return query
+}
+
+// ScraperNutrition searches the products scraped by the Python service.
+type ScraperNutrition struct {
+	BaseURL string
+}
+
+func (ScraperNutrition) Name() string { return models.NutritionProviderScraper }
+
+func (p ScraperNutrition) Lookup(item *models.Item) (*models.NutritionSource, error) {
+	if item.GTIN != "" {
+		source, err := p.search(item.GTIN, "barcode="+item.GTIN)
+		if err == nil {
+			source.Confidence = barcodeConfidence
+			return source, nil
+		}
+	}
+
+	query := brandQuery(item)
+	source, err := p.search(query, "query="+strings.ReplaceAll(query, " ", "+"))
+	if err != nil {
+		return nil, err
+	}
+	source.Confidence = matchConfidence(scraperConfidence, query, source.MatchedProduct)
+	return source, nil
+}
+
+// search returns the first scraped product found by a search, with
+// nutrition.
+func (p ScraperNutrition) search(query, params string) (*models.NutritionSource, error) {
+	url := fmt.Sprintf("%s/api/v1/products/search?%s", p.BaseURL, params)
+	logger.Info("Searching Python Scraper", "query", query, "url", url)
+
+	client := &http.Client{Timeout: 3 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("scraper request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("scraper returned status: %d", resp.StatusCode)
+	}
+
+	var results []struct {
+		Name          string `json:"name"`
+		NutritionInfo struct {
+			Energy        float64  `json:"energy"`
+			Protein       float64  `json:"protein"`
+			Fat           float64  `json:"fat"`
+			Carbohydrates float64  `json:"carbohydrates"`
+			Sugars        *float64 `json:"sugars"`
+			SaturatedFat  *float64 `json:"saturated_fat"`
+			Sodium        *float64 `json:"sodium"`
+			Iron          *float64 `json:"iron"`
+			Calcium       *float64 `json:"calcium"`
+			VitaminB12    *float64 `json:"vitamin_b12"`
+		} `json:"nutrition_info"`
+		NutritionBasis      string  `json:"nutrition_basis"`
+		ServingSizeValue    float64 `json:"serving_size_value"`
+		ServingSizeUnit     string  `json:"serving_size_unit"`
+		AllergenInformation string  `json:"allergen_information"`
+		DietaryPreference   string  `json:"dietary_preference"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		return nil, fmt.Errorf("failed to decode scraper response: %v", err)
+	}
+
+	if len(results) > 0 {
+		// Take the first result
+		p := results[0]
+		if p.NutritionInfo.Energy > 0 || p.NutritionInfo.Protein > 0 {
+			basis := scraperBasis(p.NutritionBasis, p.ServingSizeValue, p.ServingSizeUnit)
+			source := &models.NutritionSource{
+				Query:          query,
+				MatchedProduct: p.Name,
+				Verified:       true,
+				Calories:       p.NutritionInfo.Energy,
+				Protein:        p.NutritionInfo.Protein,
+				Carbs:          p.NutritionInfo.Carbohydrates,
+				Fat:            p.NutritionInfo.Fat,
+				ServingWeight:  basis.Amount,
+				ServingUnit:    basis.Unit,
+				DietProfile:    scraperDiet(p.AllergenInformation, p.DietaryPreference),
+			}
+			n := p.NutritionInfo
+			for _, v := range []struct {
+				code, unit string
+				amount     *float64
+			}{
+				{models.NutrientSugars, "g", n.Sugars},
+				{models.NutrientSaturatedFat, "g", n.SaturatedFat},
+				{models.NutrientSodium, "mg", n.Sodium},
+				{models.NutrientIron, "mg", n.Iron},
+				{models.NutrientCalcium, "mg", n.Calcium},
+				{models.NutrientVitaminB12, "µg", n.VitaminB12},
+			} {
+				if v.amount != nil {
+					source.Nutrients = append(source.Nutrients, models.ItemNutrient{Code: v.code, Amount: *v.amount, Unit: v.unit, Basis: basis.Code()})
+				}
+			}
+			return source, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no products found in scraper")
+}
+
+// scraperBasis is the basis of a scraped product's nutrition. The scraper
+// rescales labels given per serving to 100g or 100ml when the serving size
+// is printed, so per_serving remains only for servings without a size,
+// taken as one piece. Products scraped before the basis was recorded are
+// per 100g.
+func scraperBasis(basis string, servingValue float64, servingUnit string) NutritionBasis {
+	switch basis {
+	case models.NutrientBasisPer100ml:
+		return Per100ml
+	case models.NutrientBasisPerServing:
+		if b, ok := NewNutritionBasis(servingValue, servingUnit); ok {
+			return b
+		}
+		return PerPiece
+	default:
+		return Per100g
+	}
+}
+
+// openFoodFactsQueries are the searches to try for an item, most specific
+// (brand and product name) first.
+func openFoodFactsQueries(item *models.Item) []string {
+	queries := []string{}
+
+	cleanProductName := strings.TrimSpace(item.ProductName)
+	brandName := ""
+	if item.Brand != nil {
+		brandName = strings.TrimSpace(item.Brand.Name)
+	}
+
+	if brandName != "" {
+		// Tier 1: Brand + Specific Product Name
+		// Check if brand is already at the start of product name to avoid "Mooz Mooz ..."
+		fullQuery := cleanProductName
+		if !strings.HasPrefix(strings.ToLower(cleanProductName), strings.ToLower(brandName)) {
+			fullQuery = brandName + " " + cleanProductName
+		}
+		queries = append(queries, fullQuery)
+
+		// Tier 2: Brand + Ingredient Name
+		queries = append(queries, brandName+" "+item.Ingredient.Name)
+
+		// Tier 3: Brand + Simplified Product Name (most specific noun)
+		// Strip common generic words that clutter search
+		stripWords := []string{"organic", "set", "artisanal", "pure", "fresh", "toned", "natural"}
+		simplified := strings.ToLower(cleanProductName)
+		for _, w := range stripWords {
+			simplified = strings.ReplaceAll(simplified, w, "")
+		}
+		parts := strings.Fields(simplified)
+		if len(parts) > 0 {
+			// Try Brand + Last Word (usually the noun)
+			nounSearch := brandName + " " + parts[len(parts)-1]
+			if !strings.Contains(strings.ToLower(fullQuery), strings.ToLower(nounSearch)) {
+				queries = append(queries, nounSearch)
+			}
+		}
+	} else {
+		if cleanProductName != "" {
+			queries = append(queries, cleanProductName)
+		}
+		if item.Ingredient.Name != "" {
+			queries = append(queries, item.Ingredient.Name)
+		}
+	}
+
+	uniqueQueries := []string{}
+	seen := make(map[string]bool)
+	for _, q := range queries {
+		q = strings.TrimSpace(q)
+		if q != "" && !seen[strings.ToLower(q)] {
+			uniqueQueries = append(uniqueQueries, q)
+			seen[strings.ToLower(q)] = true
+		}
+	}
+
+	const limit = 3
+	if len(uniqueQueries) > limit {
+		uniqueQueries = uniqueQueries[:limit]
+	}
+	return uniqueQueries
+}
+
+// bestOpenFoodFactsMatch picks the search result whose brand and name best
+// fit the item, among those with an energy value, rather than trusting the
+// search engine's first result. Matches from less specific queries (tier 0
+// is brand and product name) are less likely to be the product.
+func bestOpenFoodFactsMatch(item *models.Item, query string, tier int, products []models.OpenFoodFactsProduct) *models.NutritionSource {
+	fullQuery := brandQuery(item)
+	base := openFoodFactsConfidence - 0.1*float64(tier)
+
+	var best *models.NutritionSource
+	for _, p := range products {
+		if p.EnergyKcal <= 0 {
+			continue
+		}
+		source := openFoodFactsSource(p, query, 0)
+		source.Confidence = matchConfidence(base, fullQuery, source.MatchedProduct)
+		if best == nil || source.Confidence > best.Confidence {
+			best = source
+		}
+	}
+	return best
+}
+
+// openFoodFactsBasis is the basis of an Open Food Facts product's
+// nutriments: per 100g, or per 100ml for products sold by volume.
+func openFoodFactsBasis(p models.OpenFoodFactsProduct) NutritionBasis {
+	if _, unit := units.PackSize(p.Quantity); unit == units.Millilitre {
+		return Per100ml
+	}
+	return Per100g
+}
+
+func openFoodFactsSource(p models.OpenFoodFactsProduct, query string, confidence float64) *models.NutritionSource {
+	basis := openFoodFactsBasis(p)
+	return &models.NutritionSource{
+		Query:          query,
+		MatchedProduct: strings.TrimSpace(strings.TrimSpace(p.Brands) + " " + strings.TrimSpace(p.ProductName)),
+		Confidence:     confidence,
+		Verified:       true,
+		Calories:       p.EnergyKcal,
+		Protein:        p.Proteins,
+		Carbs:          p.Carbohydrates,
+		Fat:            p.Fat,
+		Fiber:          p.Fiber,
+		ServingWeight:  basis.Amount,
+		ServingUnit:    basis.Unit,
+		Nutrients:      openFoodFactsNutrients(p, basis),
+		DietProfile:    openFoodFactsDiet(p),
+	}
+}
+
+// OpenFoodFactsNutrition searches the live Open Food Facts API, from the
+// most specific query (brand and product name) to the least.
+type OpenFoodFactsNutrition struct{}
+
+func (OpenFoodFactsNutrition) Name() string { return models.NutritionProviderOpenFoodFacts }
+
+func (OpenFoodFactsNutrition) Lookup(item *models.Item) (*models.NutritionSource, error) {
+	if item.GTIN != "" {
+		product, err := fetchOpenFoodFactsBarcode(item.GTIN)
+		if err == nil && product.EnergyKcal > 0 {
+			logger.Info("Nutrition fetched from Open Food Facts by barcode", "item", item.Name, "gtin", item.GTIN)
+			return openFoodFactsSource(*product, item.GTIN, barcodeConfidence), nil
+		}
+		if err != nil {
+			logger.Warn("Open Food Facts barcode lookup failed", "gtin", item.GTIN, "error", err)
+		}
+	}
+
+	for i, query := range openFoodFactsQueries(item) {
+		logger.Info("Searching Open Food Facts", "query", query)
+		products, err := searchOpenFoodFactsLive(query)
+		if err != nil {
+			logger.Warn("Open Food Facts search failed or timed out", "query", query, "error", err)
+			continue
+		}
+
+		if source := bestOpenFoodFactsMatch(item, query, i, products); source != nil {
+			logger.Info("Nutrition fetched from Open Food Facts", "item", item.Name, "query", query, "matched", source.MatchedProduct)
+			return source, nil
+		}
+		if len(products) > 0 {
+			logger.Warn("Open Food Facts returned zero calories", "query", query)
+		}
+	}
+
+	return nil, fmt.Errorf("no valid products found on Open Food Facts for any tried queries")
+}
+
+// searchOpenFoodFactsLive runs one Open Food Facts API search, reading
+// nutrients per 100g.
+func searchOpenFoodFactsLive(query string) ([]models.OpenFoodFactsProduct, error) {
+	url := fmt.Sprintf("https://world.openfoodfacts.org/cgi/search.pl?search_terms=%s&search_simple=1&action=process&json=1", strings.ReplaceAll(query, " ", "+"))
+
+	client := &http.Client{Timeout: 2 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("open food facts returned status: %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Products []offAPIProduct `json:"products"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode Open Food Facts response: %v", err)
+	}
+
+	products := make([]models.OpenFoodFactsProduct, 0, len(result.Products))
+	for _, p := range result.Products {
+		products = append(products, p.product())
+	}
+	return products, nil
+}
+
+// fetchOpenFoodFactsBarcode reads one product from the live Open Food Facts
+// API by barcode.
+func fetchOpenFoodFactsBarcode(gtin string) (*models.OpenFoodFactsProduct, error) {
+	url := fmt.Sprintf("https://world.openfoodfacts.org/api/v2/product/%s.json?fields=code,product_name,brands,quantity,nutriments,allergens_tags,ingredients_analysis_tags", gtin)
+
+	client := &http.Client{Timeout: 2 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("barcode %s not on Open Food Facts", gtin)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("open food facts returned status: %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Status  int           `json:"status"` // 1 when found
+		Product offAPIProduct `json:"product"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode Open Food Facts response: %v", err)
+	}
+	if result.Status != 1 {
+		return nil, fmt.Errorf("barcode %s not on Open Food Facts", gtin)
+	}
+	product := result.Product.product()
+	return &product, nil
+}
+
+// offAPIProduct is a product as the Open Food Facts API returns it.
+type offAPIProduct struct {
+	Code                    string   `json:"code"`
+	ProductName             string   `json:"product_name"`
+	Brands                  string   `json:"brands"`
+	Quantity                string   `json:"quantity"`
+	AllergensTags           []string `json:"allergens_tags"`
+	IngredientsAnalysisTags []string `json:"ingredients_analysis_tags"`
+	Nutriments              struct {
+		EnergyKcal100g    offNumber `json:"energy-kcal_100g"`
+		Proteins100g      offNumber `json:"proteins_100g"`
+		Carbohydrates100g offNumber `json:"carbohydrates_100g"`
+		Fat100g           offNumber `json:"fat_100g"`
+		Fiber100g         offNumber `json:"fiber_100g"`
+		offMicronutrients
+	} `json:"nutriments"`
+}
+
+func (p offAPIProduct) product() models.OpenFoodFactsProduct {
+	product := models.OpenFoodFactsProduct{Code: p.Code, ProductName: p.ProductName, Brands: p.Brands, Quantity: p.Quantity}
+	product.EnergyKcal = float64(p.Nutriments.EnergyKcal100g)
+	product.Proteins = float64(p.Nutriments.Proteins100g)
+	product.Carbohydrates = float64(p.Nutriments.Carbohydrates100g)
+	product.Fat = float64(p.Nutriments.Fat100g)
+	product.Fiber = float64(p.Nutriments.Fiber100g)
+
+	var rec offRecord
+	p.Nutriments.read(&rec)
+	product.Sugars, product.SaturatedFat, product.Sodium = rec.Sugars, rec.SaturatedFat, rec.Sodium
+	product.Iron, product.Calcium, product.VitaminB12 = rec.Iron, rec.Calcium, rec.VitaminB12
+	product.AllergensTags = strings.Join(p.AllergensTags, ",")
+	product.IngredientsAnalysisTags = strings.Join(p.IngredientsAnalysisTags, ",")
+	return product
+}
+
+// Local mirror results compared per query.
+const offMirrorCandidates = 10
+
+// OFFMirrorNutrition searches the local Open Food Facts mirror filled by
+// cmd/import-off, with the same queries as the live API. It needs no
+// network and gives the same answer every time.
+type OFFMirrorNutrition struct {
+	DB *gorm.DB
+}
+
+func (OFFMirrorNutrition) Name() string { return models.NutritionProviderOFFMirror }
+
+func (p OFFMirrorNutrition) Lookup(item *models.Item) (*models.NutritionSource, error) {
+	if p.DB == nil {
+		return nil, fmt.Errorf("no database for the Open Food Facts mirror")
+	}
+	if item.GTIN != "" {
+		if product, ok := LookupOpenFoodFactsBarcode(p.DB, item.GTIN); ok {
+			return openFoodFactsSource(*product, item.GTIN, barcodeConfidence), nil
+		}
+	}
+	for i, query := range openFoodFactsQueries(item) {
+		products, err := SearchOpenFoodFacts(p.DB, query, offMirrorCandidates)
+		if err != nil {
+			return nil, err
+		}
+		if source := bestOpenFoodFactsMatch(item, query, i, products); source != nil {
+			return source, nil
+		}
+	}
+	return nil, fmt.Errorf("no products found in the Open Food Facts mirror")
+}
+
+// LLMNutrition estimates nutrition with the LLM, per 100g or 100ml, or per
+// piece for counted items.
+type LLMNutrition struct {
+	Client *llm.Client
+}
+
+func (LLMNutrition) Name() string { return models.NutritionProviderLLM }
+
+func (p LLMNutrition) Lookup(item *models.Item) (*models.NutritionSource, error) {
+	logger.Info("Using LLM to estimate nutrition", "item", item.Name)
+
+	basis := DefaultBasis(item.Unit)
+	unitType := "per 100g"
+	isCountBased := false
+	switch basis {
+	case PerPiece:
+		unitType = "per 1 unit/piece"
+		isCountBased = true
+	case Per100ml:
+		unitType = "per 100ml"
+	}
+
+	prompt := fmt.Sprintf(`Provide nutritional information %s for this item.
+Item: %s (Brand: %s, Ingredient: %s, Unit: %s)
+
+Return ONLY a JSON object:
+{
+  "calories": float,
+  "protein": float,
+  "carbs": float,
+  "fat": float,
+  "fiber": float
+}`, unitType, item.ProductName, func() string {
+		if item.Brand != nil {
+			return item.Brand.Name
+		}
+		return "Unknown"
+	}(), item.Ingredient.Name, item.Unit)
+
+	resp, err := p.Client.Chat([]llm.Message{
+		{Role: "system", Content: fmt.Sprintf("You are a nutrition expert. Provide estimated nutritional data %s. If brand info is unavailable, use average values for the ingredient.", unitType)},
+		{Role: "user", Content: prompt},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Clean output from possible markdown code blocks
+	cleanResp := strings.TrimSpace(resp)
+	if strings.HasPrefix(cleanResp, "```json") {
+		cleanResp = strings.TrimPrefix(cleanResp, "```json")
+		cleanResp = strings.TrimSuffix(cleanResp, "```")
+	}
+
+	var data struct {
+		Calories float64 `json:"calories"`
+		Protein  float64 `json:"protein"`
+		Carbs    float64 `json:"carbs"`
+		Fat      float64 `json:"fat"`
+		Fiber    float64 `json:"fiber"`
+	}
+
+	if err := json.Unmarshal([]byte(cleanResp), &data); err != nil {
+		return nil, err
+	}
+
+	// Sanity Checks: Ensure values are realistic per 100g
+	// Max possible calories in 100g (pure fat) is ~900.
+	if data.Calories > 900 {
+		logger.Warn("Insane calorie value detected, capping at 900", "val", data.Calories)
+		data.Calories = 900
+	}
+	// Max macros per 100g is 100g
+	if data.Protein > 100 {
+		data.Protein = 100
+	}
+	if data.Carbs > 100 {
+		data.Carbs = 100
+	}
+	if data.Fat > 100 {
+		data.Fat = 100
+	}
+	if data.Fiber > 100 {
+		data.Fiber = 100
+	}
+
+	msg := "🔥 Nutrition estimated (per 100g/ml)"
+	if isCountBased {
+		msg = "🔥 Nutrition estimated (per piece/unit)"
+	}
+	logger.Info(msg, "item", item.Name, "kcal", data.Calories)
+
+	return &models.NutritionSource{
+		Query:         item.ProductName,
+		Confidence:    llmConfidence,
+		Verified:      false, // It's an estimation
+		Calories:      data.Calories,
+		Protein:       data.Protein,
+		Carbs:         data.Carbs,
+		Fat:           data.Fat,
+		Fiber:         data.Fiber,
+		ServingWeight: basis.Amount,
+		ServingUnit:   basis.Unit,
+	}, nil
+}
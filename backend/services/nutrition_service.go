@@ -3,7 +3,7 @@ package services
 import (
 	"encoding/json"
 	"fmt"
-	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -12,317 +12,226 @@ import (
 	"github.com/pmitra96/pateproject/llm"
 	"github.com/pmitra96/pateproject/logger"
 	"github.com/pmitra96/pateproject/models"
+	"github.com/pmitra96/pateproject/units"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type NutritionService struct {
 	llmClient *llm.Client
+	providers []NutritionProvider
+	policy    NutritionPolicy
 }
 
+// NewNutritionService builds the provider chain from NUTRITION_PROVIDERS
+// and the policy from NUTRITION_ACCEPT_CONFIDENCE.
 func NewNutritionService() *NutritionService {
+	llmClient := llm.NewClient()
 	return &NutritionService{
-		llmClient: llm.NewClient(),
+		llmClient: llmClient,
+		providers: NutritionProvidersFromEnv(llmClient),
+		policy:    NutritionPolicyFromEnv(),
 	}
 }
 
-// FetchItemNutrition attempts to fetch nutrition data for an item.
-func (s *NutritionService) FetchItemNutrition(item *models.Item) error {
-	// Step 0: Check our own Scraper (Zepto)
-	err := s.fetchFromPythonScraper(item)
-	if err == nil && item.NutritionVerified {
-		logger.Info("Nutrition fetched from Zepto Scraper", "item", item.Name)
-		return nil
-	}
-
-	// Step 1: Check Open Food Facts
-	err = s.fetchFromOpenFoodFacts(item)
-	if err == nil && item.NutritionVerified {
-		logger.Info("Nutrition fetched from Open Food Facts", "item", item.Name)
-		return nil
+// NewNutritionServiceWith uses the given providers, in order, instead of the
+// configured chain, e.g. fakes to run the chain offline.
+func NewNutritionServiceWith(policy NutritionPolicy, providers ...NutritionProvider) *NutritionService {
+	return &NutritionService{
+		llmClient: llm.NewClient(),
+		providers: providers,
+		policy:    policy,
 	}
-
-	// Step 2: Fallback to LLM Estimation
-	return s.estimateWithLLM(item)
 }
 
-func (s *NutritionService) fetchFromPythonScraper(item *models.Item) error {
-	baseURL := config.GetEnv("PYTHON_EXTRACTOR_URL", "http://localhost:8081")
-
-	cleanProductName := strings.TrimSpace(item.ProductName)
-	brandName := ""
-	if item.Brand != nil {
-		brandName = strings.TrimSpace(item.Brand.Name)
-	}
-
-	query := cleanProductName
-	if brandName != "" && !strings.Contains(strings.ToLower(cleanProductName), strings.ToLower(brandName)) {
-		query = brandName + " " + cleanProductName
-	}
-
-	url := fmt.Sprintf("%s/api/v1/products/search?query=%s", baseURL, strings.ReplaceAll(query, " ", "+"))
-	logger.Info("Searching Python Scraper", "query", query, "url", url)
+const defaultAcceptConfidence = 0.8
 
-	client := &http.Client{Timeout: 3 * time.Second}
-	resp, err := client.Get(url)
-	if err != nil {
-		return fmt.Errorf("scraper request failed: %v", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("scraper returned status: %d", resp.StatusCode)
-	}
-
-	var results []struct {
-		Name          string `json:"name"`
-		NutritionInfo struct {
-			Energy        float64 `json:"energy"`
-			Protein       float64 `json:"protein"`
-			Fat           float64 `json:"fat"`
-			Carbohydrates float64 `json:"carbohydrates"`
-		} `json:"nutrition_info"`
-		ServingSizeValue float64 `json:"serving_size_value"`
-		ServingSizeUnit  string  `json:"serving_size_unit"`
-	}
-
-	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
-		return fmt.Errorf("failed to decode scraper response: %v", err)
-	}
-
-	if len(results) > 0 {
-		// Take the first result
-		p := results[0]
-		if p.NutritionInfo.Energy > 0 || p.NutritionInfo.Protein > 0 {
-			item.Calories = p.NutritionInfo.Energy
-			item.Protein = p.NutritionInfo.Protein
-			item.Carbs = p.NutritionInfo.Carbohydrates
-			item.Fat = p.NutritionInfo.Fat
-
-			// Track portion context
-			if p.ServingSizeValue > 0 {
-				item.ServingWeight = p.ServingSizeValue
-				item.ServingUnit = p.ServingSizeUnit
-			} else {
-				// Default to 100g standard if not specified
-				item.ServingWeight = 100
-				item.ServingUnit = "g"
-			}
-
-			item.NutritionVerified = true
-			return nil
-		}
-	}
-
-	return fmt.Errorf("no products found in scraper")
+// NutritionPolicy decides how far down the chain to look and which source
+// an item's macros come from.
+type NutritionPolicy struct {
+	// AcceptConfidence stops the chain at the first source at least this
+	// confident; later providers are not asked.
+	AcceptConfidence float64
 }
 
-func (s *NutritionService) fetchFromOpenFoodFacts(item *models.Item) error {
-	queries := []string{}
-
-	cleanProductName := strings.TrimSpace(item.ProductName)
-	brandName := ""
-	if item.Brand != nil {
-		brandName = strings.TrimSpace(item.Brand.Name)
+// NutritionPolicyFromEnv reads NUTRITION_ACCEPT_CONFIDENCE.
+func NutritionPolicyFromEnv() NutritionPolicy {
+	confidence, err := strconv.ParseFloat(config.GetEnv("NUTRITION_ACCEPT_CONFIDENCE", ""), 64)
+	if err != nil || confidence <= 0 || confidence > 1 {
+		confidence = defaultAcceptConfidence
 	}
+	return NutritionPolicy{AcceptConfidence: confidence}
+}
 
-	if brandName != "" {
-		// Tier 1: Brand + Specific Product Name
-		// Check if brand is already at the start of product name to avoid "Mooz Mooz ..."
-		fullQuery := cleanProductName
-		if !strings.HasPrefix(strings.ToLower(cleanProductName), strings.ToLower(brandName)) {
-			fullQuery = brandName + " " + cleanProductName
-		}
-		queries = append(queries, fullQuery)
-
-		// Tier 2: Brand + Ingredient Name
-		queries = append(queries, brandName+" "+item.Ingredient.Name)
-
-		// Tier 3: Brand + Simplified Product Name (most specific noun)
-		// Strip common generic words that clutter search
-		stripWords := []string{"organic", "set", "artisanal", "pure", "fresh", "toned", "natural"}
-		simplified := strings.ToLower(cleanProductName)
-		for _, w := range stripWords {
-			simplified = strings.ReplaceAll(simplified, w, "")
+// Best returns the index of the source to use: the most confident, then
+// label data over estimates, then the most recently fetched. It returns -1
+// when there are none.
+func (p NutritionPolicy) Best(sources []models.NutritionSource) int {
+	best := -1
+	for i, s := range sources {
+		if best < 0 {
+			best = i
+			continue
 		}
-		parts := strings.Fields(simplified)
-		if len(parts) > 0 {
-			// Try Brand + Last Word (usually the noun)
-			nounSearch := brandName + " " + parts[len(parts)-1]
-			if !strings.Contains(strings.ToLower(fullQuery), strings.ToLower(nounSearch)) {
-				queries = append(queries, nounSearch)
+		b := sources[best]
+		switch {
+		case s.Confidence != b.Confidence:
+			if s.Confidence > b.Confidence {
+				best = i
 			}
-		}
-	} else {
-		if cleanProductName != "" {
-			queries = append(queries, cleanProductName)
-		}
-		if item.Ingredient.Name != "" {
-			queries = append(queries, item.Ingredient.Name)
-		}
-	}
-
-	uniqueQueries := []string{}
-	seen := make(map[string]bool)
-	for _, q := range queries {
-		q = strings.TrimSpace(q)
-		if q != "" && !seen[strings.ToLower(q)] {
-			uniqueQueries = append(uniqueQueries, q)
-			seen[strings.ToLower(q)] = true
+		case s.Verified != b.Verified:
+			if s.Verified {
+				best = i
+			}
+		case s.FetchedAt.After(b.FetchedAt):
+			best = i
 		}
 	}
+	return best
+}
 
-	limit := 3
-	for i, query := range uniqueQueries {
-		if i >= limit {
-			break
-		}
-		query = strings.TrimSpace(query)
-		if query == "" {
-			continue
-		}
-		logger.Info("Searching Open Food Facts", "query", query)
-		url := fmt.Sprintf("https://world.openfoodfacts.org/cgi/search.pl?search_terms=%s&search_simple=1&action=process&json=1", strings.ReplaceAll(query, " ", "+"))
-
-		client := &http.Client{Timeout: 2 * time.Second}
-		resp, err := client.Get(url)
+// Lookup asks each provider in the chain for the item's nutrition until one
+// is confident enough for the policy, returning what each provider found.
+func (s *NutritionService) Lookup(item *models.Item) []models.NutritionSource {
+	var sources []models.NutritionSource
+	for _, p := range s.providers {
+		source, err := p.Lookup(item)
 		if err != nil {
-			logger.Warn("Open Food Facts search failed or timed out", "query", query, "error", err)
+			logger.Info("Nutrition provider found nothing", "provider", p.Name(), "item", item.Name, "error", err)
 			continue
 		}
-		defer resp.Body.Close()
-
-		var result struct {
-			Products []struct {
-				Nutriments struct {
-					EnergyKcal100g    json.Number `json:"energy-kcal_100g"`
-					Proteins100g      json.Number `json:"proteins_100g"`
-					Carbohydrates100g json.Number `json:"carbohydrates_100g"`
-					Fat100g           json.Number `json:"fat_100g"`
-					Fiber100g         json.Number `json:"fiber_100g"`
-				} `json:"nutriments"`
-			} `json:"products"`
-		}
-
-		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-			logger.Warn("Failed to decode Open Food Facts response", "query", query, "error", err)
-			continue
-		}
-
-		if len(result.Products) > 0 {
-			p := result.Products[0]
-			kcal, _ := p.Nutriments.EnergyKcal100g.Float64()
-			protein, _ := p.Nutriments.Proteins100g.Float64()
-			carbs, _ := p.Nutriments.Carbohydrates100g.Float64()
-			fat, _ := p.Nutriments.Fat100g.Float64()
-			fiber, _ := p.Nutriments.Fiber100g.Float64()
-
-			// Only accept if we have meaningful energy data
-			if kcal > 0 {
-				item.Calories = kcal
-				item.Protein = protein
-				item.Carbs = carbs
-				item.Fat = fat
-				item.Fiber = fiber
-				item.NutritionVerified = true
-				logger.Info("Nutrition fetched from Open Food Facts", "item", item.Name, "query", query)
-				return nil
-			}
-			logger.Warn("Open Food Facts returned zero calories", "query", query)
+		source.ItemID = item.ID
+		source.Provider = p.Name()
+		source.FetchedAt = time.Now()
+		normalizeSourceBasis(item, source)
+		sources = append(sources, *source)
+		if source.Confidence >= s.policy.AcceptConfidence {
+			break
 		}
 	}
-
-	return fmt.Errorf("no valid products found on Open Food Facts for any tried queries")
+	return sources
 }
 
-func (s *NutritionService) estimateWithLLM(item *models.Item) error {
-	logger.Info("Using LLM to estimate nutrition", "item", item.Name)
+// FetchItemNutrition looks up the item's nutrition and sets its macros,
+// other nutrients and diet profile from the best source by policy, among
+// those found now and those recorded before. For a saved item the sources
+// and its nutrients are recorded, one per provider (a refetch replaces the
+// provider's earlier source), with the source used marked selected; the
+// item itself is left for the caller to save.
+func (s *NutritionService) FetchItemNutrition(item *models.Item) error {
+	found := s.Lookup(item)
 
-	unitType := "per 100g"
-	isCountBased := false
-	lowerUnit := strings.ToLower(item.Unit)
-	if lowerUnit == "pc" || lowerUnit == "pcs" || lowerUnit == "unit" || lowerUnit == "units" || lowerUnit == "piece" || lowerUnit == "pieces" || lowerUnit == "pack" || lowerUnit == "dozen" {
-		unitType = "per 1 unit/piece"
-		isCountBased = true
-	} else if lowerUnit == "ml" || lowerUnit == "l" {
-		unitType = "per 100ml"
+	persist := item.ID != 0 && database.DB != nil
+	var recorded []models.NutritionSource
+	if persist {
+		if err := database.DB.Where("item_id = ?", item.ID).Find(&recorded).Error; err != nil {
+			return err
+		}
 	}
 
-	prompt := fmt.Sprintf(`Provide nutritional information %s for this item. 
-Item: %s (Brand: %s, Ingredient: %s, Unit: %s)
-
-Return ONLY a JSON object:
-{
-  "calories": float,
-  "protein": float,
-  "carbs": float,
-  "fat": float,
-  "fiber": float
-}`, unitType, item.ProductName, func() string {
-		if item.Brand != nil {
-			return item.Brand.Name
+	// Earlier sources from providers that answered again are superseded
+	refetched := make(map[string]bool, len(found))
+	for _, f := range found {
+		refetched[f.Provider] = true
+	}
+	var previous []models.NutritionSource
+	for _, p := range recorded {
+		if !refetched[p.Provider] {
+			normalizeSourceBasis(item, &p)
+			previous = append(previous, p)
 		}
-		return "Unknown"
-	}(), item.Ingredient.Name, item.Unit)
-
-	resp, err := s.llmClient.Chat([]llm.Message{
-		{Role: "system", Content: fmt.Sprintf("You are a nutrition expert. Provide estimated nutritional data %s. If brand info is unavailable, use average values for the ingredient.", unitType)},
-		{Role: "user", Content: prompt},
-	})
-	if err != nil {
-		return err
 	}
-
-	// Clean output from possible markdown code blocks
-	cleanResp := strings.TrimSpace(resp)
-	if strings.HasPrefix(cleanResp, "```json") {
-		cleanResp = strings.TrimPrefix(cleanResp, "```json")
-		cleanResp = strings.TrimSuffix(cleanResp, "```")
+	sources := append(previous, found...)
+	best := s.policy.Best(sources)
+	if best < 0 {
+		return fmt.Errorf("no nutrition provider found nutrition for %q", item.Name)
 	}
-
-	var data struct {
-		Calories float64 `json:"calories"`
-		Protein  float64 `json:"protein"`
-		Carbs    float64 `json:"carbs"`
-		Fat      float64 `json:"fat"`
-		Fiber    float64 `json:"fiber"`
+	for i := range sources {
+		sources[i].Selected = i == best
 	}
+	applyNutritionSource(item, sources[best])
+	logger.Info("Nutrition source selected", "item", item.Name, "provider", sources[best].Provider, "confidence", sources[best].Confidence, "matched", sources[best].MatchedProduct)
 
-	if err := json.Unmarshal([]byte(cleanResp), &data); err != nil {
-		return err
+	if !persist {
+		return nil
 	}
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.NutritionSource{}).Where("item_id = ? AND selected = ?", item.ID, true).Update("selected", false).Error; err != nil {
+			return err
+		}
+		if best < len(previous) {
+			if err := tx.Model(&models.NutritionSource{}).Where("id = ?", sources[best].ID).Update("selected", true).Error; err != nil {
+				return err
+			}
+		}
+		if newSources := sources[len(previous):]; len(newSources) > 0 {
+			err := tx.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "item_id"}, {Name: "provider"}},
+				UpdateAll: true,
+			}).Create(&newSources).Error
+			if err != nil {
+				return err
+			}
+		}
+		if err := tx.Where("item_id = ?", item.ID).Delete(&models.ItemNutrient{}).Error; err != nil {
+			return err
+		}
+		if len(item.Nutrients) > 0 {
+			return tx.Create(&item.Nutrients).Error
+		}
+		return nil
+	})
+}
 
-	// Sanity Checks: Ensure values are realistic per 100g
-	// Max possible calories in 100g (pure fat) is ~900.
-	if data.Calories > 900 {
-		logger.Warn("Insane calorie value detected, capping at 900", "val", data.Calories)
-		data.Calories = 900
-	}
-	// Max macros per 100g is 100g
-	if data.Protein > 100 {
-		data.Protein = 100
-	}
-	if data.Carbs > 100 {
-		data.Carbs = 100
-	}
-	if data.Fat > 100 {
-		data.Fat = 100
-	}
-	if data.Fiber > 100 {
-		data.Fiber = 100
+// applyNutritionSource copies a source's macros with their basis, and its
+// nutrients and diet profile, onto the item.
+func applyNutritionSource(item *models.Item, source models.NutritionSource) {
+	item.Calories = source.Calories
+	item.Protein = source.Protein
+	item.Carbs = source.Carbs
+	item.Fat = source.Fat
+	item.Fiber = source.Fiber
+	item.ServingWeight = source.ServingWeight
+	item.ServingUnit = source.ServingUnit
+	item.NutritionVerified = source.Verified
+	item.NutritionProvider = source.Provider
+	item.DietProfile = source.DietProfile
+
+	item.Nutrients = make([]models.ItemNutrient, 0, len(source.Nutrients))
+	for _, n := range source.Nutrients {
+		n.ID, n.ItemID = 0, item.ID
+		item.Nutrients = append(item.Nutrients, n)
 	}
+}
 
-	item.Calories = data.Calories
-	item.Protein = data.Protein
-	item.Carbs = data.Carbs
-	item.Fat = data.Fat
-	item.Fiber = data.Fiber
-	item.NutritionVerified = false // It's an estimation
+// NutritionSources returns an item's recorded sources, the selected one
+// first, then by confidence.
+func NutritionSources(db *gorm.DB, itemID uint) ([]models.NutritionSource, error) {
+	var sources []models.NutritionSource
+	err := db.Where("item_id = ?", itemID).Order("selected DESC, confidence DESC, fetched_at DESC").Find(&sources).Error
+	if err != nil {
+		return nil, err
+	}
+	return sources, nil
+}
 
-	msg := "🔥 Nutrition estimated (per 100g/ml)"
-	if isCountBased {
-		msg = "🔥 Nutrition estimated (per piece/unit)"
+// EstimateForItem is the nutrition in quantity of unit of a catalog item,
+// from ComputeMacros.
+func EstimateForItem(item *models.Item, quantity float64, unit string) (*FoodEstimate, error) {
+	macros, err := ComputeMacros(item, quantity, unit)
+	if err != nil {
+		return nil, err
 	}
-	logger.Info(msg, "item", item.Name, "kcal", item.Calories)
-	return nil
+	return &FoodEstimate{
+		Name:        fmt.Sprintf("%g %s %s", quantity, unit, item.Name),
+		Calories:    macros.Calories,
+		Protein:     macros.Protein,
+		Fat:         macros.Fat,
+		Carbs:       macros.Carbs,
+		Sugars:      macros.Sugars,
+		Sodium:      macros.Sodium,
+		DietProfile: item.DietProfile,
+	}, nil
 }
 
 // EstimateNutritionFromQuery estimates nutrition from a text query
@@ -333,23 +242,30 @@ func (s *NutritionService) EstimateNutritionFromQuery(query string) (*FoodEstima
 		return nil, fmt.Errorf("empty query")
 	}
 
+	// "200g paneer" asks about that amount; a bare name about one serving
+	// on the item's basis (100g, 100ml or one piece)
+	quantity, unit, name := units.ParseLeading(query)
+	hasQuantity := name != query
+
 	// 1. Search DB for exact or close match
 	// We'll search Items table.
 	var item models.Item
 	// Try simplified search: Name ILIKE query
-	err := database.DB.Where("name ILIKE ?", query).Or("product_name ILIKE ?", query).Order("nutrition_verified DESC").First(&item).Error
+	err := database.DB.Preload("Ingredient").Preload("Nutrients").Where("name ILIKE ?", name).Or("product_name ILIKE ?", name).Order("nutrition_verified DESC").First(&item).Error
 	if err == nil {
 		// Found it! use its macros
 		// Check if it has non-zero macros
 		if item.Calories > 0 {
-			logger.Info("Found item in DB for query", "query", query, "item", item.Name)
-			return &FoodEstimate{
-				Calories: item.Calories,
-				Protein:  item.Protein,
-				Fat:      item.Fat,
-				Carbs:    item.Carbs,
-				Name:     item.Name,
-			}, nil
+			if !hasQuantity {
+				basis := BasisOf(&item)
+				quantity, unit = basis.Amount, basis.Unit
+			}
+			estimate, err := EstimateForItem(&item, quantity, unit)
+			if err == nil {
+				logger.Info("Found item in DB for query", "query", query, "item", item.Name)
+				return estimate, nil
+			}
+			logger.Warn("Cannot scale item nutrition to the query", "query", query, "item", item.Name, "error", err)
 		}
 	}
 
@@ -374,8 +290,14 @@ Return ONLY a JSON object:
   "protein": float,
   "carbs": float,
   "fat": float,
+  "sugars": float,
+  "sodium_mg": float,
+  "allergens": [string], // any of: %s
+  "vegetarian": bool, // no meat, fish or egg
+  "vegan": bool,
+  "contains_egg": bool,
   "serving_size": string
-}`, query)
+}`, query, strings.Join(allergenCodes(), ", "))
 
 	// Using the same client
 	resp, err := s.llmClient.Chat([]llm.Message{
@@ -393,22 +315,41 @@ Return ONLY a JSON object:
 	}
 
 	var data struct {
-		Calories    float64 `json:"calories"`
-		Protein     float64 `json:"protein"`
-		Carbs       float64 `json:"carbs"`
-		Fat         float64 `json:"fat"`
-		ServingSize string  `json:"serving_size"`
+		Calories    float64  `json:"calories"`
+		Protein     float64  `json:"protein"`
+		Carbs       float64  `json:"carbs"`
+		Fat         float64  `json:"fat"`
+		Sugars      float64  `json:"sugars"`
+		Sodium      float64  `json:"sodium_mg"`
+		Allergens   []string `json:"allergens"`
+		Vegetarian  *bool    `json:"vegetarian"`
+		Vegan       *bool    `json:"vegan"`
+		ContainsEgg *bool    `json:"contains_egg"`
+		ServingSize string   `json:"serving_size"`
 	}
 
 	if err := json.Unmarshal([]byte(cleanResp), &data); err != nil {
 		return nil, err
 	}
 
+	diet := models.DietProfile{Vegetarian: data.Vegetarian, Vegan: data.Vegan, ContainsEgg: data.ContainsEgg}
+	var allergens []string
+	for _, a := range data.Allergens {
+		if a = strings.ToLower(strings.TrimSpace(a)); IsAllergen(a) {
+			allergens = appendUnique(allergens, a)
+		}
+	}
+	diet.Allergens = strings.Join(allergens, ",")
+	settleDiet(&diet)
+
 	return &FoodEstimate{
-		Calories: data.Calories,
-		Protein:  data.Protein,
-		Fat:      data.Fat,
-		Carbs:    data.Carbs,
-		Name:     query, // or data.ServingSize + " " + query
+		Calories:    data.Calories,
+		Protein:     data.Protein,
+		Fat:         data.Fat,
+		Carbs:       data.Carbs,
+		Sugars:      data.Sugars,
+		Sodium:      data.Sodium,
+		Name:        query, // or data.ServingSize + " " + query
+		DietProfile: diet,
 	}, nil
 }
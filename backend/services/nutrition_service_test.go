@@ -0,0 +1,179 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/pmitra96/pateproject/models"
+)
+
+// fakeProvider answers every lookup with source, or with err.
+type fakeProvider struct {
+	name   string
+	source models.NutritionSource
+	err    error
+	calls  int
+}
+
+func (f *fakeProvider) Name() string { return f.name }
+
+func (f *fakeProvider) Lookup(item *models.Item) (*models.NutritionSource, error) {
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	source := f.source
+	return &source, nil
+}
+
+func fakeSource(confidence, calories float64) models.NutritionSource {
+	return models.NutritionSource{
+		Confidence:    confidence,
+		Calories:      calories,
+		ServingWeight: 100,
+		ServingUnit:   "g",
+	}
+}
+
+func TestLookupStopsAtAcceptConfidence(t *testing.T) {
+	low := &fakeProvider{name: "low", source: fakeSource(0.5, 100)}
+	high := &fakeProvider{name: "high", source: fakeSource(0.9, 200)}
+	never := &fakeProvider{name: "never", source: fakeSource(1, 300)}
+	s := NewNutritionServiceWith(NutritionPolicy{AcceptConfidence: 0.8}, low, high, never)
+
+	sources := s.Lookup(&models.Item{Name: "Paneer", Unit: "g"})
+	if len(sources) != 2 {
+		t.Fatalf("got %d sources, want 2", len(sources))
+	}
+	if sources[0].Provider != "low" || sources[1].Provider != "high" {
+		t.Errorf("providers = %s, %s; want low, high", sources[0].Provider, sources[1].Provider)
+	}
+	if never.calls != 0 {
+		t.Errorf("provider after an accepted source was asked %d times", never.calls)
+	}
+}
+
+func TestLookupAsksEveryProviderBelowAcceptConfidence(t *testing.T) {
+	a := &fakeProvider{name: "a", source: fakeSource(0.3, 100)}
+	b := &fakeProvider{name: "b", source: fakeSource(0.6, 200)}
+	s := NewNutritionServiceWith(NutritionPolicy{AcceptConfidence: 0.8}, a, b)
+
+	if sources := s.Lookup(&models.Item{Name: "Paneer", Unit: "g"}); len(sources) != 2 {
+		t.Fatalf("got %d sources, want 2", len(sources))
+	}
+}
+
+func TestFetchItemNutritionFallsBackWhenProviderErrors(t *testing.T) {
+	broken := &fakeProvider{name: "broken", err: errors.New("service unavailable")}
+	backup := &fakeProvider{name: "backup", source: fakeSource(0.7, 265)}
+	s := NewNutritionServiceWith(NutritionPolicy{AcceptConfidence: 0.8}, broken, backup)
+
+	item := &models.Item{Name: "Paneer", Unit: "g"}
+	if err := s.FetchItemNutrition(item); err != nil {
+		t.Fatalf("FetchItemNutrition: %v", err)
+	}
+	if broken.calls != 1 || backup.calls != 1 {
+		t.Errorf("calls = %d, %d; want both providers asked once", broken.calls, backup.calls)
+	}
+	if item.NutritionProvider != "backup" || item.Calories != 265 {
+		t.Errorf("item nutrition from %q with %v kcal; want backup with 265", item.NutritionProvider, item.Calories)
+	}
+}
+
+func TestFetchItemNutritionFailsWhenNothingFound(t *testing.T) {
+	s := NewNutritionServiceWith(NutritionPolicy{AcceptConfidence: 0.8},
+		&fakeProvider{name: "a", err: errors.New("no match")},
+		&fakeProvider{name: "b", err: errors.New("no match")})
+
+	if err := s.FetchItemNutrition(&models.Item{Name: "Paneer", Unit: "g"}); err == nil {
+		t.Fatal("FetchItemNutrition succeeded with no sources")
+	}
+}
+
+func TestFetchItemNutritionPicksBestSource(t *testing.T) {
+	estimate := &fakeProvider{name: "estimate", source: fakeSource(0.6, 300)}
+	label := &fakeProvider{name: "label", source: fakeSource(0.75, 265)}
+	s := NewNutritionServiceWith(NutritionPolicy{AcceptConfidence: 0.8}, estimate, label)
+
+	item := &models.Item{Name: "Paneer", Unit: "g"}
+	if err := s.FetchItemNutrition(item); err != nil {
+		t.Fatalf("FetchItemNutrition: %v", err)
+	}
+	if item.NutritionProvider != "label" {
+		t.Errorf("selected %q, want the more confident label", item.NutritionProvider)
+	}
+}
+
+func TestNutritionPolicyBest(t *testing.T) {
+	now := time.Now()
+	source := func(confidence float64, verified bool, fetchedAt time.Time) models.NutritionSource {
+		return models.NutritionSource{Confidence: confidence, Verified: verified, FetchedAt: fetchedAt}
+	}
+	policy := NutritionPolicy{AcceptConfidence: 0.8}
+
+	tests := []struct {
+		name    string
+		sources []models.NutritionSource
+		want    int
+	}{
+		{"none", nil, -1},
+		{"one", []models.NutritionSource{source(0.2, false, now)}, 0},
+		{
+			"most confident first",
+			[]models.NutritionSource{source(0.7, true, now), source(0.9, false, now.Add(-time.Hour)), source(0.8, true, now)},
+			1,
+		},
+		{
+			"label data over an estimate at equal confidence",
+			[]models.NutritionSource{source(0.8, false, now), source(0.8, true, now.Add(-time.Hour))},
+			1,
+		},
+		{
+			"newest at equal confidence and verification",
+			[]models.NutritionSource{source(0.8, true, now.Add(-time.Hour)), source(0.8, true, now), source(0.8, true, now.Add(-2*time.Hour))},
+			1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := policy.Best(tt.sources); got != tt.want {
+				t.Errorf("Best = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFetchItemNutritionReplacesRefetchedSources(t *testing.T) {
+	db := testDB(t, &models.IngredientCategory{}, &models.Ingredient{}, &models.Brand{},
+		&models.Item{}, &models.ItemNutrient{}, &models.NutritionSource{})
+	item := testItem(t, db, "Test Paneer", "g")
+
+	scraper := &fakeProvider{name: "scraper", source: fakeSource(0.6, 300)}
+	estimate := &fakeProvider{name: "estimate", source: fakeSource(0.5, 280)}
+	s := NewNutritionServiceWith(NutritionPolicy{AcceptConfidence: 0.8}, scraper, estimate)
+	for i := 0; i < 3; i++ {
+		if err := s.FetchItemNutrition(item); err != nil {
+			t.Fatalf("FetchItemNutrition #%d: %v", i+1, err)
+		}
+	}
+
+	scraper.source = fakeSource(0.7, 265)
+	if err := s.FetchItemNutrition(item); err != nil {
+		t.Fatalf("FetchItemNutrition after update: %v", err)
+	}
+
+	sources, err := NutritionSources(db, item.ID)
+	if err != nil {
+		t.Fatalf("NutritionSources: %v", err)
+	}
+	if len(sources) != 2 {
+		t.Fatalf("got %d sources after four fetches, want one per provider", len(sources))
+	}
+	if !sources[0].Selected || sources[0].Provider != "scraper" || sources[0].Calories != 265 {
+		t.Errorf("selected source = %+v, want the refetched scraper source", sources[0])
+	}
+	if sources[1].Selected {
+		t.Error("more than one source selected")
+	}
+}
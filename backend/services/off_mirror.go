@@ -0,0 +1,449 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pmitra96/pateproject/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Open Food Facts dump formats.
+const (
+	OFFFormatJSONL = "jsonl"
+	OFFFormatCSV   = "csv" // Tab-separated as published; comma-separated subsets also work
+)
+
+// OFFImportOptions filters an Open Food Facts import.
+type OFFImportOptions struct {
+	Format  string // OFFFormatJSONL or OFFFormatCSV
+	Country string // Only products sold here, as a countries tag ("en:india"); empty for all
+	Limit   int    // Stop after this many products are imported; 0 for no limit
+}
+
+// OFFImportStats counts what an import did.
+type OFFImportStats struct {
+	Read     int
+	Imported int
+	Skipped  int // Unreadable, no barcode, name or energy, or not sold in the country
+}
+
+const offImportBatchSize = 1000
+
+// offRecord is one product as read from a dump, before filtering.
+type offRecord struct {
+	Code          string
+	ProductName   string
+	Brands        string
+	Quantity      string
+	ServingSize   string
+	Countries     string
+	EnergyKcal    float64
+	EnergyKJ      float64
+	Proteins      float64
+	Carbohydrates float64
+	Fat           float64
+	Fiber         float64
+
+	Sugars, SaturatedFat, Sodium, Iron, Calcium, VitaminB12 float64
+
+	AllergensTags           string
+	IngredientsAnalysisTags string
+}
+
+// ImportOpenFoodFacts reads an Open Food Facts dump (the JSONL or CSV
+// export, or a filtered subset of either) into the local mirror, replacing
+// products already imported under the same barcode. Products without a
+// barcode, name or energy value are skipped, as are records that can't be
+// read; a barcode repeated in the dump keeps its last product.
+func ImportOpenFoodFacts(db *gorm.DB, r io.Reader, opts OFFImportOptions) (OFFImportStats, error) {
+	var stats OFFImportStats
+	now := time.Now()
+	batch := make([]models.OpenFoodFactsProduct, 0, offImportBatchSize)
+	// Position of each barcode in the batch; Postgres rejects an upsert
+	// that touches the same row twice
+	inBatch := make(map[string]int, offImportBatchSize)
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		err := db.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "code"}},
+			UpdateAll: true,
+		}).Create(&batch).Error
+		batch = batch[:0]
+		clear(inBatch)
+		return err
+	}
+
+	errLimit := errors.New("limit reached")
+	skip := func() {
+		stats.Read++
+		stats.Skipped++
+	}
+	add := func(rec offRecord) error {
+		stats.Read++
+		product, ok := rec.product(opts.Country, now)
+		if !ok {
+			stats.Skipped++
+			return nil
+		}
+		if i, ok := inBatch[product.Code]; ok {
+			batch[i] = product
+			return nil
+		}
+		inBatch[product.Code] = len(batch)
+		batch = append(batch, product)
+		stats.Imported++
+		if len(batch) == offImportBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		if opts.Limit > 0 && stats.Imported >= opts.Limit {
+			return errLimit
+		}
+		return nil
+	}
+
+	var err error
+	switch opts.Format {
+	case OFFFormatJSONL:
+		err = readOFFJSONL(r, add, skip)
+	case OFFFormatCSV:
+		err = readOFFCSV(r, add, skip)
+	default:
+		return stats, fmt.Errorf("unknown Open Food Facts format %q", opts.Format)
+	}
+	if err != nil && !errors.Is(err, errLimit) {
+		return stats, err
+	}
+	return stats, flush()
+}
+
+// product turns a dump record into a mirror row, reporting false for
+// records that should be skipped.
+func (rec offRecord) product(country string, importedAt time.Time) (models.OpenFoodFactsProduct, bool) {
+	code := strings.TrimSpace(rec.Code)
+	name := strings.TrimSpace(rec.ProductName)
+	kcal := rec.EnergyKcal
+	if kcal <= 0 && rec.EnergyKJ > 0 {
+		kcal = rec.EnergyKJ / 4.184
+	}
+	if code == "" || len(code) > 32 || name == "" || kcal <= 0 {
+		return models.OpenFoodFactsProduct{}, false
+	}
+	if country != "" && !hasTag(rec.Countries, country) {
+		return models.OpenFoodFactsProduct{}, false
+	}
+	return models.OpenFoodFactsProduct{
+		Code:          code,
+		ProductName:   truncate(name, 255),
+		Brands:        truncate(strings.TrimSpace(rec.Brands), 255),
+		Quantity:      truncate(strings.TrimSpace(rec.Quantity), 100),
+		ServingSize:   truncate(strings.TrimSpace(rec.ServingSize), 100),
+		EnergyKcal:    kcal,
+		Proteins:      rec.Proteins,
+		Carbohydrates: rec.Carbohydrates,
+		Fat:           rec.Fat,
+		Fiber:         rec.Fiber,
+		ImportedAt:    importedAt,
+
+		Sugars:       rec.Sugars,
+		SaturatedFat: rec.SaturatedFat,
+		Sodium:       rec.Sodium,
+		Iron:         rec.Iron,
+		Calcium:      rec.Calcium,
+		VitaminB12:   rec.VitaminB12,
+
+		AllergensTags:           rec.AllergensTags,
+		IngredientsAnalysisTags: rec.IngredientsAnalysisTags,
+	}, true
+}
+
+// hasTag reports whether a comma-separated tag list ("en:india,en:france")
+// contains tag.
+func hasTag(tags, tag string) bool {
+	for _, t := range strings.Split(tags, ",") {
+		if strings.EqualFold(strings.TrimSpace(t), tag) {
+			return true
+		}
+	}
+	return false
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	// Cut on a rune boundary
+	for n > 0 && n < len(s) && s[n]&0xC0 == 0x80 {
+		n--
+	}
+	return s[:n]
+}
+
+// readOFFJSONL reads one product per line, calling skip for lines that
+// aren't a product.
+func readOFFJSONL(r io.Reader, add func(offRecord) error, skip func()) error {
+	br := bufio.NewReaderSize(r, 1<<20)
+	for {
+		line, err := br.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("reading Open Food Facts JSONL: %w", err)
+		}
+		if len(bytes.TrimSpace(line)) > 0 {
+			if rec, ok := parseOFFJSONLine(line); ok {
+				if err := add(rec); err != nil {
+					return err
+				}
+			} else {
+				skip()
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+func parseOFFJSONLine(line []byte) (offRecord, bool) {
+	var p struct {
+		Code                    string   `json:"code"`
+		ProductName             string   `json:"product_name"`
+		Brands                  string   `json:"brands"`
+		Quantity                string   `json:"quantity"`
+		ServingSize             string   `json:"serving_size"`
+		CountriesTags           []string `json:"countries_tags"`
+		AllergensTags           []string `json:"allergens_tags"`
+		IngredientsAnalysisTags []string `json:"ingredients_analysis_tags"`
+		Nutriments              struct {
+			EnergyKcal100g    offNumber `json:"energy-kcal_100g"`
+			Energy100g        offNumber `json:"energy_100g"` // kJ
+			Proteins100g      offNumber `json:"proteins_100g"`
+			Carbohydrates100g offNumber `json:"carbohydrates_100g"`
+			Fat100g           offNumber `json:"fat_100g"`
+			Fiber100g         offNumber `json:"fiber_100g"`
+			offMicronutrients
+		} `json:"nutriments"`
+	}
+	if err := json.Unmarshal(line, &p); err != nil {
+		return offRecord{}, false
+	}
+
+	n := p.Nutriments
+	rec := offRecord{
+		Code:          p.Code,
+		ProductName:   p.ProductName,
+		Brands:        p.Brands,
+		Quantity:      p.Quantity,
+		ServingSize:   p.ServingSize,
+		Countries:     strings.Join(p.CountriesTags, ","),
+		EnergyKcal:    float64(n.EnergyKcal100g),
+		EnergyKJ:      float64(n.Energy100g),
+		Proteins:      float64(n.Proteins100g),
+		Carbohydrates: float64(n.Carbohydrates100g),
+		Fat:           float64(n.Fat100g),
+		Fiber:         float64(n.Fiber100g),
+
+		AllergensTags:           strings.Join(p.AllergensTags, ","),
+		IngredientsAnalysisTags: strings.Join(p.IngredientsAnalysisTags, ","),
+	}
+	n.offMicronutrients.read(&rec)
+	return rec, true
+}
+
+// readOFFCSV reads a header row and one product per row, calling skip for
+// rows with a value that isn't a number in a nutrient column.
+func readOFFCSV(r io.Reader, add func(offRecord) error, skip func()) error {
+	br := bufio.NewReaderSize(r, 1<<20)
+	header, err := br.ReadString('\n')
+	if err != nil && header == "" {
+		return fmt.Errorf("reading Open Food Facts CSV header: %w", err)
+	}
+
+	// The published export is tab-separated; subsets are often commas
+	delimiter := ','
+	if strings.Count(header, "\t") > strings.Count(header, ",") {
+		delimiter = '\t'
+	}
+	newReader := func(r io.Reader) *csv.Reader {
+		cr := csv.NewReader(r)
+		cr.Comma = delimiter
+		cr.LazyQuotes = true
+		cr.FieldsPerRecord = -1
+		cr.ReuseRecord = true
+		return cr
+	}
+
+	columns, err := newReader(strings.NewReader(header)).Read()
+	if err != nil {
+		return fmt.Errorf("reading Open Food Facts CSV header: %w", err)
+	}
+	index := make(map[string]int, len(columns))
+	for i, c := range columns {
+		index[strings.TrimSpace(c)] = i
+	}
+	if _, ok := index["code"]; !ok {
+		return fmt.Errorf("Open Food Facts CSV has no code column")
+	}
+
+	cr := newReader(br)
+	for {
+		row, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("reading Open Food Facts CSV: %w", err)
+		}
+
+		field := func(name string) string {
+			if i, ok := index[name]; ok && i < len(row) {
+				return row[i]
+			}
+			return ""
+		}
+		valid := true
+		number := func(name string) float64 {
+			v, err := parseOFFNumber(field(name))
+			if err != nil {
+				valid = false
+			}
+			return v
+		}
+		allergens := field("allergens_tags")
+		if allergens == "" {
+			allergens = field("allergens")
+		}
+		rec := offRecord{
+			Code:          field("code"),
+			ProductName:   field("product_name"),
+			Brands:        field("brands"),
+			Quantity:      field("quantity"),
+			ServingSize:   field("serving_size"),
+			Countries:     field("countries_tags"),
+			EnergyKcal:    number("energy-kcal_100g"),
+			EnergyKJ:      number("energy_100g"),
+			Proteins:      number("proteins_100g"),
+			Carbohydrates: number("carbohydrates_100g"),
+			Fat:           number("fat_100g"),
+			Fiber:         number("fiber_100g"),
+
+			Sugars:       number("sugars_100g"),
+			SaturatedFat: number("saturated-fat_100g"),
+			Sodium:       number("sodium_100g"),
+			Iron:         number("iron_100g"),
+			Calcium:      number("calcium_100g"),
+			VitaminB12:   number("vitamin-b12_100g"),
+
+			AllergensTags:           allergens,
+			IngredientsAnalysisTags: field("ingredients_analysis_tags"),
+		}
+		if !valid {
+			skip()
+			continue
+		}
+		if err := add(rec); err != nil {
+			return err
+		}
+	}
+}
+
+// offNumber is a nutrient value from a dump. Most are JSON numbers, but
+// some products have them quoted, empty or with a decimal comma.
+type offNumber float64
+
+func (n *offNumber) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		*n = 0
+		return nil
+	}
+	if strings.HasPrefix(s, `"`) {
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+	}
+	v, err := parseOFFNumber(s)
+	if err != nil {
+		return err
+	}
+	*n = offNumber(v)
+	return nil
+}
+
+// parseOFFNumber reads a nutrient value, reporting an empty one as zero.
+func parseOFFNumber(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	v, err := strconv.ParseFloat(strings.Replace(s, ",", ".", 1), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid nutrient value %q", s)
+	}
+	return v, nil
+}
+
+// offMicronutrients are the nutrients beyond the macros in an Open Food
+// Facts product's nutriments, in grams per 100g.
+type offMicronutrients struct {
+	Sugars100g       offNumber `json:"sugars_100g"`
+	SaturatedFat100g offNumber `json:"saturated-fat_100g"`
+	Sodium100g       offNumber `json:"sodium_100g"`
+	Iron100g         offNumber `json:"iron_100g"`
+	Calcium100g      offNumber `json:"calcium_100g"`
+	VitaminB12100g   offNumber `json:"vitamin-b12_100g"`
+}
+
+func (n offMicronutrients) read(rec *offRecord) {
+	rec.Sugars = float64(n.Sugars100g)
+	rec.SaturatedFat = float64(n.SaturatedFat100g)
+	rec.Sodium = float64(n.Sodium100g)
+	rec.Iron = float64(n.Iron100g)
+	rec.Calcium = float64(n.Calcium100g)
+	rec.VitaminB12 = float64(n.VitaminB12100g)
+}
+
+// LookupOpenFoodFactsBarcode finds a product in the local mirror by barcode,
+// in any of the forms the dump may have it under.
+func LookupOpenFoodFactsBarcode(db *gorm.DB, code string) (*models.OpenFoodFactsProduct, bool) {
+	gtin, err := NormalizeGTIN(code)
+	if err != nil {
+		return nil, false
+	}
+	var product models.OpenFoodFactsProduct
+	if err := db.Where("code IN ?", gtinVariants(gtin)).First(&product).Error; err != nil {
+		return nil, false
+	}
+	return &product, true
+}
+
+// SearchOpenFoodFacts full-text searches the local mirror's brands and
+// product names, best match first.
+func SearchOpenFoodFacts(db *gorm.DB, query string, limit int) ([]models.OpenFoodFactsProduct, error) {
+	var products []models.OpenFoodFactsProduct
+	if strings.TrimSpace(query) == "" {
+		return products, nil
+	}
+	const document = "to_tsvector('simple', brands || ' ' || product_name)"
+	err := db.Where(document+" @@ plainto_tsquery('simple', ?)", query).
+		Order(clause.OrderBy{Expression: clause.Expr{
+			SQL:  "ts_rank(" + document + ", plainto_tsquery('simple', ?)) DESC, code",
+			Vars: []interface{}{query},
+		}}).
+		Limit(limit).
+		Find(&products).Error
+	return products, err
+}
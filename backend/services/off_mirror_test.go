@@ -0,0 +1,162 @@
+package services
+
+import (
+	"math"
+	"os"
+	"testing"
+
+	"github.com/pmitra96/pateproject/models"
+	"gorm.io/gorm"
+)
+
+func TestParseOFFNumber(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    float64
+		wantErr bool
+	}{
+		{"", 0, false},
+		{" 58 ", 58, false},
+		{"3.1", 3.1, false},
+		{"3,1", 3.1, false},
+		{"n/a", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseOFFNumber(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseOFFNumber(%q) = %v, %v; want %v, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+// readOFFFixture reads a testdata dump without a database, returning the
+// records read and how many were skipped.
+func readOFFFixture(t *testing.T, name string, read func(*os.File, func(offRecord) error, func()) error) ([]offRecord, int) {
+	t.Helper()
+	f, err := os.Open("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var records []offRecord
+	skipped := 0
+	err = read(f, func(rec offRecord) error {
+		records = append(records, rec)
+		return nil
+	}, func() { skipped++ })
+	if err != nil {
+		t.Fatalf("reading %s: %v", name, err)
+	}
+	return records, skipped
+}
+
+func TestReadOFFJSONLSkipsBadRecords(t *testing.T) {
+	records, skipped := readOFFFixture(t, "off_products.jsonl", func(f *os.File, add func(offRecord) error, skip func()) error {
+		return readOFFJSONL(f, add, skip)
+	})
+	// The unreadable nutrient and the truncated line
+	if skipped != 2 {
+		t.Errorf("skipped %d records, want 2", skipped)
+	}
+	if len(records) != 4 {
+		t.Fatalf("read %d records, want 4", len(records))
+	}
+	milk := records[1]
+	if milk.EnergyKcal != 58 || milk.Proteins != 3.1 || milk.Carbohydrates != 4.7 || milk.Fat != 0 {
+		t.Errorf("quoted nutriments read as %+v", milk)
+	}
+}
+
+func TestReadOFFCSVSkipsBadRows(t *testing.T) {
+	records, skipped := readOFFFixture(t, "off_products.csv", func(f *os.File, add func(offRecord) error, skip func()) error {
+		return readOFFCSV(f, add, skip)
+	})
+	if skipped != 1 {
+		t.Errorf("skipped %d rows, want 1", skipped)
+	}
+	if len(records) != 3 {
+		t.Fatalf("read %d rows, want 3", len(records))
+	}
+	if atta := records[1]; atta.EnergyKJ != 1490 || atta.Proteins != 12.1 {
+		t.Errorf("atta read as %+v", atta)
+	}
+}
+
+func importOFFFixture(t *testing.T, db *gorm.DB, name, format string) OFFImportStats {
+	t.Helper()
+	f, err := os.Open("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	stats, err := ImportOpenFoodFacts(db, f, OFFImportOptions{Format: format, Country: "en:india"})
+	if err != nil {
+		t.Fatalf("ImportOpenFoodFacts(%s): %v", name, err)
+	}
+	return stats
+}
+
+func TestImportOpenFoodFacts(t *testing.T) {
+	db := testDB(t, &models.OpenFoodFactsProduct{})
+
+	jsonl := importOFFFixture(t, db, "off_products.jsonl", OFFFormatJSONL)
+	if want := (OFFImportStats{Read: 6, Imported: 2, Skipped: 3}); jsonl != want {
+		t.Errorf("JSONL import = %+v, want %+v", jsonl, want)
+	}
+	csv := importOFFFixture(t, db, "off_products.csv", OFFFormatCSV)
+	if want := (OFFImportStats{Read: 4, Imported: 2, Skipped: 1}); csv != want {
+		t.Errorf("CSV import = %+v, want %+v", csv, want)
+	}
+
+	// A barcode repeated in a dump keeps its last product
+	paneer, ok := LookupOpenFoodFactsBarcode(db, "8909990000019")
+	if !ok {
+		t.Fatal("paneer not in the mirror")
+	}
+	if paneer.EnergyKcal != 270 {
+		t.Errorf("paneer has %v kcal, want the last record's 270", paneer.EnergyKcal)
+	}
+	ghee, ok := LookupOpenFoodFactsBarcode(db, "8909990000033")
+	if !ok || ghee.EnergyKcal != 898 {
+		t.Errorf("ghee = %+v, %v; want the last row's 898 kcal", ghee, ok)
+	}
+	atta, ok := LookupOpenFoodFactsBarcode(db, "8909990000040")
+	if !ok || math.Abs(atta.EnergyKcal-1490/4.184) > 0.01 {
+		t.Errorf("atta = %+v, %v; want its energy converted from kJ", atta, ok)
+	}
+	if _, ok := LookupOpenFoodFactsBarcode(db, "8909990000057"); ok {
+		t.Error("product without a readable nutrient was imported")
+	}
+
+	products, err := SearchOpenFoodFacts(db, "zorvik milk", 5)
+	if err != nil {
+		t.Fatalf("SearchOpenFoodFacts: %v", err)
+	}
+	if len(products) != 1 || products[0].Code != "8909990000026" {
+		t.Errorf("search for zorvik milk = %+v, want the toned milk", products)
+	}
+
+	mirror := OFFMirrorNutrition{DB: db}
+	source, err := mirror.Lookup(&models.Item{Name: "Paneer", GTIN: "8909990000019", Unit: "g"})
+	if err != nil {
+		t.Fatalf("Lookup by barcode: %v", err)
+	}
+	if source.Calories != 270 || source.ServingWeight != 100 || source.ServingUnit != "g" {
+		t.Errorf("barcode source = %+v", source)
+	}
+	source, err = mirror.Lookup(&models.Item{
+		Name:        "Zorvik Toned Milk",
+		ProductName: "Toned Milk",
+		Unit:        "ml",
+		Brand:       &models.Brand{Name: "Zorvik Dairy"},
+		Ingredient:  models.Ingredient{Name: "Milk"},
+	})
+	if err != nil {
+		t.Fatalf("Lookup by name: %v", err)
+	}
+	if source.Calories != 58 || source.Protein != 3.1 || source.ServingUnit != "ml" {
+		t.Errorf("name source = %+v, want the toned milk per 100 ml", source)
+	}
+}
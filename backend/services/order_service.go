@@ -0,0 +1,215 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/pmitra96/pateproject/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrOrderCancelled is returned when a cancelled order is amended.
+	ErrOrderCancelled = errors.New("order is cancelled")
+	// ErrOrderItemNotFound is returned when an amendment names a line that
+	// is not on the order.
+	ErrOrderItemNotFound = errors.New("order item not found")
+)
+
+// OrderLineChange sets the delivered quantity of an order line (in its
+// item's unit) or removes the line.
+type OrderLineChange struct {
+	OrderItemID uint     `json:"order_item_id"`
+	Quantity    *float64 `json:"quantity"`
+	Remove      bool     `json:"remove"`
+}
+
+// AmendOrder applies line changes to an order, e.g. an item that was not
+// delivered or was refunded, moving the differences into or out of the
+// pantry. Quantities are absolute, so repeating an amendment changes nothing.
+func AmendOrder(db *gorm.DB, order *models.Order, changes []OrderLineChange, reason string) error {
+	if order.Status == models.OrderCancelled {
+		return ErrOrderCancelled
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		var notes []string
+		for _, change := range changes {
+			var line models.OrderItem
+			err := tx.Unscoped().Preload("Item.Ingredient").
+				Where("id = ? AND order_id = ?", change.OrderItemID, order.ID).First(&line).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return fmt.Errorf("%w: %d", ErrOrderItemNotFound, change.OrderItemID)
+			}
+			if err != nil {
+				return err
+			}
+			if line.DeletedAt.Valid {
+				continue // Already removed
+			}
+
+			quantity := line.Quantity
+			switch {
+			case change.Remove:
+				quantity = 0
+			case change.Quantity != nil:
+				quantity = math.Max(*change.Quantity, 0)
+			}
+			if quantity == line.Quantity && !change.Remove {
+				continue
+			}
+
+			if err := reverseOrderLine(tx, order, &line, quantity-line.Quantity, reason); err != nil {
+				return err
+			}
+			if change.Remove {
+				// The removed line keeps its quantity for the record
+				if err := tx.Delete(&line).Error; err != nil {
+					return err
+				}
+				notes = append(notes, fmt.Sprintf("%s: removed (%g %s)", line.RawName, line.Quantity, line.Item.Unit))
+				continue
+			}
+			notes = append(notes, fmt.Sprintf("%s: %g -> %g %s", line.RawName, line.Quantity, quantity, line.Item.Unit))
+			// Prices are for the whole line, so a partial refund scales them
+			if line.Quantity > 0 {
+				line.LinePrice.Scale(quantity / line.Quantity)
+			}
+			line.Quantity = quantity
+			if err := tx.Omit(clause.Associations).Save(&line).Error; err != nil {
+				return err
+			}
+		}
+
+		if len(notes) == 0 {
+			return nil
+		}
+		return changeOrderStatus(tx, order, models.OrderAmended, reason, strings.Join(notes, "; "))
+	})
+}
+
+// CancelOrder reverses the pantry effect of every line of an order and
+// rejects its pending reviews. The order and its lines are kept as placed,
+// so ingesting it again is still skipped as a duplicate. Cancelling twice is
+// a no-op.
+func CancelOrder(db *gorm.DB, order *models.Order, reason string) error {
+	if order.Status == models.OrderCancelled {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		var lines []models.OrderItem
+		if err := tx.Preload("Item.Ingredient").Where("order_id = ?", order.ID).Find(&lines).Error; err != nil {
+			return err
+		}
+		for i := range lines {
+			if err := reverseOrderLine(tx, order, &lines[i], -lines[i].Quantity, reason); err != nil {
+				return err
+			}
+		}
+
+		now := time.Now()
+		err := tx.Model(&models.ExtractionReview{}).
+			Where("order_id = ? AND status = ?", order.ID, models.ReviewPending).
+			Updates(map[string]interface{}{"status": models.ReviewRejected, "reviewed_at": now}).Error
+		if err != nil {
+			return err
+		}
+
+		return changeOrderStatus(tx, order, models.OrderCancelled, reason, fmt.Sprintf("%d lines reversed", len(lines)))
+	})
+}
+
+// reverseOrderLine moves a change in an order line's quantity (delta, in the
+// item's unit) into or out of the pantry. Stock is taken back from the
+// line's own batch first; whatever was already used from it comes out of
+// other batches, as long as there is stock left. Item.Ingredient must be
+// preloaded.
+func reverseOrderLine(tx *gorm.DB, order *models.Order, line *models.OrderItem, delta float64, reason string) error {
+	if delta == 0 {
+		return nil
+	}
+
+	if delta < 0 {
+		// Nothing to take back from a pantry item the user has deleted
+		var count int64
+		tx.Model(&models.PantryItem{}).Where("user_id = ? AND ingredient_id = ?", order.UserID, line.Item.IngredientID).Count(&count)
+		if count == 0 {
+			return nil
+		}
+	}
+
+	pi, err := PantryItemFor(tx, order.UserID, line.Item)
+	if err != nil {
+		return err
+	}
+	change, err := ToPantryUnit(pi, delta, line.Item.Unit)
+	if err != nil {
+		return err
+	}
+	movement := Movement{
+		Source:      models.MovementSourceOrderAdjustment,
+		OrderItemID: &line.ID,
+		Note:        reason,
+		PurchasedAt: order.OrderDate,
+	}
+
+	var batch models.PantryBatch
+	hasBatch := tx.Where("order_item_id = ? AND pantry_item_id = ?", line.ID, pi.ID).First(&batch).Error == nil
+
+	if change > 0 {
+		if hasBatch {
+			movement.BatchID = &batch.ID
+		}
+		if _, err := applyDelta(tx, pi, change, movement); err != nil {
+			return err
+		}
+		if hasBatch {
+			if err := tx.Model(&batch).Update("quantity", batch.Quantity+change).Error; err != nil {
+				return err
+			}
+		}
+	} else {
+		remove := -change
+		if hasBatch {
+			fromBatch := math.Min(remove, batch.Remaining)
+			if fromBatch > 0 {
+				movement.BatchID = &batch.ID
+				applied, err := applyDelta(tx, pi, -fromBatch, movement)
+				if err != nil {
+					return err
+				}
+				remove += applied
+			}
+			if err := tx.Model(&batch).Update("quantity", math.Max(batch.Quantity+change, 0)).Error; err != nil {
+				return err
+			}
+		}
+		if remove > 1e-9 {
+			movement.BatchID = nil
+			if _, err := applyDelta(tx, pi, -remove, movement); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func changeOrderStatus(tx *gorm.DB, order *models.Order, status, reason, note string) error {
+	change := models.OrderStatusChange{
+		OrderID:    order.ID,
+		FromStatus: order.Status,
+		ToStatus:   status,
+		Reason:     reason,
+		Note:       note,
+	}
+	if err := tx.Create(&change).Error; err != nil {
+		return err
+	}
+	order.Status = status
+	return tx.Model(order).Update("status", status).Error
+}
@@ -0,0 +1,150 @@
+package services
+
+import (
+	"strings"
+	"time"
+
+	"github.com/pmitra96/pateproject/models"
+	"gorm.io/gorm"
+)
+
+// shelfLifeRules default a batch's best-before date from the ingredient
+// name. Earlier rules win, so "paneer" is dairy before anything else.
+var shelfLifeRules = []struct {
+	category string
+	days     int
+	keywords []string
+}{
+	{"meat_fish", 2, []string{"chicken", "mutton", "fish", "prawn", "prawns", "meat", "keema"}},
+	{"leafy_greens", 3, []string{"spinach", "palak", "coriander", "methi", "lettuce", "mint", "pudina"}},
+	{"bakery", 4, []string{"bread", "bun", "buns", "pav"}},
+	{"fresh_dairy", 5, []string{"milk", "curd", "yogurt", "yoghurt", "dahi", "paneer", "cream", "buttermilk", "lassi", "tofu"}},
+	{"fruit", 6, []string{"banana", "apple", "orange", "grapes", "mango", "papaya", "berries", "strawberry"}},
+	{"vegetables", 7, []string{"tomato", "capsicum", "broccoli", "carrot", "cucumber", "beans", "cauliflower", "cabbage", "mushroom", "mushrooms", "lemon"}},
+	{"eggs", 21, []string{"egg", "eggs"}},
+	{"aged_dairy", 30, []string{"cheese", "butter", "ghee"}},
+	{"roots", 30, []string{"onion", "potato", "garlic", "ginger"}},
+}
+
+// ShelfLifeCategory returns the shelf-life category of an ingredient, or ""
+// for shelf-stable goods.
+func ShelfLifeCategory(ingredient string) string {
+	category, _ := shelfLife(ingredient)
+	return category
+}
+
+func shelfLife(ingredient string) (string, int) {
+	words := strings.Fields(strings.ToLower(ingredient))
+	for _, rule := range shelfLifeRules {
+		for _, kw := range rule.keywords {
+			for _, w := range words {
+				if w == kw {
+					return rule.category, rule.days
+				}
+			}
+		}
+	}
+	return "", 0
+}
+
+// DefaultBestBefore is the best-before date for an ingredient bought at
+// purchasedAt, or nil if it does not meaningfully spoil.
+func DefaultBestBefore(ingredient string, purchasedAt time.Time) *time.Time {
+	_, days := shelfLife(ingredient)
+	if days == 0 {
+		return nil
+	}
+	t := purchasedAt.AddDate(0, 0, days)
+	return &t
+}
+
+// openBatch starts a new batch for stock added to a pantry item.
+func openBatch(tx *gorm.DB, pi *models.PantryItem, quantity float64, m Movement) (*models.PantryBatch, error) {
+	purchasedAt := m.PurchasedAt
+	if purchasedAt.IsZero() {
+		purchasedAt = time.Now()
+	}
+	batch := &models.PantryBatch{
+		PantryItemID:     pi.ID,
+		UserID:           pi.UserID,
+		OrderItemID:      m.OrderItemID,
+		Quantity:         quantity,
+		Unit:             PantryUnit(pi),
+		PurchasedAt:      purchasedAt,
+		BestBefore:       m.BestBefore,
+		BestBeforeSource: models.BestBeforeManual,
+	}
+	if batch.BestBefore == nil {
+		batch.BestBefore = DefaultBestBefore(pi.Ingredient.Name, purchasedAt)
+		batch.BestBeforeSource = models.BestBeforeDefault
+	}
+	if err := tx.Create(batch).Error; err != nil {
+		return nil, err
+	}
+	return batch, nil
+}
+
+// consumableBatches returns the batches a removal draws from: the targeted
+// batch, or every batch with stock left, oldest purchase first.
+func consumableBatches(tx *gorm.DB, pi *models.PantryItem, batchID *uint) ([]models.PantryBatch, error) {
+	query := tx.Where("pantry_item_id = ? AND remaining > 0", pi.ID)
+	if batchID != nil {
+		query = query.Where("id = ?", *batchID)
+	}
+	var batches []models.PantryBatch
+	err := query.Order("purchased_at, id").Find(&batches).Error
+	return batches, err
+}
+
+// ExpiringBatch is a batch that spoils soon, with its pantry context.
+type ExpiringBatch struct {
+	models.PantryBatch
+	Ingredient string  `json:"ingredient"`
+	ItemName   string  `json:"item_name"`
+	DaysLeft   float64 `json:"days_left"` // Negative once past best-before
+}
+
+// ExpiringBatches lists a user's batches with stock left whose best-before
+// date falls before now+within, soonest first. Already expired batches are
+// included.
+func ExpiringBatches(db *gorm.DB, userID uint, within time.Duration, now time.Time) ([]ExpiringBatch, error) {
+	var batches []models.PantryBatch
+	err := db.Preload("PantryItem.Ingredient").Preload("PantryItem.Item").
+		Where("user_id = ? AND remaining > 0 AND best_before IS NOT NULL AND best_before <= ?", userID, now.Add(within)).
+		Order("best_before, purchased_at").Find(&batches).Error
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]ExpiringBatch, len(batches))
+	for i, b := range batches {
+		result[i] = ExpiringBatch{
+			PantryBatch: b,
+			Ingredient:  b.PantryItem.Ingredient.Name,
+			ItemName:    b.PantryItem.Item.Name,
+			DaysLeft:    b.BestBefore.Sub(now).Hours() / 24,
+		}
+	}
+	return result, nil
+}
+
+// EarliestBestBefore maps pantry item IDs to the soonest best-before date
+// among their batches with stock left.
+func EarliestBestBefore(db *gorm.DB, userID uint) (map[uint]time.Time, error) {
+	var rows []struct {
+		PantryItemID uint
+		BestBefore   time.Time
+	}
+	err := db.Model(&models.PantryBatch{}).
+		Select("pantry_item_id, MIN(best_before) AS best_before").
+		Where("user_id = ? AND remaining > 0 AND best_before IS NOT NULL", userID).
+		Group("pantry_item_id").Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[uint]time.Time, len(rows))
+	for _, r := range rows {
+		result[r.PantryItemID] = r.BestBefore
+	}
+	return result, nil
+}
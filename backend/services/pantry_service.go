@@ -0,0 +1,397 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/pmitra96/pateproject/logger"
+	"github.com/pmitra96/pateproject/models"
+	"github.com/pmitra96/pateproject/units"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrUnitMismatch is returned for stock that cannot be converted to the
+// pantry item's unit, e.g. pieces of an ingredient tracked in grams with no
+// typical piece weight. Such stock is rejected rather than recorded in the
+// wrong unit.
+var ErrUnitMismatch = errors.New("quantity cannot be converted to the pantry unit")
+
+// Movement is a stock change to apply to a pantry item.
+type Movement struct {
+	Delta       float64
+	Unit        string
+	Source      string
+	OrderItemID *uint
+	MealLogID   *uint
+	Note        string
+
+	// BatchID targets one batch: an addition goes back into it and a
+	// removal draws only from it. Otherwise additions open a new batch and
+	// removals consume batches oldest first.
+	BatchID *uint
+	// PurchasedAt and BestBefore describe the batch opened by an addition;
+	// they default to now and the ingredient's shelf life.
+	PurchasedAt time.Time
+	BestBefore  *time.Time
+}
+
+// PantryUnit is the unit a pantry item's quantities are tracked in: the
+// unit of its representative item. Item and Ingredient must be preloaded.
+func PantryUnit(pi *models.PantryItem) string {
+	if pi.Item.Unit == "" {
+		return units.Piece
+	}
+	return pi.Item.Unit
+}
+
+// ToPantryUnit converts an amount into the pantry item's unit, using the
+// ingredient's density or piece weight when the dimensions differ
+// ("2 eggs" against a pantry tracked in grams).
+func ToPantryUnit(pi *models.PantryItem, value float64, unit string) (float64, error) {
+	target := PantryUnit(pi)
+	q, err := units.New(value, unit)
+	if err != nil {
+		// Same unrecognised unit on both sides, e.g. "tray" and "tray".
+		if strings.EqualFold(strings.TrimSpace(unit), target) {
+			return value, nil
+		}
+		return 0, err
+	}
+	v, err := q.In(target, pi.Ingredient.Name)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s to %s for %s: %v", ErrUnitMismatch, unit, target, pi.Ingredient.Name, err)
+	}
+	return v, nil
+}
+
+// toPantryUnitLoose is ToPantryUnit for reading the ledger and purchase
+// history: an amount that cannot be converted is left out, with a warning,
+// rather than counted in the wrong unit.
+func toPantryUnitLoose(pi *models.PantryItem, value float64, unit string) float64 {
+	v, err := ToPantryUnit(pi, value, unit)
+	if err != nil {
+		logger.Warn("Cannot reconcile pantry units, leaving quantity out",
+			"ingredient", pi.Ingredient.Name, "pantry_unit", PantryUnit(pi), "unit", unit, "error", err)
+		return 0
+	}
+	return v
+}
+
+// ApplyMovement converts m into the pantry item's unit, applies it to
+// DerivedQuantity and appends it to the ledger, returning the delta actually
+// applied. Stock never goes below zero.
+func ApplyMovement(db *gorm.DB, pi *models.PantryItem, m Movement) (float64, error) {
+	delta, err := ToPantryUnit(pi, m.Delta, m.Unit)
+	if err != nil {
+		return 0, err
+	}
+	return applyDelta(db, pi, delta, m)
+}
+
+// applyDelta writes a change already in the pantry item's unit. Additions go
+// into a new batch (or back into m.BatchID); removals draw from batches
+// oldest first and are recorded as one movement per batch touched.
+func applyDelta(db *gorm.DB, pi *models.PantryItem, delta float64, m Movement) (float64, error) {
+	applied := 0.0
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := lockPantryItem(tx, pi); err != nil {
+			return err
+		}
+		if err := ensureOpeningBalance(tx, pi); err != nil {
+			return err
+		}
+
+		record := func(d float64, batchID *uint) error {
+			applied += d
+			return tx.Create(&models.PantryMovement{
+				PantryItemID: pi.ID,
+				UserID:       pi.UserID,
+				Delta:        d,
+				Unit:         PantryUnit(pi),
+				Source:       m.Source,
+				OrderItemID:  m.OrderItemID,
+				MealLogID:    m.MealLogID,
+				BatchID:      batchID,
+				Note:         m.Note,
+			}).Error
+		}
+
+		switch {
+		case delta > 0:
+			var batch *models.PantryBatch
+			if m.BatchID != nil {
+				batch = &models.PantryBatch{}
+				if err := tx.Where("id = ? AND pantry_item_id = ?", *m.BatchID, pi.ID).First(batch).Error; err != nil {
+					batch = nil
+				}
+			}
+			// Putting stock back into a batch leaves what was bought
+			// unchanged; a new batch holds exactly this addition.
+			if batch == nil {
+				var err error
+				if batch, err = openBatch(tx, pi, delta, m); err != nil {
+					return err
+				}
+			}
+			batch.Remaining += delta
+			if err := tx.Save(batch).Error; err != nil {
+				return err
+			}
+			if err := record(delta, &batch.ID); err != nil {
+				return err
+			}
+
+		case delta < 0:
+			need := math.Min(-delta, pi.DerivedQuantity)
+			batches, err := consumableBatches(tx, pi, m.BatchID)
+			if err != nil {
+				return err
+			}
+			for i := range batches {
+				if need <= 0 {
+					break
+				}
+				b := &batches[i]
+				take := math.Min(need, b.Remaining)
+				b.Remaining -= take
+				need -= take
+				if err := tx.Save(b).Error; err != nil {
+					return err
+				}
+				if err := record(-take, &b.ID); err != nil {
+					return err
+				}
+			}
+			// Stock from before batches were tracked has no batch to draw from.
+			if need > 1e-9 && m.BatchID == nil {
+				if err := record(-need, nil); err != nil {
+					return err
+				}
+			}
+		}
+
+		pi.DerivedQuantity += applied
+		return tx.Omit(clause.Associations).Save(pi).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+	return applied, nil
+}
+
+// AddPurchase records a purchased quantity of item (in item.Unit) as a new
+// batch and makes item the pantry item's representative. Existing stock is
+// converted to the new item's unit; if that is impossible the old
+// representative is kept, and a quantity that can't be converted to it
+// fails with ErrUnitMismatch.
+func AddPurchase(db *gorm.DB, pi *models.PantryItem, item models.Item, quantity float64, orderItemID uint, purchasedAt time.Time) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := lockPantryItem(tx, pi); err != nil {
+			return err
+		}
+		if err := ensureOpeningBalance(tx, pi); err != nil {
+			return err
+		}
+
+		if oldUnit := PantryUnit(pi); oldUnit != item.Unit && pi.DerivedQuantity != 0 {
+			err := convertBatches(tx, pi, item.Unit)
+			switch {
+			case errors.Is(err, ErrUnitMismatch):
+				logger.Warn("Keeping pantry representative item, units differ",
+					"ingredient", pi.Ingredient.Name, "pantry_unit", oldUnit, "item_unit", item.Unit, "error", err)
+			case err != nil:
+				return err
+			default:
+				converted, _ := units.Convert(pi.DerivedQuantity, oldUnit, item.Unit, pi.Ingredient.Name)
+				pi.DerivedQuantity = converted
+				pi.ItemID, pi.Item = item.ID, item
+			}
+		} else {
+			pi.ItemID, pi.Item = item.ID, item
+		}
+
+		delta, err := ToPantryUnit(pi, quantity, item.Unit)
+		if err != nil {
+			return err
+		}
+		_, err = applyDelta(tx, pi, delta, Movement{
+			Source:      models.MovementSourceOrderItem,
+			OrderItemID: &orderItemID,
+			PurchasedAt: purchasedAt,
+		})
+		return err
+	})
+}
+
+// convertBatches re-expresses a pantry item's batches in a new unit when
+// its representative item changes. Every batch is converted before any is
+// written, so stock is never left in a mix of units; ErrUnitMismatch means
+// nothing was changed. The ledger keeps each movement's own unit, so it
+// needs no rewrite.
+func convertBatches(tx *gorm.DB, pi *models.PantryItem, unit string) error {
+	ingredient := pi.Ingredient.Name
+	if _, err := units.Convert(1, PantryUnit(pi), unit, ingredient); err != nil {
+		return fmt.Errorf("%w: %s to %s for %s: %v", ErrUnitMismatch, PantryUnit(pi), unit, ingredient, err)
+	}
+
+	var batches []models.PantryBatch
+	if err := tx.Where("pantry_item_id = ?", pi.ID).Find(&batches).Error; err != nil {
+		return err
+	}
+	updates := make([]map[string]interface{}, len(batches))
+	for i, b := range batches {
+		quantity, err := units.Convert(b.Quantity, b.Unit, unit, ingredient)
+		if err != nil {
+			return fmt.Errorf("%w: batch %d from %s to %s for %s: %v", ErrUnitMismatch, b.ID, b.Unit, unit, ingredient, err)
+		}
+		remaining, err := units.Convert(b.Remaining, b.Unit, unit, ingredient)
+		if err != nil {
+			return fmt.Errorf("%w: batch %d from %s to %s for %s: %v", ErrUnitMismatch, b.ID, b.Unit, unit, ingredient, err)
+		}
+		updates[i] = map[string]interface{}{"quantity": quantity, "remaining": remaining, "unit": unit}
+	}
+
+	// A failed write rolls back the batches already rewritten
+	return tx.Transaction(func(tx *gorm.DB) error {
+		for i := range batches {
+			if err := tx.Model(&batches[i]).Updates(updates[i]).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SetQuantity records the change needed to bring a pantry item to an
+// absolute quantity (in its own unit), e.g. after a stock count.
+func SetQuantity(db *gorm.DB, pi *models.PantryItem, quantity float64, source, note string) (float64, error) {
+	applied := 0.0
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := lockPantryItem(tx, pi); err != nil {
+			return err
+		}
+		if err := ensureOpeningBalance(tx, pi); err != nil {
+			return err
+		}
+		var err error
+		applied, err = applyDelta(tx, pi, quantity-pi.DerivedQuantity, Movement{Source: source, Note: note})
+		return err
+	})
+	return applied, err
+}
+
+// lockPantryItem locks a pantry item's row for the rest of the transaction
+// and reloads its stock, so movements running alongside each other (a meal
+// log and the ingestion worker) apply one after the other and
+// DerivedQuantity stays the sum of the ledger.
+func lockPantryItem(tx *gorm.DB, pi *models.PantryItem) error {
+	var current models.PantryItem
+	err := tx.Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).
+		Select("derived_quantity", "manual_quantity").First(&current, pi.ID).Error
+	if err != nil {
+		return err
+	}
+	pi.DerivedQuantity, pi.ManualQuantity = current.DerivedQuantity, current.ManualQuantity
+	return nil
+}
+
+// RemovePantryItem takes a pantry item out of the user's pantry. Its stock
+// is closed out with a final movement and the row is soft-deleted, so the
+// ledger and batches stay for history. Item and Ingredient must be
+// preloaded.
+func RemovePantryItem(db *gorm.DB, pi *models.PantryItem) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if _, err := SetQuantity(tx, pi, 0, models.MovementSourceManual, "removed from pantry"); err != nil {
+			return err
+		}
+		return tx.Delete(pi).Error
+	})
+}
+
+// ensureOpeningBalance seeds the ledger and a batch for pantry items that
+// predate them, and folds a legacy manual override into the ledger.
+func ensureOpeningBalance(tx *gorm.DB, pi *models.PantryItem) error {
+	if pi.DerivedQuantity != 0 {
+		var count int64
+		if err := tx.Model(&models.PantryMovement{}).Where("pantry_item_id = ?", pi.ID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			batch, err := openBatch(tx, pi, pi.DerivedQuantity, Movement{PurchasedAt: pi.LastUpdated})
+			if err != nil {
+				return err
+			}
+			batch.Remaining = pi.DerivedQuantity
+			if err := tx.Save(batch).Error; err != nil {
+				return err
+			}
+			opening := models.PantryMovement{
+				PantryItemID: pi.ID,
+				UserID:       pi.UserID,
+				Delta:        pi.DerivedQuantity,
+				Unit:         PantryUnit(pi),
+				Source:       models.MovementSourceOpening,
+				BatchID:      &batch.ID,
+			}
+			if err := tx.Create(&opening).Error; err != nil {
+				return err
+			}
+		}
+	}
+
+	if pi.ManualQuantity != nil {
+		target := *pi.ManualQuantity
+		pi.ManualQuantity = nil
+		if err := tx.Model(pi).Update("manual_quantity", nil).Error; err != nil {
+			return err
+		}
+		if delta := target - pi.DerivedQuantity; delta != 0 {
+			_, err := applyDelta(tx, pi, delta, Movement{Source: models.MovementSourceManual, Note: "manual override"})
+			return err
+		}
+	}
+	return nil
+}
+
+// LedgerEntry is a movement with the pantry balance after it, in the
+// pantry item's current unit.
+type LedgerEntry struct {
+	models.PantryMovement
+	ConvertedDelta float64 `json:"converted_delta"`
+	Balance        float64 `json:"balance"`
+}
+
+// PantryHistory returns a pantry item's movements, oldest first, with a
+// running balance.
+func PantryHistory(db *gorm.DB, pi *models.PantryItem) ([]LedgerEntry, error) {
+	var movements []models.PantryMovement
+	if err := db.Where("pantry_item_id = ?", pi.ID).Order("created_at, id").Find(&movements).Error; err != nil {
+		return nil, err
+	}
+
+	entries := make([]LedgerEntry, len(movements))
+	balance := 0.0
+	for i, m := range movements {
+		delta := toPantryUnitLoose(pi, m.Delta, m.Unit)
+		balance += delta
+		entries[i] = LedgerEntry{PantryMovement: m, ConvertedDelta: delta, Balance: balance}
+	}
+	return entries, nil
+}
+
+// RecomputeDerivedQuantity rebuilds DerivedQuantity from the ledger.
+func RecomputeDerivedQuantity(db *gorm.DB, pi *models.PantryItem) (float64, error) {
+	entries, err := PantryHistory(db, pi)
+	if err != nil {
+		return 0, err
+	}
+	if len(entries) == 0 {
+		// Nothing recorded yet; the stored value is the opening balance.
+		return pi.EffectiveQuantity(), nil
+	}
+	return entries[len(entries)-1].Balance, nil
+}
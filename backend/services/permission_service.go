@@ -2,6 +2,7 @@ package services
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/pmitra96/pateproject/models"
 )
@@ -13,6 +14,9 @@ type FoodEstimate struct {
 	Protein  float64 `json:"protein"`
 	Fat      float64 `json:"fat"`
 	Carbs    float64 `json:"carbs"`
+	Sugars   float64 `json:"sugars,omitempty"` // g
+	Sodium   float64 `json:"sodium,omitempty"` // mg
+	models.DietProfile
 }
 
 // PermissionResult represents the authoritative decision
@@ -28,6 +32,29 @@ const (
 	StatusBlock               = "BLOCK"
 )
 
+// Sugar and sodium in one food above which it is flagged: half the WHO
+// daily limits.
+const (
+	highSugars = 25.0   // g
+	highSodium = 1000.0 // mg
+)
+
+// CheckFoodPermissionForUser applies the user's diet and allergies before
+// the budget rules of CheckFoodPermission: a food they can't eat is blocked
+// whatever the budget. Foods whose allergens or diet flags are unknown are
+// left to the budget rules.
+func CheckFoodPermissionForUser(state *models.RemainingDayState, prefs *models.UserPreferences, food FoodEstimate) PermissionResult {
+	if prefs != nil {
+		if avoided := AvoidedAllergens(food.DietProfile, prefs.AvoidAllergens); len(avoided) > 0 {
+			return PermissionResult{Status: StatusBlock, Reason: fmt.Sprintf("Contains %s, which you avoid.", strings.Join(avoided, ", "))}
+		}
+		if fits, why := FitsDiet(food.DietProfile, prefs.Diet); !fits {
+			return PermissionResult{Status: StatusBlock, Reason: fmt.Sprintf("This is %s.", why)}
+		}
+	}
+	return CheckFoodPermission(state, food)
+}
+
 // CheckFoodPermission evaluates if a food is allowed based on the remaining day state
 // This function implements the Rules 0-5 from the spec "Can I Eat This?"
 func CheckFoodPermission(state *models.RemainingDayState, food FoodEstimate) PermissionResult {
@@ -92,6 +119,16 @@ func CheckFoodPermission(state *models.RemainingDayState, food FoodEstimate) Per
 		return PermissionResult{Status: StatusAllowWithConstraint, Reason: reason}
 	}
 
+	// Rule 3b — High Sugar or Sodium
+	// Fits the budget, but one food with over half a day's sugar or sodium
+	// is worth a warning.
+	if food.Sugars > highSugars {
+		return PermissionResult{Status: StatusAllowWithConstraint, Reason: fmt.Sprintf("Allowed, but this has %.0fg of sugar, over half a day's limit.", food.Sugars)}
+	}
+	if food.Sodium > highSodium {
+		return PermissionResult{Status: StatusAllowWithConstraint, Reason: fmt.Sprintf("Allowed, but this has %.0fmg of sodium, over half a day's limit.", food.Sodium)}
+	}
+
 	// Rule 4 — Protein Exception
 	// If protein target not yet met AND food is protein-dominant
 	// AND calories and fat fit remaining limits (checked by 1 & 2)
@@ -0,0 +1,296 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pmitra96/pateproject/config"
+	"github.com/pmitra96/pateproject/logger"
+	"github.com/pmitra96/pateproject/models"
+	"github.com/pmitra96/pateproject/units"
+	"gorm.io/gorm"
+)
+
+// Where a price was seen.
+const (
+	PriceSourceOrder     = "order"     // Paid on one of the user's orders
+	PriceSourceCatalogue = "catalogue" // Listed on the provider's site, from the scraper
+)
+
+const defaultPriceWindowDays = 90
+
+// PriceWindow is how far back a price still counts as recent, from
+// PRICE_COMPARISON_DAYS.
+func PriceWindow() time.Duration {
+	days, err := strconv.Atoi(config.GetEnv("PRICE_COMPARISON_DAYS", ""))
+	if err != nil || days <= 0 {
+		days = defaultPriceWindowDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// SourcePrice is the latest known unit price of an ingredient at one
+// provider.
+type SourcePrice struct {
+	Provider  string    `json:"provider"`
+	Source    string    `json:"source"` // order or catalogue
+	Item      string    `json:"item"`
+	Brand     string    `json:"brand,omitempty"`
+	UnitPrice float64   `json:"unit_price"`
+	PriceUnit string    `json:"price_unit"` // kg, l or pcs
+	SeenAt    time.Time `json:"seen_at"`    // Order date or scrape time
+	OrderID   uint      `json:"order_id,omitempty"`
+	URL       string    `json:"url,omitempty"`
+}
+
+// PriceComparison ranks providers by their latest unit price for an
+// ingredient, optionally of one brand.
+type PriceComparison struct {
+	IngredientID uint          `json:"ingredient_id"`
+	Ingredient   string        `json:"ingredient"`
+	BrandID      *uint         `json:"brand_id,omitempty"`
+	Brand        string        `json:"brand,omitempty"`
+	Since        time.Time     `json:"since"`
+	Cheapest     *SourcePrice  `json:"cheapest"`
+	Sources      []SourcePrice `json:"sources"` // One per provider, cheapest first
+}
+
+// ComparePrices finds the cheapest recent source for an ingredient from
+// what the user paid since `since` and, if withCatalogue, from prices the
+// scraper listed in that period. A brand of nil compares all brands.
+func ComparePrices(db *gorm.DB, userID uint, ingredient models.Ingredient, brand *models.Brand, since, now time.Time, withCatalogue bool) (*PriceComparison, error) {
+	points, err := pricePoints(db, userID, since, now.Add(24*time.Hour), func(line models.OrderItem) bool {
+		return line.Item.IngredientID == ingredient.ID && (brand == nil || (line.Item.BrandID != nil && *line.Item.BrandID == brand.ID))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var prices []SourcePrice
+	for _, p := range points {
+		prices = append(prices, orderPrice(p))
+	}
+
+	if withCatalogue {
+		listed, err := CataloguePrices(ingredient.Name)
+		if err != nil {
+			// The scraper is optional; compare on order history alone
+			logger.Warn("Catalogue prices unavailable", "ingredient", ingredient.Name, "error", err)
+		}
+		for _, p := range listed {
+			if p.SeenAt.Before(since) {
+				continue
+			}
+			if brand != nil && !strings.Contains(strings.ToLower(p.Item), strings.ToLower(brand.Name)) {
+				continue
+			}
+			prices = append(prices, p)
+		}
+	}
+
+	comparison := &PriceComparison{
+		IngredientID: ingredient.ID,
+		Ingredient:   ingredient.Name,
+		Since:        since,
+		Sources:      latestPerProvider(prices),
+	}
+	if brand != nil {
+		comparison.BrandID = &brand.ID
+		comparison.Brand = brand.Name
+	}
+	if len(comparison.Sources) > 0 {
+		comparison.Cheapest = &comparison.Sources[0]
+	}
+	return comparison, nil
+}
+
+type priceKey struct {
+	ingredientID uint
+	brandID      uint // 0 for any brand
+}
+
+// CheapestSources holds the cheapest recent provider per ingredient, and
+// per ingredient and brand.
+type CheapestSources map[priceKey]SourcePrice
+
+// For returns the cheapest source for an ingredient, or for one brand of
+// it when brandID is set.
+func (c CheapestSources) For(ingredientID uint, brandID *uint) *SourcePrice {
+	key := priceKey{ingredientID: ingredientID}
+	if brandID != nil {
+		key.brandID = *brandID
+	}
+	if p, ok := c[key]; ok {
+		return &p
+	}
+	return nil
+}
+
+// FindCheapestSources works out the cheapest recent source for each
+// ingredient from the user's orders alone, so that lists can be annotated
+// without a catalogue lookup per line.
+func FindCheapestSources(db *gorm.DB, userID uint, ingredientIDs []uint, now time.Time) (CheapestSources, error) {
+	sources := make(CheapestSources)
+	if len(ingredientIDs) == 0 {
+		return sources, nil
+	}
+	wanted := make(map[uint]bool, len(ingredientIDs))
+	for _, id := range ingredientIDs {
+		wanted[id] = true
+	}
+
+	points, err := pricePoints(db, userID, now.Add(-PriceWindow()), now.Add(24*time.Hour), func(line models.OrderItem) bool {
+		return wanted[line.Item.IngredientID]
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	grouped := make(map[priceKey][]SourcePrice)
+	for _, p := range points {
+		price := orderPrice(p)
+		keys := []priceKey{{ingredientID: p.IngredientID}}
+		if p.BrandID != nil {
+			keys = append(keys, priceKey{ingredientID: p.IngredientID, brandID: *p.BrandID})
+		}
+		for _, key := range keys {
+			grouped[key] = append(grouped[key], price)
+		}
+	}
+	for key, prices := range grouped {
+		if ranked := latestPerProvider(prices); len(ranked) > 0 {
+			sources[key] = ranked[0]
+		}
+	}
+	return sources, nil
+}
+
+func orderPrice(p PricePoint) SourcePrice {
+	return SourcePrice{
+		Provider:  p.Provider,
+		Source:    PriceSourceOrder,
+		Item:      p.Item,
+		Brand:     p.Brand,
+		UnitPrice: p.UnitPrice,
+		PriceUnit: p.PriceUnit,
+		SeenAt:    p.OrderDate,
+		OrderID:   p.OrderID,
+	}
+}
+
+// latestPerProvider keeps each provider's most recent price, cheapest
+// first. Prices per kg and per piece cannot be compared, so only the unit
+// most prices are in is kept.
+func latestPerProvider(prices []SourcePrice) []SourcePrice {
+	count := make(map[string]int)
+	for _, p := range prices {
+		count[p.PriceUnit]++
+	}
+	unit := ""
+	for u, n := range count {
+		if n > count[unit] || (n == count[unit] && u < unit) {
+			unit = u
+		}
+	}
+
+	latest := make(map[string]SourcePrice)
+	for _, p := range prices {
+		if p.PriceUnit != unit || p.Provider == "" {
+			continue
+		}
+		if cur, ok := latest[p.Provider]; !ok || p.SeenAt.After(cur.SeenAt) {
+			latest[p.Provider] = p
+		}
+	}
+
+	ranked := []SourcePrice{}
+	for _, p := range latest {
+		ranked = append(ranked, p)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].UnitPrice != ranked[j].UnitPrice {
+			return ranked[i].UnitPrice < ranked[j].UnitPrice
+		}
+		return ranked[i].Provider < ranked[j].Provider
+	})
+	return ranked
+}
+
+// CataloguePrices looks up listed prices for a product name in the Python
+// scraper's catalogue.
+func CataloguePrices(query string) ([]SourcePrice, error) {
+	baseURL := config.GetEnv("PYTHON_EXTRACTOR_URL", "http://localhost:8081")
+	searchURL := fmt.Sprintf("%s/api/v1/products/search?priced=true&query=%s", baseURL, url.QueryEscape(query))
+
+	client := &http.Client{Timeout: 3 * time.Second}
+	resp, err := client.Get(searchURL)
+	if err != nil {
+		return nil, fmt.Errorf("scraper request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("scraper returned status: %d", resp.StatusCode)
+	}
+
+	var results []struct {
+		Name           string   `json:"name"`
+		URL            string   `json:"url"`
+		Provider       string   `json:"provider"`
+		Price          *float64 `json:"price"`
+		Weight         string   `json:"weight"`
+		Unit           string   `json:"unit"`
+		PriceScrapedAt string   `json:"price_scraped_at"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		return nil, fmt.Errorf("failed to decode scraper response: %v", err)
+	}
+
+	var prices []SourcePrice
+	for _, r := range results {
+		if r.Price == nil || *r.Price <= 0 {
+			continue
+		}
+		seenAt, ok := parseScrapedAt(r.PriceScrapedAt)
+		if !ok {
+			continue
+		}
+
+		quantity, unit := units.PackSize(strings.TrimSpace(r.Weight + " " + r.Unit))
+		if strings.TrimSpace(r.Weight) == "" {
+			quantity, unit = units.PackSize(r.Name)
+		}
+		price, per, ok := UnitPrice(*r.Price, quantity, unit)
+		if !ok {
+			continue
+		}
+
+		prices = append(prices, SourcePrice{
+			Provider:  r.Provider,
+			Source:    PriceSourceCatalogue,
+			Item:      r.Name,
+			UnitPrice: price,
+			PriceUnit: per,
+			SeenAt:    seenAt,
+			URL:       r.URL,
+		})
+	}
+	return prices, nil
+}
+
+// parseScrapedAt reads the scraper's timestamps, which are UTC without a
+// zone.
+func parseScrapedAt(s string) (time.Time, bool) {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999999"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
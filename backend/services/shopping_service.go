@@ -0,0 +1,158 @@
+package services
+
+import (
+	"strings"
+	"time"
+
+	"github.com/pmitra96/pateproject/logger"
+	"github.com/pmitra96/pateproject/models"
+	"github.com/pmitra96/pateproject/units"
+	"gorm.io/gorm"
+)
+
+// ShoppingEntry is something to put on a shopping list.
+type ShoppingEntry struct {
+	IngredientID uint
+	BrandID      *uint
+	ItemID       *uint
+	Quantity     float64
+	Unit         string
+	Source       string
+	Note         string
+}
+
+// AddShoppingEntry merges an entry into the list. Entries for the same
+// ingredient and brand share a row and their quantities add up, except
+// that a low-stock suggestion only raises a row that is itself a low-stock
+// suggestion, so re-populating a list does not double it without losing
+// what a meal or the user asked for.
+func AddShoppingEntry(db *gorm.DB, listID uint, e ShoppingEntry) (*models.ShoppingListItem, error) {
+	quantity, unit := units.Normalize(e.Quantity, e.Unit)
+
+	query := db.Preload("Ingredient").Where("list_id = ? AND ingredient_id = ? AND checked = ?", listID, e.IngredientID, false)
+	if e.BrandID != nil {
+		query = query.Where("brand_id = ?", *e.BrandID)
+	} else {
+		query = query.Where("brand_id IS NULL")
+	}
+
+	var existing []models.ShoppingListItem
+	if err := query.Find(&existing).Error; err != nil {
+		return nil, err
+	}
+	for i := range existing {
+		row := &existing[i]
+		converted, err := units.Convert(quantity, unit, row.Unit, row.Ingredient.Name)
+		if err != nil {
+			if !strings.EqualFold(unit, row.Unit) {
+				continue
+			}
+			converted = quantity
+		}
+
+		if e.Source == models.ShoppingSourceLowStock && row.Source == models.ShoppingSourceLowStock {
+			if converted > row.Quantity {
+				row.Quantity = converted
+			}
+		} else {
+			row.Quantity += converted
+		}
+		if e.ItemID != nil {
+			row.ItemID = e.ItemID
+		}
+		if e.Note != "" && !strings.Contains(row.Note, e.Note) {
+			row.Note = strings.TrimPrefix(row.Note+"; "+e.Note, "; ")
+		}
+		if err := db.Omit("Ingredient", "Brand", "Item").Save(row).Error; err != nil {
+			return nil, err
+		}
+		return row, nil
+	}
+
+	row := &models.ShoppingListItem{
+		ListID:       listID,
+		IngredientID: e.IngredientID,
+		BrandID:      e.BrandID,
+		ItemID:       e.ItemID,
+		Quantity:     quantity,
+		Unit:         unit,
+		Source:       e.Source,
+		Note:         e.Note,
+	}
+	if err := db.Create(row).Error; err != nil {
+		return nil, err
+	}
+	return row, nil
+}
+
+// PopulateFromForecast adds every pantry item flagged as low stock, using
+// the forecaster's suggested reorder quantity and the pantry's brand.
+func PopulateFromForecast(db *gorm.DB, userID, listID uint, now time.Time) (int, error) {
+	forecasts, err := ForecastPantry(db, userID, ForecastSettingsFor(db, userID), now)
+	if err != nil {
+		return 0, err
+	}
+
+	var pantryItems []models.PantryItem
+	db.Preload("Item").Where("user_id = ?", userID).Find(&pantryItems)
+	brands := make(map[uint]*uint, len(pantryItems))
+	for _, pi := range pantryItems {
+		brands[pi.ID] = pi.Item.BrandID
+	}
+
+	added := 0
+	for _, f := range forecasts {
+		if !f.Low {
+			continue
+		}
+		quantity := f.SuggestedQuantity
+		if quantity <= 0 {
+			quantity = f.PackSize
+		}
+		if quantity <= 0 {
+			quantity = 1
+		}
+		itemID := f.ItemID
+		if _, err := AddShoppingEntry(db, listID, ShoppingEntry{
+			IngredientID: f.IngredientID,
+			BrandID:      brands[f.PantryItemID],
+			ItemID:       &itemID,
+			Quantity:     quantity,
+			Unit:         f.Unit,
+			Source:       models.ShoppingSourceLowStock,
+			Note:         f.Reason,
+		}); err != nil {
+			return added, err
+		}
+		added++
+	}
+	return added, nil
+}
+
+// CheckOffPurchased ticks unchecked entries on the user's open lists whose
+// ingredient was bought in the order. Any brand counts; the list brand is
+// only a preference.
+func CheckOffPurchased(db *gorm.DB, userID, orderID uint, ingredientIDs []uint) (int64, error) {
+	if len(ingredientIDs) == 0 {
+		return 0, nil
+	}
+
+	openLists := db.Model(&models.ShoppingList{}).Select("id").
+		Where("user_id = ? AND status = ?", userID, models.ShoppingListOpen)
+
+	now := time.Now()
+	result := db.Model(&models.ShoppingListItem{}).
+		Where("list_id IN (?) AND checked = ? AND ingredient_id IN ?", openLists, false, ingredientIDs).
+		Updates(map[string]interface{}{
+			"checked":          true,
+			"checked_at":       now,
+			"checked_order_id": orderID,
+		})
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	if result.RowsAffected > 0 {
+		logger.Info("Checked off shopping list items from order", "user_id", userID, "order_id", orderID, "count", result.RowsAffected)
+	}
+	return result.RowsAffected, nil
+}
@@ -0,0 +1,257 @@
+package services
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/pmitra96/pateproject/models"
+	"github.com/pmitra96/pateproject/units"
+	"gorm.io/gorm"
+)
+
+// Ways to group spend.
+const (
+	SpendByMonth    = "month"
+	SpendByProvider = "provider"
+	SpendByCategory = "category"
+	SpendByBrand    = "brand"
+)
+
+// ErrUnknownSpendGrouping is returned for a grouping other than the above.
+var ErrUnknownSpendGrouping = errors.New("group by month, provider, category or brand")
+
+// Labels for lines without a category or brand.
+const (
+	uncategorized = "Uncategorized"
+	unbranded     = "Unbranded"
+)
+
+// SpendBucket is the spend for one month, provider, category or brand, in
+// rupees.
+type SpendBucket struct {
+	Key      string  `json:"key"`
+	Spend    float64 `json:"spend"`
+	Orders   int     `json:"orders"`
+	Lines    int     `json:"lines"`
+	Unpriced int     `json:"unpriced_lines"` // Lines without a price; not in Spend
+}
+
+// SpendSummary groups a user's spend between From and To. Month and
+// provider spend include delivery and other fees; category and brand spend
+// only cover the lines.
+type SpendSummary struct {
+	By      string        `json:"by"`
+	From    time.Time     `json:"from"`
+	To      time.Time     `json:"to"`
+	Total   float64       `json:"total"`
+	Buckets []SpendBucket `json:"buckets"`
+}
+
+// Spend groups what a user paid for orders placed in [from, to). Cancelled
+// orders and removed lines are left out.
+func Spend(db *gorm.DB, userID uint, by string, from, to time.Time) (*SpendSummary, error) {
+	switch by {
+	case SpendByMonth, SpendByProvider, SpendByCategory, SpendByBrand:
+	default:
+		return nil, ErrUnknownSpendGrouping
+	}
+
+	orders, err := pricedOrders(db, userID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &SpendSummary{By: by, From: from, To: to, Buckets: []SpendBucket{}}
+	buckets := make(map[string]*SpendBucket)
+	bucket := func(key string) *SpendBucket {
+		b, ok := buckets[key]
+		if !ok {
+			b = &SpendBucket{Key: key}
+			buckets[key] = b
+		}
+		return b
+	}
+
+	for _, order := range orders {
+		switch by {
+		case SpendByMonth, SpendByProvider:
+			key := order.OrderDate.Format("2006-01")
+			if by == SpendByProvider {
+				key = order.Provider
+			}
+			b := bucket(key)
+			b.Orders++
+			spend, lines, unpriced := orderSpend(order)
+			b.Spend += spend
+			b.Lines += lines
+			b.Unpriced += unpriced
+			summary.Total += spend
+
+		default:
+			seen := make(map[string]bool)
+			for _, line := range order.OrderItems {
+				key := lineGroup(line.Item, by)
+				b := bucket(key)
+				if !seen[key] {
+					seen[key] = true
+					b.Orders++
+				}
+				b.Lines++
+				paid, ok := line.Paid()
+				if !ok {
+					b.Unpriced++
+					continue
+				}
+				b.Spend += paid
+				summary.Total += paid
+			}
+		}
+	}
+
+	for _, b := range buckets {
+		summary.Buckets = append(summary.Buckets, *b)
+	}
+	sort.Slice(summary.Buckets, func(i, j int) bool {
+		if by == SpendByMonth {
+			return summary.Buckets[i].Key < summary.Buckets[j].Key
+		}
+		return summary.Buckets[i].Spend > summary.Buckets[j].Spend
+	})
+	return summary, nil
+}
+
+// orderSpend is what an order cost: its printed total, or else its lines
+// plus fees less order discounts.
+func orderSpend(order models.Order) (spend float64, lines, unpriced int) {
+	for _, line := range order.OrderItems {
+		lines++
+		paid, ok := line.Paid()
+		if !ok {
+			unpriced++
+			continue
+		}
+		spend += paid
+	}
+	if order.Total != nil {
+		return *order.Total, lines, unpriced
+	}
+	for _, fee := range []*float64{order.DeliveryFee, order.OtherFees} {
+		if fee != nil {
+			spend += *fee
+		}
+	}
+	if order.Discount != nil {
+		spend -= *order.Discount
+	}
+	return spend, lines, unpriced
+}
+
+func lineGroup(item models.Item, by string) string {
+	if by == SpendByBrand {
+		if item.Brand != nil {
+			return item.Brand.Name
+		}
+		return unbranded
+	}
+	if item.Ingredient.Category != nil {
+		return item.Ingredient.Category.Name
+	}
+	return uncategorized
+}
+
+// pricedOrders loads a user's orders in [from, to) that count towards spend,
+// with their remaining lines, items, brands and categories.
+func pricedOrders(db *gorm.DB, userID uint, from, to time.Time) ([]models.Order, error) {
+	var orders []models.Order
+	err := db.Preload("OrderItems.Item.Brand").Preload("OrderItems.Item.Ingredient.Category").
+		Where("user_id = ? AND status <> ? AND order_date >= ? AND order_date < ?", userID, models.OrderCancelled, from, to).
+		Order("order_date").Find(&orders).Error
+	return orders, err
+}
+
+// UnitPrice converts the price of a quantity in a base unit to rupees per
+// kg, litre or piece.
+func UnitPrice(paid, quantity float64, unit string) (float64, string, bool) {
+	if quantity <= 0 {
+		return 0, "", false
+	}
+	switch unit {
+	case units.Gram:
+		return paid / quantity * 1000, "kg", true
+	case units.Millilitre:
+		return paid / quantity * 1000, "l", true
+	}
+	return paid / quantity, units.Piece, true
+}
+
+// PricePoint is what one purchase of an ingredient cost per kg, litre or
+// piece.
+type PricePoint struct {
+	OrderID      uint      `json:"order_id"`
+	OrderDate    time.Time `json:"order_date"`
+	Provider     string    `json:"provider"`
+	IngredientID uint      `json:"ingredient_id"`
+	ItemID       uint      `json:"item_id"`
+	Item         string    `json:"item"`
+	BrandID      *uint     `json:"brand_id,omitempty"`
+	Brand        string    `json:"brand,omitempty"`
+	Quantity     float64   `json:"quantity"`
+	Unit         string    `json:"unit"`
+	Paid         float64   `json:"paid"`
+	UnitPrice    float64   `json:"unit_price"`
+	PriceUnit    string    `json:"price_unit"` // kg, l or pcs
+}
+
+// PriceTrend lists the unit prices a user paid for an ingredient in
+// [from, to), oldest first.
+func PriceTrend(db *gorm.DB, userID, ingredientID uint, from, to time.Time) ([]PricePoint, error) {
+	return pricePoints(db, userID, from, to, func(line models.OrderItem) bool {
+		return line.Item.IngredientID == ingredientID
+	})
+}
+
+// pricePoints lists the unit prices of the priced lines in [from, to) that
+// match, oldest first.
+func pricePoints(db *gorm.DB, userID uint, from, to time.Time, match func(models.OrderItem) bool) ([]PricePoint, error) {
+	orders, err := pricedOrders(db, userID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	points := []PricePoint{}
+	for _, order := range orders {
+		for _, line := range order.OrderItems {
+			if !match(line) {
+				continue
+			}
+			paid, ok := line.Paid()
+			if !ok {
+				continue
+			}
+			price, per, ok := UnitPrice(paid, line.Quantity, line.Item.Unit)
+			if !ok {
+				continue
+			}
+			point := PricePoint{
+				OrderID:      order.ID,
+				OrderDate:    order.OrderDate,
+				Provider:     order.Provider,
+				IngredientID: line.Item.IngredientID,
+				ItemID:       line.ItemID,
+				Item:         line.Item.Name,
+				BrandID:      line.Item.BrandID,
+				Quantity:     line.Quantity,
+				Unit:         line.Item.Unit,
+				Paid:         paid,
+				UnitPrice:    price,
+				PriceUnit:    per,
+			}
+			if line.Item.Brand != nil {
+				point.Brand = line.Item.Brand.Name
+			}
+			points = append(points, point)
+		}
+	}
+	return points, nil
+}
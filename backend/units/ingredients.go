@@ -0,0 +1,127 @@
+package units
+
+import (
+	"sort"
+	"strings"
+)
+
+// DefaultDensity (g/ml) is used for volume <-> mass when an ingredient has
+// no entry. Water is a reasonable middle ground for most kitchen liquids.
+const DefaultDensity = 1.0
+
+// densities in g/ml, keyed on a lower-case ingredient word or phrase.
+var densities = map[string]float64{
+	"water":      1.0,
+	"milk":       1.03,
+	"curd":       1.03,
+	"yogurt":     1.03,
+	"yoghurt":    1.03,
+	"dahi":       1.03,
+	"buttermilk": 1.03,
+	"cream":      1.0,
+	"juice":      1.04,
+	"oil":        0.92,
+	"ghee":       0.91,
+	"butter":     0.91,
+	"honey":      1.42,
+	"jaggery":    0.95,
+	"sugar":      0.85,
+	"salt":       1.2,
+	"rice":       0.85,
+	"poha":       0.3,
+	"oats":       0.41,
+	"atta":       0.53,
+	"flour":      0.53,
+	"maida":      0.53,
+	"besan":      0.45,
+	"sooji":      0.65,
+	"rava":       0.65,
+	"dal":        0.8,
+	"lentils":    0.8,
+	"chana":      0.8,
+	"rajma":      0.8,
+	"moong":      0.8,
+	"masoor":     0.8,
+	"peanuts":    0.6,
+	"paneer":     0.6,
+	"tofu":       0.6,
+}
+
+// pieceWeights is the typical edible weight in grams of one piece.
+var pieceWeights = map[string]float64{
+	"egg":      50,
+	"banana":   120,
+	"apple":    180,
+	"orange":   150,
+	"lemon":    60,
+	"lime":     30,
+	"onion":    110,
+	"tomato":   100,
+	"potato":   150,
+	"capsicum": 150,
+	"carrot":   60,
+	"cucumber": 200,
+	"garlic":   5,
+	"chilli":   5,
+	"chili":    5,
+	"bread":    25, // one slice
+	"roti":     40,
+	"chapati":  40,
+	"paratha":  80,
+	"idli":     40,
+	"dosa":     100,
+}
+
+// lookupIngredient matches table keys against whole words of the
+// ingredient name, preferring the longest key, so "oil" never matches
+// "boiled eggs". Plurals match their singular key ("2 tomatoes").
+func lookupIngredient(table map[string]float64, ingredient string) (float64, bool) {
+	words := strings.Fields(strings.ToLower(ingredient))
+	if len(words) == 0 {
+		return 0, false
+	}
+	names := []string{" " + strings.Join(words, " ") + " "}
+	for _, suffix := range []string{"es", "s"} {
+		singular := make([]string, len(words))
+		for i, w := range words {
+			singular[i] = w
+			if len(w) > len(suffix)+2 {
+				singular[i] = strings.TrimSuffix(w, suffix)
+			}
+		}
+		names = append(names, " "+strings.Join(singular, " ")+" ")
+	}
+
+	keys := make([]string, 0, len(table))
+	for k := range table {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if len(keys[i]) != len(keys[j]) {
+			return len(keys[i]) > len(keys[j])
+		}
+		return keys[i] < keys[j]
+	})
+
+	for _, k := range keys {
+		for _, name := range names {
+			if strings.Contains(name, " "+k+" ") {
+				return table[k], true
+			}
+		}
+	}
+	return 0, false
+}
+
+// DensityOf returns the density of an ingredient in g/ml.
+func DensityOf(ingredient string) float64 {
+	if d, ok := lookupIngredient(densities, ingredient); ok {
+		return d
+	}
+	return DefaultDensity
+}
+
+// PieceWeight returns the typical weight in grams of one piece of an ingredient.
+func PieceWeight(ingredient string) (float64, bool) {
+	return lookupIngredient(pieceWeights, ingredient)
+}
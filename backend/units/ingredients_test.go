@@ -0,0 +1,30 @@
+package units
+
+import "testing"
+
+func TestPieceWeightPlurals(t *testing.T) {
+	tests := []struct {
+		ingredient string
+		want       float64
+	}{
+		{"egg", 50},
+		{"eggs", 50},
+		{"boiled eggs", 50},
+		{"tomatoes", 100},
+		{"onions", 110},
+		{"bananas", 120},
+		{"green chillies", 5},
+	}
+	for _, tt := range tests {
+		if got, ok := PieceWeight(tt.ingredient); !ok || got != tt.want {
+			t.Errorf("PieceWeight(%q) = %v, %v; want %v", tt.ingredient, got, ok, tt.want)
+		}
+	}
+
+	if got, err := Convert(2, "pcs", "g", "tomatoes"); err != nil || got != 200 {
+		t.Errorf("Convert(2 pcs tomatoes) = %v, %v; want 200 g", got, err)
+	}
+	if _, ok := PieceWeight("oats"); ok {
+		t.Error("PieceWeight(oats) matched; oats are not counted in pieces")
+	}
+}
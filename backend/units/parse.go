@@ -0,0 +1,74 @@
+package units
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// leadingQuantityRegex matches a free-text ingredient such as "100g Paneer",
+// "1/2 cup rice" or "2 Eggs".
+var leadingQuantityRegex = regexp.MustCompile(`^(\d+/\d+|\d+(?:\.\d+)?)\s*([a-zA-Z]+\.?)?\s*(.*)$`)
+
+// ParseLeading splits "100g Paneer" into (100, "g", "Paneer"). When the
+// word after the number is not a unit it is treated as part of the name and
+// the unit defaults to pieces ("2 Eggs" -> 2, "pcs", "Eggs"). Text without a
+// leading number is returned as one piece.
+func ParseLeading(s string) (value float64, unit string, rest string) {
+	s = strings.TrimSpace(s)
+	m := leadingQuantityRegex.FindStringSubmatch(s)
+	if m == nil {
+		return 1, Piece, s
+	}
+
+	value = parseNumber(m[1])
+	word, rest := m[2], strings.TrimSpace(m[3])
+	if word == "" {
+		return value, Piece, rest
+	}
+	if u, ok := Lookup(word); ok {
+		return value, u.Symbol, rest
+	}
+	return value, Piece, strings.TrimSpace(word + " " + rest)
+}
+
+func parseNumber(s string) float64 {
+	if num, den, ok := strings.Cut(s, "/"); ok {
+		n, _ := strconv.ParseFloat(num, 64)
+		d, _ := strconv.ParseFloat(den, 64)
+		if d == 0 {
+			return 0
+		}
+		return n / d
+	}
+	v, _ := strconv.ParseFloat(s, 64)
+	return v
+}
+
+// packSizeUnits is the unit alternation used to find pack sizes in product
+// names. Longer spellings come first so "ltr" is not read as "l".
+const packSizeUnits = `kilograms?|kgs?|grams?|gms?|gm|mg|g|millilit(?:re|er)s?|ml|lit(?:re|er)s?|ltr|l|pcs|pc|pieces?|packs?|packets?|sets?|bundles?|dozen|nos`
+
+var (
+	multiPackRegex  = regexp.MustCompile(`(?i)(\d+)\s*[x×]\s*(\d+(?:\.\d+)?)\s*(` + packSizeUnits + `)\b`)
+	parenPackRegex  = regexp.MustCompile(`(?i)\((\d+(?:\.\d+)?)\s*(` + packSizeUnits + `)\b[^)]*\)`)
+	inlinePackRegex = regexp.MustCompile(`(?i)(\d+(?:\.\d+)?)\s*(` + packSizeUnits + `)\b`)
+)
+
+// PackSize extracts the pack size from a product name, e.g.
+// "Amul Taaza Milk (500 ml)" -> 500, "ml" or "Eggs 2 x 6 pcs" -> 12, "pcs".
+// The result is in base units; names without a size are one piece.
+func PackSize(name string) (float64, string) {
+	if m := multiPackRegex.FindStringSubmatch(name); m != nil {
+		count := parseNumber(m[1])
+		value, unit := Normalize(parseNumber(m[2]), m[3])
+		return round(count * value), unit
+	}
+	if m := parenPackRegex.FindStringSubmatch(name); m != nil {
+		return Normalize(parseNumber(m[1]), m[2])
+	}
+	if m := inlinePackRegex.FindStringSubmatch(name); m != nil {
+		return Normalize(parseNumber(m[1]), m[2])
+	}
+	return 1, Piece
+}
@@ -0,0 +1,215 @@
+package units
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Dimension is the kind of physical quantity a unit measures.
+type Dimension string
+
+const (
+	Mass   Dimension = "mass"
+	Volume Dimension = "volume"
+	Count  Dimension = "count"
+)
+
+// Base units every quantity is normalised to. Pantry and order quantities
+// are always stored in one of these.
+const (
+	Gram       = "g"
+	Millilitre = "ml"
+	Piece      = "pcs"
+)
+
+var (
+	ErrUnknownUnit  = errors.New("unknown unit")
+	ErrIncompatible = errors.New("incompatible units")
+)
+
+// Unit describes a unit symbol and how it maps onto its dimension's base unit.
+type Unit struct {
+	Symbol    string
+	Dimension Dimension
+	Factor    float64 // base units per one of this unit
+}
+
+// unitTable is keyed on lower-case symbols and common spellings.
+var unitTable = map[string]Unit{
+	"mg":         {"mg", Mass, 0.001},
+	"g":          {"g", Mass, 1},
+	"gm":         {"g", Mass, 1},
+	"gms":        {"g", Mass, 1},
+	"gram":       {"g", Mass, 1},
+	"grams":      {"g", Mass, 1},
+	"kg":         {"kg", Mass, 1000},
+	"kgs":        {"kg", Mass, 1000},
+	"kilogram":   {"kg", Mass, 1000},
+	"kilograms":  {"kg", Mass, 1000},
+	"ml":         {"ml", Volume, 1},
+	"millilitre": {"ml", Volume, 1},
+	"milliliter": {"ml", Volume, 1},
+	"l":          {"l", Volume, 1000},
+	"ltr":        {"l", Volume, 1000},
+	"litre":      {"l", Volume, 1000},
+	"liter":      {"l", Volume, 1000},
+	"litres":     {"l", Volume, 1000},
+	"liters":     {"l", Volume, 1000},
+	"cup":        {"cup", Volume, 240},
+	"cups":       {"cup", Volume, 240},
+	"tbsp":       {"tbsp", Volume, 15},
+	"tablespoon": {"tbsp", Volume, 15},
+	"tsp":        {"tsp", Volume, 5},
+	"teaspoon":   {"tsp", Volume, 5},
+	"pc":         {"pcs", Count, 1},
+	"pcs":        {"pcs", Count, 1},
+	"piece":      {"pcs", Count, 1},
+	"pieces":     {"pcs", Count, 1},
+	"unit":       {"pcs", Count, 1},
+	"units":      {"pcs", Count, 1},
+	"nos":        {"pcs", Count, 1},
+	"no":         {"pcs", Count, 1},
+	"dozen":      {"dozen", Count, 12},
+	// Packaged goods counted as whole packs.
+	"pack":    {"pcs", Count, 1},
+	"packs":   {"pcs", Count, 1},
+	"packet":  {"pcs", Count, 1},
+	"packets": {"pcs", Count, 1},
+	"pouch":   {"pcs", Count, 1},
+	"box":     {"pcs", Count, 1},
+	"bottle":  {"pcs", Count, 1},
+	"set":     {"pcs", Count, 1},
+	"bundle":  {"pcs", Count, 1},
+}
+
+// Lookup resolves a unit symbol, ignoring case, surrounding space and a
+// trailing period ("Kg.", " ML "). A missing unit means a plain count.
+func Lookup(symbol string) (Unit, bool) {
+	s := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(symbol)), ".")
+	if s == "" {
+		return unitTable[Piece], true
+	}
+	u, ok := unitTable[s]
+	return u, ok
+}
+
+// DimensionOf returns the dimension of a unit symbol.
+func DimensionOf(symbol string) (Dimension, bool) {
+	u, ok := Lookup(symbol)
+	return u.Dimension, ok
+}
+
+// BaseUnit returns the base symbol for a dimension.
+func BaseUnit(d Dimension) string {
+	switch d {
+	case Mass:
+		return Gram
+	case Volume:
+		return Millilitre
+	default:
+		return Piece
+	}
+}
+
+// Quantity is an amount expressed in its dimension's base unit.
+type Quantity struct {
+	Value     float64   `json:"value"`
+	Dimension Dimension `json:"dimension"`
+}
+
+// New builds a Quantity from a value in any known unit.
+func New(value float64, unit string) (Quantity, error) {
+	u, ok := Lookup(unit)
+	if !ok {
+		return Quantity{}, fmt.Errorf("%w: %q", ErrUnknownUnit, unit)
+	}
+	return Quantity{Value: value * u.Factor, Dimension: u.Dimension}, nil
+}
+
+// Unit returns the base unit symbol the quantity is expressed in.
+func (q Quantity) Unit() string {
+	return BaseUnit(q.Dimension)
+}
+
+// In converts the quantity to the target unit. Crossing dimensions
+// (volume <-> mass, count <-> mass/volume) uses the density and piece
+// weight tables for the named ingredient.
+func (q Quantity) In(unit string, ingredient string) (float64, error) {
+	u, ok := Lookup(unit)
+	if !ok {
+		return 0, fmt.Errorf("%w: %q", ErrUnknownUnit, unit)
+	}
+	base, err := q.toDimension(u.Dimension, ingredient)
+	if err != nil {
+		return 0, err
+	}
+	return base / u.Factor, nil
+}
+
+func (q Quantity) toDimension(target Dimension, ingredient string) (float64, error) {
+	if q.Dimension == target {
+		return q.Value, nil
+	}
+
+	// Go through grams, which every cross-dimension conversion can reach.
+	var grams float64
+	switch q.Dimension {
+	case Mass:
+		grams = q.Value
+	case Volume:
+		grams = q.Value * DensityOf(ingredient)
+	case Count:
+		w, ok := PieceWeight(ingredient)
+		if !ok {
+			return 0, fmt.Errorf("%w: no piece weight for %q", ErrIncompatible, ingredient)
+		}
+		grams = q.Value * w
+	}
+
+	switch target {
+	case Mass:
+		return grams, nil
+	case Volume:
+		return grams / DensityOf(ingredient), nil
+	case Count:
+		w, ok := PieceWeight(ingredient)
+		if !ok {
+			return 0, fmt.Errorf("%w: no piece weight for %q", ErrIncompatible, ingredient)
+		}
+		return grams / w, nil
+	}
+	return 0, fmt.Errorf("%w: %s -> %s", ErrIncompatible, q.Dimension, target)
+}
+
+// Convert converts value from one unit to another for an ingredient.
+func Convert(value float64, from, to, ingredient string) (float64, error) {
+	q, err := New(value, from)
+	if err != nil {
+		return 0, err
+	}
+	return q.In(to, ingredient)
+}
+
+// Normalize converts a value into its base unit (g, ml or pcs). Unknown
+// units are passed through lower-cased so no information is lost.
+func Normalize(value float64, unit string) (float64, string) {
+	q, err := New(value, unit)
+	if err != nil {
+		return value, strings.ToLower(strings.TrimSpace(unit))
+	}
+	return round(q.Value), q.Unit()
+}
+
+// NormalizeUnit returns the base unit for a unit symbol, or the lower-cased
+// symbol if it is unknown.
+func NormalizeUnit(unit string) string {
+	_, base := Normalize(1, unit)
+	return base
+}
+
+// round trims floating point noise from conversions (0.1 kg -> 100.00000000000001 g).
+func round(v float64) float64 {
+	return math.Round(v*1e6) / 1e6
+}
@@ -374,15 +374,27 @@ function App() {
     if (!extractionResult) return;
     try {
       const orderData = {
-        user_id: user?.sub || user?.id || "1",
-        external_order_id: `INV_${Date.now()}`,
+        external_order_id: extractionResult.order_id || (extractionResult.invoice_number ? "" : `INV_${Date.now()}`),
+        invoice_number: extractionResult.invoice_number,
         provider: extractionResult.provider || "unknown",
-        order_date: new Date().toISOString(),
+        seller: extractionResult.seller,
+        address_hash: extractionResult.address_hash,
+        order_date: extractionResult.order_date || new Date().toISOString(),
         items: extractionResult.items.map(item => ({
           raw_name: item.name,
           quantity: item.count * item.unit_value,
-          unit: item.unit
-        }))
+          unit: item.unit,
+          mrp: item.mrp,
+          discount: item.discount,
+          taxable_value: item.taxable_value,
+          gst: item.gst,
+          amount: item.amount
+        })),
+        item_total: extractionResult.item_total,
+        delivery_fee: extractionResult.delivery_fee,
+        other_fees: extractionResult.other_fees,
+        discount: extractionResult.discount,
+        total: extractionResult.total
       };
 
       await ingestOrder(orderData);
@@ -78,19 +78,53 @@ export const extractItems = async (file) => {
 };
 
 export const ingestOrder = async (orderData) => {
-  // We need to inject the user_id from the token/state
-  // For now, assume the backend extraction return include it or we add it here
+  // Orders are attributed to the signed-in user
   const response = await fetch(`${API_BASE}/ingest/order`, {
     method: 'POST',
     headers: {
       'Content-Type': 'application/json',
-      'X-API-Key': 'secret-key',
+      ...getAuthHeader(),
     },
     body: JSON.stringify(orderData),
   });
 
   if (!response.ok) throw new Error('Ingestion failed');
-  return response.json();
+  const result = await response.json();
+  if (response.status !== 202) return result;
+  return waitForIngestionJob(result.job_id);
+};
+
+// Ingestion runs in the background; poll the job until it settles.
+export const uploadOrder = async (file) => {
+  // Extracts the invoice and ingests it in one step; re-uploads are skipped
+  const formData = new FormData();
+  formData.append('file', file);
+
+  const response = await fetch(`${API_BASE}/orders/upload`, {
+    method: 'POST',
+    headers: getAuthHeader(),
+    body: formData,
+  });
+
+  if (!response.ok) throw new Error('Upload failed');
+  const result = await response.json();
+  if (response.status !== 202) return result;
+  return waitForIngestionJob(result.job_id);
+};
+
+export const waitForIngestionJob = async (jobId, intervalMs = 1000, timeoutMs = 120000) => {
+  const deadline = Date.now() + timeoutMs;
+  while (Date.now() < deadline) {
+    const response = await fetch(`${API_BASE}/ingest/jobs/${jobId}`, {
+      headers: getAuthHeader(),
+    });
+    if (!response.ok) throw new Error('Failed to fetch ingestion job');
+    const job = await response.json();
+    if (job.status === 'completed') return job;
+    if (job.status === 'failed') throw new Error(job.last_error || 'Ingestion failed');
+    await new Promise(resolve => setTimeout(resolve, intervalMs));
+  }
+  throw new Error('Ingestion is taking longer than expected');
 };
 
 export const suggestMeal = async (inventory) => {
@@ -0,0 +1,32 @@
+"""add product prices
+
+Revision ID: 5f2c9a1e7b30
+Revises: dcb748521b52
+Create Date: 2026-10-16 10:12:03.418220
+
+"""
+from typing import Sequence, Union
+
+from alembic import op
+import sqlalchemy as sa
+
+
+# revision identifiers, used by Alembic.
+revision: str = '5f2c9a1e7b30'
+down_revision: Union[str, Sequence[str], None] = 'dcb748521b52'
+branch_labels: Union[str, Sequence[str], None] = None
+depends_on: Union[str, Sequence[str], None] = None
+
+
+def upgrade() -> None:
+    """Upgrade schema."""
+    op.add_column('products', sa.Column('provider', sa.String(), nullable=True))
+    op.add_column('products', sa.Column('price', sa.Float(), nullable=True))
+    op.add_column('products', sa.Column('price_scraped_at', sa.DateTime(), nullable=True))
+
+
+def downgrade() -> None:
+    """Downgrade schema."""
+    op.drop_column('products', 'price_scraped_at')
+    op.drop_column('products', 'price')
+    op.drop_column('products', 'provider')
@@ -0,0 +1,30 @@
+"""add product barcode
+
+Revision ID: 8b41d2c6e9a5
+Revises: 5f2c9a1e7b30
+Create Date: 2026-10-16 16:20:41.207315
+
+"""
+from typing import Sequence, Union
+
+from alembic import op
+import sqlalchemy as sa
+
+
+# revision identifiers, used by Alembic.
+revision: str = '8b41d2c6e9a5'
+down_revision: Union[str, Sequence[str], None] = '5f2c9a1e7b30'
+branch_labels: Union[str, Sequence[str], None] = None
+depends_on: Union[str, Sequence[str], None] = None
+
+
+def upgrade() -> None:
+    """Upgrade schema."""
+    op.add_column('products', sa.Column('barcode', sa.String(), nullable=True))
+    op.create_index(op.f('ix_products_barcode'), 'products', ['barcode'], unique=False)
+
+
+def downgrade() -> None:
+    """Downgrade schema."""
+    op.drop_index(op.f('ix_products_barcode'), table_name='products')
+    op.drop_column('products', 'barcode')
@@ -57,8 +57,20 @@ def get_nutrition(brand_name: str = None, item_name: str = None, db: Session = D
     return products
 
 @router.get("/products/search")
-def search_products(query: str, min_protein: float = None, max_fat: float = None, db: Session = Depends(get_db)):
-    db_query = db.query(Product).filter(Product.name.ilike(f"%{query}%"))
+def search_products(query: str = None, barcode: str = None, min_protein: float = None, max_fat: float = None, priced: bool = False, db: Session = Depends(get_db)):
+    if not query and not barcode:
+        raise HTTPException(status_code=400, detail="query or barcode is required")
+
+    # A barcode is an exact match; the name query is a substring search
+    db_query = db.query(Product)
+    if barcode:
+        db_query = db_query.filter(Product.barcode == barcode)
+    if query:
+        db_query = db_query.filter(Product.name.ilike(f"%{query}%"))
+
+    # Price lookups want listed prices, with or without nutrition
+    if priced:
+        return db_query.filter(Product.price.isnot(None)).all()
     
     # Note: Complex JSON filtering is DB-specific. 
     # For SQLite/Simple JSON, we might need to filter in Python or use specific JSON operators if supported.
@@ -47,11 +47,23 @@ class ExtractedItem(BaseModel):
     count: float
     unit_value: float
     unit: str
+    # Line prices in rupees, for the whole line; None when not printed
+    mrp: Optional[float] = None
+    discount: Optional[float] = None
+    taxable_value: Optional[float] = None
+    gst: Optional[float] = None  # CGST + SGST, or IGST
+    amount: Optional[float] = None
 
 
 class ExtractionResult(BaseModel):
     provider: str
     items: List[ExtractedItem]
+    # Order totals in rupees; None when not printed
+    item_total: Optional[float] = None
+    delivery_fee: Optional[float] = None
+    other_fees: Optional[float] = None  # Handling, packaging, small cart
+    discount: Optional[float] = None  # Coupons
+    total: Optional[float] = None
 
 
 def parse_unit_and_value(text: str) -> tuple[float, str]:
@@ -115,6 +127,88 @@ def clean_item_name(name: str, unit_value: float, unit: str) -> str:
     return name
 
 
+# Header cell text (lower case, without spaces or dots) -> line price field
+PRICE_HEADERS = {
+    'mrp': 'mrp',
+    'discount': 'discount',
+    'disc': 'discount',
+    'taxable': 'taxable_value',
+    'taxablevalue': 'taxable_value',
+    'taxableamt': 'taxable_value',
+    'gst': 'gst',
+    'igst': 'gst',
+    'cgst': 'gst',
+    'sgst': 'gst',
+    'total': 'amount',
+    'amount': 'amount',
+    'netamount': 'amount',
+    'totalamount': 'amount',
+}
+
+
+def parse_money(text) -> Optional[float]:
+    """Parse amounts such as '₹1,234.50', 'Rs. 25' or '-40.00'."""
+    if text is None:
+        return None
+    text = str(text).strip()
+    if '%' in text:
+        return None
+    text = re.sub(r'^-|₹|Rs\.?|INR|,|\s', '', text)
+    if not re.fullmatch(r'\d+(\.\d+)?', text):
+        return None
+    return float(text)
+
+
+def find_price_columns(header_row) -> dict:
+    """Map price column indices to line price fields from a table header."""
+    columns = {}
+    for j, cell in enumerate(header_row):
+        if not cell:
+            continue
+        key = re.sub(r'[\s.]', '', str(cell).lower())
+        # Headers such as "CGST (Amt)" still name a GST column
+        key = re.sub(r'\(.*\)$', '', key)
+        if key in PRICE_HEADERS:
+            columns[j] = PRICE_HEADERS[key]
+    return columns
+
+
+def read_line_prices(row, columns: dict) -> dict:
+    """Read an item row's prices; GST columns are summed."""
+    prices = {}
+    for j, field in columns.items():
+        if j >= len(row):
+            continue
+        value = parse_money(row[j])
+        if value is None:
+            continue
+        prices[field] = prices.get(field, 0) + value if field == 'gst' else prices.get(field, value)
+    return prices
+
+
+def read_charges(page_text: str, charges: dict) -> None:
+    """Add order totals and fees printed on a page, such as "Delivery Fee ₹25"."""
+    for line in page_text.splitlines():
+        amounts = [parse_money(m) for m in re.findall(r'-?(?:₹|Rs\.?)?\s?[\d,]+(?:\.\d+)?(?!\s?%)', line)]
+        amounts = [a for a in amounts if a is not None]
+        if not amounts:
+            continue
+        value = amounts[-1]
+
+        text = re.sub(r'\s', '', line.lower())
+        is_fee = 'fee' in text or 'charge' in text
+        if 'delivery' in text and is_fee:
+            charges['delivery_fee'] = charges.get('delivery_fee', 0) + value
+        elif is_fee and any(k in text for k in ('handling', 'packaging', 'packing', 'smallcart', 'convenience', 'latenight', 'surge', 'platform')):
+            charges['other_fees'] = charges.get('other_fees', 0) + value
+        elif any(k in text for k in ('coupon', 'promo', 'cartdiscount')):
+            charges['discount'] = charges.get('discount', 0) + value
+        elif any(k in text for k in ('grandtotal', 'invoicevalue', 'totalamount', 'amountpaid', 'ordertotal', 'totalpayable', 'netpayable')):
+            charges.setdefault('total', value)
+        elif 'itemtotal' in text or 'subtotal' in text:
+            charges.setdefault('item_total', value)
+
+
 def detect_provider(pdf_path: str) -> str:
     """Detect the provider from PDF file."""
     logger.info(f"Detecting provider from PDF: {pdf_path}")
@@ -144,9 +238,11 @@ def extract_from_zepto(pdf_path: str) -> ExtractionResult:
     """Extract items from Zepto PDF."""
     logger.info("Starting Zepto extraction")
     items = []
+    charges = {}
     
     with pdfplumber.open(pdf_path) as pdf:
         for page in pdf.pages:
+            read_charges(page.extract_text() or '', charges)
             
             # Extract tables
             tables = page.extract_tables()
@@ -182,6 +278,8 @@ def extract_from_zepto(pdf_path: str) -> ExtractionResult:
                 
                 if header_idx is None or desc_col_idx is None:
                     continue
+
+                price_cols = find_price_columns(table[header_idx])
                 
                 # Extract items
                 for row in table[header_idx + 1:]:
@@ -217,11 +315,12 @@ def extract_from_zepto(pdf_path: str) -> ExtractionResult:
                             name=clean_name,
                             count=qty,
                             unit_value=unit_value,
-                            unit=unit
+                            unit=unit,
+                            **read_line_prices(row, price_cols)
                         ))
     
     logger.info(f"Zepto extraction completed. Found {len(items)} items")
-    return ExtractionResult(provider="zepto", items=items)
+    return ExtractionResult(provider="zepto", items=items, **charges)
 
 
 def extract_from_first_club(pdf_path: str) -> ExtractionResult:
@@ -321,9 +420,11 @@ def extract_from_blinkit(pdf_path: str) -> ExtractionResult:
     """Extract items from Blinkit PDF."""
     logger.info("Starting Blinkit extraction")
     items = []
+    charges = {}
     
     with pdfplumber.open(pdf_path) as pdf:
         for page in pdf.pages:
+            read_charges(page.extract_text() or '', charges)
             # Extract tables
             tables = page.extract_tables()
             
@@ -358,6 +459,8 @@ def extract_from_blinkit(pdf_path: str) -> ExtractionResult:
                 
                 if header_idx is None or desc_col_idx is None:
                     continue
+
+                price_cols = find_price_columns(table[header_idx])
                 
                 # Extract items
                 for row in table[header_idx + 1:]:
@@ -396,20 +499,23 @@ def extract_from_blinkit(pdf_path: str) -> ExtractionResult:
                             name=clean_name,
                             count=qty,
                             unit_value=unit_value,
-                            unit=unit
+                            unit=unit,
+                            **read_line_prices(row, price_cols)
                         ))
     
     logger.info(f"Blinkit extraction completed. Found {len(items)} items")
-    return ExtractionResult(provider="blinkit", items=items)
+    return ExtractionResult(provider="blinkit", items=items, **charges)
 
 
 def extract_from_swiggy(pdf_path: str) -> ExtractionResult:
     """Extract items from Swiggy Instamart PDF."""
     logger.info("Starting Swiggy extraction")
     items = []
+    charges = {}
     
     with pdfplumber.open(pdf_path) as pdf:
         for page in pdf.pages:
+            read_charges(page.extract_text() or '', charges)
             # Extract tables
             tables = page.extract_tables()
             
@@ -444,6 +550,8 @@ def extract_from_swiggy(pdf_path: str) -> ExtractionResult:
                 
                 if header_idx is None or desc_col_idx is None:
                     continue
+
+                price_cols = find_price_columns(table[header_idx])
                 
                 # Extract items
                 for row in table[header_idx + 1:]:
@@ -479,11 +587,12 @@ def extract_from_swiggy(pdf_path: str) -> ExtractionResult:
                             name=clean_name,
                             count=qty,
                             unit_value=unit_value,
-                            unit=unit
+                            unit=unit,
+                            **read_line_prices(row, price_cols)
                         ))
     
     logger.info(f"Swiggy extraction completed. Found {len(items)} items")
-    return ExtractionResult(provider="swiggy", items=items)
+    return ExtractionResult(provider="swiggy", items=items, **charges)
 
 
 def get_extraction_function(provider: str):
@@ -41,6 +41,7 @@ class Product(Base):
     brand_id = Column(String, ForeignKey("brands.id"))
     name = Column(String, index=True)
     zepto_id = Column(String, unique=True, index=True)
+    barcode = Column(String, index=True) # GTIN from the listing's JSON-LD, when given
     url = Column(String)
     image_url = Column(String)
     
@@ -75,6 +76,11 @@ class Product(Base):
     
     serving_size = Column(String) # Raw string from Zepto
 
+    # Listed selling price in rupees for the pack in weight/unit
+    provider = Column(String) # "zepto" or "swiggy", as on order invoices
+    price = Column(Float)
+    price_scraped_at = Column(DateTime)
+
     last_scraped_at = Column(DateTime, default=datetime.utcnow)
 
     brand = relationship("Brand", back_populates="products")
@@ -7,6 +7,26 @@ import logging
 logging.basicConfig(level=logging.INFO)
 logger = logging.getLogger(__name__)
 
+def offer_price(data: dict):
+    """Selling price from a JSON-LD Product's offers, if listed."""
+    offers = data.get('offers') or {}
+    if isinstance(offers, list):
+        offers = offers[0] if offers else {}
+    try:
+        return float(offers.get('price'))
+    except (TypeError, ValueError, AttributeError):
+        return None
+
+def product_gtin(data: dict):
+    """Barcode (GTIN-8/12/13/14) from a JSON-LD Product, if listed."""
+    for key in ('gtin13', 'gtin', 'gtin12', 'gtin14', 'gtin8'):
+        value = data.get(key)
+        if value:
+            digits = ''.join(ch for ch in str(value) if ch.isdigit())
+            if len(digits) in (8, 12, 13, 14):
+                return digits
+    return None
+
 class ZeptoScraper:
     def __init__(self):
         self.browser = None
@@ -98,6 +118,8 @@ class ZeptoScraper:
                         product_data['description'] = data.get('description')
                         product_data['image'] = data.get('image')
                         product_data['brand'] = data.get('brand', {}).get('name')
+                        product_data['price'] = offer_price(data)
+                        product_data['barcode'] = product_gtin(data)
                 except:
                     pass
             
@@ -182,6 +204,17 @@ class ZeptoScraper:
                 carbs = extract_val(r"Carbohydrate.*?(\d+(?:\.\d+)?)", raw_nutrition) or extract_val(r"Carb.*?(\d+(?:\.\d+)?)", raw_nutrition)
                 energy = extract_val(r"Energy.*?(\d+(?:\.\d+)?)", raw_nutrition) or extract_val(r"Calories.*?(\d+(?:\.\d+)?)", raw_nutrition)
 
+                # Other nutrients, in the units Indian labels print them in:
+                # grams for sugars and saturated fat, mg for minerals, mcg for B12
+                micronutrients = {
+                    'sugars': extract_val(r"(?:Total )?Sugars?.*?(\d+(?:\.\d+)?)", raw_nutrition),
+                    'saturated_fat': extract_val(r"Saturated (?:Fat|Fatty Acids).*?(\d+(?:\.\d+)?)", raw_nutrition),
+                    'sodium': extract_val(r"Sodium.*?(\d+(?:\.\d+)?)", raw_nutrition),
+                    'iron': extract_val(r"\bIron\b.*?(\d+(?:\.\d+)?)", raw_nutrition),
+                    'calcium': extract_val(r"Calcium.*?(\d+(?:\.\d+)?)", raw_nutrition),
+                    'vitamin_b12': extract_val(r"(?:Vitamin B12|Cobalamin).*?(\d+(?:\.\d+)?)", raw_nutrition),
+                }
+
                 # Determine Basis and Normalize
                 # 1. Check for explicit basis key in product_data
                 unit_hint = (product_data.get('unit') or "").lower()
@@ -237,6 +270,9 @@ class ZeptoScraper:
                     nutrition_json['fat'] = round(fat * factor, 2) if fat is not None else None
                     nutrition_json['carbohydrates'] = round(carbs * factor, 2) if carbs is not None else None
                     nutrition_json['energy'] = round(energy * factor, 2) if energy is not None else None
+                    for key, val in micronutrients.items():
+                        if val is not None:
+                            nutrition_json[key] = round(val * factor, 2)
                     logger.info(f"Normalized nutrition for {product_data.get('name')} from {serving_val}{serving_unit} to 100{target_unit} (Factor: {factor})")
                     product_data['nutrition_basis'] = f"per_100{target_unit}"
                 else:
@@ -244,6 +280,9 @@ class ZeptoScraper:
                     nutrition_json['fat'] = fat
                     nutrition_json['carbohydrates'] = carbs
                     nutrition_json['energy'] = energy
+                    for key, val in micronutrients.items():
+                        if val is not None:
+                            nutrition_json[key] = val
 
                 product_data['nutrition_info'] = nutrition_json
             
@@ -5,6 +5,8 @@ import json
 import logging
 import re
 
+from app.scraper import offer_price, product_gtin
+
 logging.basicConfig(level=logging.INFO)
 logger = logging.getLogger(__name__)
 
@@ -120,6 +122,8 @@ class InstamartScraper:
                         product_data['image'] = product_data.get('image') or data.get('image')
                         product_data['brand'] = data.get('brand', {}).get('name')
                         product_data['description'] = data.get('description')
+                        product_data['price'] = offer_price(data)
+                        product_data['barcode'] = product_gtin(data)
                 except:
                     pass
 
@@ -5,14 +5,17 @@ import asyncio
 from app.database import SessionLocal
 from app.models import Product, Brand
 import logging
+from datetime import datetime
 
 logger = logging.getLogger(__name__)
 
 async def _scrape_product_async(url: str, category_id: str = None):
     if "swiggy.com" in url:
         scraper = InstamartScraper()
+        provider = "swiggy"
     else:
         scraper = ZeptoScraper()
+        provider = "zepto"
         
     try:
         data = await scraper.scrape_product(url)
@@ -70,6 +73,11 @@ async def _scrape_product_async(url: str, category_id: str = None):
                             nutrition_basis=data.get('nutrition_basis'),
                             serving_size_value=data.get('serving_size_value'),
                             serving_size_unit=data.get('serving_size_unit'),
+
+                            provider=provider,
+                            price=data.get('price'),
+                            price_scraped_at=datetime.utcnow() if data.get('price') else None,
+                            barcode=data.get('barcode'),
                         )
                         db.add(product)
                     else:
@@ -79,11 +87,20 @@ async def _scrape_product_async(url: str, category_id: str = None):
                         product.image_url = data.get('image')
                         product.ingredients = data.get('ingredients')
                         product.allergen_information = data.get('allergen_information')
+                        product.dietary_preference = data.get('dietary_preference')
                         
                         # Update normalization fields
                         product.nutrition_basis = data.get('nutrition_basis')
                         product.serving_size_value = data.get('serving_size_value')
                         product.serving_size_unit = data.get('serving_size_unit')
+
+                        # Keep the last known price if this scrape found none
+                        product.provider = provider
+                        if data.get('price'):
+                            product.price = data.get('price')
+                            product.price_scraped_at = datetime.utcnow()
+                        if data.get('barcode'):
+                            product.barcode = data.get('barcode')
                         
                         if category_id:
                             product.category_id = category_id
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/pmitra96/pateproject/extractor" // Run from the backend dir: go run ../scripts/debug_invoice.go invoice.pdf
+)
+
+// Prints what the table parser made of each row of an invoice PDF.
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Println("usage: debug_invoice <invoice.pdf>")
+		os.Exit(1)
+	}
+
+	result, err := extractor.DiagnoseInvoice(os.Args[1])
+	if result == nil {
+		fmt.Printf("ERROR: %v\n", err)
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Printf("WARNING: %v\n", err)
+	}
+
+	fmt.Printf("Provider: %s (order %s)\n\n", result.Provider, result.OrderID)
+	for _, d := range result.Diagnostics {
+		fmt.Printf("p%d y=%6.1f %-7s %-60.60s %s\n", d.Page, d.Y, d.Status, d.Text, d.Reason)
+	}
+
+	fmt.Printf("\nItems Found: %d\n", len(result.Items))
+	for i, item := range result.Items {
+		fmt.Printf("%d. %s (qty: %.0f, unit: %.0f %s)\n", i+1, item.Name, item.Count, item.UnitValue, item.Unit)
+	}
+}
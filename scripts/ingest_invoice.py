@@ -1,3 +1,4 @@
+import os
 import sys
 import requests
 import json
@@ -5,18 +6,19 @@ import time
 
 def main():
     if len(sys.argv) < 2:
-        print("Usage: python3 ingest_invoice.py <path_to_pdf> [user_id]")
+        print("Usage: PATE_TOKEN=<id token> PATE_API_KEY=<key> python3 ingest_invoice.py <path_to_pdf>")
         sys.exit(1)
 
     pdf_path = sys.argv[1]
-    user_id = sys.argv[2] if len(sys.argv) > 2 else "1"
+    token = os.environ.get("PATE_TOKEN", "")
+    api_key = os.environ.get("PATE_API_KEY", "")
     api_base = "http://localhost:8080"
     
     # 1. Extract
     print(f"🔍 Extracting items from {pdf_path}...")
     with open(pdf_path, 'rb') as f:
         files = {'invoice': f}
-        headers = {'Authorization': f'Bearer {user_id}'}
+        headers = {'Authorization': f'Bearer {token}'}
         resp = requests.post(f"{api_base}/items/extract", files=files, headers=headers)
     
     if resp.status_code != 200:
@@ -36,9 +38,8 @@ def main():
     print(f"✅ Extracted {len(items)} items. Detected provider: {provider}")
 
     # 2. Transform & Ingest
-    print(f"📥 Ingesting into pantry for user {user_id}...")
+    print("📥 Ingesting into pantry for the API key's owner...")
     order_data = {
-        "user_id": user_id,
         "external_order_id": f"ORD_{int(time.time())}",
         "provider": provider,
         "order_date": time.strftime("%Y-%m-%dT%H:%M:%SZ", time.gmtime()),
@@ -53,12 +54,15 @@ def main():
 
     headers = {
         'Content-Type': 'application/json',
-        'X-API-Key': 'secret-key'
+        'X-API-Key': api_key
     }
     
     resp = requests.post(f"{api_base}/ingest/order", json=order_data, headers=headers)
     
-    if resp.status_code in [200, 201]:
+    if resp.status_code == 202:
+        print(f"🎉 Order queued as ingestion job {resp.json().get('job_id')} (GET /ingest/jobs/<id> for progress)")
+        print(json.dumps(resp.json(), indent=2))
+    elif resp.status_code in [200, 201]:
         print("🎉 Ingestion successful!")
         print(json.dumps(resp.json(), indent=2))
     else:
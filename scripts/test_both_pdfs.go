@@ -8,13 +8,13 @@ import (
 
 func main() {
 	fmt.Println("=== Testing Blinkit PDF ===")
-	result, err := extractor.ParseImage("../blinkit.pdf")
+	result, err := extractor.ParseInvoice("../blinkit.pdf")
 	if err != nil {
 		fmt.Printf("ERROR: %v\n", err)
 		return
 	}
 
-	fmt.Printf("Provider: %s\n", result.Provider)
+	fmt.Printf("Provider: %s (order %s)\n", result.Provider, result.OrderID)
 	fmt.Printf("Items Found: %d\n\n", len(result.Items))
 
 	for i, item := range result.Items {
@@ -22,13 +22,13 @@ func main() {
 	}
 
 	fmt.Println("\n=== Testing Zepto PDF ===")
-	result2, err := extractor.ParseImage("../zepto.pdf")
+	result2, err := extractor.ParseInvoice("../zepto.pdf")
 	if err != nil {
 		fmt.Printf("ERROR: %v\n", err)
 		return
 	}
 
-	fmt.Printf("Provider: %s\n", result2.Provider)
+	fmt.Printf("Provider: %s (order %s)\n", result2.Provider, result2.OrderID)
 	fmt.Printf("Items Found: %d\n\n", len(result2.Items))
 
 	for i, item := range result2.Items {